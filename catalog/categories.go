@@ -0,0 +1,153 @@
+// Package catalog содержит встроенный словарь категорий товаров, по которому элементам списка
+// автоматически назначается категория.
+package catalog
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Встроенные категории товаров.
+const (
+	Produce      = "produce"
+	Dairy        = "dairy"
+	Bakery       = "bakery"
+	Meat         = "meat"
+	Fish         = "fish"
+	Frozen       = "frozen"
+	Grocery      = "grocery"
+	Snacks       = "snacks"
+	Beverages    = "beverages"
+	Household    = "household"
+	PersonalCare = "personal-care"
+	Baby         = "baby"
+	Pets         = "pets"
+)
+
+// Categories - встроенные категории в порядке, в котором их удобно обходить в типичном магазине.
+var Categories = []string{
+	Produce, Bakery, Meat, Fish, Dairy, Grocery, Snacks, Beverages, Frozen, Household, PersonalCare, Baby, Pets,
+}
+
+// stems сопоставляет основы слов на русском и английском языках с категориями.
+// Слово названия относится к категории, если начинается с основы; из нескольких подходящих основ выбирается самая длинная.
+var stems = map[string]string{
+	// Овощи и фрукты.
+	"яблок": Produce, "банан": Produce, "апельсин": Produce, "лимон": Produce, "груш": Produce,
+	"виноград": Produce, "картоф": Produce, "картошк": Produce, "морков": Produce, "лук": Produce,
+	"чеснок": Produce, "помидор": Produce, "томат": Produce, "огур": Produce, "капуст": Produce,
+	"перец": Produce, "зелень": Produce, "укроп": Produce, "петрушк": Produce, "салат": Produce,
+	"apple": Produce, "banana": Produce, "orange": Produce, "lemon": Produce, "pear": Produce,
+	"grape": Produce, "potato": Produce, "carrot": Produce, "onion": Produce, "garlic": Produce,
+	"tomato": Produce, "cucumber": Produce, "cabbage": Produce, "pepper": Produce, "lettuce": Produce,
+	"avocado": Produce, "berr": Produce, "strawberr": Produce, "blueberr": Produce, "raspberr": Produce,
+	// Молочные продукты.
+	"молок": Dairy, "кефир": Dairy, "сметан": Dairy, "творог": Dairy, "сыр": Dairy, "масл": Dairy,
+	"йогурт": Dairy, "ряженк": Dairy, "сливк": Dairy, "яйц": Dairy, "яиц": Dairy,
+	"milk": Dairy, "cheese": Dairy, "butter": Dairy, "yogurt": Dairy, "yoghurt": Dairy, "cream": Dairy,
+	"egg": Dairy,
+	// Выпечка.
+	"хлеб": Bakery, "батон": Bakery, "булк": Bakery, "багет": Bakery, "лаваш": Bakery, "пирог": Bakery,
+	"круассан": Bakery, "сушк": Bakery,
+	"bread": Bakery, "baguette": Bakery, "bun": Bakery, "croissant": Bakery, "bagel": Bakery, "pita": Bakery,
+	// Мясо.
+	"мяс": Meat, "курин": Meat, "куриц": Meat, "говядин": Meat, "свинин": Meat, "фарш": Meat,
+	"колбас": Meat, "сосиск": Meat, "ветчин": Meat, "бекон": Meat,
+	"meat": Meat, "chicken": Meat, "beef": Meat, "pork": Meat, "mince": Meat, "sausage": Meat,
+	"ham": Meat, "bacon": Meat, "turkey": Meat,
+	// Рыба.
+	"рыб": Fish, "лосос": Fish, "семг": Fish, "форел": Fish, "креветк": Fish, "тунец": Fish, "сельд": Fish,
+	"fish": Fish, "salmon": Fish, "trout": Fish, "shrimp": Fish, "prawn": Fish, "tuna": Fish, "cod": Fish,
+	// Замороженные продукты.
+	"пельмен": Frozen, "мороженое": Frozen, "заморож": Frozen,
+	"frozen": Frozen, "ice cream": Frozen, "dumpling": Frozen,
+	// Бакалея.
+	"круп": Grocery, "рис": Grocery, "гречк": Grocery, "макарон": Grocery, "спагетти": Grocery,
+	"мук": Grocery, "сахар": Grocery, "соль": Grocery, "овсян": Grocery, "консерв": Grocery, "соус": Grocery,
+	"кетчуп": Grocery, "майонез": Grocery,
+	"rice": Grocery, "pasta": Grocery, "spaghetti": Grocery, "flour": Grocery, "sugar": Grocery,
+	"salt": Grocery, "oat": Grocery, "cereal": Grocery, "sauce": Grocery, "ketchup": Grocery, "beans": Grocery,
+	// Сладости и снеки.
+	"шоколад": Snacks, "конфет": Snacks, "печень": Snacks, "чипс": Snacks, "орех": Snacks,
+	"chocolate": Snacks, "cand": Snacks, "cookie": Snacks, "biscuit": Snacks, "chips": Snacks,
+	"crisps": Snacks, "nut": Snacks,
+	// Напитки.
+	"вод": Beverages, "сок": Beverages, "чай": Beverages, "кофе": Beverages, "пив": Beverages,
+	"вин": Beverages, "лимонад": Beverages, "газировк": Beverages,
+	"water": Beverages, "juice": Beverages, "tea": Beverages, "coffee": Beverages, "beer": Beverages,
+	"wine": Beverages, "soda": Beverages, "lemonade": Beverages,
+	// Хозяйственные товары.
+	"порош": Household, "губк": Household, "пакет": Household, "салфет": Household, "бумаг": Household,
+	"средство": Household, "фольг": Household,
+	"detergent": Household, "sponge": Household, "bags": Household, "napkin": Household,
+	"paper towel": Household, "toilet paper": Household, "foil": Household,
+	// Личная гигиена.
+	"шампун": PersonalCare, "мыл": PersonalCare, "зубн": PersonalCare, "гель": PersonalCare,
+	"дезодорант": PersonalCare, "щетк": PersonalCare,
+	"shampoo": PersonalCare, "soap": PersonalCare, "toothpaste": PersonalCare, "toothbrush": PersonalCare,
+	"deodorant": PersonalCare, "shower gel": PersonalCare,
+	// Детские товары.
+	"подгузник": Baby, "пюре": Baby, "смесь": Baby,
+	"diaper": Baby, "nappy": Baby, "nappies": Baby, "formula": Baby,
+	// Товары для животных.
+	"корм": Pets, "наполнитель": Pets,
+	"pet food": Pets, "cat food": Pets, "dog food": Pets, "litter": Pets,
+}
+
+// IsBuiltin сообщает, является ли категория встроенной.
+func IsBuiltin(category string) bool {
+	for _, c := range Categories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeName приводит название товара к виду, по которому сопоставляются категории:
+// нижний регистр, без знаков препинания и лишних пробелов.
+func NormalizeName(name string) string {
+	f := func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}
+	return strings.Join(strings.FieldsFunc(strings.ToLower(name), f), " ")
+}
+
+// Guess возвращает встроенную категорию товара по его названию или пустую строку, если категория не найдена.
+// Сначала проверяются словосочетания словаря, затем каждое слово названия по самой длинной подходящей основе.
+func Guess(name string) string {
+	normalized := NormalizeName(name)
+	if normalized == "" {
+		return ""
+	}
+
+	best, bestLen := "", 0
+	padded := " " + normalized + " "
+	for stem, category := range stems {
+		if strings.Contains(stem, " ") && strings.Contains(padded, " "+stem) && betterMatch(stem, category, bestLen, best) {
+			best, bestLen = category, len(stem)
+		}
+	}
+	if best != "" {
+		return best
+	}
+
+	for _, word := range strings.Fields(normalized) {
+		for stem, category := range stems {
+			if strings.HasPrefix(word, stem) && betterMatch(stem, category, bestLen, best) {
+				best, bestLen = category, len(stem)
+			}
+		}
+		// Категория определяется по первому слову, для которого нашлась основа: обычно это сам товар.
+		if best != "" {
+			return best
+		}
+	}
+	return ""
+}
+
+// betterMatch сообщает, лучше ли основа найденной ранее: побеждает более длинная основа,
+// а при равной длине - категория, идущая раньше по алфавиту, чтобы результат не зависел от порядка обхода словаря.
+func betterMatch(stem, category string, bestLen int, best string) bool {
+	return len(stem) > bestLen || (len(stem) == bestLen && category < best)
+}
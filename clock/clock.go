@@ -0,0 +1,102 @@
+// Package clock абстрагирует текущее время, чтобы код, работающий по расписанию, можно было проверять
+// с поддельными часами.
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock возвращает текущее время и ожидает заданные промежутки.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// Real - системные часы.
+type Real struct{}
+
+// System - системные часы, которыми пользуется сервер.
+var System Clock = Real{}
+
+// Now возвращает текущее системное время.
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+// After ожидает d по системным часам.
+func (Real) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Fake - поддельные часы, время которых меняется только вызовами Set и Advance.
+// Ожидания After срабатывают, когда время часов доходит до их срока.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+}
+
+type waiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// NewFake создает поддельные часы, показывающие now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now возвращает время поддельных часов.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// After возвращает канал, в который придет время часов, когда они будут переведены на d вперед.
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c := make(chan time.Time, 1)
+	at := f.now.Add(d)
+	if d <= 0 {
+		c <- f.now
+		return c
+	}
+	f.waiters = append(f.waiters, waiter{at: at, c: c})
+	sort.Slice(f.waiters, func(i, j int) bool { return f.waiters[i].at.Before(f.waiters[j].at) })
+	return c
+}
+
+// Advance переводит часы на d вперед.
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set переводит часы на время t и будит ожидания, срок которых наступил.
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = t
+	pending := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.at.After(t) {
+			pending = append(pending, w)
+		} else {
+			w.c <- t
+		}
+	}
+	f.waiters = pending
+}
+
+// Waiters возвращает число ожиданий, которые еще не сработали. Позволяет дождаться,
+// пока проверяемый код начнет ждать, прежде чем переводить часы.
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
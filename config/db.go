@@ -13,11 +13,37 @@ import (
 // DB - переменная для представления объекта MongoDB базы данных.
 var DB *mongo.Database
 
-// ShoppingLists и Users - переменные для представления коллекций MongoDB.
-var ShoppingLists, Users *mongo.Collection
+// ShoppingLists, Users, Groups и ShareInvites - переменные для представления коллекций MongoDB.
+var ShoppingLists, Users, Groups, ShareInvites *mongo.Collection
 
-// init - функция, вызываемая автоматически при запуске программы.
-func init() {
+// InviteReports - коллекция жалоб на приглашения.
+var InviteReports *mongo.Collection
+
+// Counters и Tombstones - коллекции счетчика изменений и записей об удалении для синхронизации.
+var Counters, Tombstones *mongo.Collection
+
+// IdempotencyKeys - коллекция сохраненных ответов на запросы с заголовком Idempotency-Key.
+var IdempotencyKeys *mongo.Collection
+
+// Stores и CategoryChoices - коллекции магазинов с порядком отделов и категорий, выбранных пользователями для товаров.
+var Stores, CategoryChoices *mongo.Collection
+
+// Jobs - коллекция фоновых заданий: напоминаний и доставок уведомлений.
+var Jobs *mongo.Collection
+
+// Notifications, NotificationSettings и PushSubscriptions - коллекции входящих уведомлений, настроек уведомлений
+// пользователей и подписок их браузеров на Web Push.
+var Notifications, NotificationSettings, PushSubscriptions *mongo.Collection
+
+// Checkouts - коллекция истории покупок: завершений покупок списков с купленными элементами.
+var Checkouts *mongo.Collection
+
+// Settlements - коллекция платежей, которыми участники списков возвращают друг другу долги за покупки.
+var Settlements *mongo.Collection
+
+// Connect подключается к MongoDB и заполняет переменные коллекций. Вызывается при запуске сервера
+// до обращения к хранилищу; пакеты, импортирующие config, к базе данных при загрузке не подключаются.
+func Connect() {
 	// Подключение к MongoDB с использованием URI, который хранится в переменной окружения "MONGO_DB_URI".
 	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(os.Getenv("MONGO_DB_URI")))
 	if err != nil {
@@ -36,5 +62,19 @@ func init() {
 	DB = client.Database("planpulse")
 	ShoppingLists = client.Database("planpulse").Collection("shoppingLists")
 	Users = client.Database("planpulse").Collection("users")
+	Groups = client.Database("planpulse").Collection("groups")
+	ShareInvites = client.Database("planpulse").Collection("shareInvites")
+	InviteReports = client.Database("planpulse").Collection("inviteReports")
+	Counters = client.Database("planpulse").Collection("counters")
+	Tombstones = client.Database("planpulse").Collection("tombstones")
+	IdempotencyKeys = client.Database("planpulse").Collection("idempotencyKeys")
+	Stores = client.Database("planpulse").Collection("stores")
+	CategoryChoices = client.Database("planpulse").Collection("categoryChoices")
+	Jobs = client.Database("planpulse").Collection("jobs")
+	Notifications = client.Database("planpulse").Collection("notifications")
+	NotificationSettings = client.Database("planpulse").Collection("notificationSettings")
+	PushSubscriptions = client.Database("planpulse").Collection("pushSubscriptions")
+	Checkouts = client.Database("planpulse").Collection("checkouts")
+	Settlements = client.Database("planpulse").Collection("settlements")
 }
 
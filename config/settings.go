@@ -0,0 +1,107 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ShareInviteTTL - время, по истечении которого неотвеченное приглашение к списку считается просроченным.
+var ShareInviteTTL = durationFromEnv("SHARE_INVITE_TTL", 7*24*time.Hour)
+
+// ShareInviteRateLimit - максимальное число приглашений, которое пользователь может отправить за ShareInviteRateWindow.
+var ShareInviteRateLimit = intFromEnv("SHARE_INVITE_RATE_LIMIT", 20)
+
+// ShareInviteRateWindow - окно, в котором считается ShareInviteRateLimit.
+var ShareInviteRateWindow = durationFromEnv("SHARE_INVITE_RATE_WINDOW", time.Hour)
+
+// MaxPendingInvitesPerUser - максимальное число ожидающих приглашений у одного приглашенного пользователя.
+var MaxPendingInvitesPerUser = intFromEnv("MAX_PENDING_INVITES_PER_USER", 50)
+
+// MaxClockSkew - насколько метка времени правки клиента может опережать часы сервера.
+var MaxClockSkew = durationFromEnv("MAX_CLOCK_SKEW", 5*time.Minute)
+
+// IdempotencyKeyTTL - сколько хранится ответ на запрос с заголовком Idempotency-Key.
+var IdempotencyKeyTTL = durationFromEnv("IDEMPOTENCY_KEY_TTL", 24*time.Hour)
+
+// IdempotencyLease - сколько ключ Idempotency-Key остается занятым выполняющимся запросом. Если запрос не завершился
+// за это время, например из-за остановки сервера, повтор с тем же ключом выполняется заново.
+var IdempotencyLease = durationFromEnv("IDEMPOTENCY_LEASE", 2*time.Minute)
+
+// SchedulerInterval - как часто планировщик выполняет фоновые задачи, например возвращает повторяющиеся элементы.
+var SchedulerInterval = durationFromEnv("SCHEDULER_INTERVAL", time.Minute)
+
+// JobLease - на сколько фоновое задание закрепляется за выполняющим его сервером. Задание, не завершенное
+// за это время, например из-за остановки сервера, выполняется повторно.
+var JobLease = durationFromEnv("JOB_LEASE", time.Minute)
+
+// JobMaxAttempts - сколько раз выполняется фоновое задание, прежде чем оно считается неудавшимся.
+var JobMaxAttempts = intFromEnv("JOB_MAX_ATTEMPTS", 8)
+
+// JobRetryDelay - задержка перед первым повтором неудавшегося задания. Каждая следующая задержка вдвое больше.
+var JobRetryDelay = durationFromEnv("JOB_RETRY_DELAY", 30*time.Second)
+
+// ReminderGrace - насколько может опоздать напоминание, например после остановки сервера. Более старые напоминания
+// не отправляются.
+var ReminderGrace = durationFromEnv("REMINDER_GRACE", time.Hour)
+
+// SMTPAddr и SMTPFrom - адрес SMTP-сервера для уведомлений по почте и адрес отправителя.
+// Пустой SMTPAddr отключает почту.
+var (
+	SMTPAddr = stringFromEnv("SMTP_ADDR", "")
+	SMTPFrom = stringFromEnv("SMTP_FROM", "planpulse@localhost")
+)
+
+// VAPIDPublicKey, VAPIDPrivateKey и VAPIDSubject - ключи сервера для Web Push и контакт его владельца.
+// Без ключей уведомления Web Push не отправляются.
+var (
+	VAPIDPublicKey  = stringFromEnv("VAPID_PUBLIC_KEY", "")
+	VAPIDPrivateKey = stringFromEnv("VAPID_PRIVATE_KEY", "")
+	VAPIDSubject    = stringFromEnv("VAPID_SUBJECT", "mailto:planpulse@localhost")
+)
+
+// WebhookTimeout - сколько ждать ответа на исходящий веб-хук.
+var WebhookTimeout = durationFromEnv("WEBHOOK_TIMEOUT", 10*time.Second)
+
+// SuggestionHalfLife - за какое время вес покупки в подсказках товаров уменьшается вдвое.
+var SuggestionHalfLife = durationFromEnv("SUGGESTION_HALF_LIFE", 30*24*time.Hour)
+
+// SuggestionHistory - за какой период история покупок учитывается в подсказках товаров.
+var SuggestionHistory = durationFromEnv("SUGGESTION_HISTORY", 365*24*time.Hour)
+
+// stringFromEnv читает строку из переменной окружения или возвращает значение по умолчанию.
+func stringFromEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// durationFromEnv читает длительность из переменной окружения или возвращает значение по умолчанию.
+func durationFromEnv(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("Invalid duration in %s: %v, using default %s", key, err, def)
+		return def
+	}
+	return d
+}
+
+// intFromEnv читает целое число из переменной окружения или возвращает значение по умолчанию.
+func intFromEnv(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Invalid integer in %s: %v, using default %d", key, err, def)
+		return def
+	}
+	return n
+}
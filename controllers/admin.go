@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/abel-03/go-todo/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AdminResource представляет ресурс для модерации, доступный только администраторам.
+type AdminResource struct{}
+
+// ReviewReportReq содержит решение администратора по жалобе.
+type ReviewReportReq struct {
+	Status string `json:"status"`
+	Note   string `json:"note"`
+}
+
+// AdminOnly - промежуточное ПО, пропускающее только пользователей с правами администратора.
+func AdminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, claims, _ := jwtauth.FromContext(r.Context())
+		userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		u, err := models.GetUser(userId)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		} else if u == nil || !u.IsAdmin {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Routes определяет маршруты для AdminResource.
+func (rs AdminResource) Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Use(jwtauth.Verifier(tokenAuth))
+	r.Use(Authenticator)
+	r.Use(AdminOnly)
+	r.Use(Idempotent)
+
+	r.Get("/reports", rs.GetReports)
+	r.Post("/reports/{id}/review", rs.ReviewReport)
+
+	return r
+}
+
+// GetReports возвращает жалобы на приглашения с возможностью фильтрации по статусу.
+func (rs AdminResource) GetReports(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && status != models.ReportStatusOpen &&
+		status != models.ReportStatusResolved && status != models.ReportStatusDismissed {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	reports, err := models.AllInviteReports(status)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(reports); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// ReviewReport сохраняет решение администратора по жалобе.
+func (rs AdminResource) ReviewReport(w http.ResponseWriter, r *http.Request) {
+	var req ReviewReportReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if req.Status != models.ReportStatusResolved && req.Status != models.ReportStatusDismissed {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	adminId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	reportId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	success, err := models.ReviewInviteReport(reportId, adminId, req.Status, req.Note)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if !success {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
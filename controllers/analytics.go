@@ -0,0 +1,326 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/abel-03/go-todo/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Ограничения для отчетов о покупках.
+const (
+	defaultTopItemsLimit = 20
+	maxTopItemsLimit     = 100
+)
+
+// spendingHeader - столбцы итогов Spending в отчетах CSV. Числа покупок и суммы по валютам выводятся
+// в отдельных строках (см. spendingRows).
+var spendingHeader = []string{"checkouts", "items", "quantity", "currency", "spent"}
+
+// AnalyticsResource представляет ресурс отчетов о расходах и покупках по истории покупок.
+type AnalyticsResource struct{}
+
+// Routes определяет маршруты для AnalyticsResource.
+func (rs AnalyticsResource) Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Use(jwtauth.Verifier(tokenAuth))
+	r.Use(Authenticator)
+
+	r.Get("/monthly", rs.GetMonthly)
+	r.Get("/categories", rs.GetCategories)
+	r.Get("/top-items", rs.GetTopItems)
+	r.Get("/basket", rs.GetBasket)
+	r.Get("/members", rs.GetMembers)
+
+	return r
+}
+
+// analyticsFilter разбирает общие параметры отчетов: from и to - даты (включительно) в часовом поясе tz
+// или моменты в формате RFC 3339, listId - список.
+func analyticsFilter(r *http.Request) (models.AnalyticsFilter, bool) {
+	q := r.URL.Query()
+	f := models.AnalyticsFilter{Location: time.UTC}
+	if tz := q.Get("tz"); tz != "" {
+		loc, err := time.LoadLocation(tz)
+		if err != nil || loc == time.Local {
+			return f, false
+		}
+		f.Location = loc
+	}
+	if v := q.Get("listId"); v != "" {
+		listId, err := primitive.ObjectIDFromHex(v)
+		if err != nil {
+			return f, false
+		}
+		f.ListId = &listId
+	}
+
+	for _, p := range []struct {
+		name string
+		dst  **time.Time
+		end  bool
+	}{{"from", &f.From, false}, {"to", &f.To, true}} {
+		v := q.Get(p.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.ParseInLocation("2006-01-02", v, f.Location)
+		if err == nil && p.end {
+			t = t.AddDate(0, 0, 1)
+		} else if err != nil {
+			if t, err = time.Parse(time.RFC3339, v); err != nil {
+				return f, false
+			}
+		}
+		*p.dst = &t
+	}
+	if f.From != nil && f.To != nil && !f.From.Before(*f.To) {
+		return f, false
+	}
+	return f, true
+}
+
+// spendingRows возвращает строки отчета CSV для итогов s: строку с числом покупок, элементов и количеством
+// без валюты и по строке на каждую валюту только с потраченной суммой. Так суммы столбцов не учитывают покупки
+// несколько раз. Каждая строка начинается со столбцов prefix.
+func spendingRows(prefix []string, s models.Spending) [][]string {
+	row := func(cols ...string) []string {
+		return append(append([]string{}, prefix...), cols...)
+	}
+	rows := [][]string{row(strconv.Itoa(s.Checkouts), strconv.Itoa(s.Items), strconv.FormatFloat(s.Quantity, 'f', -1, 64), "", "")}
+
+	currencies := make([]string, 0, len(s.Spent))
+	for c := range s.Spent {
+		currencies = append(currencies, c)
+	}
+	sort.Strings(currencies)
+	for _, c := range currencies {
+		rows = append(rows, row("", "", "", c, strconv.FormatFloat(s.Spent[c], 'f', 2, 64)))
+	}
+	return rows
+}
+
+// writeAnalytics отправляет отчет name в формате JSON или, если задан параметр format=csv, файлом CSV
+// со столбцами header и строками, которые возвращает rows.
+func writeAnalytics(w http.ResponseWriter, r *http.Request, name string, report interface{}, header []string, rows func() [][]string) {
+	if r.URL.Query().Get("format") != "csv" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	cw.WriteAll(rows())
+	if err := cw.Error(); err != nil {
+		log.Println(err)
+	}
+}
+
+// GetMonthly возвращает расходы по месяцам.
+func (rs AnalyticsResource) GetMonthly(w http.ResponseWriter, r *http.Request) {
+	f, ok := analyticsFilter(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	months, err := models.MonthlySpend(userId, f)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if months == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeAnalytics(w, r, "monthly", months, append([]string{"month"}, spendingHeader...), func() [][]string {
+		rows := make([][]string, 0, len(months))
+		for _, m := range months {
+			rows = append(rows, spendingRows([]string{m.Month}, m.Spending)...)
+		}
+		return rows
+	})
+}
+
+// GetCategories возвращает расходы по категориям товаров.
+func (rs AnalyticsResource) GetCategories(w http.ResponseWriter, r *http.Request) {
+	f, ok := analyticsFilter(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	categories, err := models.CategorySpend(userId, f)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if categories == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeAnalytics(w, r, "categories", categories, append([]string{"category"}, spendingHeader...), func() [][]string {
+		rows := make([][]string, 0, len(categories))
+		for _, c := range categories {
+			rows = append(rows, spendingRows([]string{c.Category}, c.Spending)...)
+		}
+		return rows
+	})
+}
+
+// GetTopItems возвращает товары, которые покупались чаще всего. Параметр limit - наибольшее число товаров.
+func (rs AnalyticsResource) GetTopItems(w http.ResponseWriter, r *http.Request) {
+	f, ok := analyticsFilter(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	limit := int64(defaultTopItemsLimit)
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.ParseInt(l, 10, 64)
+		if err != nil || n < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		limit = n
+		if limit > maxTopItemsLimit {
+			limit = maxTopItemsLimit
+		}
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	items, err := models.TopItems(userId, f, limit)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if items == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeAnalytics(w, r, "top-items", items, append([]string{"name"}, spendingHeader...), func() [][]string {
+		rows := make([][]string, 0, len(items))
+		for _, i := range items {
+			rows = append(rows, spendingRows([]string{i.Name}, i.Spending)...)
+		}
+		return rows
+	})
+}
+
+// GetBasket возвращает средний размер покупки: число элементов и сумму на одно завершение покупок.
+func (rs AnalyticsResource) GetBasket(w http.ResponseWriter, r *http.Request) {
+	f, ok := analyticsFilter(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	basket, err := models.BasketSize(userId, f)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if basket == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	header := append(append([]string{}, spendingHeader...), "averageItems", "averageSpent")
+	writeAnalytics(w, r, "basket", basket, header, func() [][]string {
+		// Среднее число элементов выводится в строке с числом покупок, средняя сумма - в строках валют.
+		rows := spendingRows(nil, basket.Spending)
+		rows[0] = append(rows[0], strconv.FormatFloat(basket.AverageItems, 'f', 2, 64), "")
+		for i, row := range rows[1:] {
+			currency := row[len(row)-2]
+			rows[i+1] = append(row, "", strconv.FormatFloat(basket.AverageSpent[currency], 'f', 2, 64))
+		}
+		return rows
+	})
+}
+
+// GetMembers возвращает вклад участников в покупки: итоги покупок, оплаченных или завершенных каждым из них.
+func (rs AnalyticsResource) GetMembers(w http.ResponseWriter, r *http.Request) {
+	f, ok := analyticsFilter(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	members, err := models.MemberSpend(userId, f)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if members == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writeAnalytics(w, r, "members", members, append([]string{"userId", "userName"}, spendingHeader...), func() [][]string {
+		rows := make([][]string, 0, len(members))
+		for _, m := range members {
+			id := ""
+			if m.UserId != nil {
+				id = m.UserId.Hex()
+			}
+			rows = append(rows, spendingRows([]string{id, m.UserName}, m.Spending)...)
+		}
+		return rows
+	})
+}
@@ -0,0 +1,30 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/abel-03/go-todo/models"
+)
+
+func TestSpendingRows(t *testing.T) {
+	tests := []struct {
+		name string
+		s    models.Spending
+		want [][]string
+	}{
+		{"no prices", models.Spending{Checkouts: 2, Items: 5, Quantity: 7.5}, [][]string{
+			{"2024-03", "2", "5", "7.5", "", ""},
+		}},
+		{"currencies", models.Spending{Checkouts: 3, Items: 4, Quantity: 4, Spent: map[string]float64{"USD": 1.5, "EUR": 10}}, [][]string{
+			{"2024-03", "3", "4", "4", "", ""},
+			{"2024-03", "", "", "", "EUR", "10.00"},
+			{"2024-03", "", "", "", "USD", "1.50"},
+		}},
+	}
+	for _, tt := range tests {
+		if got := spendingRows([]string{"2024-03"}, tt.s); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: spendingRows = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -6,8 +6,11 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/abel-03/go-todo/config"
+	"github.com/abel-03/go-todo/models"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/jwtauth/v5"
 	"github.com/lestrrat-go/jwx/v2/jwt"
@@ -21,8 +24,9 @@ type AuthResource struct{}
 
 // Credentials содержит поля для имени пользователя и пароля.
 type Credentials struct {
-	Password string `json:"password"`
-	Username string `json:"username"`
+	Password    string `json:"password"`
+	Username    string `json:"username"`
+	DisplayName string `json:"displayName"`
 }
 
 var tokenAuth *jwtauth.JWTAuth
@@ -60,11 +64,14 @@ func (rs AuthResource) Routes() chi.Router {
 	r.Group(func(r chi.Router) {
 		r.Use(jwtauth.Verifier(tokenAuth))
 		r.Use(Authenticator)
+		r.Use(Idempotent)
 
 		r.Post("/logout", rs.Logout)
 	})
 
 	r.Group(func(r chi.Router) {
+		r.Use(Idempotent)
+
 		r.Post("/login", rs.Login)
 		r.Post("/register", rs.Register)
 	})
@@ -141,9 +148,11 @@ func (rs AuthResource) Login(w http.ResponseWriter, r *http.Request) {
 	http.SetCookie(w, cookie)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(struct {
-		Username string `json:"username"`
-		UserId   string `json:"userId"`
-	}{u.Name, u.ID.Hex()})
+		Username    string `json:"username"`
+		UserId      string `json:"userId"`
+		DisplayName string `json:"displayName"`
+		AvatarURL   string `json:"avatarUrl"`
+	}{u.Name, u.ID.Hex(), u.DisplayName, u.AvatarURL})
 }
 
 // Logout обрабатывает запрос на выход пользователя.
@@ -190,9 +199,10 @@ func (rs AuthResource) Register(w http.ResponseWriter, r *http.Request) {
 	}
 
 	u := models.User{
-		ID:       primitive.NewObjectID(),
-		Name:     c.Username,
-		Password: h,
+		ID:          primitive.NewObjectID(),
+		Name:        c.Username,
+		Password:    h,
+		DisplayName: strings.TrimSpace(c.DisplayName),
 	}
 
 	err = models.AddUser(u)
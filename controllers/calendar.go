@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/abel-03/go-todo/ical"
+	"github.com/abel-03/go-todo/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Ограничения импорта календаря.
+const (
+	maxCalendarImportSize  = 1 << 20
+	maxCalendarImportTodos = 1000
+)
+
+// CalendarResource представляет ресурс ленты календаря iCalendar с элементами, у которых есть срок,
+// и импорта задач из календаря в списки.
+type CalendarResource struct{}
+
+// Routes определяет маршруты для CalendarResource. Лента защищена токеном в адресе, а не токеном входа,
+// чтобы на нее можно было подписаться из приложения календаря.
+func (rs CalendarResource) Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Get("/feed/{token}.ics", rs.GetFeed)
+
+	r.Group(func(r chi.Router) {
+		r.Use(jwtauth.Verifier(tokenAuth))
+		r.Use(Authenticator)
+		r.Use(Idempotent)
+
+		r.Post("/token", rs.CreateToken)
+		r.Delete("/token", rs.DeleteToken)
+		r.Post("/import", rs.Import)
+	})
+
+	return r
+}
+
+// GetFeed возвращает календарь элементов со сроком владельца токена. Параметр type=event выводит элементы
+// событиями вместо задач.
+func (rs CalendarResource) GetFeed(w http.ResponseWriter, r *http.Request) {
+	userId, err := models.CalendarTokenUser(chi.URLParam(r, "token"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if userId == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	cal, err := models.CalendarFeed(*userId, r.URL.Query().Get("type") == "event", time.Now())
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := cal.Encode(&buf); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="planpulse.ics"`)
+	w.Write(buf.Bytes())
+}
+
+// CreateToken создает новый токен ленты календаря и возвращает путь к ленте. Прежняя ссылка перестает действовать.
+func (rs CalendarResource) CreateToken(w http.ResponseWriter, r *http.Request) {
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	token, err := models.CreateCalendarToken(userId)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if token == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		Token string `json:"token"`
+		Path  string `json:"path"`
+	}{token, "/api/calendar/feed/" + token + ".ics"})
+}
+
+// DeleteToken отключает ленту календаря пользователя.
+func (rs CalendarResource) DeleteToken(w http.ResponseWriter, r *http.Request) {
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	success, err := models.RevokeCalendarToken(userId)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if !success {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Import добавляет незавершенные задачи VTODO из файла .ics в список listId. Файл передается телом запроса
+// или полем file формы multipart/form-data. Параметр tz задает часовой пояс сроков без часового пояса (по умолчанию UTC).
+func (rs CalendarResource) Import(w http.ResponseWriter, r *http.Request) {
+	listId, err := primitive.ObjectIDFromHex(r.URL.Query().Get("listId"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	loc := time.UTC
+	if tz := r.URL.Query().Get("tz"); tz != "" {
+		if loc, err = time.LoadLocation(tz); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxCalendarImportSize)
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	cal, err := ical.Parse(body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if len(cal.Todos) > maxCalendarImportTodos {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	res, err := models.ImportCalendar(*cal, userId, listId, loc, time.Now())
+	if err == models.ErrPositionConflict {
+		w.WriteHeader(http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if res == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// versionETag формирует ETag из версии списка или элемента.
+func versionETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// ifMatchVersions возвращает версии из заголовка If-Match или nil, если заголовок не задан или содержит "*".
+// Заголовок может перечислять несколько версий через запятую, как и If-None-Match (см. etagMatches).
+// If-Match требует строгого сравнения (RFC 7232, раздел 3.1), поэтому слабые теги W/"..." не совпадают ни с одной версией.
+// ok = false означает, что заголовок задан, но не содержит ни одной версии, и запрос должен завершиться ответом 412.
+func ifMatchVersions(r *http.Request) (versions []int64, ok bool) {
+	h := strings.TrimSpace(r.Header.Get("If-Match"))
+	if h == "" {
+		return nil, true
+	}
+
+	for _, tag := range strings.Split(h, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return nil, true
+		}
+		if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+			continue
+		}
+		v, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, v)
+	}
+	return versions, len(versions) > 0
+}
+
+// etagMatches сообщает, совпадает ли etag с одним из значений заголовка If-None-Match.
+// Для If-None-Match используется слабое сравнение: префикс W/ не учитывается.
+func etagMatches(r *http.Request, etag string) bool {
+	for _, v := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" || strings.TrimPrefix(v, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeJSONWithETag кодирует ответ в JSON и отправляет его с ETag, вычисленным по содержимому.
+// Если клиент уже получил это содержимое, отправляется 304 без тела.
+func writeJSONWithETag(w http.ResponseWriter, r *http.Request, v interface{}) {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(v); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha256.Sum256(body.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	if etagMatches(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(body.Bytes()); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestIfMatchVersions(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []int64
+		ok     bool
+	}{
+		{"", nil, true},
+		{"*", nil, true},
+		{`"3"`, []int64{3}, true},
+		{`"3", "5"`, []int64{3, 5}, true},
+		{`W/"3"`, nil, false},
+		{`W/"3", "5"`, []int64{5}, true},
+		{`3`, nil, false},
+		{`"x"`, nil, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("PUT", "/", nil)
+		if tt.header != "" {
+			r.Header.Set("If-Match", tt.header)
+		}
+		if got, ok := ifMatchVersions(r); !reflect.DeepEqual(got, tt.want) || ok != tt.ok {
+			t.Errorf("ifMatchVersions(%q) = %v, %v, want %v, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestEtagMatchesWeak(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("If-None-Match", `"a", W/"b"`)
+	for etag, want := range map[string]bool{`"a"`: true, `"b"`: true, `W/"a"`: true, `"c"`: false} {
+		if got := etagMatches(r, etag); got != want {
+			t.Errorf("etagMatches(%s) = %v, want %v", etag, got, want)
+		}
+	}
+}
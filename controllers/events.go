@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/abel-03/go-todo/events"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// heartbeatInterval - как часто в поток событий отправляется комментарий, чтобы прокси не закрывали соединение.
+const heartbeatInterval = 25 * time.Second
+
+// EventsResource представляет ресурс для подписки на изменения списков в реальном времени.
+type EventsResource struct{}
+
+// Routes определяет маршруты для EventsResource.
+func (rs EventsResource) Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Use(jwtauth.Verifier(tokenAuth))
+	r.Use(Authenticator)
+
+	r.Get("/", rs.Subscribe)
+
+	return r
+}
+
+// Subscribe открывает поток Server-Sent Events с изменениями всех списков, доступных пользователю.
+func (rs EventsResource) Subscribe(w http.ResponseWriter, r *http.Request) {
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	sub := events.Default.Subscribe(userId)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case e, ok := <-sub.C:
+			// Канал закрыт, если клиент не успевал читать события: он переподключится и загрузит списки заново.
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/abel-03/go-todo/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Ограничения для оплаты покупок и платежей между участниками.
+const (
+	maxPaymentAmount = 1e9
+	maxPaymentShares = 100
+)
+
+// CheckoutPaymentReq содержит оплату покупки: кто заплатил (по умолчанию пользователь, завершивший покупки),
+// сколько и в какой валюте. Shares задает доли участников; без них сумма делится поровну между всеми участниками списка.
+type CheckoutPaymentReq struct {
+	PayerId  string            `json:"payerId"`
+	Amount   float64           `json:"amount"`
+	Currency string            `json:"currency"`
+	Shares   []PaymentShareReq `json:"shares"`
+}
+
+// PaymentShareReq содержит долю участника в оплате покупки.
+type PaymentShareReq struct {
+	UserId string  `json:"userId"`
+	Amount float64 `json:"amount"`
+}
+
+// payment проверяет поля оплаты и преобразует ее в оплату модели. Идентификаторы разрешаются функцией resolve.
+func (p CheckoutPaymentReq) payment(userId primitive.ObjectID, resolve func(string) (primitive.ObjectID, error)) (*models.CheckoutPayment, bool) {
+	if p.Amount <= 0 || p.Amount > maxPaymentAmount || !currencyPattern.MatchString(p.Currency) || len(p.Shares) > maxPaymentShares {
+		return nil, false
+	}
+
+	payment := models.CheckoutPayment{PayerId: userId, Amount: p.Amount, Currency: p.Currency}
+	if p.PayerId != "" {
+		payerId, err := resolve(p.PayerId)
+		if err != nil {
+			return nil, false
+		}
+		payment.PayerId = payerId
+	}
+	for _, s := range p.Shares {
+		id, err := resolve(s.UserId)
+		if err != nil || s.Amount < 0 || s.Amount > maxPaymentAmount {
+			return nil, false
+		}
+		payment.Shares = append(payment.Shares, models.PaymentShare{UserId: id, Amount: s.Amount})
+	}
+	return &payment, true
+}
+
+// SettlementReq содержит платеж, которым участник возвращает долг по списку. Без FromId плательщиком
+// считается пользователь, записывающий платеж.
+type SettlementReq struct {
+	FromId   string  `json:"fromId"`
+	ToId     string  `json:"toId"`
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+}
+
+// SettleUpReq задает валюту, в которой нужно рассчитаться. Без нее рассчитываются долги во всех валютах.
+type SettleUpReq struct {
+	Currency string `json:"currency"`
+}
+
+// GetListBalances возвращает балансы участников списка и переводы, которыми они могут рассчитаться.
+func (rs ShoppingListsResource) GetListBalances(w http.ResponseWriter, r *http.Request) {
+	listId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	sheet, err := models.ListBalances(listId, userId)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if sheet == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(sheet); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// CreateSettlement записывает платеж между участниками списка. Пользователь должен быть плательщиком или получателем.
+func (rs ShoppingListsResource) CreateSettlement(w http.ResponseWriter, r *http.Request) {
+	listId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var req SettlementReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if req.Amount <= 0 || req.Amount > maxPaymentAmount || !currencyPattern.MatchString(req.Currency) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	s := models.Settlement{FromId: userId, Amount: req.Amount, Currency: req.Currency}
+	if req.FromId != "" {
+		if s.FromId, err = primitive.ObjectIDFromHex(req.FromId); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	if s.ToId, err = primitive.ObjectIDFromHex(req.ToId); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	settlement, err := models.AddSettlement(listId, userId, s)
+	if err == models.ErrInvalidSettlement {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if settlement == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(settlement)
+}
+
+// SettleUpList записывает предлагаемые переводы по списку, в которых участвует пользователь,
+// и возвращает записанные платежи.
+func (rs ShoppingListsResource) SettleUpList(w http.ResponseWriter, r *http.Request) {
+	listId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Тело запроса необязательно.
+	var req SettleUpReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if req.Currency != "" && !currencyPattern.MatchString(req.Currency) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	settlements, err := models.SettleUp(listId, userId, req.Currency)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if settlements == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(settlements)
+}
@@ -0,0 +1,383 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/abel-03/go-todo/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GroupsResource представляет ресурс для управления группами пользователей.
+type GroupsResource struct{}
+
+// GroupReq содержит поля для создания группы.
+type GroupReq struct {
+	Name string `json:"name"`
+}
+
+// GroupInviteReq содержит поля для приглашения пользователя в группу.
+type GroupInviteReq struct {
+	UserName string `json:"userName"`
+	Role     string `json:"role"`
+}
+
+// HandleGroupInviteReq содержит поля для ответа на приглашение в группу.
+type HandleGroupInviteReq struct {
+	GroupId     string `json:"groupId"`
+	IsAccepting bool   `json:"isAccepting"`
+}
+
+// GroupMemberReq содержит поля для изменения роли участника группы.
+type GroupMemberReq struct {
+	Role string `json:"role"`
+}
+
+// Routes определяет маршруты для GroupsResource.
+func (rs GroupsResource) Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Use(jwtauth.Verifier(tokenAuth))
+	r.Use(Authenticator)
+	r.Use(Idempotent)
+
+	r.Get("/", rs.GetGroups)
+	r.Post("/", rs.CreateGroup)
+	r.Delete("/{id}", rs.DeleteGroup)
+	r.Post("/{id}/leave", rs.LeaveGroup)
+	r.Post("/{id}/invites", rs.CreateGroupInvite)
+	r.Put("/{id}/members/{userId}", rs.UpdateGroupMember)
+	r.Delete("/{id}/members/{userId}", rs.DeleteGroupMember)
+
+	r.Route("/invites", func(r chi.Router) {
+		r.Get("/", rs.GetGroupInvites)
+		r.Post("/respond", rs.RespondToGroupInvite)
+	})
+
+	return r
+}
+
+// GetGroups возвращает группы, в которых состоит пользователь.
+func (rs GroupsResource) GetGroups(w http.ResponseWriter, r *http.Request) {
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	groups, err := models.AllGroups(userId)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(groups); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// CreateGroup создает новую группу, владельцем которой становится пользователь.
+func (rs GroupsResource) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	var g GroupReq
+	if err := json.NewDecoder(r.Body).Decode(&g); err != nil || g.Name == "" {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	ownerId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	id, err := models.AddGroup(g.Name, ownerId)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		Name string `json:"name"`
+		Id   string `json:"id"`
+	}{g.Name, id})
+}
+
+// DeleteGroup удаляет группу. Списки группы остаются у создавших их пользователей.
+func (rs GroupsResource) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	ownerId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	groupId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	success, err := models.RemoveGroup(groupId, ownerId)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if !success {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LeaveGroup обрабатывает выход пользователя из группы.
+func (rs GroupsResource) LeaveGroup(w http.ResponseWriter, r *http.Request) {
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	groupId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	success, err := models.LeaveGroup(groupId, userId)
+	if err == models.ErrGroupOwnerLeave {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(struct {
+			Error string `json:"message"`
+		}{"Transfer group ownership before leaving"})
+		return
+	} else if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if !success {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateGroupInvite приглашает пользователя в группу.
+func (rs GroupsResource) CreateGroupInvite(w http.ResponseWriter, r *http.Request) {
+	var req GroupInviteReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = models.GroupRoleMember
+	}
+	if req.Role == models.GroupRoleOwner || !models.IsValidGroupRole(req.Role) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	inviterId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	groupId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Получение идентификатора пользователя по имени.
+	userId, err := models.GetUserIdByName(req.UserName)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	success, err := models.AddGroupInvite(groupId, inviterId, userId, req.Role)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if !success {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// GetGroupInvites возвращает группы, в которые пользователь приглашен.
+func (rs GroupsResource) GetGroupInvites(w http.ResponseWriter, r *http.Request) {
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	groups, err := models.AllGroupInvites(userId)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(groups); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// RespondToGroupInvite обрабатывает принятие или отклонение приглашения в группу.
+func (rs GroupsResource) RespondToGroupInvite(w http.ResponseWriter, r *http.Request) {
+	var h HandleGroupInviteReq
+	if err := json.NewDecoder(r.Body).Decode(&h); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	groupId, err := primitive.ObjectIDFromHex(h.GroupId)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var success bool
+	if h.IsAccepting {
+		success, err = models.AcceptGroupInvite(groupId, userId)
+	} else {
+		success, err = models.DeclineGroupInvite(groupId, userId)
+	}
+
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if success {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// UpdateGroupMember изменяет роль участника группы или передает владение группой.
+func (rs GroupsResource) UpdateGroupMember(w http.ResponseWriter, r *http.Request) {
+	var req GroupMemberReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !models.IsValidGroupRole(req.Role) {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	ownerId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	groupId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	memberId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "userId"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	success, err := models.SetGroupMemberRole(groupId, ownerId, memberId, req.Role)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if !success {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteGroupMember исключает участника из группы.
+func (rs GroupsResource) DeleteGroupMember(w http.ResponseWriter, r *http.Request) {
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	actorId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	groupId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	memberId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "userId"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	success, err := models.RemoveGroupMember(groupId, actorId, memberId)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if !success {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,164 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/abel-03/go-todo/models"
+	"github.com/go-chi/jwtauth/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxIdempotencyKeyLength - максимальная длина значения заголовка Idempotency-Key.
+const maxIdempotencyKeyLength = 255
+
+// replayedHeaders - заголовки ответа, которые сохраняются вместе с ним и отправляются при повторе.
+var replayedHeaders = []string{"Content-Type", "Location", "ETag", "Set-Cookie"}
+
+// responseRecorder передает ответ клиенту и одновременно запоминает его для сохранения.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+// WriteHeader запоминает код ответа.
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+// Write запоминает тело ответа.
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	rr.body.Write(b)
+	return rr.ResponseWriter.Write(b)
+}
+
+// Idempotent - промежуточное ПО, которое выполняет POST-запрос с заголовком Idempotency-Key не более одного раза.
+// Повтор запроса с тем же ключом получает сохраненный ответ первого запроса. Ответы с ошибкой сервера
+// не сохраняются, чтобы повтор мог выполнить запрос заново. На маршрутах с авторизацией подключается после
+// Authenticator, и ключ действует в пределах пользователя. Для запросов без пользователя, например входа,
+// ключ действует только вместе с тем же телом запроса, поэтому чужой ответ по угаданному ключу не получить.
+func Idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if r.Method != http.MethodPost || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// Извлечение идентификатора пользователя из токена. Без токена userId остается нулевым.
+		var userId primitive.ObjectID
+		_, claims, _ := jwtauth.FromContext(r.Context())
+		if id, ok := claims["userId"].(string); ok {
+			var err error
+			if userId, err = primitive.ObjectIDFromHex(id); err != nil {
+				log.Println(err)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+
+		// Тело запроса читается целиком, чтобы убедиться, что повтор отправлен с теми же данными.
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(append([]byte(r.Method+" "+r.URL.RequestURI()+"\n"), body...))
+		requestHash := hex.EncodeToString(sum[:])
+		if userId.IsZero() {
+			// Тело может содержать пароль, поэтому в ключ входит его хеш с ключом в качестве соли.
+			salted := sha256.Sum256(append([]byte(key+"\n"), sum[:]...))
+			requestHash = hex.EncodeToString(salted[:])
+			key += " " + requestHash
+		}
+
+		rec, err := models.BeginIdempotentRequest(userId, key, requestHash)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if rec != nil {
+			replayIdempotentResponse(w, rec, requestHash)
+			return
+		}
+
+		// Если обработчик завершится паникой, освобождаем ключ, чтобы его можно было использовать повторно.
+		defer func() {
+			if p := recover(); p != nil {
+				if err := models.ReleaseIdempotentRequest(userId, key); err != nil {
+					log.Println(err)
+				}
+				panic(p)
+			}
+		}()
+
+		rr := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rr, r)
+		if rr.status == 0 {
+			rr.status = http.StatusOK
+		}
+
+		if rr.status >= http.StatusInternalServerError {
+			err = models.ReleaseIdempotentRequest(userId, key)
+		} else {
+			header := make(map[string][]string)
+			for _, h := range replayedHeaders {
+				if v := w.Header().Values(h); len(v) > 0 {
+					header[h] = v
+				}
+			}
+			err = models.CompleteIdempotentRequest(userId, key, rr.status, header, rr.body.Bytes())
+		}
+		if err != nil {
+			log.Println(err)
+		}
+	})
+}
+
+// replayIdempotentResponse отправляет сохраненный ответ на запрос с уже использованным ключом.
+func replayIdempotentResponse(w http.ResponseWriter, rec *models.IdempotencyRecord, requestHash string) {
+	if rec.RequestHash != requestHash {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(struct {
+			Error string `json:"message"`
+		}{"Idempotency key was already used with a different request"})
+		return
+	}
+	if rec.Status == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(struct {
+			Error string `json:"message"`
+		}{"Request with this idempotency key is still in progress"})
+		return
+	}
+
+	for h, v := range rec.Header {
+		w.Header()[h] = v
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(rec.Status)
+	if _, err := w.Write(rec.Body); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,347 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/abel-03/go-todo/config"
+	"github.com/abel-03/go-todo/models"
+	"github.com/abel-03/go-todo/notify"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Ограничения для настроек и выборок уведомлений.
+const (
+	maxEmailLength              = 254
+	maxWebhookURLLength         = 2048
+	maxPushKeyLength            = 256
+	defaultNotificationsLimit   = 50
+	maxNotificationsLimit       = 200
+	maxNotificationIdsPerUpdate = 500
+)
+
+// NotificationsResource представляет ресурс для входящих уведомлений и настроек уведомлений пользователя.
+type NotificationsResource struct{}
+
+// MarkReadReq содержит уведомления, которые нужно отметить прочитанными. Пустой список отмечает все уведомления.
+type MarkReadReq struct {
+	Ids []string `json:"ids"`
+}
+
+// validNotificationSettings проверяет изменение настроек: известные каналы, адрес почты, адрес веб-хука
+// по HTTP или HTTPS и тихие часы.
+func validNotificationSettings(u models.NotificationSettingsUpdate) bool {
+	seen := make(map[string]bool)
+	for _, c := range u.Channels {
+		known := false
+		for _, k := range models.NotificationChannels {
+			known = known || c == k
+		}
+		if !known || seen[c] {
+			return false
+		}
+		seen[c] = true
+	}
+	if u.Email != nil && *u.Email != "" {
+		addr, err := mail.ParseAddress(*u.Email)
+		if err != nil || addr.Address != *u.Email || len(*u.Email) > maxEmailLength {
+			return false
+		}
+	}
+	if u.WebhookURL != nil && *u.WebhookURL != "" {
+		if !notify.ValidWebhookURL(*u.WebhookURL) || len(*u.WebhookURL) > maxWebhookURLLength {
+			return false
+		}
+	}
+	return u.QuietHours == nil || u.QuietHours.Start == "" || u.QuietHours.Valid()
+}
+
+// Routes определяет маршруты для NotificationsResource.
+func (rs NotificationsResource) Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Use(jwtauth.Verifier(tokenAuth))
+	r.Use(Authenticator)
+	r.Use(Idempotent)
+
+	r.Get("/", rs.GetNotifications)
+	r.Post("/read", rs.MarkRead)
+	r.Get("/settings", rs.GetSettings)
+	r.Put("/settings", rs.UpdateSettings)
+	r.Put("/mutes/{listId}", rs.MuteList)
+	r.Delete("/mutes/{listId}", rs.UnmuteList)
+	r.Get("/push/key", rs.GetPushKey)
+	r.Post("/push/subscriptions", rs.Subscribe)
+	r.Delete("/push/subscriptions", rs.Unsubscribe)
+
+	return r
+}
+
+// GetNotifications возвращает входящие уведомления пользователя от новых к старым.
+// Параметр unread=true оставляет только непрочитанные; limit и offset задают страницу выборки.
+func (rs NotificationsResource) GetNotifications(w http.ResponseWriter, r *http.Request) {
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	unreadOnly, _ := strconv.ParseBool(r.URL.Query().Get("unread"))
+	limit, offset := int64(defaultNotificationsLimit), int64(0)
+	if l := r.URL.Query().Get("limit"); l != "" {
+		limit, err = strconv.ParseInt(l, 10, 64)
+		if err != nil || limit < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if limit > maxNotificationsLimit {
+			limit = maxNotificationsLimit
+		}
+	}
+	if o := r.URL.Query().Get("offset"); o != "" {
+		offset, err = strconv.ParseInt(o, 10, 64)
+		if err != nil || offset < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	page, err := models.InboxNotifications(userId, unreadOnly, limit, offset)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// MarkRead отмечает уведомления прочитанными и возвращает число отмеченных.
+func (rs NotificationsResource) MarkRead(w http.ResponseWriter, r *http.Request) {
+	var req MarkReadReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if len(req.Ids) > maxNotificationIdsPerUpdate {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ids := make([]primitive.ObjectID, 0, len(req.Ids))
+	for _, id := range req.Ids {
+		objId, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ids = append(ids, objId)
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	n, err := models.MarkNotificationsRead(userId, ids)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Marked int64 `json:"marked"`
+	}{n})
+}
+
+// GetSettings возвращает настройки уведомлений пользователя.
+func (rs NotificationsResource) GetSettings(w http.ResponseWriter, r *http.Request) {
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	s, err := models.GetNotificationSettings(userId)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// UpdateSettings изменяет каналы, адреса почты и веб-хука и тихие часы пользователя и возвращает новые настройки.
+func (rs NotificationsResource) UpdateSettings(w http.ResponseWriter, r *http.Request) {
+	var u models.NotificationSettingsUpdate
+	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if !validNotificationSettings(u) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	s, err := models.UpdateNotificationSettings(userId, u)
+	if err == models.ErrInvalidQuietHours {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// MuteList отключает уведомления списка.
+func (rs NotificationsResource) MuteList(w http.ResponseWriter, r *http.Request) {
+	rs.setListMuted(w, r, true)
+}
+
+// UnmuteList снова включает уведомления списка.
+func (rs NotificationsResource) UnmuteList(w http.ResponseWriter, r *http.Request) {
+	rs.setListMuted(w, r, false)
+}
+
+// setListMuted отключает или включает уведомления списка из параметра маршрута listId.
+func (rs NotificationsResource) setListMuted(w http.ResponseWriter, r *http.Request, muted bool) {
+	listId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "listId"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	success, err := models.SetListMuted(userId, listId, muted)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if !success {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetPushKey возвращает открытый ключ VAPID для подписки браузера на Web Push.
+// Если Web Push на сервере не настроен, возвращается 404.
+func (rs NotificationsResource) GetPushKey(w http.ResponseWriter, r *http.Request) {
+	if config.VAPIDPublicKey == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		PublicKey string `json:"publicKey"`
+	}{config.VAPIDPublicKey})
+}
+
+// Subscribe сохраняет подписку браузера пользователя на Web Push.
+func (rs NotificationsResource) Subscribe(w http.ResponseWriter, r *http.Request) {
+	var sub notify.PushSubscription
+	if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	endpoint, err := url.Parse(sub.Endpoint)
+	if err != nil || endpoint.Scheme != "https" || endpoint.Host == "" || len(sub.Endpoint) > maxWebhookURLLength ||
+		sub.P256dh == "" || sub.Auth == "" ||
+		utf8.RuneCountInString(sub.P256dh) > maxPushKeyLength || utf8.RuneCountInString(sub.Auth) > maxPushKeyLength {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if err := models.AddPushSubscription(userId, sub); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// Unsubscribe удаляет подписку браузера пользователя по адресу службы из тела запроса.
+func (rs NotificationsResource) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	var sub notify.PushSubscription
+	if err := json.NewDecoder(r.Body).Decode(&sub); err != nil || sub.Endpoint == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	success, err := models.RemovePushSubscription(userId, sub.Endpoint)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if !success {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
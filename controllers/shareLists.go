@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/abel-03/go-todo/models"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/jwtauth/v5"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -15,26 +16,42 @@ import (
 type ShareListsResource struct{}
 
 // ShareListReq содержит поля для запроса обмена списками.
+// Пользователь указывается либо по идентификатору (например, из результатов поиска), либо по имени.
 type ShareListReq struct {
 	ListId   string `json:"listId"`
+	UserId   string `json:"userId"`
 	UserName string `json:"userName"`
+	Role     string `json:"role"`
 }
 
 // HandleShareReq содержит поля для обработки запроса на обмен списками.
+// Приглашение определяется либо по inviteId, либо по listId.
 type HandleShareReq struct {
+	InviteId    string `json:"inviteId"`
 	ListId      string `json:"listId"`
 	IsAccepting bool   `json:"isAccepting"`
 }
 
+// ReportInviteReq содержит поля жалобы на приглашение.
+type ReportInviteReq struct {
+	Reason string `json:"reason"`
+	Block  bool   `json:"block"`
+}
+
 // Routes определяет маршруты для ShareListsResource.
 func (rs ShareListsResource) Routes() chi.Router {
 	r := chi.NewRouter()
 	r.Use(jwtauth.Verifier(tokenAuth))
 	r.Use(Authenticator)
+	r.Use(Idempotent)
 
 	r.Get("/", rs.GetShareInviteLists)
 	r.Post("/create", rs.CreateShareRequest)
 	r.Post("/respond", rs.RespondToShareRequest)
+	r.Get("/inbox", rs.GetInbox)
+	r.Get("/outbox", rs.GetOutbox)
+	r.Post("/invites/{id}/cancel", rs.CancelShareRequest)
+	r.Post("/invites/{id}/report", rs.ReportShareRequest)
 
 	return r
 }
@@ -74,6 +91,13 @@ func (rs ShareListsResource) CreateShareRequest(w http.ResponseWriter, r *http.R
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	if s.Role == "" {
+		s.Role = models.ShareRoleEditor
+	}
+	if !models.IsValidShareRole(s.Role) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 
 	// Извлечение идентификатора пользователя из токена.
 	_, claims, _ := jwtauth.FromContext(r.Context())
@@ -92,12 +116,22 @@ func (rs ShareListsResource) CreateShareRequest(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	// Получение идентификатора пользователя по имени.
-	userId, err := models.GetUserIdByName(s.UserName)
-	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	// Получение идентификатора приглашаемого пользователя.
+	var userId primitive.ObjectID
+	if s.UserId != "" {
+		userId, err = primitive.ObjectIDFromHex(s.UserId)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	} else {
+		userId, err = models.GetUserIdByName(s.UserName)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 	}
 
 	// Проверка на самообмен и добавление запроса на обмен в базу данных.
@@ -106,11 +140,21 @@ func (rs ShareListsResource) CreateShareRequest(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	success, err := models.AddShareInvite(ownerId, listId, userId)
-	if !success || err != nil {
+	success, err := models.AddShareInvite(ownerId, listId, userId, s.Role)
+	if err == models.ErrInviteRateLimited || err == models.ErrTooManyPendingInvites {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(struct {
+			Error string `json:"message"`
+		}{err.Error()})
+		return
+	} else if err != nil {
 		log.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
+	} else if !success {
+		w.WriteHeader(http.StatusConflict)
+		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
@@ -134,20 +178,36 @@ func (rs ShareListsResource) RespondToShareRequest(w http.ResponseWriter, r *htt
 		return
 	}
 
-	// Преобразование строковых идентификаторов в ObjectID.
-	listId, err := primitive.ObjectIDFromHex(h.ListId)
-	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-
 	var success bool
-	// Обработка запроса на обмен (принятие или отклонение).
-	if h.IsAccepting {
-		success, err = models.ShareListWithUser(listId, userId)
+	if h.InviteId != "" {
+		// Обработка ответа на конкретное приглашение.
+		inviteId, err := primitive.ObjectIDFromHex(h.InviteId)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		success, err = models.RespondToShareInvite(inviteId, userId, h.IsAccepting)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 	} else {
-		success, err = models.DeclineShareListWithUser(listId, userId)
+		// Преобразование строковых идентификаторов в ObjectID.
+		listId, err := primitive.ObjectIDFromHex(h.ListId)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// Обработка запроса на обмен (принятие или отклонение).
+		if h.IsAccepting {
+			success, err = models.ShareListWithUser(listId, userId)
+		} else {
+			success, err = models.DeclineShareListWithUser(listId, userId)
+		}
 	}
 
 	if err != nil {
@@ -164,3 +224,110 @@ func (rs ShareListsResource) RespondToShareRequest(w http.ResponseWriter, r *htt
 	}
 }
 
+// GetInbox возвращает приглашения, полученные пользователем, с возможностью фильтрации по статусу.
+func (rs ShareListsResource) GetInbox(w http.ResponseWriter, r *http.Request) {
+	rs.getInvites(w, r, models.ShareInvitesInbox)
+}
+
+// GetOutbox возвращает приглашения, отправленные пользователем, с возможностью фильтрации по статусу.
+func (rs ShareListsResource) GetOutbox(w http.ResponseWriter, r *http.Request) {
+	rs.getInvites(w, r, models.ShareInvitesOutbox)
+}
+
+// getInvites отправляет клиенту приглашения, полученные функцией выборки.
+func (rs ShareListsResource) getInvites(w http.ResponseWriter, r *http.Request,
+	fetch func(primitive.ObjectID, string) (*[]models.ShareInvite, error)) {
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if status != "" && !models.IsValidInviteStatus(status) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	invites, err := fetch(userId, status)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(invites); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// CancelShareRequest отзывает отправленное пользователем приглашение.
+func (rs ShareListsResource) CancelShareRequest(w http.ResponseWriter, r *http.Request) {
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	inviterId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	inviteId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	success, err := models.CancelShareInvite(inviteId, inviterId)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if !success {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// ReportShareRequest сохраняет жалобу на полученное приглашение и отклоняет его.
+func (rs ShareListsResource) ReportShareRequest(w http.ResponseWriter, r *http.Request) {
+	var req ReportInviteReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	inviteId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	success, err := models.ReportShareInvite(inviteId, userId, req.Reason, req.Block)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if !success {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
@@ -2,19 +2,266 @@ package controllers
 
 import (
 	"encoding/json"
+	"io"
 	"log"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/abel-03/go-todo/hlc"
+	"github.com/abel-03/go-todo/models"
+	"github.com/abel-03/go-todo/parser"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/jwtauth/v5"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// NewListItemReq содержит данные для создания нового элемента списка.
+// Ограничения для свойств списка.
+const (
+	maxListNameLength        = 100
+	maxListDescriptionLength = 1000
+	maxListIconLength        = 32
+)
+
+// Ограничения для полей элемента списка.
+const (
+	maxItemQuantity   = models.MaxItemQuantity
+	maxItemPrice      = 1e9
+	maxItemUnitLength = 16
+	maxItemNoteLength = 500
+
+	maxItemCategoryLength = 32
+	maxParseTextLength    = 500
+)
+
+// Ограничения страниц выборок элементов и истории покупок.
+const (
+	defaultDueItemsLimit = 50
+	maxDueItemsLimit     = 200
+)
+
+// currencyPattern описывает код валюты ISO 4217.
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// listColorPattern описывает допустимый цвет списка в формате #rrggbb.
+var listColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// ItemDueReq содержит срок элемента: дату, местное время в часовом поясе TimeZone или момент в формате RFC 3339
+// (см. models.ParseItemDue) и время напоминания в минутах до срока. Пустой At снимает срок.
+type ItemDueReq struct {
+	At             string `json:"at"`
+	TimeZone       string `json:"timeZone"`
+	ReminderOffset *int   `json:"reminderOffset"`
+}
+
+// itemDue разбирает срок. Для пустого At возвращается срок с нулевым моментом, который снимает срок элемента.
+func (d ItemDueReq) itemDue() (*models.ItemDue, error) {
+	if d.At == "" {
+		return &models.ItemDue{}, nil
+	}
+	return models.ParseItemDue(d.At, d.TimeZone, d.ReminderOffset)
+}
+
+// RecurrenceReq содержит правило повторения RRULE, его начало и часовой пояс (см. models.ParseRecurrence).
+// Для элемента пустое правило снимает повторение.
+type RecurrenceReq struct {
+	Rule     string `json:"rule"`
+	Start    string `json:"start"`
+	TimeZone string `json:"timeZone"`
+}
+
+// recurrence разбирает повторение. Для пустого правила возвращается повторение без правила, которое снимает повторение элемента.
+func (rr RecurrenceReq) recurrence() (*models.Recurrence, error) {
+	if rr.Rule == "" {
+		return &models.Recurrence{}, nil
+	}
+	return models.ParseRecurrence(rr.Rule, rr.Start, rr.TimeZone, time.Now())
+}
+
+// ItemDetailsReq содержит необязательные количество, единицу, заметку, цену, приоритет, категорию, срок,
+// повторение и исполнителя элемента списка. Пустой AssigneeId снимает исполнителя.
+type ItemDetailsReq struct {
+	Quantity   *float64       `json:"quantity"`
+	Unit       *string        `json:"unit"`
+	Note       *string        `json:"note"`
+	Price      *float64       `json:"price"`
+	Currency   *string        `json:"currency"`
+	Priority   *string        `json:"priority"`
+	Category   *string        `json:"category"`
+	Due        *ItemDueReq    `json:"due"`
+	Recurrence *RecurrenceReq `json:"recurrence"`
+	AssigneeId *string        `json:"assigneeId"`
+}
+
+// valid проверяет диапазоны чисел и длину строк. Наличие валюты у цены проверяется в модели по сохраненному
+// элементу, чтобы правка могла изменить только цену.
+func (d ItemDetailsReq) valid() bool {
+	if d.Quantity != nil && (*d.Quantity < 0 || *d.Quantity > maxItemQuantity) {
+		return false
+	}
+	if d.Unit != nil && utf8.RuneCountInString(*d.Unit) > maxItemUnitLength {
+		return false
+	}
+	if d.Note != nil && utf8.RuneCountInString(*d.Note) > maxItemNoteLength {
+		return false
+	}
+	if d.Price != nil && (*d.Price < 0 || *d.Price > maxItemPrice) {
+		return false
+	}
+	if d.Currency != nil && *d.Currency != "" && !currencyPattern.MatchString(*d.Currency) {
+		return false
+	}
+	if d.Priority != nil && *d.Priority != "" && *d.Priority != parser.PriorityHigh && *d.Priority != parser.PriorityLow {
+		return false
+	}
+	if d.Category != nil && utf8.RuneCountInString(*d.Category) > maxItemCategoryLength {
+		return false
+	}
+	if d.Due != nil {
+		if _, err := d.Due.itemDue(); err != nil {
+			return false
+		}
+	}
+	if d.Recurrence != nil {
+		if _, err := d.Recurrence.recurrence(); err != nil {
+			return false
+		}
+	}
+	if d.AssigneeId != nil && *d.AssigneeId != "" && !primitive.IsValidObjectID(*d.AssigneeId) {
+		return false
+	}
+	return true
+}
+
+// pricedWithoutCurrency сообщает, что указана цена, но не валюта.
+func (d ItemDetailsReq) pricedWithoutCurrency() bool {
+	return d.Price != nil && *d.Price != 0 && (d.Currency == nil || *d.Currency == "")
+}
+
+// applyToItem заполняет поля нового элемента.
+func (d ItemDetailsReq) applyToItem(li *models.ListItem) {
+	if d.Quantity != nil {
+		li.Quantity = *d.Quantity
+	}
+	if d.Unit != nil {
+		li.Unit = strings.TrimSpace(*d.Unit)
+	}
+	if d.Note != nil {
+		li.Note = *d.Note
+	}
+	if d.Price != nil {
+		li.Price = *d.Price
+	}
+	if d.Currency != nil {
+		li.Currency = *d.Currency
+	}
+	if d.Priority != nil {
+		li.Priority = *d.Priority
+	}
+	if d.Category != nil {
+		li.Category = strings.ToLower(strings.TrimSpace(*d.Category))
+	}
+	if d.Due != nil {
+		if due, err := d.Due.itemDue(); err == nil && !due.At.IsZero() {
+			li.Due = due
+		}
+	}
+	if d.Recurrence != nil {
+		if rec, err := d.Recurrence.recurrence(); err == nil && rec.Rule != "" {
+			li.Recurrence = rec
+		}
+	}
+	if d.AssigneeId != nil {
+		if id, err := primitive.ObjectIDFromHex(*d.AssigneeId); err == nil {
+			li.AssigneeId = &id
+		}
+	}
+}
+
+// applyToPatch переносит заданные поля в правку элемента.
+func (d ItemDetailsReq) applyToPatch(p *models.ListItemPatch) {
+	p.Quantity, p.Unit, p.Note, p.Price, p.Currency = d.Quantity, d.Unit, d.Note, d.Price, d.Currency
+	p.Priority, p.Category = d.Priority, d.Category
+	if d.Due != nil {
+		p.Due, _ = d.Due.itemDue()
+	}
+	if d.Recurrence != nil {
+		p.Recurrence, _ = d.Recurrence.recurrence()
+	}
+	if d.AssigneeId != nil {
+		id, _ := primitive.ObjectIDFromHex(*d.AssigneeId)
+		p.AssigneeId = &id
+	}
+}
+
+// NewListItemReq содержит данные для создания нового элемента списка. ParentId задает родительский элемент подзадачи.
 type NewListItemReq struct {
-	Name        string `json:"name"`
-	ListId      string `json:"listId"`
-	IsCompleted bool   `json:"isCompleted"`
+	Name        string        `json:"name"`
+	ListId      string        `json:"listId"`
+	ParentId    string        `json:"parentId"`
+	IsCompleted bool          `json:"isCompleted"`
+	Clock       hlc.Timestamp `json:"clock"`
+	ItemDetailsReq
+}
+
+// UpdateListItemReq содержит правку элемента списка. Отсутствующие поля не изменяются.
+// Clock - метка времени правки на клиенте, по которой разрешаются одновременные правки.
+type UpdateListItemReq struct {
+	Name        *string       `json:"name"`
+	IsCompleted *bool         `json:"isCompleted"`
+	Clock       hlc.Timestamp `json:"clock"`
+	ItemDetailsReq
+}
+
+// CheckoutReq содержит необязательные параметры завершения покупок: метку времени,
+// на момент которой клиент видел список, элементы, которые он считает купленными, и оплату покупки.
+type CheckoutReq struct {
+	Clock   hlc.Timestamp       `json:"clock"`
+	ItemIds []string            `json:"itemIds"`
+	Payment *CheckoutPaymentReq `json:"payment"`
+}
+
+// ReAddHistoryReq задает, какие купленные элементы записи истории покупок снова добавить и в какой список.
+// Без ItemIds добавляется вся покупка, без ListId - в тот же список.
+type ReAddHistoryReq struct {
+	ListId  string   `json:"listId"`
+	ItemIds []string `json:"itemIds"`
+}
+
+// MoveListItemReq содержит место, куда перемещается элемент списка. ListId задает другой список назначения;
+// элемент ставится после AfterId и перед BeforeId. Без соседей элемент перемещается в конец списка.
+type MoveListItemReq struct {
+	ListId   string `json:"listId"`
+	AfterId  string `json:"afterId"`
+	BeforeId string `json:"beforeId"`
+}
+
+// itemMove преобразует запрос в перемещение модели. Идентификаторы разрешаются функцией resolve.
+func (m MoveListItemReq) itemMove(resolve func(string) (primitive.ObjectID, error)) (models.ItemMove, error) {
+	var move models.ItemMove
+	for _, f := range []struct {
+		id  string
+		dst **primitive.ObjectID
+	}{{m.ListId, &move.ListId}, {m.AfterId, &move.AfterId}, {m.BeforeId, &move.BeforeId}} {
+		if f.id == "" {
+			continue
+		}
+		objId, err := resolve(f.id)
+		if err != nil {
+			return move, err
+		}
+		*f.dst = &objId
+	}
+	return move, nil
+}
+
+// ListRecurrenceReq содержит повторение списка и необязательный шаблон. Без шаблона им становятся текущие элементы списка.
+type ListRecurrenceReq struct {
+	RecurrenceReq
+	Items []models.TemplateItem `json:"items"`
 }
 
 // ShoppingListReq представляет структуру для запроса списка покупок.
@@ -25,10 +272,14 @@ type ShoppingListReq struct {
 }
 
 // ListItemReq представляет структуру для запроса элемента списка.
+// Clock - метка времени последней правки элемента на клиенте: при загрузке изменения уже сохраненного элемента
+// применяются с этой меткой, а без нее не применяются.
 type ListItemReq struct {
-	ID          string `json:"id"`
-	Name        string `json:"name"`
-	IsCompleted bool   `json:"isCompleted"`
+	ID          string        `json:"id"`
+	Name        string        `json:"name"`
+	IsCompleted bool          `json:"isCompleted"`
+	Clock       hlc.Timestamp `json:"clock"`
+	ItemDetailsReq
 }
 
 // ShoppingListsResource представляет ресурс для управления списками покупок.
@@ -39,11 +290,23 @@ func (rs ShoppingListsResource) Routes() chi.Router {
 	r := chi.NewRouter()
 	r.Use(jwtauth.Verifier(tokenAuth))
 	r.Use(Authenticator)
+	r.Use(Idempotent)
 
 	r.Post("/", rs.CreateList)
 	r.Get("/", rs.GetLists)
+	r.Get("/{id}", rs.GetList)
+	r.Put("/{id}", rs.UpdateList)
 	r.Delete("/{id}", rs.DeleteList)
 	r.Post("/checkout/{id}", rs.CheckoutList)
+	r.Put("/{id}/recurrence", rs.SetListRecurrence)
+	r.Delete("/{id}/recurrence", rs.DeleteListRecurrence)
+	r.Post("/{id}/leave", rs.LeaveList)
+	r.Get("/{id}/history", rs.GetListHistory)
+	r.Post("/{id}/history/{checkoutId}/items", rs.ReAddHistoryItems)
+	r.Get("/{id}/balances", rs.GetListBalances)
+	r.Post("/{id}/settlements", rs.CreateSettlement)
+	r.Post("/{id}/settle-up", rs.SettleUpList)
+	r.Delete("/{id}/members/{userId}", rs.DeleteListMember)
 
 	r.Route("/bulk", func(r chi.Router) {
 		r.Post("/", rs.AddLists)
@@ -51,14 +314,19 @@ func (rs ShoppingListsResource) Routes() chi.Router {
 
 	r.Route("/items", func(r chi.Router) {
 		r.Post("/", rs.CreateListItem)
+		r.Get("/parse", rs.ParseListItem)
+		r.Get("/due/{range}", rs.GetDueItems)
+		r.Get("/assigned", rs.GetAssignedItems)
 		r.Delete("/{id}", rs.DeleteListItem)
 		r.Put("/{id}", rs.UpdateListItem)
+		r.Post("/{id}/move", rs.MoveListItem)
 	})
 
 	return r
 }
 
 // GetLists возвращает списки покупок пользователя.
+// С параметром store элементы каждого списка дополнительно группируются по отделам выбранного магазина.
 func (rs ShoppingListsResource) GetLists(w http.ResponseWriter, r *http.Request) {
 	// Извлечение идентификатора пользователя из токена.
 	_, claims, _ := jwtauth.FromContext(r.Context())
@@ -69,15 +337,89 @@ func (rs ShoppingListsResource) GetLists(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Необязательный фильтр по признаку архивного списка.
+	var archived *bool
+	if a := r.URL.Query().Get("archived"); a != "" {
+		v, err := strconv.ParseBool(a)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		archived = &v
+	}
+
+	// Необязательный магазин, в порядке отделов которого группируются элементы.
+	var store *models.Store
+	if s := r.URL.Query().Get("store"); s != "" {
+		storeId, err := primitive.ObjectIDFromHex(s)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		store, err = models.GetStore(storeId, objId)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		} else if store == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	}
+
 	// Получение списков покупок для пользователя из базы данных.
-	items, err := models.AllShoppingLists(objId)
+	items, err := models.AllShoppingLists(objId, archived)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if store != nil {
+		for i := range *items {
+			(*items)[i].Sections = store.Sections((*items)[i].Items)
+		}
+	}
+
+	// Клиент, опрашивающий списки, получит 304, если с прошлого запроса ничего не изменилось.
+	writeJSONWithETag(w, r, items)
+}
+
+// GetList возвращает список покупок пользователя по идентификатору. ETag ответа содержит версию списка.
+func (rs ShoppingListsResource) GetList(w http.ResponseWriter, r *http.Request) {
+	listId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	l, err := models.GetShoppingList(listId, userId)
 	if err != nil {
 		log.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
+	} else if l == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	etag := versionETag(l.Version)
+	w.Header().Set("ETag", etag)
+	if etagMatches(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(items); err != nil {
+	if err := json.NewEncoder(w).Encode(l); err != nil {
 		log.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
 	}
@@ -85,7 +427,10 @@ func (rs ShoppingListsResource) GetLists(w http.ResponseWriter, r *http.Request)
 
 // CreateList создает новый список покупок для пользователя.
 func (rs ShoppingListsResource) CreateList(w http.ResponseWriter, r *http.Request) {
-	l := struct{ Name string }{}
+	l := struct {
+		Name    string
+		GroupId string `json:"groupId"`
+	}{}
 	if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
 		log.Println(err)
 		w.WriteHeader(http.StatusBadRequest)
@@ -101,8 +446,29 @@ func (rs ShoppingListsResource) CreateList(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Проверка, что пользователь состоит в группе, в которой создается список.
+	var groupId *primitive.ObjectID
+	if l.GroupId != "" {
+		gId, err := primitive.ObjectIDFromHex(l.GroupId)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		role, err := models.GroupMemberRole(gId, ownerId)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		} else if role == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		groupId = &gId
+	}
+
 	// Добавление нового списка покупок в базу данных.
-	id, err := models.AddNewShoppingList(l.Name, ownerId)
+	id, err := models.AddNewShoppingList(l.Name, ownerId, groupId)
 	if err != nil {
 		log.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -116,6 +482,91 @@ func (rs ShoppingListsResource) CreateList(w http.ResponseWriter, r *http.Reques
 	}{l.Name, id})
 }
 
+// UpdateList изменяет название, описание, цвет, значок и признак архивного списка.
+func (rs ShoppingListsResource) UpdateList(w http.ResponseWriter, r *http.Request) {
+	listId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var m models.ListMetadata
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Проверка полей списка.
+	if m.Name != nil {
+		trimmed := strings.TrimSpace(*m.Name)
+		m.Name = &trimmed
+		if trimmed == "" || utf8.RuneCountInString(trimmed) > maxListNameLength {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	if m.Description != nil && utf8.RuneCountInString(*m.Description) > maxListDescriptionLength {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if m.Color != nil && *m.Color != "" && !listColorPattern.MatchString(*m.Color) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if m.Icon != nil && utf8.RuneCountInString(*m.Icon) > maxListIconLength {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	versions, ok := ifMatchVersions(r)
+	if !ok {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	// Изменение списка в базе данных.
+	success, err := models.UpdateShoppingList(listId, userId, m, versions)
+	if err == models.ErrVersionMismatch {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	} else if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if !success {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Возвращаем измененный список вместе с его новой версией.
+	l, err := models.GetShoppingList(listId, userId)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if l == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("ETag", versionETag(l.Version))
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(l); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
 // DeleteList удаляет список покупок пользователя.
 func (rs ShoppingListsResource) DeleteList(w http.ResponseWriter, r *http.Request) {
 	listId := chi.URLParam(r, "id")
@@ -129,9 +580,21 @@ func (rs ShoppingListsResource) DeleteList(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	versions, ok := ifMatchVersions(r)
+	if !ok {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
 	// Удаление списка покупок из базы данных.
-	success, err := models.RemoveList(listId, ownerId)
-	if err != nil {
+	success, err := models.RemoveList(listId, ownerId, versions)
+	if err == models.ErrVersionMismatch {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	} else if err == models.ErrUnsettledBalances {
+		w.WriteHeader(http.StatusConflict)
+		return
+	} else if err != nil {
 		log.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -142,7 +605,22 @@ func (rs ShoppingListsResource) DeleteList(w http.ResponseWriter, r *http.Reques
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// AddLists добавляет несколько списков покупок для пользователя.
+// BulkListResult описывает результат загрузки одного списка.
+type BulkListResult struct {
+	ClientId string `json:"clientId"`
+	Id       string `json:"id"`
+	Status   string `json:"status"`
+}
+
+// BulkResp содержит отчет о массовой загрузке и сопоставление идентификаторов клиента с идентификаторами сервера
+// для списков и элементов.
+type BulkResp struct {
+	IdMap map[string]string `json:"idMap"`
+	Lists []BulkListResult  `json:"lists"`
+}
+
+// AddLists объединяет загруженные клиентом списки покупок с сохраненными у пользователя.
+// Повторная загрузка тех же списков не создает дубликатов.
 func (rs ShoppingListsResource) AddLists(w http.ResponseWriter, r *http.Request) {
 	// Извлечение идентификатора пользователя из токена.
 	_, claims, _ := jwtauth.FromContext(r.Context())
@@ -161,37 +639,67 @@ func (rs ShoppingListsResource) AddLists(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Преобразование структур запроса в модели данных и добавление списков в базу данных.
+	// Преобразование структур запроса в модели данных. Идентификатор, который не является
+	// идентификатором сервера, сохраняется как идентификатор клиента.
 	var dbLists []models.ShoppingList
 	for _, l := range lists {
 		newList := models.ShoppingList{
-			ID:               primitive.NewObjectID(),
-			OwnerId:          ownerId,
-			Name:             l.Name,
-			Items:            make([]models.ListItem, 0),
-			SharingIds:       make([]primitive.ObjectID, 0),
-			SharingInviteIds: make([]primitive.ObjectID, 0),
-			SharingNames:     make([]string, 0),
+			ClientId:     l.ID,
+			OwnerId:      ownerId,
+			Name:         l.Name,
+			Items:        make([]models.ListItem, 0),
+			SharingIds:   make([]primitive.ObjectID, 0),
+			CoOwnerIds:   make([]primitive.ObjectID, 0),
+			SharingNames: make([]string, 0),
+		}
+		if id, err := primitive.ObjectIDFromHex(l.ID); err == nil {
+			newList.ID = id
 		}
 		for _, item := range l.Items {
+			// Загруженный элемент передается целиком, поэтому цена должна быть указана вместе с валютой.
+			if !item.valid() || item.pricedWithoutCurrency() {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
 			newItem := models.ListItem{
-				ID:          primitive.NewObjectID(),
+				ClientId:    item.ID,
 				Name:        item.Name,
 				IsCompleted: item.IsCompleted,
+				Clock:       models.NewItemClock(item.Clock),
 			}
+			item.applyToItem(&newItem)
 			newList.Items = append(newList.Items, newItem)
 		}
 		dbLists = append(dbLists, newList)
 	}
 
-	// Добавление списков покупок в базу данных.
-	err = models.AddShoppingLists(dbLists, ownerId)
-	if err != nil {
+	// Объединение списков покупок с сохраненными в базе данных.
+	results, err := models.UpsertShoppingLists(dbLists, ownerId)
+	if err == models.ErrInvalidClock {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if err != nil {
 		log.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+
+	resp := BulkResp{IdMap: make(map[string]string), Lists: make([]BulkListResult, 0, len(results))}
+	for _, res := range results {
+		if res.ClientId != "" && res.Status != models.UpsertSkipped {
+			resp.IdMap[res.ClientId] = res.ID.Hex()
+		}
+		for clientId, id := range res.Items {
+			resp.IdMap[clientId] = id.Hex()
+		}
+		resp.Lists = append(resp.Lists, BulkListResult{ClientId: res.ClientId, Id: res.ID.Hex(), Status: res.Status})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Println(err)
+	}
 }
 
 // CheckoutList отмечает список покупок как завершенный.
@@ -204,94 +712,285 @@ func (rs ShoppingListsResource) CheckoutList(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// Отмечение списка покупок как завершенного в базе данных.
-	err = models.CheckoutList(listObjId)
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
 	if err != nil {
 		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
-}
 
-// CreateListItem создает новый элемент списка покупок.
-func (rs ShoppingListsResource) CreateListItem(w http.ResponseWriter, r *http.Request) {
-	var itemData NewListItemReq
-	if err := json.NewDecoder(r.Body).Decode(&itemData); err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusBadRequest)
+	versions, ok := ifMatchVersions(r)
+	if !ok {
+		w.WriteHeader(http.StatusPreconditionFailed)
 		return
 	}
 
-	// Извлечение идентификатора пользователя из токена.
-	_, claims, _ := jwtauth.FromContext(r.Context())
-	ownerId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
-	if err != nil {
+	// Тело запроса необязательно.
+	var req CheckoutReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
 		log.Println(err)
-		w.WriteHeader(http.StatusUnauthorized)
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	itemIds := make([]primitive.ObjectID, 0, len(req.ItemIds))
+	for _, id := range req.ItemIds {
+		itemId, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		itemIds = append(itemIds, itemId)
+	}
+	var payment *models.CheckoutPayment
+	if req.Payment != nil {
+		if payment, ok = req.Payment.payment(userId, primitive.ObjectIDFromHex); !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
 
-	// Преобразование строкового идентификатора в ObjectID.
-	listId, err := primitive.ObjectIDFromHex(itemData.ListId)
-	if err != nil {
-		log.Println(err)
+	// Отмечение списка покупок как завершенного в базе данных.
+	success, err := models.CheckoutList(listObjId, userId, req.Clock, itemIds, payment, versions)
+	if err == models.ErrInvalidClock || err == models.ErrInvalidPayment {
 		w.WriteHeader(http.StatusBadRequest)
 		return
-	}
-
-	// Добавление нового элемента в список покупок в базе данных.
-	err = models.AddListItem(itemData.Name, ownerId, listId)
-	if err != nil {
+	} else if err == models.ErrVersionMismatch {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	} else if err != nil {
 		log.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
+	} else if !success {
+		w.WriteHeader(http.StatusNotFound)
+		return
 	}
-	w.WriteHeader(http.StatusCreated)
 }
 
-// DeleteListItem удаляет элемент списка покупок.
-func (rs ShoppingListsResource) DeleteListItem(w http.ResponseWriter, r *http.Request) {
-	itemId := chi.URLParam(r, "id")
-
-	// Извлечение идентификатора пользователя из токена.
-	_, claims, _ := jwtauth.FromContext(r.Context())
-	ownerId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+// SetListRecurrence делает список повторяющимся: в моменты повторения завершенные элементы удаляются,
+// а недостающие элементы шаблона добавляются в список.
+func (rs ShoppingListsResource) SetListRecurrence(w http.ResponseWriter, r *http.Request) {
+	listId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
 	if err != nil {
 		log.Println(err)
-		w.WriteHeader(http.StatusUnauthorized)
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	// Удаление элемента списка покупок из базы данных.
-	err = models.RemoveListItem(itemId, ownerId)
-	if err != nil {
+	var req ListRecurrenceReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	w.WriteHeader(http.StatusNoContent)
-}
 
-// UpdateListItem обновляет информацию об элементе списка покупок.
-func (rs ShoppingListsResource) UpdateListItem(w http.ResponseWriter, r *http.Request) {
-	listItemId := chi.URLParam(r, "id")
+	// Проверка повторения и шаблона.
+	now := time.Now()
+	rec, err := models.ParseRecurrence(req.Rule, req.Start, req.TimeZone, now)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for i, t := range req.Items {
+		req.Items[i].Name = strings.TrimSpace(t.Name)
+		if req.Items[i].Name == "" || utf8.RuneCountInString(t.Note) > maxItemNoteLength {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
 
 	// Извлечение идентификатора пользователя из токена.
 	_, claims, _ := jwtauth.FromContext(r.Context())
-	ownerId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
 	if err != nil {
 		log.Println(err)
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
-	// Декодирование запроса с новыми данными об элементе списка.
-	var itemData NewListItemReq
-	if err := json.NewDecoder(r.Body).Decode(&itemData); err != nil {
-		log.Println(err)
+	lr, err := models.SetListRecurrence(listId, userId, *rec, req.Items, now)
+	if err == models.ErrInvalidRecurrence {
 		w.WriteHeader(http.StatusBadRequest)
 		return
-	}
+	} else if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if lr == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(lr); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// DeleteListRecurrence отменяет повторение списка.
+func (rs ShoppingListsResource) DeleteListRecurrence(w http.ResponseWriter, r *http.Request) {
+	listId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	success, err := models.RemoveListRecurrence(listId, userId)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if !success {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CreateListItem создает новый элемент списка покупок.
+func (rs ShoppingListsResource) CreateListItem(w http.ResponseWriter, r *http.Request) {
+	var itemData NewListItemReq
+	if err := json.NewDecoder(r.Body).Decode(&itemData); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if !itemData.valid() {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	ownerId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Преобразование строкового идентификатора в ObjectID.
+	listId, err := primitive.ObjectIDFromHex(itemData.ListId)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Добавление нового элемента в список покупок в базе данных.
+	li := models.ListItem{Name: itemData.Name}
+	itemData.applyToItem(&li)
+	if itemData.ParentId != "" {
+		parentId, err := primitive.ObjectIDFromHex(itemData.ParentId)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		li.ParentId = &parentId
+	}
+	id, err := models.AddListItem(li, ownerId, listId, itemData.Clock)
+	if err == models.ErrInvalidClock || err == models.ErrInvalidParent || err == models.ErrInvalidAssignee ||
+		err == models.ErrMissingCurrency {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if err == models.ErrPositionConflict {
+		w.WriteHeader(http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if id == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		Id string `json:"id"`
+	}{id})
+}
+
+// ParseListItem разбирает текст элемента так же, как при его добавлении, чтобы интерфейс мог показать результат заранее.
+func (rs ShoppingListsResource) ParseListItem(w http.ResponseWriter, r *http.Request) {
+	text := r.URL.Query().Get("text")
+	if strings.TrimSpace(text) == "" || utf8.RuneCountInString(text) > maxParseTextLength {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(parser.ParseItem(text)); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// DeleteListItem удаляет элемент списка покупок вместе с его подзадачами.
+func (rs ShoppingListsResource) DeleteListItem(w http.ResponseWriter, r *http.Request) {
+	itemId := chi.URLParam(r, "id")
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	ownerId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Удаление элемента списка покупок из базы данных.
+	success, err := models.RemoveListItem(itemId, ownerId)
+	if err == models.ErrPositionConflict {
+		w.WriteHeader(http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if !success {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UpdateListItem обновляет информацию об элементе списка покупок.
+func (rs ShoppingListsResource) UpdateListItem(w http.ResponseWriter, r *http.Request) {
+	listItemId := chi.URLParam(r, "id")
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	ownerId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Декодирование запроса с новыми данными об элементе списка.
+	var itemData UpdateListItemReq
+	if err := json.NewDecoder(r.Body).Decode(&itemData); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if !itemData.valid() {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 
 	// Преобразование строкового идентификатора в ObjectID.
 	liId, err := primitive.ObjectIDFromHex(listItemId)
@@ -301,20 +1000,385 @@ func (rs ShoppingListsResource) UpdateListItem(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	// Создание структуры для обновленных данных об элементе списка.
-	li := models.ListItem{
-		ID:          liId,
+	versions, ok := ifMatchVersions(r)
+	if !ok {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	// Создание правки элемента списка.
+	patch := models.ListItemPatch{
 		Name:        itemData.Name,
 		IsCompleted: itemData.IsCompleted,
+		Clock:       itemData.Clock,
+		Versions:    versions,
 	}
+	itemData.applyToPatch(&patch)
 
 	// Обновление данных об элементе списка в базе данных.
-	err = models.ModifyListItem(ownerId, li)
+	li, err := models.ModifyListItem(ownerId, liId, patch)
+	if err == models.ErrInvalidClock || err == models.ErrInvalidAssignee || err == models.ErrMissingCurrency {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if err == models.ErrVersionMismatch {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	} else if err == models.ErrMergeConflict {
+		w.WriteHeader(http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if li == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Возвращаем элемент после слияния, чтобы клиент увидел результат одновременных правок.
+	w.Header().Set("ETag", versionETag(li.Version))
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(li); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// MoveListItem перемещает элемент внутри списка или в другой список пользователя.
+// Если место между указанными соседями изменилось из-за одновременных правок, возвращается 409.
+// Элемент с подзадачами или подзадачу нельзя перенести в другой список: в этом случае также возвращается 409.
+func (rs ShoppingListsResource) MoveListItem(w http.ResponseWriter, r *http.Request) {
+	liId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
 	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var req MoveListItemReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	move, err := req.itemMove(primitive.ObjectIDFromHex)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	versions, ok := ifMatchVersions(r)
+	if !ok {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	move.Versions = versions
+
+	li, err := models.MoveListItem(userId, liId, move)
+	if err == models.ErrPositionConflict || err == models.ErrSubtreeMove {
+		w.WriteHeader(http.StatusConflict)
+		return
+	} else if err == models.ErrVersionMismatch {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	} else if err != nil {
 		log.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
+	} else if li == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("ETag", versionETag(li.Version))
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(li); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// GetDueItems возвращает незавершенные элементы со сроком из всех списков пользователя:
+// просроченные (overdue), на сегодня (today) или на текущую неделю (week).
+// Параметр tz задает часовой пояс, в котором определяются границы дня и недели; limit и offset - страницу выборки.
+func (rs ShoppingListsResource) GetDueItems(w http.ResponseWriter, r *http.Request) {
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	loc := time.UTC
+	if tz := r.URL.Query().Get("tz"); tz != "" {
+		loc, err = time.LoadLocation(tz)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	from, to, ok := models.DueWindow(chi.URLParam(r, "range"), time.Now(), loc)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	limit, offset, ok := itemsPage(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	page, err := models.DueItems(userId, from, to, limit, offset)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// itemsPage возвращает страницу выборки элементов из параметров limit и offset запроса.
+// Слишком большой limit уменьшается до maxDueItemsLimit.
+func itemsPage(r *http.Request) (limit, offset int64, ok bool) {
+	limit = defaultDueItemsLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.ParseInt(l, 10, 64)
+		if err != nil || n < 1 {
+			return 0, 0, false
+		}
+		limit = n
+		if limit > maxDueItemsLimit {
+			limit = maxDueItemsLimit
+		}
+	}
+	if o := r.URL.Query().Get("offset"); o != "" {
+		n, err := strconv.ParseInt(o, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false
+		}
+		offset = n
+	}
+	return limit, offset, true
+}
+
+// GetAssignedItems возвращает элементы всех списков пользователя, назначенные ему.
+// Параметр completed=true включает завершенные элементы; limit и offset задают страницу выборки.
+func (rs ShoppingListsResource) GetAssignedItems(w http.ResponseWriter, r *http.Request) {
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	limit, offset, ok := itemsPage(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	page, err := models.AssignedItems(userId, r.URL.Query().Get("completed") == "true", limit, offset)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// LeaveList закрывает пользователю доступ к списку, который был ему открыт. Назначенные ему элементы освобождаются.
+func (rs ShoppingListsResource) LeaveList(w http.ResponseWriter, r *http.Request) {
+	listId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	success, err := models.LeaveSharedList(listId, userId)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if !success {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteListMember закрывает доступ к списку участнику, которому он был открыт. Назначенные ему элементы освобождаются.
+func (rs ShoppingListsResource) DeleteListMember(w http.ResponseWriter, r *http.Request) {
+	listId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	memberId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "userId"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	success, err := models.RemoveListMember(listId, userId, memberId)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if !success {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetListHistory возвращает историю покупок списка от новых записей к старым; limit и offset задают страницу.
+func (rs ShoppingListsResource) GetListHistory(w http.ResponseWriter, r *http.Request) {
+	listId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	limit, offset, ok := itemsPage(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	page, err := models.ListHistory(listId, userId, limit, offset)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if page == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
 	}
-	w.WriteHeader(http.StatusOK)
 }
 
+// ReAddHistoryItems снова добавляет купленные элементы записи истории покупок в конец списка
+// и возвращает идентификаторы новых элементов.
+func (rs ShoppingListsResource) ReAddHistoryItems(w http.ResponseWriter, r *http.Request) {
+	listId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	checkoutId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "checkoutId"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Тело запроса необязательно.
+	var req ReAddHistoryReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	targetId := listId
+	if req.ListId != "" {
+		if targetId, err = primitive.ObjectIDFromHex(req.ListId); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	itemIds := make([]primitive.ObjectID, 0, len(req.ItemIds))
+	for _, id := range req.ItemIds {
+		itemId, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		itemIds = append(itemIds, itemId)
+	}
+
+	ids, err := models.ReAddCheckoutItems(listId, checkoutId, userId, targetId, itemIds)
+	if err == models.ErrPositionConflict {
+		w.WriteHeader(http.StatusConflict)
+		return
+	} else if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if ids == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		ItemIds []primitive.ObjectID `json:"itemIds"`
+	}{ids})
+}
@@ -0,0 +1,201 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/abel-03/go-todo/catalog"
+	"github.com/abel-03/go-todo/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Ограничения для магазинов.
+const (
+	maxStoreNameLength    = 100
+	maxStoreCategoryCount = 100
+)
+
+// StoresResource представляет ресурс для управления магазинами пользователя и порядком их отделов.
+type StoresResource struct{}
+
+// StoreReq содержит поля для создания и изменения магазина.
+type StoreReq struct {
+	Name          *string  `json:"name"`
+	CategoryOrder []string `json:"categoryOrder"`
+}
+
+// valid проверяет длину названия и порядок отделов: категории не должны быть пустыми или повторяться.
+func (s StoreReq) valid() bool {
+	if s.Name != nil && (*s.Name == "" || utf8.RuneCountInString(*s.Name) > maxStoreNameLength) {
+		return false
+	}
+	if len(s.CategoryOrder) > maxStoreCategoryCount {
+		return false
+	}
+	seen := make(map[string]bool, len(s.CategoryOrder))
+	for _, c := range s.CategoryOrder {
+		if c == "" || utf8.RuneCountInString(c) > maxItemCategoryLength || seen[c] {
+			return false
+		}
+		seen[c] = true
+	}
+	return true
+}
+
+// Routes определяет маршруты для StoresResource.
+func (rs StoresResource) Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Use(jwtauth.Verifier(tokenAuth))
+	r.Use(Authenticator)
+	r.Use(Idempotent)
+
+	r.Get("/", rs.GetStores)
+	r.Post("/", rs.CreateStore)
+	r.Get("/categories", rs.GetCategories)
+	r.Put("/{id}", rs.UpdateStore)
+	r.Delete("/{id}", rs.DeleteStore)
+
+	return r
+}
+
+// GetStores возвращает магазины пользователя.
+func (rs StoresResource) GetStores(w http.ResponseWriter, r *http.Request) {
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	stores, err := models.AllStores(userId)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stores); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// GetCategories возвращает встроенные категории товаров в порядке обхода типичного магазина.
+func (rs StoresResource) GetCategories(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(catalog.Categories); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// CreateStore создает магазин пользователя.
+func (rs StoresResource) CreateStore(w http.ResponseWriter, r *http.Request) {
+	var s StoreReq
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if s.Name == nil || !s.valid() {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	ownerId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	id, err := models.AddStore(*s.Name, ownerId, s.CategoryOrder)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		Name string `json:"name"`
+		Id   string `json:"id"`
+	}{*s.Name, id})
+}
+
+// UpdateStore изменяет название магазина и порядок его отделов.
+func (rs StoresResource) UpdateStore(w http.ResponseWriter, r *http.Request) {
+	storeId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var s StoreReq
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if !s.valid() {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	success, err := models.UpdateStore(storeId, userId, models.StoreUpdate{Name: s.Name, CategoryOrder: s.CategoryOrder})
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if !success {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteStore удаляет магазин пользователя.
+func (rs StoresResource) DeleteStore(w http.ResponseWriter, r *http.Request) {
+	storeId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	success, err := models.RemoveStore(storeId, userId)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if !success {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
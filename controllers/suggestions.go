@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/abel-03/go-todo/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Ограничения для подсказок товаров.
+const (
+	defaultSuggestionsLimit = 10
+	maxSuggestionsLimit     = 50
+	maxSuggestionQuery      = 100
+	defaultRestockDays      = 2
+	maxRestockDays          = 60
+)
+
+// SuggestionsResource представляет ресурс подсказок товаров по истории покупок.
+type SuggestionsResource struct{}
+
+// Routes определяет маршруты для SuggestionsResource.
+func (rs SuggestionsResource) Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Use(jwtauth.Verifier(tokenAuth))
+	r.Use(Authenticator)
+
+	r.Get("/", rs.GetSuggestions)
+	r.Get("/restock", rs.GetRestockSuggestions)
+
+	return r
+}
+
+// suggestionsList разбирает необязательный параметр listId, ограничивающий подсказки историей одного списка.
+func suggestionsList(r *http.Request) (*primitive.ObjectID, bool) {
+	v := r.URL.Query().Get("listId")
+	if v == "" {
+		return nil, true
+	}
+	listId, err := primitive.ObjectIDFromHex(v)
+	if err != nil {
+		return nil, false
+	}
+	return &listId, true
+}
+
+// GetSuggestions дополняет название товара по истории покупок пользователя. Параметр q - начало названия,
+// listId ограничивает подсказки историей одного списка, limit - наибольшее число подсказок.
+func (rs SuggestionsResource) GetSuggestions(w http.ResponseWriter, r *http.Request) {
+	listId, ok := suggestionsList(r)
+	q := r.URL.Query().Get("q")
+	if !ok || utf8.RuneCountInString(q) > maxSuggestionQuery {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	limit := defaultSuggestionsLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		limit = n
+		if limit > maxSuggestionsLimit {
+			limit = maxSuggestionsLimit
+		}
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	suggestions, err := models.ItemSuggestions(userId, listId, q, limit)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if suggestions == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(suggestions); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// GetRestockSuggestions возвращает товары, которые пользователь обычно покупает каждые несколько дней и которые пора
+// купить снова. Параметр days - на сколько дней вперед смотреть, listId ограничивает подсказки историей одного списка.
+func (rs SuggestionsResource) GetRestockSuggestions(w http.ResponseWriter, r *http.Request) {
+	listId, ok := suggestionsList(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	days := defaultRestockDays
+	if d := r.URL.Query().Get("days"); d != "" {
+		n, err := strconv.Atoi(d)
+		if err != nil || n < 0 || n > maxRestockDays {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		days = n
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	restocks, err := models.RestockSuggestions(userId, listId, time.Duration(days)*24*time.Hour)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if restocks == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(restocks); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,382 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/abel-03/go-todo/hlc"
+	"github.com/abel-03/go-todo/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Типы операций, которые клиент может отправить пакетом после работы в офлайн-режиме.
+const (
+	SyncOpCreateList = "createList"
+	SyncOpDeleteList = "deleteList"
+	SyncOpCheckout   = "checkout"
+	SyncOpAddItem    = "addItem"
+	SyncOpUpdateItem = "updateItem"
+	SyncOpRemoveItem = "removeItem"
+	SyncOpMoveItem   = "moveItem"
+)
+
+// Результаты выполнения отдельных операций пакета.
+const (
+	SyncStatusOk       = "ok"
+	SyncStatusNotFound = "not_found"
+	SyncStatusInvalid  = "invalid"
+	SyncStatusConflict = "conflict"
+	SyncStatusError    = "error"
+)
+
+// maxSyncOps - максимальное число операций в одном пакете.
+const maxSyncOps = 500
+
+// syncOpKeyPrefix отделяет идентификаторы операций от ключей Idempotency-Key в общем хранилище ключей.
+const syncOpKeyPrefix = "syncOp:"
+
+// SyncResource представляет ресурс для синхронизации клиентов по курсору изменений.
+type SyncResource struct{}
+
+// SyncOp представляет одну операцию из очереди клиента.
+// OpId - идентификатор операции: операция с уже выполненным OpId повторно не выполняется, а возвращает сохраненный
+// результат в течение config.IdempotencyKeyTTL, поэтому клиент может повторять отправку очереди.
+// ClientId задает временный идентификатор создаваемого списка или элемента,
+// на который могут ссылаться следующие операции того же пакета.
+// Clock - метка времени, когда операция была выполнена на клиенте.
+// AfterId и BeforeId задают соседей элемента при перемещении (см. MoveListItemReq).
+// ParentId задает родительский элемент добавляемой подзадачи, Payment - оплату покупки при завершении покупок.
+type SyncOp struct {
+	OpId        string              `json:"opId"`
+	Type        string              `json:"type"`
+	ClientId    string              `json:"clientId"`
+	ListId      string              `json:"listId"`
+	ItemId      string              `json:"itemId"`
+	ItemIds     []string            `json:"itemIds"`
+	ParentId    string              `json:"parentId"`
+	GroupId     string              `json:"groupId"`
+	Name        *string             `json:"name"`
+	IsCompleted *bool               `json:"isCompleted"`
+	Clock       hlc.Timestamp       `json:"clock"`
+	AfterId     string              `json:"afterId"`
+	BeforeId    string              `json:"beforeId"`
+	Payment     *CheckoutPaymentReq `json:"payment"`
+	ItemDetailsReq
+}
+
+// SyncOpResult представляет результат выполнения операции.
+type SyncOpResult struct {
+	OpId   string `json:"opId"`
+	Status string `json:"status"`
+	Id     string `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// SyncPushReq содержит пакет операций клиента.
+type SyncPushReq struct {
+	Ops []SyncOp `json:"ops"`
+}
+
+// SyncPushResp содержит результаты операций в порядке их следования в пакете.
+type SyncPushResp struct {
+	Results []SyncOpResult `json:"results"`
+}
+
+// Routes определяет маршруты для SyncResource.
+func (rs SyncResource) Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Use(jwtauth.Verifier(tokenAuth))
+	r.Use(Authenticator)
+	r.Use(Idempotent)
+
+	r.Get("/", rs.GetChanges)
+	r.Post("/", rs.PushChanges)
+
+	return r
+}
+
+// GetChanges возвращает изменения списков и элементов, произошедшие после курсора since.
+func (rs SyncResource) GetChanges(w http.ResponseWriter, r *http.Request) {
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var since int64
+	if s := r.URL.Query().Get("since"); s != "" {
+		since, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || since < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	changes, err := models.ChangesSince(userId, since)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(changes); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// PushChanges выполняет пакет операций клиента по порядку и возвращает результат каждой из них.
+// Ошибка одной операции не прерывает выполнение остальных.
+func (rs SyncResource) PushChanges(w http.ResponseWriter, r *http.Request) {
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var req SyncPushReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Ops) > maxSyncOps {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Временные идентификаторы клиента, сопоставленные с созданными на сервере.
+	ids := make(map[string]string)
+	results := make([]SyncOpResult, 0, len(req.Ops))
+	for _, op := range req.Ops {
+		res := applySyncOpOnce(userId, op, ids)
+		res.OpId = op.OpId
+		if res.Status == SyncStatusOk && op.ClientId != "" && res.Id != "" {
+			ids[op.ClientId] = res.Id
+		}
+		results = append(results, res)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(SyncPushResp{Results: results}); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// applySyncOpOnce выполняет операцию пакета, если операция с тем же OpId еще не выполнялась, иначе возвращает
+// результат первого выполнения. Операции без OpId выполняются всегда.
+func applySyncOpOnce(userId primitive.ObjectID, op SyncOp, ids map[string]string) SyncOpResult {
+	if op.OpId == "" {
+		return applySyncOp(userId, op, ids)
+	}
+
+	body, err := json.Marshal(op)
+	if err != nil {
+		log.Println(err)
+		return SyncOpResult{Status: SyncStatusError}
+	}
+	sum := sha256.Sum256(body)
+	opHash := hex.EncodeToString(sum[:])
+
+	key := syncOpKeyPrefix + op.OpId
+	rec, err := models.BeginIdempotentRequest(userId, key, opHash)
+	if err != nil {
+		log.Println(err)
+		return SyncOpResult{Status: SyncStatusError}
+	} else if rec != nil {
+		var res SyncOpResult
+		if rec.RequestHash != opHash {
+			return SyncOpResult{Status: SyncStatusInvalid, Error: "opId reused for a different operation"}
+		} else if rec.Status == 0 {
+			return SyncOpResult{Status: SyncStatusConflict, Error: "operation is in progress"}
+		} else if err := json.Unmarshal(rec.Body, &res); err != nil {
+			log.Println(err)
+			return SyncOpResult{Status: SyncStatusError}
+		}
+		return res
+	}
+
+	res := applySyncOp(userId, op, ids)
+	if res.Status == SyncStatusError {
+		// Операция, завершившаяся ошибкой сервера, выполнится заново при повторе.
+		if err := models.ReleaseIdempotentRequest(userId, key); err != nil {
+			log.Println(err)
+		}
+		return res
+	}
+	if body, err = json.Marshal(res); err == nil {
+		err = models.CompleteIdempotentRequest(userId, key, http.StatusOK, nil, body)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+	return res
+}
+
+// applySyncOp выполняет одну операцию пакета.
+func applySyncOp(userId primitive.ObjectID, op SyncOp, ids map[string]string) SyncOpResult {
+	// resolve заменяет временный идентификатор клиента на идентификатор, созданный ранее в этом пакете.
+	resolve := func(id string) (primitive.ObjectID, error) {
+		if serverId, ok := ids[id]; ok {
+			id = serverId
+		}
+		return primitive.ObjectIDFromHex(id)
+	}
+	invalid := func(err error) SyncOpResult {
+		return SyncOpResult{Status: SyncStatusInvalid, Error: err.Error()}
+	}
+	done := func(success bool, id string, err error) SyncOpResult {
+		if err == models.ErrInvalidClock {
+			return invalid(err)
+		} else if err != nil {
+			log.Println(err)
+			return SyncOpResult{Status: SyncStatusError}
+		} else if !success {
+			return SyncOpResult{Status: SyncStatusNotFound}
+		}
+		return SyncOpResult{Status: SyncStatusOk, Id: id}
+	}
+
+	switch op.Type {
+	case SyncOpCreateList:
+		var groupId *primitive.ObjectID
+		if op.GroupId != "" {
+			gId, err := primitive.ObjectIDFromHex(op.GroupId)
+			if err != nil {
+				return invalid(err)
+			}
+			role, err := models.GroupMemberRole(gId, userId)
+			if err != nil || role == "" {
+				return done(false, "", err)
+			}
+			groupId = &gId
+		}
+		id, err := models.AddNewShoppingList(stringValue(op.Name), userId, groupId)
+		return done(true, id, err)
+
+	case SyncOpDeleteList:
+		listId, err := resolve(op.ListId)
+		if err != nil {
+			return invalid(err)
+		}
+		success, err := models.RemoveList(listId.Hex(), userId, nil)
+		if err == models.ErrUnsettledBalances {
+			return SyncOpResult{Status: SyncStatusConflict, Error: err.Error()}
+		}
+		return done(success, listId.Hex(), err)
+
+	case SyncOpCheckout:
+		listId, err := resolve(op.ListId)
+		if err != nil {
+			return invalid(err)
+		}
+		itemIds := make([]primitive.ObjectID, 0, len(op.ItemIds))
+		for _, id := range op.ItemIds {
+			itemId, err := resolve(id)
+			if err != nil {
+				return invalid(err)
+			}
+			itemIds = append(itemIds, itemId)
+		}
+		var payment *models.CheckoutPayment
+		if op.Payment != nil {
+			var ok bool
+			if payment, ok = op.Payment.payment(userId, resolve); !ok {
+				return SyncOpResult{Status: SyncStatusInvalid, Error: "invalid payment"}
+			}
+		}
+		success, err := models.CheckoutList(listId, userId, op.Clock, itemIds, payment, nil)
+		if err == models.ErrInvalidPayment {
+			return invalid(err)
+		}
+		return done(success, listId.Hex(), err)
+
+	case SyncOpAddItem:
+		listId, err := resolve(op.ListId)
+		if err != nil {
+			return invalid(err)
+		}
+		if !op.valid() {
+			return SyncOpResult{Status: SyncStatusInvalid, Error: "invalid item details"}
+		}
+		li := models.ListItem{Name: stringValue(op.Name)}
+		op.applyToItem(&li)
+		if op.ParentId != "" {
+			parentId, err := resolve(op.ParentId)
+			if err != nil {
+				return invalid(err)
+			}
+			li.ParentId = &parentId
+		}
+		id, err := models.AddListItem(li, userId, listId, op.Clock)
+		if err == models.ErrInvalidParent || err == models.ErrInvalidAssignee || err == models.ErrMissingCurrency {
+			return invalid(err)
+		} else if err == models.ErrPositionConflict {
+			return SyncOpResult{Status: SyncStatusConflict, Error: err.Error()}
+		}
+		return done(id != "", id, err)
+
+	case SyncOpUpdateItem:
+		itemId, err := resolve(op.ItemId)
+		if err != nil {
+			return invalid(err)
+		}
+		if !op.valid() {
+			return SyncOpResult{Status: SyncStatusInvalid, Error: "invalid item details"}
+		}
+		patch := models.ListItemPatch{Name: op.Name, IsCompleted: op.IsCompleted, Clock: op.Clock}
+		op.applyToPatch(&patch)
+		li, err := models.ModifyListItem(userId, itemId, patch)
+		if err == models.ErrInvalidAssignee || err == models.ErrMissingCurrency {
+			return invalid(err)
+		} else if err == models.ErrMergeConflict {
+			return SyncOpResult{Status: SyncStatusConflict, Error: err.Error()}
+		}
+		return done(li != nil, itemId.Hex(), err)
+
+	case SyncOpRemoveItem:
+		itemId, err := resolve(op.ItemId)
+		if err != nil {
+			return invalid(err)
+		}
+		success, err := models.RemoveListItem(itemId.Hex(), userId)
+		if err == models.ErrPositionConflict {
+			return SyncOpResult{Status: SyncStatusConflict, Error: err.Error()}
+		}
+		return done(success, itemId.Hex(), err)
+
+	case SyncOpMoveItem:
+		itemId, err := resolve(op.ItemId)
+		if err != nil {
+			return invalid(err)
+		}
+		move, err := MoveListItemReq{ListId: op.ListId, AfterId: op.AfterId, BeforeId: op.BeforeId}.itemMove(resolve)
+		if err != nil {
+			return invalid(err)
+		}
+		li, err := models.MoveListItem(userId, itemId, move)
+		if err == models.ErrPositionConflict || err == models.ErrSubtreeMove {
+			return SyncOpResult{Status: SyncStatusConflict, Error: err.Error()}
+		}
+		return done(li != nil, itemId.Hex(), err)
+	}
+
+	return SyncOpResult{Status: SyncStatusInvalid, Error: "unknown operation type"}
+}
+
+// stringValue возвращает строку по указателю или пустую строку для nil.
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
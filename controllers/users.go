@@ -0,0 +1,276 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/abel-03/go-todo/models"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/jwtauth/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Ограничения для поиска пользователей и полей профиля.
+const (
+	defaultUserSearchLimit = 10
+	maxUserSearchLimit     = 25
+	maxDisplayNameLength   = 64
+	maxAvatarURLLength     = 2048
+)
+
+// UsersResource представляет ресурс для поиска пользователей и управления профилем.
+type UsersResource struct{}
+
+// BlockUserReq содержит поля для блокировки пользователя по идентификатору или имени.
+type BlockUserReq struct {
+	UserId   string `json:"userId"`
+	UserName string `json:"userName"`
+}
+
+// Routes определяет маршруты для UsersResource.
+func (rs UsersResource) Routes() chi.Router {
+	r := chi.NewRouter()
+	r.Use(jwtauth.Verifier(tokenAuth))
+	r.Use(Authenticator)
+	r.Use(Idempotent)
+
+	r.Get("/search", rs.SearchUsers)
+	r.Get("/me", rs.GetProfile)
+	r.Put("/me", rs.UpdateProfile)
+
+	r.Route("/blocks", func(r chi.Router) {
+		r.Get("/", rs.GetBlockedUsers)
+		r.Post("/", rs.BlockUser)
+		r.Delete("/{id}", rs.UnblockUser)
+	})
+
+	return r
+}
+
+// SearchUsers ищет пользователей по началу имени или отображаемого имени.
+func (rs UsersResource) SearchUsers(w http.ResponseWriter, r *http.Request) {
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	limit := int64(defaultUserSearchLimit)
+	if l := r.URL.Query().Get("limit"); l != "" {
+		limit, err = strconv.ParseInt(l, 10, 64)
+		if err != nil || limit < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if limit > maxUserSearchLimit {
+			limit = maxUserSearchLimit
+		}
+	}
+
+	users, err := models.SearchUsers(q, userId, limit)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(users); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// GetProfile возвращает профиль текущего пользователя.
+func (rs UsersResource) GetProfile(w http.ResponseWriter, r *http.Request) {
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	u, err := models.GetUser(userId)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if u == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Хеш пароля никогда не отправляется клиенту.
+	u.Password = ""
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(u); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// UpdateProfile изменяет отображаемое имя, аватар и видимость пользователя в поиске.
+func (rs UsersResource) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	var p models.UserProfile
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Проверка длины отображаемого имени и адреса аватара.
+	if p.DisplayName != nil {
+		trimmed := strings.TrimSpace(*p.DisplayName)
+		p.DisplayName = &trimmed
+		if utf8.RuneCountInString(trimmed) > maxDisplayNameLength {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	if p.AvatarURL != nil && *p.AvatarURL != "" && !isValidAvatarURL(*p.AvatarURL) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if err := models.UpdateUserProfile(userId, p); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// isValidAvatarURL проверяет, что адрес аватара - абсолютный http(s) URL разумной длины.
+func isValidAvatarURL(s string) bool {
+	if len(s) > maxAvatarURLLength {
+		return false
+	}
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// GetBlockedUsers возвращает пользователей, заблокированных текущим пользователем.
+func (rs UsersResource) GetBlockedUsers(w http.ResponseWriter, r *http.Request) {
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	users, err := models.AllBlockedUsers(userId)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(users); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// BlockUser блокирует пользователя: его приглашения больше не доставляются.
+func (rs UsersResource) BlockUser(w http.ResponseWriter, r *http.Request) {
+	var req BlockUserReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Получение идентификатора блокируемого пользователя.
+	var blockedId primitive.ObjectID
+	if req.UserId != "" {
+		blockedId, err = primitive.ObjectIDFromHex(req.UserId)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	} else {
+		blockedId, err = models.GetUserIdByName(req.UserName)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+	}
+	if blockedId == userId {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := models.BlockUser(userId, blockedId); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// UnblockUser снимает блокировку с пользователя.
+func (rs UsersResource) UnblockUser(w http.ResponseWriter, r *http.Request) {
+	// Извлечение идентификатора пользователя из токена.
+	_, claims, _ := jwtauth.FromContext(r.Context())
+	userId, err := primitive.ObjectIDFromHex(claims["userId"].(string))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	blockedId, err := primitive.ObjectIDFromHex(chi.URLParam(r, "id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	success, err := models.UnblockUser(userId, blockedId)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if !success {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,126 @@
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Типы событий, которые получают участники списка.
+const (
+	ItemAdded       = "item.added"
+	ItemModified    = "item.modified"
+	ItemRemoved     = "item.removed"
+	ItemMoved       = "item.moved"
+	ListCreated     = "list.created"
+	ListUpdated     = "list.updated"
+	ListShared      = "list.shared"
+	ListUnshared    = "list.unshared"
+	ListCheckedOut  = "list.checkedOut"
+	ListSettled     = "list.settled"
+	ListRegenerated = "list.regenerated"
+	ListDeleted     = "list.deleted"
+
+	NotificationCreated = "notification.created"
+)
+
+// subscriberBuffer - сколько событий может ожидать доставки одному подписчику.
+const subscriberBuffer = 64
+
+// Event представляет изменение списка, о котором нужно уведомить его участников.
+type Event struct {
+	ID         uint64               `json:"id"`
+	Type       string               `json:"type"`
+	ListId     primitive.ObjectID   `json:"listId"`
+	ItemId     *primitive.ObjectID  `json:"itemId,omitempty"`
+	ActorId    primitive.ObjectID   `json:"actorId"`
+	Time       time.Time            `json:"time"`
+	Data       interface{}          `json:"data,omitempty"`
+	Recipients []primitive.ObjectID `json:"-"`
+}
+
+// Subscription представляет подписку пользователя на события его списков.
+// Канал C закрывается, когда подписка отменена или подписчик не успевает читать события.
+type Subscription struct {
+	UserId primitive.ObjectID
+	C      <-chan Event
+
+	c   chan Event
+	bus *Bus
+}
+
+// Bus - внутренняя шина событий, рассылающая события подписчикам в пределах процесса.
+type Bus struct {
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	nextId uint64
+}
+
+// Default - шина событий, в которую публикуют изменения модели.
+var Default = NewBus()
+
+// NewBus создает пустую шину событий.
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscribe подписывает пользователя на события списков, участником которых он является.
+func (b *Bus) Subscribe(userId primitive.ObjectID) *Subscription {
+	c := make(chan Event, subscriberBuffer)
+	s := &Subscription{UserId: userId, C: c, c: c, bus: b}
+
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+// Close отменяет подписку. Повторный вызов безопасен.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.remove(s)
+}
+
+// remove удаляет подписку и закрывает ее канал. Вызывается под блокировкой шины.
+func (b *Bus) remove(s *Subscription) {
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.c)
+	}
+}
+
+// Publish рассылает событие всем подписчикам из числа получателей события.
+// Публикация никогда не блокируется: подписчик с переполненным буфером отключается,
+// и клиент после переподключения должен заново загрузить списки.
+func (b *Bus) Publish(e Event) {
+	e.ID = atomic.AddUint64(&b.nextId, 1)
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	recipients := make(map[primitive.ObjectID]struct{}, len(e.Recipients))
+	for _, id := range e.Recipients {
+		recipients[id] = struct{}{}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subs {
+		if _, ok := recipients[s.UserId]; !ok {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			b.remove(s)
+		}
+	}
+}
+
+// Publish публикует событие в шину по умолчанию.
+func Publish(e Event) {
+	Default.Publish(e)
+}
@@ -2,8 +2,11 @@ import { Outlet } from "react-router-dom";
 import { Footer } from "./Footer";
 import { Header } from "./Header";
 import { Box } from "@mui/material";
+import { useListEvents } from "../hooks/useListEvents";
 
 export const PageLayout = () => {
+  useListEvents();
+
   return (
     <Box sx={{ minHeight: "100vh", display: "flex", flexDirection: "column" }}>
       <Header />
@@ -84,9 +84,13 @@ export default function ManageListDialog({
         })
       );
     } catch (err) {
+      // 409 means members still owe each other money on this list.
+      const unsettled = (err as { status?: unknown }).status === 409;
       dispatch(
         displaySnackBar({
-          msg: "Error deleting list",
+          msg: unsettled
+            ? "Settle up balances before deleting this list"
+            : "Error deleting list",
           severity: MsgSeverity.Error,
         })
       );
@@ -58,7 +58,7 @@ export default function NewListDialog() {
         name: data.name,
         items: [],
         sharingIds: [],
-        sharingInviteIds: [],
+        coOwnerIds: [],
         sharingNames: [],
       };
       dispatch(addNewVisitorList(newList));
@@ -50,16 +50,16 @@ export default function ShowAllLists({ lists }: ListSelectProps) {
                 {auth.user && (
                   <>
                     {list.ownerName.length > 0 &&
-                      list.ownerName !== auth.user.name && (
+                      list.ownerId !== auth.user.userId && (
                         <Typography>Owner: {list.ownerName}</Typography>
                       )}
-                    {list.ownerName === auth.user.name &&
+                    {list.ownerId === auth.user.userId &&
                       list.sharingNames.length > 0 && (
                         <Typography>
                           Shared with: {list.sharingNames}
                         </Typography>
                       )}
-                    {list.sharingInviteIds?.length > 0 && (
+                    {(list.pendingInviteCount ?? 0) > 0 && (
                       <Typography>Pending share invite</Typography>
                     )}
                   </>
@@ -0,0 +1,38 @@
+import { useEffect } from "react";
+import { useDispatch } from "react-redux";
+import { api } from "../store/api";
+import { useAuth } from "./useAuth";
+
+// Every list event type published by the server (see events/bus.go). notification.created does not
+// change lists and is not subscribed to.
+const listEventTypes = [
+  "item.added",
+  "item.modified",
+  "item.removed",
+  "item.moved",
+  "list.created",
+  "list.updated",
+  "list.shared",
+  "list.unshared",
+  "list.checkedOut",
+  "list.settled",
+  "list.regenerated",
+  "list.deleted",
+];
+// Subscribes to server-sent list events and refetches lists when a member changes them.
+export const useListEvents = () => {
+  const dispatch = useDispatch();
+  const auth = useAuth();
+
+  useEffect(() => {
+    if (!auth.user.userId) {
+      return;
+    }
+
+    const source = new EventSource("/api/events");
+    const refetch = () => dispatch(api.util.invalidateTags(["ShoppingList"]));
+    listEventTypes.forEach((type) => source.addEventListener(type, refetch));
+
+    return () => source.close();
+  }, [auth.user.userId, dispatch]);
+};
@@ -10,22 +10,230 @@ export interface ShoppingListItem {
   id: string;
   name: string;
   isCompleted: boolean;
+  quantity?: number;
+  unit?: string;
+  note?: string;
+  price?: number;
+  currency?: string;
+  priority?: "high" | "low";
+  category?: string;
+  position?: string;
+  parentId?: string;
+  children?: ShoppingListItem[];
+  assigneeId?: string;
+  assigneeName?: string;
+  due?: ShoppingListItemDue;
+  recurrence?: Recurrence;
+  hiddenUntil?: string;
+  version?: number;
+}
+
+export interface Recurrence {
+  rule: string;
+  start: string;
+  timeZone: string;
+}
+
+export interface ShoppingListRecurrence extends Recurrence {
+  nextAt: string;
+  template: Omit<
+    ShoppingListItem,
+    | "id"
+    | "isCompleted"
+    | "parentId"
+    | "children"
+    | "assigneeId"
+    | "assigneeName"
+  >[];
+}
+
+export interface ShoppingListItemDue {
+  at: string;
+  date?: string;
+  timeZone: string;
+  reminderOffset?: number;
 }
 
 export interface NewListItemRequest {
   name: string;
   listId: string;
+  parentId?: string;
 }
 
 export interface ShoppingList {
   id: string;
   ownerId: string | null;
   ownerName: string;
+  ownerAvatarUrl?: string;
   name: string;
+  description?: string;
+  color?: string;
+  icon?: string;
+  archived?: boolean;
   items: ShoppingListItem[];
   sharingIds: string[];
-  sharingInviteIds: string[];
+  coOwnerIds: string[];
   sharingNames: string[];
+  sharingAvatarUrls?: string[];
+  groupId?: string | null;
+  groupName?: string;
+  version?: number;
+  pendingInviteCount?: number;
+  recurrence?: ShoppingListRecurrence;
+  totals?: ShoppingListTotals;
+  sections?: ShoppingListSection[];
+}
+
+export interface ShoppingListSection {
+  category: string;
+  items: ShoppingListItem[];
+}
+
+export interface InboxNotification {
+  id: string;
+  key: string;
+  title: string;
+  body: string;
+  listId?: string;
+  itemId?: string;
+  createdAt: string;
+  readAt?: string;
+}
+
+export interface NotificationSettings {
+  channels: ("inbox" | "push" | "email" | "webhook")[] | null;
+  email?: string;
+  webhookUrl?: string;
+  webhookSecret?: string;
+  quietHours?: { start: string; end: string; timeZone: string };
+  mutedListIds: string[];
+}
+
+export interface CalendarImportResult {
+  itemIds: string[];
+  skipped: number;
+  noRecurrence: number;
+}
+
+export interface PurchasedItem {
+  itemId: string;
+  name: string;
+  quantity?: number;
+  unit?: string;
+  note?: string;
+  price?: number;
+  currency?: string;
+  category?: string;
+  assigneeId?: string;
+}
+
+export interface Checkout {
+  id: string;
+  listId: string;
+  userId?: string;
+  userName?: string;
+  time: string;
+  items: PurchasedItem[];
+  payment?: CheckoutPayment;
+}
+
+export interface PaymentShare {
+  userId: string;
+  amount: number;
+}
+
+export interface CheckoutPayment {
+  payerId: string;
+  amount: number;
+  currency: string;
+  shares: PaymentShare[];
+}
+
+export interface CheckoutPage {
+  checkouts: Checkout[];
+  total: number;
+  limit: number;
+  offset: number;
+}
+
+export interface ItemSuggestion {
+  name: string;
+  category?: string;
+  quantity?: number;
+  unit?: string;
+  count: number;
+  lastBought: string;
+  score: number;
+}
+
+export interface RestockSuggestion extends ItemSuggestion {
+  intervalDays: number;
+  due: string;
+}
+
+export interface Spending {
+  checkouts: number;
+  items: number;
+  quantity: number;
+  spent: Record<string, number>;
+}
+
+export interface MonthlySpending extends Spending {
+  month: string;
+}
+
+export interface CategorySpending extends Spending {
+  category: string;
+}
+
+export interface TopItem extends Spending {
+  name: string;
+}
+
+export interface MemberSpending extends Spending {
+  userId: string | null;
+  userName?: string;
+}
+
+export interface BasketStats extends Spending {
+  averageItems: number;
+  averageSpent: Record<string, number>;
+}
+
+export interface Settlement {
+  id: string;
+  listId: string;
+  fromId: string;
+  toId: string;
+  amount: number;
+  currency: string;
+  time: string;
+  createdBy: string;
+}
+
+export interface MemberBalance {
+  userId: string;
+  currency: string;
+  amount: number;
+}
+
+export interface SettlementTransfer {
+  fromId: string;
+  toId: string;
+  currency: string;
+  amount: number;
+}
+
+export interface BalanceSheet {
+  balances: MemberBalance[];
+  transfers: SettlementTransfer[];
+}
+
+export interface ShoppingListTotals {
+  itemCount: number;
+  completedCount: number;
+  estimatedCost: Record<string, number>;
+  remainingCost: Record<string, number>;
 }
 
 export interface LoginRequest {
@@ -0,0 +1,138 @@
+// Package hlc реализует гибридные логические часы (HLC). Метки времени упорядочивают
+// правки разных клиентов так, чтобы слияние по принципу "побеждает последняя запись" было детерминированным.
+package hlc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrInvalidTimestamp возвращается, если строка не является меткой времени HLC.
+var ErrInvalidTimestamp = errors.New("invalid hlc timestamp")
+
+// Timestamp - метка времени HLC вида "<физическое время, мс>-<логический счетчик>-<узел>".
+// Физическое время и счетчик записываются шестнадцатеричными числами фиксированной ширины,
+// поэтому метки можно сравнивать как строки, в том числе в запросах MongoDB.
+// Узел разрешает равенство меток, выданных разными клиентами.
+type Timestamp string
+
+// timestampPattern описывает допустимый формат метки времени.
+var timestampPattern = regexp.MustCompile(`^([0-9a-f]{12})-([0-9a-f]{6})-([0-9A-Za-z_]{1,32})$`)
+
+// maxLogical - максимальное значение логического счетчика, помещающееся в формат метки.
+const maxLogical = 1<<24 - 1
+
+// Format собирает метку времени из физического времени в миллисекундах, счетчика и идентификатора узла.
+func Format(wall int64, logical uint32, node string) Timestamp {
+	return Timestamp(fmt.Sprintf("%012x-%06x-%s", wall, logical, node))
+}
+
+// Parse разбирает метку времени на составляющие.
+func Parse(ts Timestamp) (wall int64, logical uint32, node string, err error) {
+	m := timestampPattern.FindStringSubmatch(string(ts))
+	if m == nil {
+		return 0, 0, "", ErrInvalidTimestamp
+	}
+	wall, _ = strconv.ParseInt(m[1], 16, 64)
+	l, _ := strconv.ParseUint(m[2], 16, 32)
+	return wall, uint32(l), m[3], nil
+}
+
+// Time возвращает физическое время метки.
+func (ts Timestamp) Time() time.Time {
+	wall, _, _, err := Parse(ts)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(wall)
+}
+
+// Max возвращает более позднюю из меток. Пустая метка меньше любой другой.
+func Max(a, b Timestamp) Timestamp {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Clock - гибридные логические часы одного узла.
+type Clock struct {
+	mu      sync.Mutex
+	node    string
+	wall    int64
+	logical uint32
+}
+
+// Default - часы сервера, которыми помечаются правки без собственной метки клиента.
+var Default = NewClock(randomNode())
+
+// NewClock создает часы узла с заданным идентификатором.
+func NewClock(node string) *Clock {
+	return &Clock{node: node}
+}
+
+// randomNode возвращает случайный идентификатор узла, чтобы метки разных экземпляров сервера не совпадали.
+func randomNode() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "server"
+	}
+	return "s" + hex.EncodeToString(b)
+}
+
+// Now выдает новую метку времени, которая больше всех ранее выданных и полученных этими часами.
+func (c *Clock) Now() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pt := time.Now().UnixMilli()
+	if pt > c.wall {
+		c.wall, c.logical = pt, 0
+	} else {
+		c.tick()
+	}
+	return Format(c.wall, c.logical, c.node)
+}
+
+// Update учитывает метку, полученную от другого узла, чтобы следующие метки этих часов были больше нее.
+func (c *Clock) Update(remote Timestamp) error {
+	rw, rl, _, err := Parse(remote)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pt := time.Now().UnixMilli()
+	switch {
+	case pt > c.wall && pt > rw:
+		c.wall, c.logical = pt, 0
+	case rw > c.wall:
+		c.wall, c.logical = rw, rl
+		c.tick()
+	case rw == c.wall:
+		if rl > c.logical {
+			c.logical = rl
+		}
+		c.tick()
+	default:
+		c.tick()
+	}
+	return nil
+}
+
+// tick увеличивает логический счетчик, а при его переполнении переходит к следующей миллисекунде.
+func (c *Clock) tick() {
+	if c.logical == maxLogical {
+		c.wall, c.logical = c.wall+1, 0
+		return
+	}
+	c.logical++
+}
@@ -0,0 +1,115 @@
+package hlc
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+// stamp - случайная корректная метка времени для testing/quick.
+type stamp struct {
+	wall    int64
+	logical uint32
+	node    string
+}
+
+func (stamp) Generate(r *rand.Rand, _ int) reflect.Value {
+	const alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz_"
+	node := make([]byte, 1+r.Intn(8))
+	for i := range node {
+		node[i] = alphabet[r.Intn(len(alphabet))]
+	}
+	// Небольшие диапазоны, чтобы совпадения физического времени и счетчика встречались часто.
+	return reflect.ValueOf(stamp{wall: r.Int63n(1 << 4), logical: uint32(r.Intn(1 << 3)), node: string(node)})
+}
+
+func (s stamp) ts() Timestamp {
+	return Format(s.wall, s.logical, s.node)
+}
+
+func TestFormatParseRoundTrip(t *testing.T) {
+	f := func(s stamp) bool {
+		wall, logical, node, err := Parse(s.ts())
+		return err == nil && wall == s.wall && logical == s.logical && node == s.node
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, ts := range []Timestamp{"", "0", "00000000000g-000000-n", "000000000000-000000-", "000000000000-000000-a.b"} {
+		if _, _, _, err := Parse(ts); err != ErrInvalidTimestamp {
+			t.Errorf("Parse(%q) = %v, want ErrInvalidTimestamp", ts, err)
+		}
+	}
+}
+
+// Строковое сравнение меток совпадает со сравнением физического времени, затем счетчика.
+func TestTimestampOrder(t *testing.T) {
+	f := func(a, b stamp) bool {
+		if a.wall != b.wall || a.logical != b.logical {
+			tupleLess := a.wall < b.wall || (a.wall == b.wall && a.logical < b.logical)
+			return (a.ts() < b.ts()) == tupleLess
+		}
+		return (a.ts() < b.ts()) == (a.node < b.node)
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// Результат Max не зависит от порядка, в котором приходят метки.
+func TestMaxOrderIndependent(t *testing.T) {
+	f := func(stamps []stamp, seed int64) bool {
+		want := Timestamp("")
+		for _, s := range stamps {
+			want = Max(want, s.ts())
+		}
+		got := Timestamp("")
+		for _, i := range rand.New(rand.NewSource(seed)).Perm(len(stamps)) {
+			got = Max(got, stamps[i].ts())
+		}
+		return got == want
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// Каждая новая метка часов больше всех выданных ими и всех полученных от других узлов.
+func TestClockMonotonic(t *testing.T) {
+	f := func(remotes []stamp, updates []bool) bool {
+		c := NewClock("local")
+		last := Timestamp("")
+		for i, r := range remotes {
+			if i < len(updates) && updates[i] {
+				// Метки других узлов немного отстают от физического времени или опережают его.
+				remote := Format(time.Now().UnixMilli()+r.wall-8, r.logical, r.node)
+				if err := c.Update(remote); err != nil {
+					return false
+				}
+				last = Max(last, remote)
+			}
+			now := c.Now()
+			if now <= last {
+				return false
+			}
+			last = now
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClockTickOverflow(t *testing.T) {
+	c := &Clock{node: "n", wall: 1 << 45, logical: maxLogical}
+	before := Format(c.wall, c.logical, c.node)
+	if ts := c.Now(); ts <= before {
+		t.Errorf("Now() = %s, want after %s", ts, before)
+	}
+}
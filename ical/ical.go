@@ -0,0 +1,628 @@
+// Package ical читает и записывает календари iCalendar (RFC 5545) с задачами VTODO и событиями VEVENT.
+//
+// Поддерживаются свойства, нужные для обмена элементами списков: название, описание, категории, приоритет,
+// срок, статус завершения и правило повторения. Остальные свойства при чтении пропускаются.
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Статусы задачи.
+const (
+	StatusNeedsAction = "NEEDS-ACTION"
+	StatusCompleted   = "COMPLETED"
+	StatusInProcess   = "IN-PROCESS"
+	StatusCancelled   = "CANCELLED"
+)
+
+// Форматы дат и времени iCalendar.
+const (
+	dateLayout     = "20060102"
+	dateTimeLayout = "20060102T150405"
+	utcLayout      = "20060102T150405Z"
+)
+
+// timezoneYears - на сколько лет после последнего момента календаря описываются переходы часового пояса в VTIMEZONE.
+// Повторения после этого срока читатель рассчитывает по последнему описанному смещению.
+const timezoneYears = 10
+
+// maxLineOctets - наибольшая длина строки содержимого без перевода строки (RFC 5545, раздел 3.1).
+const maxLineOctets = 75
+
+// ErrInvalidCalendar возвращается, если данные не являются календарем iCalendar.
+var ErrInvalidCalendar = errors.New("invalid iCalendar data")
+
+// DateTime - дата или момент iCalendar. Для даты без времени DateOnly равен true, а Time - полночь этой даты.
+// Момент в UTC записывается с суффиксом Z, в другом часовом поясе - с параметром TZID, содержащим имя пояса IANA;
+// для каждого такого пояса календарь содержит компонент VTIMEZONE (RFC 5545, раздел 3.6.5).
+// Floating - момент без часового пояса, который относится к местному времени читателя; при чтении он
+// возвращается в UTC.
+type DateTime struct {
+	Time     time.Time
+	DateOnly bool
+	Floating bool
+}
+
+// Todo - задача VTODO.
+type Todo struct {
+	UID         string
+	Summary     string
+	Description string
+	Categories  []string
+	Priority    int
+	Status      string
+	Completed   *time.Time
+	Start       *DateTime
+	Due         *DateTime
+	RRule       string
+	Stamp       time.Time
+}
+
+// Event - событие VEVENT. Если End не задан, событие длится Duration.
+type Event struct {
+	UID         string
+	Summary     string
+	Description string
+	Categories  []string
+	Start       DateTime
+	End         *DateTime
+	Duration    time.Duration
+	RRule       string
+	Stamp       time.Time
+}
+
+// Calendar - календарь VCALENDAR. Name выводится в свойстве X-WR-CALNAME, которое приложения показывают
+// как название подписки.
+type Calendar struct {
+	ProdID string
+	Name   string
+	Todos  []Todo
+	Events []Event
+}
+
+// Encode записывает календарь в w.
+func (c Calendar) Encode(w io.Writer) error {
+	e := encoder{w: bufio.NewWriter(w)}
+	e.line("BEGIN", nil, "VCALENDAR")
+	e.line("VERSION", nil, "2.0")
+	e.line("PRODID", nil, c.ProdID)
+	e.line("CALSCALE", nil, "GREGORIAN")
+	if c.Name != "" {
+		e.line("X-WR-CALNAME", nil, escapeText(c.Name))
+	}
+	for _, z := range c.zones() {
+		e.timezone(z)
+	}
+	for _, t := range c.Todos {
+		e.line("BEGIN", nil, "VTODO")
+		e.line("UID", nil, t.UID)
+		e.line("DTSTAMP", nil, t.Stamp.UTC().Format(utcLayout))
+		e.line("SUMMARY", nil, escapeText(t.Summary))
+		if t.Description != "" {
+			e.line("DESCRIPTION", nil, escapeText(t.Description))
+		}
+		if len(t.Categories) > 0 {
+			e.line("CATEGORIES", nil, escapeList(t.Categories))
+		}
+		if t.Priority > 0 {
+			e.line("PRIORITY", nil, strconv.Itoa(t.Priority))
+		}
+		if t.Start != nil {
+			e.dateTime("DTSTART", *t.Start)
+		}
+		if t.Due != nil {
+			e.dateTime("DUE", *t.Due)
+		}
+		if t.RRule != "" {
+			e.line("RRULE", nil, t.RRule)
+		}
+		if t.Status != "" {
+			e.line("STATUS", nil, t.Status)
+		}
+		if t.Completed != nil {
+			e.line("COMPLETED", nil, t.Completed.UTC().Format(utcLayout))
+			e.line("PERCENT-COMPLETE", nil, "100")
+		}
+		e.line("END", nil, "VTODO")
+	}
+	for _, ev := range c.Events {
+		e.line("BEGIN", nil, "VEVENT")
+		e.line("UID", nil, ev.UID)
+		e.line("DTSTAMP", nil, ev.Stamp.UTC().Format(utcLayout))
+		e.line("SUMMARY", nil, escapeText(ev.Summary))
+		if ev.Description != "" {
+			e.line("DESCRIPTION", nil, escapeText(ev.Description))
+		}
+		if len(ev.Categories) > 0 {
+			e.line("CATEGORIES", nil, escapeList(ev.Categories))
+		}
+		e.dateTime("DTSTART", ev.Start)
+		if ev.End != nil {
+			e.dateTime("DTEND", *ev.End)
+		} else if ev.Duration > 0 {
+			e.line("DURATION", nil, formatDuration(ev.Duration))
+		}
+		if ev.RRule != "" {
+			e.line("RRULE", nil, ev.RRule)
+		}
+		e.line("END", nil, "VEVENT")
+	}
+	e.line("END", nil, "VCALENDAR")
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+// zone - часовой пояс, моменты в котором встречаются в календаре, и интервал этих моментов.
+type zone struct {
+	loc      *time.Location
+	from, to time.Time
+}
+
+// zones возвращает часовые пояса моментов календаря, записываемых с параметром TZID, в порядке их имен.
+func (c Calendar) zones() []zone {
+	byName := make(map[string]*zone)
+	add := func(dt *DateTime) {
+		if dt == nil || dt.DateOnly || dt.Floating || dt.Time.Location() == time.UTC {
+			return
+		}
+		name := dt.Time.Location().String()
+		z, ok := byName[name]
+		if !ok {
+			byName[name] = &zone{loc: dt.Time.Location(), from: dt.Time, to: dt.Time}
+			return
+		}
+		if dt.Time.Before(z.from) {
+			z.from = dt.Time
+		}
+		if dt.Time.After(z.to) {
+			z.to = dt.Time
+		}
+	}
+	for i := range c.Todos {
+		add(c.Todos[i].Start)
+		add(c.Todos[i].Due)
+	}
+	for i := range c.Events {
+		add(&c.Events[i].Start)
+		add(c.Events[i].End)
+	}
+
+	zones := make([]zone, 0, len(byName))
+	for _, z := range byName {
+		zones = append(zones, *z)
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].loc.String() < zones[j].loc.String() })
+	return zones
+}
+
+// timezone записывает компонент VTIMEZONE с переходами пояса z от начала года первого момента
+// на timezoneYears лет после последнего. Каждый переход записывается отдельным компонентом STANDARD
+// или DAYLIGHT без правила повторения.
+func (e *encoder) timezone(z zone) {
+	e.line("BEGIN", nil, "VTIMEZONE")
+	e.line("TZID", nil, z.loc.String())
+
+	t := time.Date(z.from.In(z.loc).Year(), time.January, 1, 0, 0, 0, 0, z.loc)
+	limit := time.Date(z.to.In(z.loc).Year()+timezoneYears, time.January, 1, 0, 0, 0, 0, z.loc)
+	onset, end := t.ZoneBounds()
+	_, offsetFrom := t.Zone()
+	if !onset.IsZero() {
+		_, offsetFrom = onset.Add(-time.Second).Zone()
+	}
+	for {
+		name, offset := t.Zone()
+		kind := "STANDARD"
+		if t.IsDST() {
+			kind = "DAYLIGHT"
+		}
+		e.line("BEGIN", nil, kind)
+		if onset.IsZero() {
+			// Пояс никогда не менял смещения до этого момента.
+			e.line("DTSTART", nil, "19700101T000000")
+		} else {
+			// Начало перехода записывается в местном времени, действовавшем до него.
+			e.line("DTSTART", nil, onset.In(time.FixedZone("", offsetFrom)).Format(dateTimeLayout))
+		}
+		e.line("TZOFFSETFROM", nil, formatOffset(offsetFrom))
+		e.line("TZOFFSETTO", nil, formatOffset(offset))
+		e.line("TZNAME", nil, escapeText(name))
+		e.line("END", nil, kind)
+
+		if end.IsZero() || !end.Before(limit) {
+			break
+		}
+		t, offsetFrom = end, offset
+		onset, end = t.ZoneBounds()
+	}
+	e.line("END", nil, "VTIMEZONE")
+}
+
+// formatOffset записывает смещение от UTC в формате UTC-OFFSET: ±HHMM или ±HHMMSS.
+func formatOffset(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign, offset = "-", -offset
+	}
+	s := fmt.Sprintf("%s%02d%02d", sign, offset/3600, offset/60%60)
+	if offset%60 != 0 {
+		s += fmt.Sprintf("%02d", offset%60)
+	}
+	return s
+}
+
+// encoder записывает строки содержимого, складывая длинные строки.
+type encoder struct {
+	w   *bufio.Writer
+	err error
+}
+
+// line записывает свойство name с параметрами params и значением value.
+func (e *encoder) line(name string, params []string, value string) {
+	s := name
+	for _, p := range params {
+		s += ";" + p
+	}
+	s += ":" + value
+
+	// Продолжение строки начинается с пробела, который тоже входит в ее длину.
+	limit := maxLineOctets
+	for len(s) > limit && e.err == nil {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		_, e.err = e.w.WriteString(s[:cut] + "\r\n ")
+		s, limit = s[cut:], maxLineOctets-1
+	}
+	if e.err == nil {
+		_, e.err = e.w.WriteString(s + "\r\n")
+	}
+}
+
+// dateTime записывает свойство со значением даты или момента.
+func (e *encoder) dateTime(name string, dt DateTime) {
+	switch {
+	case dt.DateOnly:
+		e.line(name, []string{"VALUE=DATE"}, dt.Time.Format(dateLayout))
+	case dt.Floating:
+		e.line(name, nil, dt.Time.Format(dateTimeLayout))
+	case dt.Time.Location() == time.UTC:
+		e.line(name, nil, dt.Time.Format(utcLayout))
+	default:
+		e.line(name, []string{"TZID=" + dt.Time.Location().String()}, dt.Time.Format(dateTimeLayout))
+	}
+}
+
+// escapeText экранирует значение типа TEXT.
+func escapeText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// escapeList экранирует список значений типа TEXT.
+func escapeList(values []string) string {
+	escaped := make([]string, len(values))
+	for i, v := range values {
+		escaped[i] = escapeText(v)
+	}
+	return strings.Join(escaped, ",")
+}
+
+// formatDuration записывает длительность в формате DURATION с точностью до секунд.
+func formatDuration(d time.Duration) string {
+	s := "PT"
+	if h := int(d.Hours()); h > 0 {
+		s += strconv.Itoa(h) + "H"
+		d -= time.Duration(h) * time.Hour
+	}
+	if m := int(d.Minutes()); m > 0 {
+		s += strconv.Itoa(m) + "M"
+		d -= time.Duration(m) * time.Minute
+	}
+	if sec := int(d.Seconds()); sec > 0 || s == "PT" {
+		s += strconv.Itoa(sec) + "S"
+	}
+	return s
+}
+
+// property - разобранная строка содержимого.
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// Parse читает календарь из r. Задачи и события вложенных календарей не поддерживаются;
+// неизвестные компоненты, такие как VTIMEZONE и VALARM, пропускаются.
+func Parse(r io.Reader) (*Calendar, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		c       Calendar
+		inCal   bool
+		stack   []string
+		todo    *Todo
+		event   *Event
+		started bool
+	)
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		p, err := parseProperty(line)
+		if err != nil {
+			return nil, err
+		}
+
+		switch p.name {
+		case "BEGIN":
+			v := strings.ToUpper(p.value)
+			if !started {
+				if v != "VCALENDAR" {
+					return nil, ErrInvalidCalendar
+				}
+				started, inCal = true, true
+				continue
+			}
+			stack = append(stack, v)
+			if len(stack) == 1 && v == "VTODO" {
+				todo = &Todo{}
+			} else if len(stack) == 1 && v == "VEVENT" {
+				event = &Event{}
+			}
+			continue
+		case "END":
+			v := strings.ToUpper(p.value)
+			if len(stack) == 0 {
+				if v != "VCALENDAR" {
+					return nil, ErrInvalidCalendar
+				}
+				inCal = false
+				continue
+			}
+			if stack[len(stack)-1] != v {
+				return nil, ErrInvalidCalendar
+			}
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 && todo != nil {
+				c.Todos = append(c.Todos, *todo)
+				todo = nil
+			} else if len(stack) == 0 && event != nil {
+				c.Events = append(c.Events, *event)
+				event = nil
+			}
+			continue
+		}
+
+		switch {
+		case !inCal:
+			return nil, ErrInvalidCalendar
+		case len(stack) == 0:
+			switch p.name {
+			case "PRODID":
+				c.ProdID = p.value
+			case "X-WR-CALNAME":
+				c.Name = unescapeText(p.value)
+			}
+		case len(stack) == 1 && todo != nil:
+			if err := todo.set(p); err != nil {
+				return nil, err
+			}
+		case len(stack) == 1 && event != nil:
+			if err := event.set(p); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !started || inCal || len(stack) > 0 {
+		return nil, ErrInvalidCalendar
+	}
+	return &c, nil
+}
+
+// set заполняет поле задачи из свойства p.
+func (t *Todo) set(p property) error {
+	var err error
+	switch p.name {
+	case "UID":
+		t.UID = p.value
+	case "SUMMARY":
+		t.Summary = unescapeText(p.value)
+	case "DESCRIPTION":
+		t.Description = unescapeText(p.value)
+	case "CATEGORIES":
+		t.Categories = append(t.Categories, splitList(p.value)...)
+	case "PRIORITY":
+		t.Priority, err = strconv.Atoi(p.value)
+	case "STATUS":
+		t.Status = strings.ToUpper(p.value)
+	case "COMPLETED":
+		var dt DateTime
+		if dt, err = parseDateTime(p); err == nil {
+			t.Completed = &dt.Time
+		}
+	case "DTSTART":
+		var dt DateTime
+		if dt, err = parseDateTime(p); err == nil {
+			t.Start = &dt
+		}
+	case "DUE":
+		var dt DateTime
+		if dt, err = parseDateTime(p); err == nil {
+			t.Due = &dt
+		}
+	case "RRULE":
+		t.RRule = p.value
+	case "DTSTAMP":
+		var dt DateTime
+		if dt, err = parseDateTime(p); err == nil {
+			t.Stamp = dt.Time
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidCalendar, p.name, err)
+	}
+	return nil
+}
+
+// set заполняет поле события из свойства p.
+func (ev *Event) set(p property) error {
+	var err error
+	switch p.name {
+	case "UID":
+		ev.UID = p.value
+	case "SUMMARY":
+		ev.Summary = unescapeText(p.value)
+	case "DESCRIPTION":
+		ev.Description = unescapeText(p.value)
+	case "CATEGORIES":
+		ev.Categories = append(ev.Categories, splitList(p.value)...)
+	case "DTSTART":
+		ev.Start, err = parseDateTime(p)
+	case "DTEND":
+		var dt DateTime
+		if dt, err = parseDateTime(p); err == nil {
+			ev.End = &dt
+		}
+	case "RRULE":
+		ev.RRule = p.value
+	case "DTSTAMP":
+		var dt DateTime
+		if dt, err = parseDateTime(p); err == nil {
+			ev.Stamp = dt.Time
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidCalendar, p.name, err)
+	}
+	return nil
+}
+
+// unfold читает строки содержимого, соединяя сложенные строки.
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lines := make([]string, 0)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// parseProperty разбирает строку содержимого вида NAME;PARAM=VALUE:значение. Значения параметров
+// в кавычках могут содержать разделители.
+func parseProperty(line string) (property, error) {
+	p := property{params: make(map[string]string)}
+	quoted := false
+	start, paramName := 0, ""
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		switch {
+		case ch == '"':
+			quoted = !quoted
+		case quoted:
+		case ch == '=' && p.name != "" && paramName == "":
+			paramName = strings.ToUpper(line[start:i])
+			start = i + 1
+		case ch == ';' || ch == ':':
+			part := line[start:i]
+			if p.name == "" {
+				p.name = strings.ToUpper(part)
+			} else if paramName != "" {
+				p.params[paramName] = strings.Trim(part, `"`)
+				paramName = ""
+			}
+			start = i + 1
+			if ch == ':' {
+				p.value = line[start:]
+				if p.name == "" {
+					return p, ErrInvalidCalendar
+				}
+				return p, nil
+			}
+		}
+	}
+	return p, ErrInvalidCalendar
+}
+
+// parseDateTime разбирает значение даты или момента с учетом параметров VALUE и TZID.
+// Неизвестный часовой пояс TZID заменяется на UTC.
+func parseDateTime(p property) (DateTime, error) {
+	v := p.value
+	if strings.EqualFold(p.params["VALUE"], "DATE") || len(v) == len(dateLayout) {
+		t, err := time.Parse(dateLayout, v)
+		return DateTime{Time: t, DateOnly: true}, err
+	}
+	if strings.HasSuffix(v, "Z") {
+		t, err := time.Parse(utcLayout, v)
+		return DateTime{Time: t}, err
+	}
+	if tzid := p.params["TZID"]; tzid != "" {
+		loc, err := time.LoadLocation(strings.TrimPrefix(tzid, "/"))
+		if err != nil {
+			loc = time.UTC
+		}
+		t, err := time.ParseInLocation(dateTimeLayout, v, loc)
+		return DateTime{Time: t}, err
+	}
+	t, err := time.Parse(dateTimeLayout, v)
+	return DateTime{Time: t, Floating: true}, err
+}
+
+// unescapeText снимает экранирование значения типа TEXT.
+func unescapeText(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// splitList разбирает список значений типа TEXT, разделенных неэкранированными запятыми.
+func splitList(s string) []string {
+	values := make([]string, 0)
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+		} else if s[i] == ',' {
+			values = append(values, unescapeText(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(values, unescapeText(s[start:]))
+}
@@ -0,0 +1,176 @@
+package ical
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func encode(t *testing.T, c Calendar) string {
+	t.Helper()
+	var b bytes.Buffer
+	if err := c.Encode(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestRoundTrip(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stamp := time.Date(2024, time.March, 1, 9, 0, 0, 0, time.UTC)
+	completed := time.Date(2024, time.March, 2, 10, 30, 0, 0, time.UTC)
+	date := func(y int, m time.Month, d int) *DateTime {
+		return &DateTime{Time: time.Date(y, m, d, 0, 0, 0, 0, time.UTC), DateOnly: true}
+	}
+
+	tests := []struct {
+		name string
+		cal  Calendar
+	}{
+		{"empty", Calendar{ProdID: "-//test//EN"}},
+		{"escaping", Calendar{ProdID: "-//test//EN", Name: "Дом; дача, гараж", Todos: []Todo{{
+			UID:         "1@test",
+			Summary:     `milk; bread, eggs \ butter`,
+			Description: "first line\nsecond line",
+			Categories:  []string{"Dairy, eggs", "Молочное"},
+			Status:      StatusNeedsAction,
+			Stamp:       stamp,
+		}}}},
+		{"folding", Calendar{ProdID: "-//test//EN", Todos: []Todo{{
+			UID:         "2@test",
+			Summary:     strings.Repeat("очень длинное название ", 10),
+			Description: strings.Repeat("x", 200),
+			Stamp:       stamp,
+		}}}},
+		{"todo dates", Calendar{ProdID: "-//test//EN", Todos: []Todo{
+			{UID: "3@test", Summary: "date", Start: date(2024, time.March, 4), Due: date(2024, time.March, 4),
+				RRule: "FREQ=WEEKLY;BYDAY=MO", Priority: 1, Stamp: stamp},
+			{UID: "4@test", Summary: "zoned", Start: &DateTime{Time: time.Date(2024, time.March, 30, 9, 0, 0, 0, berlin)},
+				Due: &DateTime{Time: time.Date(2024, time.April, 2, 9, 0, 0, 0, berlin)}, RRule: "FREQ=DAILY", Stamp: stamp},
+			{UID: "5@test", Summary: "floating", Due: &DateTime{Time: time.Date(2024, time.March, 4, 18, 0, 0, 0, time.UTC), Floating: true},
+				Status: StatusCompleted, Completed: &completed, Stamp: stamp},
+			{UID: "6@test", Summary: "utc", Due: &DateTime{Time: time.Date(2024, time.March, 4, 18, 0, 0, 0, time.UTC)}, Priority: 9, Stamp: stamp},
+		}}},
+		{"events", Calendar{ProdID: "-//test//EN", Events: []Event{
+			{UID: "7@test", Summary: "all day", Start: *date(2024, time.March, 4), End: date(2024, time.March, 5), Stamp: stamp},
+			{UID: "8@test", Summary: "zoned", Start: DateTime{Time: time.Date(2024, time.October, 27, 9, 0, 0, 0, berlin)},
+				End: &DateTime{Time: time.Date(2024, time.October, 27, 10, 0, 0, 0, berlin)}, RRule: "FREQ=DAILY;COUNT=3", Stamp: stamp},
+		}}},
+	}
+	for _, tt := range tests {
+		out := encode(t, tt.cal)
+		parsed, err := Parse(strings.NewReader(out))
+		if err != nil {
+			t.Errorf("%s: Parse: %v\n%s", tt.name, err, out)
+			continue
+		}
+		if again := encode(t, *parsed); again != out {
+			t.Errorf("%s: encoding after Parse differs:\n%s\nwant\n%s", tt.name, again, out)
+		}
+		if parsed.Name != tt.cal.Name || len(parsed.Todos) != len(tt.cal.Todos) || len(parsed.Events) != len(tt.cal.Events) {
+			t.Errorf("%s: parsed %+v, want %+v", tt.name, parsed, tt.cal)
+			continue
+		}
+		for i, todo := range parsed.Todos {
+			want := tt.cal.Todos[i]
+			if todo.Summary != want.Summary || todo.Description != want.Description ||
+				(len(want.Categories) > 0 && !reflect.DeepEqual(todo.Categories, want.Categories)) {
+				t.Errorf("%s: todo %d = %+v, want %+v", tt.name, i, todo, want)
+			}
+			if want.Due != nil && (todo.Due == nil || !todo.Due.Time.Equal(want.Due.Time) || todo.Due.DateOnly != want.Due.DateOnly) {
+				t.Errorf("%s: todo %d due = %+v, want %+v", tt.name, i, todo.Due, want.Due)
+			}
+		}
+		for i, ev := range parsed.Events {
+			if want := tt.cal.Events[i]; !ev.Start.Time.Equal(want.Start.Time) || ev.Start.DateOnly != want.Start.DateOnly {
+				t.Errorf("%s: event %d start = %+v, want %+v", tt.name, i, ev.Start, want.Start)
+			}
+		}
+	}
+}
+
+func TestEncodeFoldsLines(t *testing.T) {
+	out := encode(t, Calendar{ProdID: "-//test//EN", Todos: []Todo{{UID: "1@test", Summary: strings.Repeat("молоко ", 40)}}})
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+		if len(line) > maxLineOctets || !utf8.ValidString(line) {
+			t.Errorf("line %q: %d octets, valid UTF-8 %v", line, len(line), utf8.ValidString(line))
+		}
+	}
+	if !strings.Contains(out, "\r\n ") {
+		t.Error("long line was not folded")
+	}
+}
+
+func TestEncodeTimezones(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := encode(t, Calendar{ProdID: "-//test//EN", Todos: []Todo{
+		{UID: "1@test", Due: &DateTime{Time: time.Date(2024, time.March, 4, 9, 0, 0, 0, berlin)}},
+		{UID: "2@test", Due: &DateTime{Time: time.Date(2024, time.March, 4, 9, 0, 0, 0, time.UTC)}},
+	}})
+
+	// Для пояса Berlin есть ровно один VTIMEZONE с переходом на летнее время 31 марта 2024 года; UTC описывать не нужно.
+	for _, want := range []string{
+		"DUE;TZID=Europe/Berlin:20240304T090000\r\n",
+		"BEGIN:VTIMEZONE\r\nTZID:Europe/Berlin\r\n",
+		"BEGIN:DAYLIGHT\r\nDTSTART:20240331T020000\r\nTZOFFSETFROM:+0100\r\nTZOFFSETTO:+0200\r\nTZNAME:CEST\r\nEND:DAYLIGHT\r\n",
+		"DUE:20240304T090000Z\r\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("calendar does not contain %q:\n%s", want, out)
+		}
+	}
+	if n := strings.Count(out, "BEGIN:VTIMEZONE"); n != 1 {
+		t.Errorf("%d VTIMEZONE components, want 1", n)
+	}
+	if strings.Index(out, "END:VTIMEZONE") > strings.Index(out, "BEGIN:VTODO") {
+		t.Error("VTIMEZONE after VTODO")
+	}
+}
+
+func TestFormatOffset(t *testing.T) {
+	for offset, want := range map[int]string{0: "+0000", 3600: "+0100", -5 * 3600: "-0500", 5*3600 + 45*60: "+0545", 2*3600 + 30: "+020030"} {
+		if got := formatOffset(offset); got != want {
+			t.Errorf("formatOffset(%d) = %q, want %q", offset, got, want)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"BEGIN:VTODO\r\nEND:VTODO\r\n",
+		"BEGIN:VCALENDAR\r\nBEGIN:VTODO\r\nEND:VCALENDAR\r\n",
+		"BEGIN:VCALENDAR\r\nBEGIN:VTODO\r\nDUE:tomorrow\r\nEND:VTODO\r\nEND:VCALENDAR\r\n",
+		"BEGIN:VCALENDAR\r\nno colon\r\nEND:VCALENDAR\r\n",
+	} {
+		if _, err := Parse(strings.NewReader(in)); !errors.Is(err, ErrInvalidCalendar) {
+			t.Errorf("Parse(%q) = %v, want ErrInvalidCalendar", in, err)
+		}
+	}
+}
+
+func TestParseUnfoldsAndSkipsComponents(t *testing.T) {
+	in := "BEGIN:VCALENDAR\r\nPRODID:x\r\nBEGIN:VTIMEZONE\r\nTZID:Europe/Berlin\r\nBEGIN:STANDARD\r\nDTSTART:19701025T030000\r\n" +
+		"END:STANDARD\r\nEND:VTIMEZONE\r\nBEGIN:VTODO\r\nUID:1\r\nSUMMARY:long\r\n  name\\, with comma\r\n" +
+		"DUE;TZID=Europe/Berlin:20240304T090000\r\nBEGIN:VALARM\r\nTRIGGER:-PT15M\r\nEND:VALARM\r\nEND:VTODO\r\nEND:VCALENDAR\r\n"
+	c, err := Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Todos) != 1 || c.Todos[0].Summary != "long name, with comma" {
+		t.Fatalf("todos = %+v", c.Todos)
+	}
+	if due := c.Todos[0].Due; due == nil || !due.Time.Equal(time.Date(2024, time.March, 4, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("due = %+v, want 2024-03-04 08:00 UTC", due)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"io/fs"
 	"log"
@@ -8,7 +9,15 @@ import (
 	"os"
 	"strings"
 	"time"
+	// Встроенная база часовых поясов нужна для сроков элементов, если в системе ее нет.
+	_ "time/tzdata"
 
+	"github.com/abel-03/go-todo/clock"
+	"github.com/abel-03/go-todo/config"
+	"github.com/abel-03/go-todo/controllers"
+	"github.com/abel-03/go-todo/models"
+	"github.com/abel-03/go-todo/notify"
+	"github.com/abel-03/go-todo/scheduler"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 )
@@ -17,6 +26,53 @@ import (
 var staticFS embed.FS
 
 func main() {
+	// Подключаемся к MongoDB.
+	config.Connect()
+
+	// Переносим приглашения из устаревшего формата хранения.
+	if err := models.MigrateSharingInviteIds(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Присваиваем позиции элементам, сохраненным до появления ручного порядка.
+	if err := models.MigrateItemPositions(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Создаем индексы хранилища ключей идемпотентности.
+	if err := models.EnsureIdempotencyIndexes(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Создаем индекс истории покупок.
+	if err := models.EnsureHistoryIndexes(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Создаем индексы очереди фоновых заданий и уведомлений.
+	if err := models.EnsureJobIndexes(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Подключаем внешние каналы уведомлений.
+	push, err := notify.NewWebPush(config.VAPIDPrivateKey, config.VAPIDSubject)
+	if err != nil {
+		log.Fatal(err)
+	}
+	models.RegisterNotificationChannel(push)
+	models.RegisterNotificationChannel(notify.Email{Addr: config.SMTPAddr, From: config.SMTPFrom})
+	models.RegisterNotificationChannel(notify.Webhook{Client: &http.Client{Timeout: config.WebhookTimeout}})
+
+	// Запускаем фоновые задачи: возврат повторяющихся элементов, обновление повторяющихся списков,
+	// создание напоминаний, истечение приглашений и выполнение заданий очереди.
+	sched := scheduler.New(clock.System, config.SchedulerInterval).
+		Add("recurring items", models.ProcessRecurringItems).
+		Add("recurring lists", models.ProcessRecurringLists).
+		Add("reminders", models.ScheduleReminders).
+		Add("share invites", models.ExpireShareInvites).
+		Add("jobs", models.RunJobs)
+	go sched.Start(context.Background())
+
 	// Создаем новый роутер Chi.
 	r := chi.NewRouter()
 
@@ -25,15 +81,30 @@ func main() {
 	r.Use(middleware.RealIP)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(60 * time.Second))
 
-	// Монтируем статические файлы из встроенного файла системы.
-	FileServer(r, "/", getFileSystem(staticFS))
+	// Поток событий держит соединение открытым, поэтому монтируется без ограничения времени запроса.
+	r.Mount("/api/events", controllers.EventsResource{}.Routes())
 
-	// Монтируем роутеры для API функционала.
-	r.Mount("/api/auth", controllers.AuthResource{}.Routes())
-	r.Mount("/api/lists", controllers.ShoppingListsResource{}.Routes())
-	r.Mount("/api/share-lists", controllers.ShareListsResource{}.Routes())
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Timeout(60 * time.Second))
+
+		// Монтируем статические файлы из встроенного файла системы.
+		FileServer(r, "/", getFileSystem(staticFS))
+
+		// Монтируем роутеры для API функционала.
+		r.Mount("/api/auth", controllers.AuthResource{}.Routes())
+		r.Mount("/api/lists", controllers.ShoppingListsResource{}.Routes())
+		r.Mount("/api/share-lists", controllers.ShareListsResource{}.Routes())
+		r.Mount("/api/groups", controllers.GroupsResource{}.Routes())
+		r.Mount("/api/users", controllers.UsersResource{}.Routes())
+		r.Mount("/api/admin", controllers.AdminResource{}.Routes())
+		r.Mount("/api/sync", controllers.SyncResource{}.Routes())
+		r.Mount("/api/stores", controllers.StoresResource{}.Routes())
+		r.Mount("/api/notifications", controllers.NotificationsResource{}.Routes())
+		r.Mount("/api/calendar", controllers.CalendarResource{}.Routes())
+		r.Mount("/api/suggestions", controllers.SuggestionsResource{}.Routes())
+		r.Mount("/api/analytics", controllers.AnalyticsResource{}.Routes())
+	})
 
 	// Получаем порт из переменной окружения.
 	port := os.Getenv("PORT")
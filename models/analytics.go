@@ -0,0 +1,292 @@
+package models
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/abel-03/go-todo/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AnalyticsFilter ограничивает историю покупок, по которой строятся отчеты: покупки в промежутке [From, To)
+// и в списке ListId. Незаданные поля не ограничивают выборку. Месяцы определяются в часовом поясе Location.
+type AnalyticsFilter struct {
+	From     *time.Time
+	To       *time.Time
+	ListId   *primitive.ObjectID
+	Location *time.Location
+}
+
+// Spending содержит итоги покупок: число завершений покупок, купленных элементов и их количество
+// (единица, если количество не задано), а также потраченные суммы по валютам. Стоимость считается так же,
+// как в ListTotals.
+type Spending struct {
+	Checkouts int                `json:"checkouts" bson:"checkouts"`
+	Items     int                `json:"items" bson:"items"`
+	Quantity  float64            `json:"quantity" bson:"quantity"`
+	Spent     map[string]float64 `json:"spent" bson:"spent"`
+}
+
+// MonthlySpending содержит итоги покупок за месяц в формате "2006-01".
+type MonthlySpending struct {
+	Month    string `json:"month" bson:"key"`
+	Spending `bson:",inline"`
+}
+
+// CategorySpending содержит итоги покупок в категории. Пустая категория объединяет элементы без категории.
+type CategorySpending struct {
+	Category string `json:"category" bson:"key"`
+	Spending `bson:",inline"`
+}
+
+// TopItem содержит итоги покупок товара. Товары сопоставляются по названию без учета регистра,
+// Name - название из последней покупки.
+type TopItem struct {
+	Name     string `json:"name" bson:"label"`
+	Spending `bson:",inline"`
+}
+
+// MemberSpending содержит итоги покупок, оплаченных участником списка или, если оплата не указана, завершенных им.
+// Покупки без оплаты, учтенные при обновлении повторяющегося списка, не имеют участника.
+type MemberSpending struct {
+	UserId   *primitive.ObjectID `json:"userId" bson:"key"`
+	UserName string              `json:"userName,omitempty" bson:"userName,omitempty"`
+	Spending `bson:",inline"`
+}
+
+// BasketStats содержит средний размер покупки: число элементов и потраченную сумму по валютам
+// в расчете на одно завершение покупок.
+type BasketStats struct {
+	Spending
+	AverageItems float64            `json:"averageItems"`
+	AverageSpent map[string]float64 `json:"averageSpent"`
+}
+
+// analyticsMatch возвращает условие выборки истории покупок доступных пользователю списков по фильтру f.
+// Если список фильтра не найден или недоступен пользователю, возвращается nil.
+func analyticsMatch(userId primitive.ObjectID, f AnalyticsFilter) (bson.M, error) {
+	access, err := listAccessFilter(userId)
+	if err != nil {
+		return nil, err
+	}
+	lists := bson.M{"$or": access}
+	if f.ListId != nil {
+		lists["_id"] = *f.ListId
+	}
+	cursor, err := config.ShoppingLists.Find(context.TODO(), lists, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var found []ShoppingList
+	if err := cursor.All(context.TODO(), &found); err != nil {
+		return nil, err
+	}
+	if f.ListId != nil && len(found) == 0 {
+		return nil, nil
+	}
+
+	listIds := make([]primitive.ObjectID, 0, len(found))
+	for _, l := range found {
+		listIds = append(listIds, l.ID)
+	}
+	// История удаленных списков учитывается только в итогах по всем спискам.
+	match := bson.M{"listId": bson.M{"$in": listIds}}
+	if f.ListId == nil {
+		match = historyMatch(userId, listIds)
+	}
+	period := bson.M{}
+	if f.From != nil {
+		period["$gte"] = *f.From
+	}
+	if f.To != nil {
+		period["$lt"] = *f.To
+	}
+	if len(period) > 0 {
+		match["time"] = period
+	}
+	return match, nil
+}
+
+// spendingStages возвращает стадии конвейера, группирующие купленные элементы по выражению key в итоги Spending
+// с полем key. Если задано выражение label, в поле label сохраняется его значение для последней покупки.
+// Стадии ожидают записи истории с развернутым полем items, упорядоченные от новых к старым.
+func spendingStages(key, label interface{}) mongo.Pipeline {
+	quantity := bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{"$items.quantity", 0}}, "$items.quantity", 1}}
+	priced := bson.M{"$and": bson.A{
+		bson.M{"$gt": bson.A{"$items.price", 0}},
+		bson.M{"$gt": bson.A{"$items.currency", ""}},
+	}}
+
+	byCurrency := bson.M{
+		"_id":       bson.M{"key": key, "currency": bson.M{"$cond": bson.A{priced, "$items.currency", nil}}},
+		"checkouts": bson.M{"$addToSet": "$_id"},
+		"items":     bson.M{"$sum": 1},
+		"quantity":  bson.M{"$sum": quantity},
+		"spent": bson.M{"$sum": bson.M{"$cond": bson.A{
+			priced, bson.M{"$multiply": bson.A{"$items.price", quantity}}, 0,
+		}}},
+	}
+	byKey := bson.M{
+		"_id":       "$_id.key",
+		"checkouts": bson.M{"$push": "$checkouts"},
+		"items":     bson.M{"$sum": "$items"},
+		"quantity":  bson.M{"$sum": "$quantity"},
+		"spent":     bson.M{"$push": bson.M{"k": "$_id.currency", "v": bson.M{"$round": bson.A{"$spent", 2}}}},
+	}
+	project := bson.M{
+		"_id":      0,
+		"key":      "$_id",
+		"items":    1,
+		"quantity": 1,
+		"checkouts": bson.M{"$size": bson.M{"$reduce": bson.M{
+			"input":        "$checkouts",
+			"initialValue": bson.A{},
+			"in":           bson.M{"$setUnion": bson.A{"$$value", "$$this"}},
+		}}},
+		"spent": bson.M{"$arrayToObject": bson.M{"$filter": bson.M{
+			"input": "$spent",
+			"as":    "s",
+			"cond":  bson.M{"$ne": bson.A{"$$s.k", nil}},
+		}}},
+	}
+	if label != nil {
+		byCurrency["label"] = bson.M{"$first": label}
+		byKey["label"] = bson.M{"$first": "$label"}
+		project["label"] = 1
+	}
+
+	return mongo.Pipeline{
+		{{Key: "$group", Value: byCurrency}},
+		{{Key: "$group", Value: byKey}},
+		{{Key: "$project", Value: project}},
+	}
+}
+
+// aggregateSpending строит по истории покупок пользователя итоги, сгруппированные по выражению key
+// (см. spendingStages), применяет к ним стадии stages и декодирует результат в results.
+// Возвращает false, если список фильтра не найден или недоступен пользователю.
+func aggregateSpending(userId primitive.ObjectID, f AnalyticsFilter, key, label interface{}, stages mongo.Pipeline, results interface{}) (bool, error) {
+	match, err := analyticsMatch(userId, f)
+	if err != nil || match == nil {
+		return false, err
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$sort", Value: bson.D{{Key: "time", Value: -1}, {Key: "_id", Value: -1}}}},
+		{{Key: "$unwind", Value: "$items"}},
+	}
+	pipeline = append(pipeline, spendingStages(key, label)...)
+	pipeline = append(pipeline, stages...)
+
+	cursor, err := config.Checkouts.Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return false, err
+	}
+	defer cursor.Close(context.TODO())
+	return true, cursor.All(context.TODO(), results)
+}
+
+// MonthlySpend возвращает итоги покупок по месяцам в хронологическом порядке.
+// Если список фильтра не найден или недоступен пользователю, возвращается nil.
+func MonthlySpend(userId primitive.ObjectID, f AnalyticsFilter) ([]MonthlySpending, error) {
+	loc := f.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	key := bson.M{"$dateToString": bson.M{"format": "%Y-%m", "date": "$time", "timezone": loc.String()}}
+
+	res := make([]MonthlySpending, 0)
+	ok, err := aggregateSpending(userId, f, key, nil, mongo.Pipeline{
+		{{Key: "$sort", Value: bson.M{"key": 1}}},
+	}, &res)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return res, nil
+}
+
+// CategorySpend возвращает итоги покупок по категориям от самых частых к редким.
+// Если список фильтра не найден или недоступен пользователю, возвращается nil.
+func CategorySpend(userId primitive.ObjectID, f AnalyticsFilter) ([]CategorySpending, error) {
+	res := make([]CategorySpending, 0)
+	ok, err := aggregateSpending(userId, f, bson.M{"$ifNull": bson.A{"$items.category", ""}}, nil, mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "items", Value: -1}, {Key: "key", Value: 1}}}},
+	}, &res)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return res, nil
+}
+
+// TopItems возвращает не больше limit товаров, которые покупались чаще всего.
+// Если список фильтра не найден или недоступен пользователю, возвращается nil.
+func TopItems(userId primitive.ObjectID, f AnalyticsFilter, limit int64) ([]TopItem, error) {
+	key := bson.M{"$toLower": bson.M{"$trim": bson.M{"input": "$items.name"}}}
+
+	res := make([]TopItem, 0)
+	ok, err := aggregateSpending(userId, f, key, "$items.name", mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "items", Value: -1}, {Key: "quantity", Value: -1}, {Key: "key", Value: 1}}}},
+		{{Key: "$limit", Value: limit}},
+	}, &res)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return res, nil
+}
+
+// MemberSpend возвращает итоги покупок по участникам вместе с их отображаемыми именами. Покупка относится
+// к плательщику, если указана ее оплата, иначе к участнику, завершившему покупки, как и в балансах списка.
+// Участники упорядочены по числу купленных элементов. Если список фильтра не найден или недоступен пользователю,
+// возвращается nil.
+func MemberSpend(userId primitive.ObjectID, f AnalyticsFilter) ([]MemberSpending, error) {
+	res := make([]MemberSpending, 0)
+	key := bson.M{"$ifNull": bson.A{"$payment.payerId", "$userId"}}
+	ok, err := aggregateSpending(userId, f, key, nil, mongo.Pipeline{
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "users",
+			"localField":   "key",
+			"foreignField": "_id",
+			"as":           "user",
+		}}},
+		{{Key: "$addFields", Value: bson.M{
+			"userName": bson.M{"$arrayElemAt": bson.A{displayNames("$user"), 0}},
+		}}},
+		{{Key: "$project", Value: bson.M{"user": 0}}},
+		{{Key: "$sort", Value: bson.D{{Key: "items", Value: -1}, {Key: "key", Value: 1}}}},
+	}, &res)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return res, nil
+}
+
+// BasketSize возвращает средний размер покупки. Если список фильтра не найден или недоступен пользователю,
+// возвращается nil.
+func BasketSize(userId primitive.ObjectID, f AnalyticsFilter) (*BasketStats, error) {
+	var res []Spending
+	ok, err := aggregateSpending(userId, f, nil, nil, nil, &res)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	stats := BasketStats{
+		Spending:     Spending{Spent: make(map[string]float64)},
+		AverageSpent: make(map[string]float64),
+	}
+	if len(res) > 0 {
+		stats.Spending = res[0]
+	}
+	if stats.Checkouts > 0 {
+		n := float64(stats.Checkouts)
+		stats.AverageItems = math.Round(float64(stats.Items)/n*100) / 100
+		for c, v := range stats.Spent {
+			stats.AverageSpent[c] = math.Round(v/n*100) / 100
+		}
+	}
+	return &stats, nil
+}
@@ -0,0 +1,205 @@
+package models
+
+import (
+	"context"
+	"errors"
+
+	"github.com/abel-03/go-todo/config"
+	"github.com/abel-03/go-todo/events"
+	"github.com/abel-03/go-todo/hlc"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInvalidAssignee возвращается, если исполнитель элемента не является участником списка.
+var ErrInvalidAssignee = errors.New("assignee is not a list member")
+
+// assigneeLookupStages возвращает стадии конвейера, подставляющие в элементы списка отображаемые имена исполнителей.
+func assigneeLookupStages() mongo.Pipeline {
+	return mongo.Pipeline{
+		{
+			{Key: "$lookup", Value: bson.M{
+				"from":         "users",
+				"localField":   "items.assigneeId",
+				"foreignField": "_id",
+				"as":           "assignees",
+			}},
+		},
+		{
+			{Key: "$addFields", Value: bson.M{
+				"items": bson.M{"$map": bson.M{
+					"input": "$items",
+					"as":    "i",
+					"in": bson.M{"$mergeObjects": bson.A{"$$i", bson.M{
+						"assigneeName": bson.M{"$arrayElemAt": bson.A{displayNames(bson.M{"$filter": bson.M{
+							"input": "$assignees",
+							"as":    "a",
+							"cond":  bson.M{"$eq": bson.A{"$$a._id", "$$i.assigneeId"}},
+						}}), 0}},
+					}}},
+				}},
+			}},
+		},
+		{
+			{Key: "$project", Value: bson.M{"assignees": 0}},
+		},
+	}
+}
+
+// withAssignee дополняет фильтр списка условием, что список доступен исполнителю assigneeId:
+// исполнителем может быть владелец списка, пользователь, которому он открыт, или участник группы списка.
+func withAssignee(filter bson.M, assigneeId primitive.ObjectID) (bson.M, error) {
+	access, err := listAccessFilter(assigneeId)
+	if err != nil {
+		return nil, err
+	}
+
+	guarded := bson.M{}
+	for k, v := range filter {
+		guarded[k] = v
+	}
+	conditions, _ := guarded["$and"].(bson.A)
+	guarded["$and"] = append(append(bson.A{}, conditions...), bson.M{"$or": access})
+	return guarded, nil
+}
+
+// invalidAssignee вызывается, когда список по фильтру с условием исполнителя assigneeId не найден.
+// Возвращает ErrInvalidAssignee, если список по фильтру filter существует, но недоступен исполнителю,
+// и nil, если список не найден по другой причине.
+func invalidAssignee(filter bson.M, assigneeId primitive.ObjectID) error {
+	opts := options.Count().SetLimit(1)
+	n, err := config.ShoppingLists.CountDocuments(context.TODO(), filter, opts)
+	if err != nil || n == 0 {
+		return err
+	}
+
+	guarded, err := withAssignee(filter, assigneeId)
+	if err != nil {
+		return err
+	}
+	if n, err = config.ShoppingLists.CountDocuments(context.TODO(), guarded, opts); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrInvalidAssignee
+	}
+	return nil
+}
+
+// AssignedItems возвращает элементы всех доступных пользователю неархивных списков, назначенные ему.
+// Завершенные элементы включаются, только если задан withCompleted; скрытые до повторения не включаются.
+// Элементы упорядочены по спискам, а внутри списка - по позиции.
+func AssignedItems(userId primitive.ObjectID, withCompleted bool, limit, offset int64) (*DueItemsPage, error) {
+	access, err := listAccessFilter(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	itemMatch := bson.M{"items.assigneeId": userId, "items.hiddenUntil": bson.M{"$exists": false}}
+	if !withCompleted {
+		itemMatch["items.isCompleted"] = false
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$or": access, "archived": bson.M{"$ne": true}, "items.assigneeId": userId}}},
+		{{Key: "$unwind", Value: "$items"}},
+		{{Key: "$match", Value: itemMatch}},
+		{{Key: "$sort", Value: bson.D{
+			{Key: "name", Value: 1},
+			{Key: "_id", Value: 1},
+			{Key: "items.position", Value: 1},
+			{Key: "items._id", Value: 1},
+		}}},
+		{{Key: "$facet", Value: bson.M{
+			"items": bson.A{
+				bson.M{"$skip": offset},
+				bson.M{"$limit": limit},
+				bson.M{"$project": bson.M{"_id": 0, "listId": "$_id", "listName": "$name", "item": "$items"}},
+			},
+			"total": bson.A{bson.M{"$count": "n"}},
+		}}},
+	}
+
+	cursor, err := config.ShoppingLists.Aggregate(context.Background(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var result []struct {
+		Items []DueItem `bson:"items"`
+		Total []struct {
+			N int64 `bson:"n"`
+		} `bson:"total"`
+	}
+	if err = cursor.All(context.Background(), &result); err != nil {
+		return nil, err
+	}
+
+	page := DueItemsPage{Items: make([]DueItem, 0), Limit: limit, Offset: offset}
+	if len(result) > 0 {
+		page.Items = append(page.Items, result[0].Items...)
+		if len(result[0].Total) > 0 {
+			page.Total = result[0].Total[0].N
+		}
+	}
+	return &page, nil
+}
+
+// unassignUser снимает пользователя userId с элементов списков по фильтру, например когда он потерял доступ к ним.
+// Снятие помечается текущим временем сервера, поэтому более ранние правки назначения его не отменяют.
+func unassignUser(filter bson.M, userId primitive.ObjectID) error {
+	match := bson.M{"items.assigneeId": userId}
+	for k, v := range filter {
+		match[k] = v
+	}
+	cursor, err := config.ShoppingLists.Find(context.TODO(), match, options.Find().SetProjection(bson.M{
+		"ownerId":    1,
+		"sharingIds": 1,
+		"groupId":    1,
+		"items":      1,
+	}))
+	if err != nil {
+		return err
+	}
+	var lists []ShoppingList
+	if err := cursor.All(context.TODO(), &lists); err != nil {
+		return err
+	}
+
+	ts := hlc.Default.Now()
+	for _, l := range lists {
+		seq, err := NextChangeSeq()
+		if err != nil {
+			return err
+		}
+		_, err = config.ShoppingLists.UpdateOne(context.TODO(),
+			bson.M{"_id": l.ID},
+			bson.M{
+				"$unset": bson.M{"items.$[a].assigneeId": ""},
+				"$set": bson.M{
+					"items.$[a].clock.assigneeId": ts,
+					"items.$[a].seq":              seq,
+				},
+				"$max": bson.M{"seq": seq},
+				"$inc": bson.M{"items.$[a].version": 1, "version": 1},
+			},
+			options.Update().SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"a.assigneeId": userId}}}))
+		releaseChangeSeq(seq)
+		if err != nil {
+			return err
+		}
+
+		for _, li := range l.Items {
+			if li.AssigneeId == nil || *li.AssigneeId != userId {
+				continue
+			}
+			li.AssigneeId, li.Clock.AssigneeId = nil, ts
+			li.Seq = seq
+			li.Version++
+			publishListEvent(l, events.ItemModified, userId, &li.ID, li)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,393 @@
+package models
+
+import (
+	"context"
+	"sort"
+
+	"github.com/abel-03/go-todo/catalog"
+	"github.com/abel-03/go-todo/config"
+	"github.com/abel-03/go-todo/events"
+	"github.com/abel-03/go-todo/hlc"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Результаты объединения списка при массовой загрузке.
+const (
+	UpsertCreated = "created"
+	UpsertUpdated = "updated"
+	UpsertSkipped = "skipped"
+)
+
+// ListUpsertResult описывает, что произошло со списком при массовой загрузке.
+// Items сопоставляет идентификаторы элементов клиента с идентификаторами на сервере.
+type ListUpsertResult struct {
+	ClientId string
+	ID       primitive.ObjectID
+	Status   string
+	Items    map[string]primitive.ObjectID
+
+	// index - номер создаваемого списка среди создаваемых в этой загрузке.
+	index int
+}
+
+// UpsertShoppingLists объединяет списки, загруженные клиентом, с уже сохраненными на сервере.
+// Список сопоставляется по ID, если клиент знает идентификатор сервера, иначе по ClientId среди списков пользователя;
+// элементы сопоставляются внутри списка по ClientId, а элементы без ClientId - по названию. Ненайденные списки
+// и элементы создаются, у найденных обновляются измененные поля (см. uploadPatches). Элементы, которых нет в загрузке,
+// не удаляются. Список с ID, недоступным пользователю, пропускается. Списки с одинаковым ClientId в одной загрузке
+// объединяются в один. Метки всех элементов проверяются до первой записи, чтобы неверная загрузка
+// не применялась частично.
+func UpsertShoppingLists(sl []ShoppingList, userId primitive.ObjectID) ([]ListUpsertResult, error) {
+	if err := checkUploadClocks(sl); err != nil {
+		return nil, err
+	}
+
+	access, err := listAccessFilter(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]ListUpsertResult, 0, len(sl))
+	created := make([]ShoppingList, 0)
+	createdByClientId := make(map[string]int)
+	for _, l := range sl {
+		// Список с этим ClientId уже создается в этой загрузке: добавляем к нему новые элементы.
+		if i, ok := createdByClientId[l.ClientId]; ok && l.ID.IsZero() {
+			res := results[i]
+			created[res.index].Items, err = appendUploadedItems(created[res.index].Items, l.Items, res.Items, userId)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, res)
+			continue
+		}
+
+		existing, err := findUpsertTarget(l, userId, access)
+		if err != nil {
+			return nil, err
+		}
+
+		if existing != nil {
+			res, err := mergeShoppingList(*existing, l, userId)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, res)
+			continue
+		}
+
+		if !l.ID.IsZero() {
+			results = append(results, ListUpsertResult{ClientId: l.ClientId, ID: l.ID, Status: UpsertSkipped})
+			continue
+		}
+
+		// Создаем новый список с элементами.
+		res := ListUpsertResult{
+			ClientId: l.ClientId,
+			ID:       primitive.NewObjectID(),
+			Status:   UpsertCreated,
+			Items:    make(map[string]primitive.ObjectID),
+			index:    len(created),
+		}
+		l.ID = res.ID
+		if l.Items, err = appendUploadedItems(make([]ListItem, 0, len(l.Items)), l.Items, res.Items, userId); err != nil {
+			return nil, err
+		}
+		if l.ClientId != "" {
+			createdByClientId[l.ClientId] = len(results)
+		}
+		created = append(created, l)
+		results = append(results, res)
+	}
+
+	if err := AddShoppingLists(created, userId); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// checkUploadClocks возвращает ErrInvalidClock, если метка какого-либо поля загруженного элемента неверна.
+func checkUploadClocks(sl []ShoppingList) error {
+	for _, l := range sl {
+		for _, li := range l.Items {
+			c := li.Clock
+			for _, ts := range []hlc.Timestamp{c.Name, c.IsCompleted, c.Quantity, c.Unit, c.Note, c.Price, c.Currency,
+				c.Priority, c.Category, c.Due, c.Recurrence, c.AssigneeId} {
+				if ts == "" {
+					continue
+				}
+				if err := checkClock(ts); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// appendUploadedItems добавляет к элементам создаваемого списка items загруженные элементы uploaded, которых среди
+// них еще нет: с тем же ClientId или, для элементов без ClientId, с тем же названием. Идентификаторы созданных
+// элементов записываются в ids.
+func appendUploadedItems(items, uploaded []ListItem, ids map[string]primitive.ObjectID, userId primitive.ObjectID) ([]ListItem, error) {
+	names := make(map[string]bool, len(items))
+	for _, li := range items {
+		if li.ClientId == "" {
+			names[catalog.NormalizeName(li.Name)] = true
+		}
+	}
+
+	for _, li := range uploaded {
+		li, err := categorize(userId, withParsedName(li))
+		if err != nil {
+			return nil, err
+		}
+		if li.ClientId != "" {
+			if _, ok := ids[li.ClientId]; ok {
+				continue
+			}
+		} else if key := catalog.NormalizeName(li.Name); names[key] {
+			continue
+		} else {
+			names[key] = true
+		}
+
+		li.ID = primitive.NewObjectID()
+		if li.ClientId != "" {
+			ids[li.ClientId] = li.ID
+		}
+		items = append(items, li)
+	}
+	return items, nil
+}
+
+// findUpsertTarget ищет сохраненный список, соответствующий загруженному, или возвращает nil.
+func findUpsertTarget(l ShoppingList, userId primitive.ObjectID, access bson.A) (*ShoppingList, error) {
+	var filter bson.M
+	if !l.ID.IsZero() {
+		filter = bson.M{"_id": l.ID, "$or": access}
+	} else if l.ClientId != "" {
+		filter = bson.M{"ownerId": userId, "clientId": l.ClientId}
+	} else {
+		return nil, nil
+	}
+
+	var existing ShoppingList
+	err := config.ShoppingLists.FindOne(context.TODO(), filter).Decode(&existing)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &existing, nil
+}
+
+// uploadPatches возвращает правки сохраненного элемента cur по загруженному элементу li, упорядоченные по времени.
+// Каждое измененное поле попадает в правку с меткой времени этого поля li.Clock, то есть с моментом, когда клиент
+// изменил его. Поля без метки клиента не изменяются: нельзя определить, новее ли они сохраненных. Незаполненные
+// количество, единица, заметка, цена, приоритет, категория и срок не стирают сохраненные значения.
+func uploadPatches(cur, li ListItem) []ListItemPatch {
+	byClock := make(map[hlc.Timestamp]*ListItemPatch)
+	patch := func(ts hlc.Timestamp) *ListItemPatch {
+		if ts == "" {
+			return nil
+		}
+		if byClock[ts] == nil {
+			byClock[ts] = &ListItemPatch{Clock: ts}
+		}
+		return byClock[ts]
+	}
+
+	if p := patch(li.Clock.Name); p != nil && li.Name != cur.Name {
+		name := li.Name
+		p.Name = &name
+	}
+	if p := patch(li.Clock.IsCompleted); p != nil && li.IsCompleted != cur.IsCompleted {
+		isCompleted := li.IsCompleted
+		p.IsCompleted = &isCompleted
+	}
+	if p := patch(li.Clock.Quantity); p != nil && li.Quantity != 0 && li.Quantity != cur.Quantity {
+		quantity := li.Quantity
+		p.Quantity = &quantity
+	}
+	if p := patch(li.Clock.Unit); p != nil && li.Unit != "" && li.Unit != cur.Unit {
+		unit := li.Unit
+		p.Unit = &unit
+	}
+	if p := patch(li.Clock.Note); p != nil && li.Note != "" && li.Note != cur.Note {
+		note := li.Note
+		p.Note = &note
+	}
+	if p := patch(li.Clock.Price); p != nil && li.Price != 0 && (li.Price != cur.Price || li.Currency != cur.Currency) {
+		price, currency := li.Price, li.Currency
+		p.Price, p.Currency = &price, &currency
+	}
+	if p := patch(li.Clock.Priority); p != nil && li.Priority != "" && li.Priority != cur.Priority {
+		priority := li.Priority
+		p.Priority = &priority
+	}
+	if p := patch(li.Clock.Category); p != nil && li.Category != "" && li.Category != cur.Category {
+		category := li.Category
+		p.Category = &category
+	}
+	if p := patch(li.Clock.Due); p != nil && li.Due != nil && !li.Due.Equal(cur.Due) {
+		p.Due = li.Due
+	}
+
+	res := make([]ListItemPatch, 0, len(byClock))
+	for _, p := range byClock {
+		if !p.IsEmpty() {
+			res = append(res, *p)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Clock < res[j].Clock })
+	return res
+}
+
+// mergeShoppingList добавляет в сохраненный список новые элементы загрузки и применяет изменения найденных.
+// Название списка меняется, только если загружает его владелец.
+func mergeShoppingList(existing, l ShoppingList, userId primitive.ObjectID) (ListUpsertResult, error) {
+	res := ListUpsertResult{
+		ClientId: l.ClientId,
+		ID:       existing.ID,
+		Status:   UpsertSkipped,
+		Items:    make(map[string]primitive.ObjectID),
+	}
+
+	byKey := make(map[string]ListItem, len(existing.Items))
+	byName := make(map[string]ListItem, len(existing.Items))
+	for _, li := range existing.Items {
+		byKey[li.ID.Hex()] = li
+		if li.ClientId != "" {
+			byKey[li.ClientId] = li
+		}
+		if key := catalog.NormalizeName(li.Name); key != "" {
+			if _, ok := byName[key]; !ok || (byName[key].IsCompleted && !li.IsCompleted) {
+				byName[key] = li
+			}
+		}
+	}
+
+	newItems := make([]ListItem, 0)
+	newNames := make(map[string]bool)
+	for _, li := range l.Items {
+		if _, ok := res.Items[li.ClientId]; ok && li.ClientId != "" {
+			continue
+		}
+
+		// Элемент без ClientId сопоставляется по названию, чтобы повторная загрузка не добавляла его снова.
+		li = withParsedName(li)
+		cur, ok := byKey[li.ClientId]
+		if li.ClientId == "" {
+			key := catalog.NormalizeName(li.Name)
+			if newNames[key] {
+				continue
+			}
+			cur, ok = byName[key]
+		}
+		if !ok {
+			li, err := categorize(userId, li)
+			if err != nil {
+				return res, err
+			}
+			li.ID, li.ParentId, li.AssigneeId = primitive.NewObjectID(), nil, nil
+			if li.ClientId != "" {
+				res.Items[li.ClientId] = li.ID
+			} else {
+				newNames[catalog.NormalizeName(li.Name)] = true
+			}
+			newItems = append(newItems, li)
+			continue
+		}
+		if li.ClientId != "" {
+			res.Items[li.ClientId] = cur.ID
+		}
+
+		// Изменения элемента применяются как обычные правки с метками клиента, чтобы более поздние правки
+		// других участников не затирались.
+		patches := uploadPatches(cur, li)
+		for _, p := range patches {
+			if _, err := ModifyListItem(userId, cur.ID, p); err != nil {
+				return res, err
+			}
+		}
+		if len(patches) == 0 {
+			continue
+		}
+		res.Status = UpsertUpdated
+	}
+
+	rename := l.Name != existing.Name && existing.OwnerId == userId
+	if !rename && len(newItems) == 0 {
+		return res, nil
+	}
+
+	seq, err := NextChangeSeq()
+	if err != nil {
+		return res, err
+	}
+	defer releaseChangeSeq(seq)
+
+	// Формируем обновление списка: новое название и новые элементы.
+	ts := hlc.Default.Now()
+	for i := range newItems {
+		newItems[i].Seq, newItems[i].CreatedSeq, newItems[i].Version = seq, seq, 1
+		newItems[i].Clock = NewItemClock(ts)
+	}
+	set := bson.M{}
+	if rename {
+		set["name"] = l.Name
+	}
+
+	// Новые элементы ставятся в конец списка. Если конец списка заняли во время загрузки, позиции вычисляются заново.
+	var updated ShoppingList
+	last := lastPosition(existing.Items)
+	for attempt := 1; ; attempt++ {
+		if err := appendPositions(newItems, last); err != nil {
+			return res, err
+		}
+
+		filter := bson.M{"_id": existing.ID}
+		update := bson.M{"$max": bson.M{"seq": seq}, "$inc": bson.M{"version": 1}}
+		if len(set) > 0 {
+			update["$set"] = set
+		}
+		if len(newItems) > 0 {
+			filter["items.position"] = bson.M{"$not": bson.M{"$gte": newItems[0].Position}}
+			update["$push"] = bson.M{"items": bson.M{"$each": newItems}}
+		}
+
+		err = config.ShoppingLists.FindOneAndUpdate(context.TODO(), filter, update,
+			options.FindOneAndUpdate().SetProjection(listMembersProjection)).Decode(&updated)
+		if err != mongo.ErrNoDocuments || len(newItems) == 0 {
+			break
+		}
+		current, ferr := findListItems(bson.M{"_id": existing.ID}, options.FindOne().SetProjection(bson.M{"items.position": 1}))
+		if ferr != nil {
+			return res, ferr
+		} else if current == nil {
+			break
+		} else if attempt == maxPositionAttempts {
+			return res, ErrPositionConflict
+		}
+		last = lastPosition(current.Items)
+	}
+	if err == mongo.ErrNoDocuments {
+		// Список удалили во время загрузки.
+		return ListUpsertResult{ClientId: l.ClientId, ID: existing.ID, Status: UpsertSkipped}, nil
+	} else if err != nil {
+		return res, err
+	}
+
+	if rename {
+		publishListEvent(updated, events.ListUpdated, userId, nil, bson.M{"name": l.Name})
+	}
+	for _, li := range newItems {
+		id := li.ID
+		publishListEvent(updated, events.ItemAdded, userId, &id, li)
+	}
+	res.Status = UpsertUpdated
+	return res, nil
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/abel-03/go-todo/hlc"
+)
+
+func TestCheckUploadClocks(t *testing.T) {
+	now := hlc.Format(time.Now().UnixMilli(), 0, "c")
+	future := hlc.Format(time.Now().Add(24*time.Hour).UnixMilli(), 0, "c")
+	upload := func(ts ...hlc.Timestamp) []ShoppingList {
+		l := ShoppingList{}
+		for _, ts := range ts {
+			l.Items = append(l.Items, ListItem{Name: "milk", Clock: NewItemClock(ts)})
+		}
+		return []ShoppingList{{Items: []ListItem{{Name: "bread"}}}, l}
+	}
+
+	tests := []struct {
+		name string
+		sl   []ShoppingList
+		want error
+	}{
+		{"valid", upload(now, ""), nil},
+		{"malformed", upload(now, "bad"), ErrInvalidClock},
+		{"too far ahead", upload(now, future), ErrInvalidClock},
+	}
+	for _, tt := range tests {
+		if err := checkUploadClocks(tt.sl); err != tt.want {
+			t.Errorf("%s: checkUploadClocks = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
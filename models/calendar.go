@@ -0,0 +1,289 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/abel-03/go-todo/config"
+	"github.com/abel-03/go-todo/ical"
+	"github.com/abel-03/go-todo/parser"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Ограничения календаря.
+const (
+	// maxCalendarItems - сколько элементов со сроком попадает в календарь пользователя.
+	maxCalendarItems = 5000
+	// calendarEventDuration - длительность события для элемента со сроком, заданным временем.
+	calendarEventDuration = 30 * time.Minute
+	// calendarProdID - идентификатор программы, создавшей календарь.
+	calendarProdID = "-//PlanPulse//Shopping lists//EN"
+	// calendarUIDDomain дополняет идентификаторы элементов до глобально уникальных UID.
+	calendarUIDDomain = "@planpulse"
+)
+
+// CalendarImport описывает результат импорта календаря. Skipped - число задач без названия и завершенных задач,
+// NoRecurrence - число задач, импортированных без повторения, правило которого не поддерживается.
+type CalendarImport struct {
+	ItemIds      []primitive.ObjectID `json:"itemIds"`
+	Skipped      int                  `json:"skipped"`
+	NoRecurrence int                  `json:"noRecurrence"`
+}
+
+// hashCalendarToken возвращает хеш токена календаря. В базе хранится только хеш, поэтому токен показывается
+// пользователю один раз при создании.
+func hashCalendarToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateCalendarToken создает новый токен ленты календаря пользователя. Прежний токен перестает действовать.
+func CreateCalendarToken(userId primitive.ObjectID) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	res, err := config.Users.UpdateOne(context.TODO(), bson.M{"_id": userId},
+		bson.M{"$set": bson.M{"calendarTokenHash": hashCalendarToken(token)}})
+	if err != nil {
+		return "", err
+	} else if res.MatchedCount == 0 {
+		return "", nil
+	}
+	return token, nil
+}
+
+// RevokeCalendarToken отключает ленту календаря пользователя.
+func RevokeCalendarToken(userId primitive.ObjectID) (bool, error) {
+	res, err := config.Users.UpdateOne(context.TODO(),
+		bson.M{"_id": userId, "calendarTokenHash": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"calendarTokenHash": ""}})
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount > 0, nil
+}
+
+// CalendarTokenUser возвращает пользователя, которому принадлежит токен ленты календаря, или nil.
+func CalendarTokenUser(token string) (*primitive.ObjectID, error) {
+	if token == "" {
+		return nil, nil
+	}
+	var u User
+	err := config.Users.FindOne(context.TODO(), bson.M{"calendarTokenHash": hashCalendarToken(token)},
+		options.FindOne().SetProjection(bson.M{"_id": 1})).Decode(&u)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &u.ID, nil
+}
+
+// CalendarFeed возвращает календарь элементов со сроком из всех доступных пользователю неархивных списков.
+// Элементы выводятся задачами VTODO или, если asEvents, событиями VEVENT для приложений, которые не показывают задачи.
+func CalendarFeed(userId primitive.ObjectID, asEvents bool, now time.Time) (*ical.Calendar, error) {
+	access, err := listAccessFilter(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$or": access, "archived": bson.M{"$ne": true}, "items.due.at": bson.M{"$exists": true}}}},
+		{{Key: "$unwind", Value: "$items"}},
+		{{Key: "$match", Value: bson.M{"items.due.at": bson.M{"$exists": true}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "items.due.at", Value: 1}, {Key: "items._id", Value: 1}}}},
+		{{Key: "$limit", Value: maxCalendarItems}},
+		{{Key: "$project", Value: bson.M{"_id": 0, "listId": "$_id", "listName": "$name", "item": "$items"}}},
+	}
+	cursor, err := config.ShoppingLists.Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]DueItem, 0)
+	if err := cursor.All(context.TODO(), &items); err != nil {
+		return nil, err
+	}
+
+	cal := ical.Calendar{ProdID: calendarProdID, Name: "PlanPulse"}
+	for _, di := range items {
+		li := di.Item
+		summary := li.Name
+		if li.Quantity > 0 {
+			summary += " (" + strings.TrimSpace(strconv.FormatFloat(li.Quantity, 'f', -1, 64)+" "+li.Unit) + ")"
+		}
+		categories := []string{di.ListName}
+		if li.Category != "" {
+			categories = append(categories, li.Category)
+		}
+		due := calendarDateTime(*li.Due)
+
+		var rrule string
+		var start *ical.DateTime
+		if li.Recurrence != nil {
+			rrule = li.Recurrence.Rule
+			loc, err := time.LoadLocation(li.Recurrence.TimeZone)
+			if err != nil {
+				loc = time.UTC
+			}
+			start = &ical.DateTime{Time: li.Recurrence.Start.In(loc)}
+			// DTSTART и DUE должны быть одного типа: для срока-даты начало повторения тоже записывается датой.
+			if due.DateOnly {
+				y, m, d := start.Time.Date()
+				start = &ical.DateTime{Time: time.Date(y, m, d, 0, 0, 0, 0, time.UTC), DateOnly: true}
+			}
+		}
+
+		if asEvents {
+			ev := ical.Event{
+				UID:         li.ID.Hex() + calendarUIDDomain,
+				Summary:     summary,
+				Description: li.Note,
+				Categories:  categories,
+				Start:       due,
+				RRule:       rrule,
+				Stamp:       now,
+			}
+			if li.IsCompleted {
+				ev.Summary = "✓ " + ev.Summary
+			}
+			if due.DateOnly {
+				ev.End = &ical.DateTime{Time: due.Time.AddDate(0, 0, 1), DateOnly: true}
+			} else {
+				ev.Duration = calendarEventDuration
+			}
+			cal.Events = append(cal.Events, ev)
+			continue
+		}
+
+		todo := ical.Todo{
+			UID:         li.ID.Hex() + calendarUIDDomain,
+			Summary:     summary,
+			Description: li.Note,
+			Categories:  categories,
+			Status:      ical.StatusNeedsAction,
+			Start:       start,
+			Due:         &due,
+			RRule:       rrule,
+			Stamp:       now,
+		}
+		switch li.Priority {
+		case parser.PriorityHigh:
+			todo.Priority = 1
+		case parser.PriorityLow:
+			todo.Priority = 9
+		}
+		if li.IsCompleted {
+			completed := now
+			if li.Clock.IsCompleted != "" {
+				completed = li.Clock.IsCompleted.Time()
+			}
+			todo.Status, todo.Completed = ical.StatusCompleted, &completed
+		}
+		cal.Todos = append(cal.Todos, todo)
+	}
+	return &cal, nil
+}
+
+// calendarDateTime возвращает срок элемента как дату или момент в часовом поясе срока.
+func calendarDateTime(d ItemDue) ical.DateTime {
+	if d.Date != "" {
+		if t, err := time.Parse(dueDateLayout, d.Date); err == nil {
+			return ical.DateTime{Time: t, DateOnly: true}
+		}
+	}
+	loc, err := time.LoadLocation(d.TimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+	return ical.DateTime{Time: d.At.In(loc)}
+}
+
+// ImportCalendar добавляет незавершенные задачи календаря в конец списка покупок. Срок без часового пояса
+// и повторение без него относятся к часовому поясу loc. Если список не найден или недоступен пользователю,
+// возвращается nil.
+func ImportCalendar(cal ical.Calendar, userId, listId primitive.ObjectID, loc *time.Location, now time.Time) (*CalendarImport, error) {
+	res := CalendarImport{}
+	items := make([]ListItem, 0, len(cal.Todos))
+	for _, t := range cal.Todos {
+		name := strings.TrimSpace(t.Summary)
+		if name == "" || t.Status == ical.StatusCompleted || t.Status == ical.StatusCancelled || t.Completed != nil {
+			res.Skipped++
+			continue
+		}
+
+		li := ListItem{Name: name, Note: truncateRunes(strings.TrimSpace(t.Description), 500)}
+		if len(t.Categories) > 0 {
+			li.Category = truncateRunes(strings.ToLower(strings.TrimSpace(t.Categories[0])), 32)
+		}
+		switch {
+		case t.Priority >= 1 && t.Priority <= 4:
+			li.Priority = parser.PriorityHigh
+		case t.Priority >= 6 && t.Priority <= 9:
+			li.Priority = parser.PriorityLow
+		}
+		if t.Due != nil {
+			value, zone := importDateTime(*t.Due, loc)
+			if due, err := ParseItemDue(value, zone, nil); err == nil {
+				li.Due = due
+			}
+		}
+		if t.RRule != "" {
+			start := t.Start
+			if start == nil {
+				start = t.Due
+			}
+			value, zone := "", loc.String()
+			if start != nil {
+				value, zone = importDateTime(*start, loc)
+			}
+			if rec, err := ParseRecurrence(t.RRule, value, zone, now); err == nil {
+				li.Recurrence = rec
+			} else {
+				res.NoRecurrence++
+			}
+		}
+		items = append(items, li)
+	}
+
+	ids, err := AddListItems(items, userId, listId)
+	if err != nil || ids == nil {
+		return nil, err
+	}
+	res.ItemIds = ids
+	return &res, nil
+}
+
+// importDateTime возвращает дату или местное время и часовой пояс для ParseItemDue и ParseRecurrence.
+// Момент без часового пояса и момент в UTC относятся к часовому поясу loc.
+func importDateTime(dt ical.DateTime, loc *time.Location) (value, zone string) {
+	switch {
+	case dt.DateOnly:
+		return dt.Time.Format(dueDateLayout), loc.String()
+	case dt.Floating:
+		return dt.Time.Format(dueLocalSecsLayout), loc.String()
+	case dt.Time.Location() == time.UTC:
+		return dt.Time.In(loc).Format(dueLocalSecsLayout), loc.String()
+	}
+	return dt.Time.Format(dueLocalSecsLayout), dt.Time.Location().String()
+}
+
+// truncateRunes обрезает строку до n символов.
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
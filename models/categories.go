@@ -0,0 +1,65 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/abel-03/go-todo/catalog"
+	"github.com/abel-03/go-todo/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CategoryChoice запоминает категорию, которую пользователь выбрал для товара.
+// Name хранится в виде, возвращаемом catalog.NormalizeName.
+type CategoryChoice struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserId    primitive.ObjectID `bson:"userId"`
+	Name      string             `bson:"name"`
+	Category  string             `bson:"category"`
+	UpdatedAt time.Time          `bson:"updatedAt"`
+}
+
+// rememberCategory сохраняет выбор категории для товара, чтобы назначать ее автоматически в следующий раз.
+func rememberCategory(userId primitive.ObjectID, name, category string) error {
+	normalized := catalog.NormalizeName(name)
+	if normalized == "" || category == "" {
+		return nil
+	}
+
+	_, err := config.CategoryChoices.UpdateOne(context.TODO(),
+		bson.M{"userId": userId, "name": normalized},
+		bson.M{"$set": bson.M{"category": category, "updatedAt": time.Now()}},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+// guessCategory возвращает категорию товара: сначала ранее выбранную пользователем, затем из встроенного словаря.
+func guessCategory(userId primitive.ObjectID, name string) (string, error) {
+	normalized := catalog.NormalizeName(name)
+	if normalized == "" {
+		return "", nil
+	}
+
+	var choice CategoryChoice
+	err := config.CategoryChoices.FindOne(context.TODO(), bson.M{"userId": userId, "name": normalized}).Decode(&choice)
+	if err == mongo.ErrNoDocuments {
+		return catalog.Guess(normalized), nil
+	} else if err != nil {
+		return "", err
+	}
+	return choice.Category, nil
+}
+
+// categorize назначает новому элементу категорию. Категория, указанная явно, запоминается как выбор пользователя.
+func categorize(userId primitive.ObjectID, li ListItem) (ListItem, error) {
+	if li.Category != "" {
+		return li, rememberCategory(userId, li.Name, li.Category)
+	}
+
+	category, err := guessCategory(userId, li.Name)
+	li.Category = category
+	return li, err
+}
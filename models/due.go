@@ -0,0 +1,191 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/abel-03/go-todo/config"
+	"github.com/abel-03/go-todo/parser"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Выборки элементов по сроку.
+const (
+	DueOverdue = "overdue"
+	DueToday   = "today"
+	DueWeek    = "week"
+)
+
+// MaxReminderOffset - наибольшее время напоминания до срока, в минутах.
+const MaxReminderOffset = 30 * 24 * 60
+
+// ErrInvalidDue возвращается, если срок элемента, часовой пояс или время напоминания заданы неверно.
+var ErrInvalidDue = errors.New("invalid due date")
+
+// Форматы срока: дата без времени и местное время в часовом поясе элемента.
+const (
+	dueDateLayout      = "2006-01-02"
+	dueLocalLayout     = "2006-01-02T15:04"
+	dueLocalSecsLayout = "2006-01-02T15:04:05"
+)
+
+// ItemDue описывает срок элемента. At - момент, после которого элемент считается просроченным;
+// для срока без времени это конец дня Date в часовом поясе TimeZone.
+// ReminderOffset задает, за сколько минут до срока напомнить об элементе; nil - без напоминания.
+type ItemDue struct {
+	At             time.Time `json:"at" bson:"at"`
+	Date           string    `json:"date,omitempty" bson:"date,omitempty"`
+	TimeZone       string    `json:"timeZone" bson:"timeZone"`
+	ReminderOffset *int      `json:"reminderOffset,omitempty" bson:"reminderOffset,omitempty"`
+}
+
+// ParseItemDue разбирает срок элемента. value - дата ("2024-05-01"), местное время в часовом поясе timeZone
+// ("2024-05-01T18:00") или момент со смещением в формате RFC 3339. Пустой timeZone означает UTC.
+func ParseItemDue(value, timeZone string, reminderOffset *int) (*ItemDue, error) {
+	if timeZone == "" {
+		timeZone = "UTC"
+	}
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, ErrInvalidDue
+	}
+	if reminderOffset != nil && (*reminderOffset < 0 || *reminderOffset > MaxReminderOffset) {
+		return nil, ErrInvalidDue
+	}
+
+	due := ItemDue{TimeZone: timeZone, ReminderOffset: reminderOffset}
+	if d, err := time.ParseInLocation(dueDateLayout, value, loc); err == nil {
+		due.Date, due.At = value, d.AddDate(0, 0, 1)
+	} else if t, err := time.ParseInLocation(dueLocalLayout, value, loc); err == nil {
+		due.At = t
+	} else if t, err := time.ParseInLocation(dueLocalSecsLayout, value, loc); err == nil {
+		due.At = t
+	} else if t, err := time.Parse(time.RFC3339, value); err == nil {
+		due.At = t
+	} else {
+		return nil, ErrInvalidDue
+	}
+	due.At = due.At.UTC()
+	return &due, nil
+}
+
+// Equal сообщает, совпадает ли срок с other.
+func (d ItemDue) Equal(other *ItemDue) bool {
+	if other == nil || !d.At.Equal(other.At) || d.Date != other.Date || d.TimeZone != other.TimeZone {
+		return false
+	}
+	if d.ReminderOffset == nil || other.ReminderOffset == nil {
+		return d.ReminderOffset == nil && other.ReminderOffset == nil
+	}
+	return *d.ReminderOffset == *other.ReminderOffset
+}
+
+// DueWindow возвращает полуинтервал [from, to) сроков выборки kind в момент now для часового пояса loc.
+// Просроченные - элементы со сроком раньше now; на сегодня - до конца текущего дня; на неделю - до конца
+// текущей недели, которая начинается в понедельник. Конец дня входит в выборку, чтобы в нее попадали
+// элементы со сроком без времени. Нулевая from означает, что начало не ограничено.
+func DueWindow(kind string, now time.Time, loc *time.Location) (from, to time.Time, ok bool) {
+	local := now.In(loc)
+	startOfDay := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	switch kind {
+	case DueOverdue:
+		return time.Time{}, now, true
+	case DueToday:
+		return now, startOfDay.AddDate(0, 0, 1).Add(time.Millisecond), true
+	case DueWeek:
+		daysLeft := (7 - int(local.Weekday()) + int(time.Monday)) % 7
+		if daysLeft == 0 {
+			daysLeft = 7
+		}
+		return now, startOfDay.AddDate(0, 0, daysLeft).Add(time.Millisecond), true
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+// DueItem представляет элемент со сроком вместе с его списком.
+type DueItem struct {
+	ListId   primitive.ObjectID `json:"listId" bson:"listId"`
+	ListName string             `json:"listName" bson:"listName"`
+	Item     ListItem           `json:"item" bson:"item"`
+}
+
+// DueItemsPage представляет страницу элементов со сроком. Total - общее число элементов выборки.
+type DueItemsPage struct {
+	Items  []DueItem `json:"items"`
+	Total  int64     `json:"total"`
+	Limit  int64     `json:"limit"`
+	Offset int64     `json:"offset"`
+}
+
+// DueItems возвращает незавершенные и не скрытые до повторения элементы всех доступных пользователю неархивных списков
+// со сроком в полуинтервале [from, to) (см. DueWindow). Элементы упорядочены по сроку, затем по приоритету и позиции.
+func DueItems(userId primitive.ObjectID, from, to time.Time, limit, offset int64) (*DueItemsPage, error) {
+	access, err := listAccessFilter(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	at := bson.M{"$lt": to}
+	if !from.IsZero() {
+		at["$gte"] = from
+	}
+	itemMatch := bson.M{"items.isCompleted": false, "items.due.at": at, "items.hiddenUntil": bson.M{"$exists": false}}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$or": access, "archived": bson.M{"$ne": true}, "items.due.at": at}}},
+		{{Key: "$unwind", Value: "$items"}},
+		{{Key: "$match", Value: itemMatch}},
+		{{Key: "$addFields", Value: bson.M{
+			// Высокий приоритет идет раньше обычного, низкий - позже.
+			"priorityOrder": bson.M{"$switch": bson.M{
+				"branches": bson.A{
+					bson.M{"case": bson.M{"$eq": bson.A{"$items.priority", parser.PriorityHigh}}, "then": 0},
+					bson.M{"case": bson.M{"$eq": bson.A{"$items.priority", parser.PriorityLow}}, "then": 2},
+				},
+				"default": 1,
+			}},
+		}}},
+		{{Key: "$sort", Value: bson.D{
+			{Key: "items.due.at", Value: 1},
+			{Key: "priorityOrder", Value: 1},
+			{Key: "items.position", Value: 1},
+			{Key: "items._id", Value: 1},
+		}}},
+		{{Key: "$facet", Value: bson.M{
+			"items": bson.A{
+				bson.M{"$skip": offset},
+				bson.M{"$limit": limit},
+				bson.M{"$project": bson.M{"_id": 0, "listId": "$_id", "listName": "$name", "item": "$items"}},
+			},
+			"total": bson.A{bson.M{"$count": "n"}},
+		}}},
+	}
+
+	cursor, err := config.ShoppingLists.Aggregate(context.Background(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var result []struct {
+		Items []DueItem `bson:"items"`
+		Total []struct {
+			N int64 `bson:"n"`
+		} `bson:"total"`
+	}
+	if err = cursor.All(context.Background(), &result); err != nil {
+		return nil, err
+	}
+
+	page := DueItemsPage{Items: make([]DueItem, 0), Limit: limit, Offset: offset}
+	if len(result) > 0 {
+		page.Items = append(page.Items, result[0].Items...)
+		if len(result[0].Total) > 0 {
+			page.Total = result[0].Total[0].N
+		}
+	}
+	return &page, nil
+}
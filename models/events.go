@@ -0,0 +1,56 @@
+package models
+
+import (
+	"context"
+	"log"
+
+	"github.com/abel-03/go-todo/config"
+	"github.com/abel-03/go-todo/events"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// listMembersProjection - поля списка, необходимые для определения его участников.
+var listMembersProjection = bson.M{"ownerId": 1, "sharingIds": 1, "groupId": 1}
+
+// listMembers возвращает идентификаторы всех пользователей, имеющих доступ к списку.
+func listMembers(l ShoppingList) ([]primitive.ObjectID, error) {
+	ids := append([]primitive.ObjectID{l.OwnerId}, l.SharingIds...)
+	if l.GroupId == nil {
+		return ids, nil
+	}
+
+	var g Group
+	err := config.Groups.FindOne(context.TODO(), bson.M{"_id": *l.GroupId},
+		options.FindOne().SetProjection(bson.M{"members.userId": 1})).Decode(&g)
+	if err == mongo.ErrNoDocuments {
+		return ids, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, m := range g.Members {
+		ids = append(ids, m.UserId)
+	}
+	return ids, nil
+}
+
+// publishListEvent публикует событие об изменении списка для всех его участников.
+// Ошибка определения получателей только логируется: изменение уже сохранено и не должно из-за нее откатываться.
+func publishListEvent(l ShoppingList, eventType string, actorId primitive.ObjectID, itemId *primitive.ObjectID, data interface{}) {
+	members, err := listMembers(l)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	events.Publish(events.Event{
+		Type:       eventType,
+		ListId:     l.ID,
+		ItemId:     itemId,
+		ActorId:    actorId,
+		Data:       data,
+		Recipients: members,
+	})
+}
@@ -0,0 +1,414 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/abel-03/go-todo/config"
+	"github.com/abel-03/go-todo/events"
+	"github.com/abel-03/go-todo/split"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInvalidPayment возвращается, если плательщик или участник доли оплаты не является участником списка,
+// доли повторяются или не складываются в сумму оплаты.
+var ErrInvalidPayment = errors.New("invalid checkout payment")
+
+// ErrInvalidSettlement возвращается, если платеж проводится не между участниками расчетов списка
+// или пользователь, записывающий платеж, не является ни плательщиком, ни получателем.
+var ErrInvalidSettlement = errors.New("invalid settlement")
+
+// CheckoutPayment представляет оплату покупки: PayerId заплатил Amount в валюте Currency,
+// а Shares - доли участников списка в этой сумме.
+type CheckoutPayment struct {
+	PayerId  primitive.ObjectID `json:"payerId" bson:"payerId"`
+	Amount   float64            `json:"amount" bson:"amount"`
+	Currency string             `json:"currency" bson:"currency"`
+	Shares   []PaymentShare     `json:"shares" bson:"shares"`
+}
+
+// PaymentShare представляет долю участника в оплате покупки.
+type PaymentShare struct {
+	UserId primitive.ObjectID `json:"userId" bson:"userId"`
+	Amount float64            `json:"amount" bson:"amount"`
+}
+
+// Settlement представляет платеж, которым участник FromId вернул участнику ToId долг за покупки списка.
+// CreatedBy - пользователь, записавший платеж. Key определяется платежом и балансами, по которым он рассчитан
+// (см. settlementKey), и уникален, поэтому повтор одного запроса не записывает платеж дважды.
+type Settlement struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	ListId    primitive.ObjectID `json:"listId" bson:"listId"`
+	FromId    primitive.ObjectID `json:"fromId" bson:"fromId"`
+	ToId      primitive.ObjectID `json:"toId" bson:"toId"`
+	Amount    float64            `json:"amount" bson:"amount"`
+	Currency  string             `json:"currency" bson:"currency"`
+	Time      time.Time          `json:"time" bson:"time"`
+	CreatedBy primitive.ObjectID `json:"createdBy" bson:"createdBy"`
+	Key       string             `json:"-" bson:"key,omitempty"`
+}
+
+// MemberBalance представляет баланс участника списка в валюте Currency:
+// положительный, если участнику должны, и отрицательный, если должен он.
+type MemberBalance struct {
+	UserId   primitive.ObjectID `json:"userId"`
+	Currency string             `json:"currency"`
+	Amount   float64            `json:"amount"`
+}
+
+// SettlementTransfer представляет перевод, который предлагается сделать, чтобы рассчитаться по списку.
+type SettlementTransfer struct {
+	FromId   primitive.ObjectID `json:"fromId"`
+	ToId     primitive.ObjectID `json:"toId"`
+	Currency string             `json:"currency"`
+	Amount   float64            `json:"amount"`
+}
+
+// BalanceSheet содержит ненулевые балансы участников списка и переводы, которые их обнуляют.
+type BalanceSheet struct {
+	Balances  []MemberBalance      `json:"balances"`
+	Transfers []SettlementTransfer `json:"transfers"`
+}
+
+// distinctMembers возвращает множество участников списка.
+func distinctMembers(l ShoppingList) (map[primitive.ObjectID]bool, []primitive.ObjectID, error) {
+	members, err := listMembers(l)
+	if err != nil {
+		return nil, nil, err
+	}
+	set := make(map[primitive.ObjectID]bool, len(members))
+	ordered := make([]primitive.ObjectID, 0, len(members))
+	for _, id := range members {
+		if !set[id] {
+			set[id] = true
+			ordered = append(ordered, id)
+		}
+	}
+	return set, ordered, nil
+}
+
+// resolvePayment проверяет оплату покупки списка l и округляет суммы до минимальных единиц валюты.
+// Если доли не заданы, сумма делится поровну между всеми участниками списка.
+func resolvePayment(l ShoppingList, p CheckoutPayment) (*CheckoutPayment, error) {
+	set, members, err := distinctMembers(l)
+	if err != nil {
+		return nil, err
+	}
+	if !set[p.PayerId] {
+		return nil, ErrInvalidPayment
+	}
+
+	total := split.Cents(p.Amount)
+	resolved := CheckoutPayment{PayerId: p.PayerId, Amount: split.Amount(total), Currency: p.Currency}
+	if len(p.Shares) == 0 {
+		ids := make([]string, 0, len(members))
+		for _, id := range members {
+			ids = append(ids, id.Hex())
+		}
+		shares := split.Equal(total, ids)
+		for _, id := range members {
+			resolved.Shares = append(resolved.Shares, PaymentShare{UserId: id, Amount: split.Amount(shares[id.Hex()])})
+		}
+		return &resolved, nil
+	}
+
+	seen := make(map[primitive.ObjectID]bool, len(p.Shares))
+	var sum int64
+	for _, s := range p.Shares {
+		if !set[s.UserId] || seen[s.UserId] {
+			return nil, ErrInvalidPayment
+		}
+		seen[s.UserId] = true
+		cents := split.Cents(s.Amount)
+		sum += cents
+		resolved.Shares = append(resolved.Shares, PaymentShare{UserId: s.UserId, Amount: split.Amount(cents)})
+	}
+	if sum != total {
+		return nil, ErrInvalidPayment
+	}
+	return &resolved, nil
+}
+
+// balances возвращает балансы участников списка listId по валютам в минимальных единицах валюты:
+// плательщику покупки должны ее сумму, а каждый участник должен свою долю; платеж уменьшает долг плательщика
+// и то, что должны получателю. snapshot обозначает состояние расчетов, по которому посчитаны балансы:
+// последнюю оплаченную покупку и последний платеж.
+func balances(listId primitive.ObjectID) (res map[string]map[primitive.ObjectID]int64, snapshot string, err error) {
+	res = make(map[string]map[primitive.ObjectID]int64)
+	add := func(currency string, userId primitive.ObjectID, cents int64) {
+		if res[currency] == nil {
+			res[currency] = make(map[primitive.ObjectID]int64)
+		}
+		res[currency][userId] += cents
+	}
+
+	cursor, err := config.Checkouts.Find(context.TODO(),
+		bson.M{"listId": listId, "payment": bson.M{"$exists": true}},
+		options.Find().SetProjection(bson.M{"payment": 1}))
+	if err != nil {
+		return nil, "", err
+	}
+	var checkouts []Checkout
+	if err := cursor.All(context.TODO(), &checkouts); err != nil {
+		return nil, "", err
+	}
+	var lastCheckout, lastSettlement primitive.ObjectID
+	for _, c := range checkouts {
+		if bytes.Compare(c.ID[:], lastCheckout[:]) > 0 {
+			lastCheckout = c.ID
+		}
+		p := c.Payment
+		add(p.Currency, p.PayerId, split.Cents(p.Amount))
+		for _, s := range p.Shares {
+			add(p.Currency, s.UserId, -split.Cents(s.Amount))
+		}
+	}
+
+	cursor, err = config.Settlements.Find(context.TODO(), bson.M{"listId": listId})
+	if err != nil {
+		return nil, "", err
+	}
+	var settlements []Settlement
+	if err := cursor.All(context.TODO(), &settlements); err != nil {
+		return nil, "", err
+	}
+	for _, s := range settlements {
+		if bytes.Compare(s.ID[:], lastSettlement[:]) > 0 {
+			lastSettlement = s.ID
+		}
+		add(s.Currency, s.FromId, split.Cents(s.Amount))
+		add(s.Currency, s.ToId, -split.Cents(s.Amount))
+	}
+	return res, lastCheckout.Hex() + ":" + lastSettlement.Hex(), nil
+}
+
+// hasOpenBalances сообщает, остались ли у участников списка listId ненулевые балансы.
+func hasOpenBalances(listId primitive.ObjectID) (bool, error) {
+	byCurrency, _, err := balances(listId)
+	if err != nil {
+		return false, err
+	}
+	for _, members := range byCurrency {
+		for _, v := range members {
+			if v != 0 {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// settlementTransfers возвращает переводы, обнуляющие балансы, по валютам (см. split.Settle).
+func settlementTransfers(byCurrency map[string]map[primitive.ObjectID]int64) []SettlementTransfer {
+	currencies := make([]string, 0, len(byCurrency))
+	for c := range byCurrency {
+		currencies = append(currencies, c)
+	}
+	sort.Strings(currencies)
+
+	res := make([]SettlementTransfer, 0)
+	for _, c := range currencies {
+		ids := make(map[string]primitive.ObjectID, len(byCurrency[c]))
+		cents := make(map[string]int64, len(byCurrency[c]))
+		for id, v := range byCurrency[c] {
+			ids[id.Hex()] = id
+			cents[id.Hex()] = v
+		}
+		for _, t := range split.Settle(cents) {
+			res = append(res, SettlementTransfer{FromId: ids[t.From], ToId: ids[t.To], Currency: c, Amount: split.Amount(t.Amount)})
+		}
+	}
+	return res
+}
+
+// findMemberList возвращает участников доступного пользователю списка listId или nil, если список не найден.
+func findMemberList(listId, userId primitive.ObjectID) (*ShoppingList, error) {
+	access, err := listAccessFilter(userId)
+	if err != nil {
+		return nil, err
+	}
+	return findListItems(bson.M{"_id": listId, "$or": access}, options.FindOne().SetProjection(listMembersProjection))
+}
+
+// ListBalances возвращает балансы участников списка и переводы, которыми им можно рассчитаться.
+// Если список не найден или недоступен пользователю, возвращается nil.
+func ListBalances(listId, userId primitive.ObjectID) (*BalanceSheet, error) {
+	l, err := findMemberList(listId, userId)
+	if err != nil || l == nil {
+		return nil, err
+	}
+	byCurrency, _, err := balances(l.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := BalanceSheet{Balances: make([]MemberBalance, 0), Transfers: settlementTransfers(byCurrency)}
+	for c, members := range byCurrency {
+		for id, v := range members {
+			if v != 0 {
+				res.Balances = append(res.Balances, MemberBalance{UserId: id, Currency: c, Amount: split.Amount(v)})
+			}
+		}
+	}
+	sort.Slice(res.Balances, func(i, j int) bool {
+		a, b := res.Balances[i], res.Balances[j]
+		if a.Currency != b.Currency {
+			return a.Currency < b.Currency
+		}
+		return a.UserId.Hex() < b.UserId.Hex()
+	})
+	return &res, nil
+}
+
+// settlementKey возвращает ключ платежа s, рассчитанного по балансам в состоянии snapshot (см. balances).
+// Одинаковые платежи, рассчитанные по одному состоянию, например при повторе запроса, получают один ключ,
+// а после любой новой оплаты или платежа ключ меняется.
+func settlementKey(snapshot string, s Settlement) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%s|%s|%s|%d",
+		s.ListId.Hex(), snapshot, s.FromId.Hex(), s.ToId.Hex(), s.Currency, split.Cents(s.Amount))))
+	return hex.EncodeToString(sum[:])
+}
+
+// recordSettlements сохраняет платежи по списку l, уведомляет о новых платежах участников списка и возвращает
+// сохраненные платежи. Платеж, ключ которого уже записан, не сохраняется повторно: вместо него возвращается
+// записанный ранее.
+func recordSettlements(l ShoppingList, userId primitive.ObjectID, settlements []Settlement) ([]Settlement, error) {
+	if len(settlements) == 0 {
+		return settlements, nil
+	}
+	docs := make([]interface{}, 0, len(settlements))
+	for _, s := range settlements {
+		docs = append(docs, s)
+	}
+	_, err := config.Settlements.InsertMany(context.TODO(), docs, options.InsertMany().SetOrdered(false))
+	duplicate := make(map[int]bool)
+	if bwe, ok := err.(mongo.BulkWriteException); ok && bwe.WriteConcernError == nil {
+		for _, we := range bwe.WriteErrors {
+			if !mongo.IsDuplicateKeyError(we.WriteError) {
+				return nil, err
+			}
+			duplicate[we.Index] = true
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	inserted := make([]Settlement, 0, len(settlements))
+	keys := make([]string, 0, len(duplicate))
+	for i, s := range settlements {
+		if duplicate[i] {
+			keys = append(keys, s.Key)
+		} else {
+			inserted = append(inserted, s)
+		}
+	}
+	if len(inserted) > 0 {
+		publishListEvent(l, events.ListSettled, userId, nil, inserted)
+	}
+	if len(keys) == 0 {
+		return settlements, nil
+	}
+
+	cursor, err := config.Settlements.Find(context.TODO(), bson.M{"key": bson.M{"$in": keys}})
+	if err != nil {
+		return nil, err
+	}
+	var existing []Settlement
+	if err := cursor.All(context.TODO(), &existing); err != nil {
+		return nil, err
+	}
+	byKey := make(map[string]Settlement, len(existing))
+	for _, s := range existing {
+		byKey[s.Key] = s
+	}
+	res := make([]Settlement, 0, len(settlements))
+	for i, s := range settlements {
+		if stored, ok := byKey[s.Key]; duplicate[i] && ok {
+			s = stored
+		}
+		res = append(res, s)
+	}
+	return res, nil
+}
+
+// AddSettlement записывает платеж s по списку listId от имени пользователя userId, который должен быть
+// плательщиком или получателем. Платить можно текущим участникам списка и тем, с кем по списку остались расчеты.
+// Если список не найден или недоступен пользователю, возвращается nil.
+func AddSettlement(listId, userId primitive.ObjectID, s Settlement) (*Settlement, error) {
+	l, err := findMemberList(listId, userId)
+	if err != nil || l == nil {
+		return nil, err
+	}
+	participants, _, err := distinctMembers(*l)
+	if err != nil {
+		return nil, err
+	}
+	byCurrency, snapshot, err := balances(l.ID)
+	if err != nil {
+		return nil, err
+	}
+	for id, v := range byCurrency[s.Currency] {
+		participants[id] = participants[id] || v != 0
+	}
+
+	if s.FromId == s.ToId || !participants[s.FromId] || !participants[s.ToId] || (userId != s.FromId && userId != s.ToId) {
+		return nil, ErrInvalidSettlement
+	}
+
+	s.ID = primitive.NewObjectID()
+	s.ListId = l.ID
+	s.Amount = split.Amount(split.Cents(s.Amount))
+	s.Time = time.Now()
+	s.CreatedBy = userId
+	s.Key = settlementKey(snapshot, s)
+	recorded, err := recordSettlements(*l, userId, []Settlement{s})
+	if err != nil {
+		return nil, err
+	}
+	return &recorded[0], nil
+}
+
+// SettleUp записывает предлагаемые переводы по списку listId (см. ListBalances), в которых пользователь userId
+// платит или получает деньги, и возвращает записанные платежи. Если задан currency, рассчитываются только долги
+// в этой валюте. Повторы запроса, рассчитанные по тем же балансам, не записывают переводы второй раз.
+// Если список не найден или недоступен пользователю, возвращается nil.
+func SettleUp(listId, userId primitive.ObjectID, currency string) ([]Settlement, error) {
+	l, err := findMemberList(listId, userId)
+	if err != nil || l == nil {
+		return nil, err
+	}
+	byCurrency, snapshot, err := balances(l.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	settlements := make([]Settlement, 0)
+	for _, t := range settlementTransfers(byCurrency) {
+		if (t.FromId != userId && t.ToId != userId) || (currency != "" && t.Currency != currency) {
+			continue
+		}
+		s := Settlement{
+			ID:        primitive.NewObjectID(),
+			ListId:    l.ID,
+			FromId:    t.FromId,
+			ToId:      t.ToId,
+			Amount:    t.Amount,
+			Currency:  t.Currency,
+			Time:      now,
+			CreatedBy: userId,
+		}
+		s.Key = settlementKey(snapshot, s)
+		settlements = append(settlements, s)
+	}
+	return recordSettlements(*l, userId, settlements)
+}
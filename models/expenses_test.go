@@ -0,0 +1,30 @@
+package models
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSettlementKey(t *testing.T) {
+	s := Settlement{ListId: primitive.NewObjectID(), FromId: primitive.NewObjectID(), ToId: primitive.NewObjectID(), Amount: 12.5, Currency: "EUR"}
+	key := settlementKey("a:b", s)
+
+	// Повтор того же платежа по тем же балансам получает тот же ключ, даже с другим ID и временем.
+	retry := s
+	retry.ID = primitive.NewObjectID()
+	if got := settlementKey("a:b", retry); got != key {
+		t.Errorf("retry key = %s, want %s", got, key)
+	}
+
+	other := s
+	other.Amount = 12.51
+	for name, got := range map[string]string{
+		"new snapshot": settlementKey("a:c", s),
+		"amount":       settlementKey("a:b", other),
+	} {
+		if got == key {
+			t.Errorf("%s: key %s equals the original", name, got)
+		}
+	}
+}
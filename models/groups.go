@@ -0,0 +1,427 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/abel-03/go-todo/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Роли участников группы.
+const (
+	GroupRoleOwner  = "owner"
+	GroupRoleAdmin  = "admin"
+	GroupRoleMember = "member"
+)
+
+// ErrGroupOwnerLeave возвращается, когда владелец пытается покинуть группу, в которой есть другие участники.
+var ErrGroupOwnerLeave = errors.New("group owner must transfer ownership before leaving")
+
+// GroupMember представляет участника группы.
+type GroupMember struct {
+	UserId   primitive.ObjectID `json:"userId" bson:"userId"`
+	Name     string             `json:"name" bson:"name,omitempty"`
+	Role     string             `json:"role" bson:"role"`
+	JoinedAt time.Time          `json:"joinedAt" bson:"joinedAt"`
+}
+
+// GroupInvite представляет приглашение пользователя в группу.
+type GroupInvite struct {
+	UserId    primitive.ObjectID `json:"userId" bson:"userId"`
+	Name      string             `json:"name" bson:"name,omitempty"`
+	Role      string             `json:"role" bson:"role"`
+	InvitedBy primitive.ObjectID `json:"invitedBy" bson:"invitedBy"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// Group представляет группу пользователей (например, семью), которая совместно владеет списками.
+type Group struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Name      string             `json:"name" bson:"name"`
+	OwnerId   primitive.ObjectID `json:"ownerId" bson:"ownerId"`
+	Members   []GroupMember      `json:"members" bson:"members"`
+	Invites   []GroupInvite      `json:"invites" bson:"invites"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// IsValidGroupRole проверяет, что роль может быть назначена участнику группы.
+func IsValidGroupRole(role string) bool {
+	return role == GroupRoleOwner || role == GroupRoleAdmin || role == GroupRoleMember
+}
+
+// groupNameLookupStages возвращает стадии конвейера, подставляющие имена участников и приглашенных пользователей.
+func groupNameLookupStages() mongo.Pipeline {
+	withName := func(field, users string) bson.M {
+		return bson.M{
+			"$map": bson.M{
+				"input": "$" + field,
+				"as":    "m",
+				"in": bson.M{
+					"$mergeObjects": bson.A{
+						"$$m",
+						bson.M{"name": bson.M{"$arrayElemAt": bson.A{
+							bson.M{"$map": bson.M{
+								"input": bson.M{"$filter": bson.M{
+									"input": "$" + users,
+									"as":    "u",
+									"cond":  bson.M{"$eq": bson.A{"$$u._id", "$$m.userId"}},
+								}},
+								"as": "u",
+								"in": bson.M{"$ifNull": bson.A{"$$u.displayName", "$$u.name"}},
+							}},
+							0,
+						}}},
+					},
+				},
+			},
+		}
+	}
+
+	return mongo.Pipeline{
+		{
+			{Key: "$lookup", Value: bson.M{
+				"from":         "users",
+				"localField":   "members.userId",
+				"foreignField": "_id",
+				"as":           "memberUsers",
+			}},
+		},
+		{
+			{Key: "$lookup", Value: bson.M{
+				"from":         "users",
+				"localField":   "invites.userId",
+				"foreignField": "_id",
+				"as":           "inviteUsers",
+			}},
+		},
+		{
+			{Key: "$addFields", Value: bson.M{
+				"members": withName("members", "memberUsers"),
+				"invites": withName("invites", "inviteUsers"),
+			}},
+		},
+		{
+			{Key: "$project", Value: bson.M{"memberUsers": 0, "inviteUsers": 0}},
+		},
+	}
+}
+
+// aggregateGroups выполняет поиск групп по фильтру с подстановкой имен пользователей.
+func aggregateGroups(match bson.M) (*[]Group, error) {
+	pipeline := append(mongo.Pipeline{{{Key: "$match", Value: match}}}, groupNameLookupStages()...)
+
+	var result []Group
+	cursor, err := config.Groups.Aggregate(context.Background(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	if err = cursor.All(context.Background(), &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// AllGroups возвращает все группы, в которых состоит пользователь.
+func AllGroups(userId primitive.ObjectID) (*[]Group, error) {
+	return aggregateGroups(bson.M{"members.userId": userId})
+}
+
+// AllGroupInvites возвращает все группы, в которые пользователь приглашен.
+func AllGroupInvites(userId primitive.ObjectID) (*[]Group, error) {
+	return aggregateGroups(bson.M{"invites.userId": userId})
+}
+
+// AddGroup создает новую группу, владельцем которой становится пользователь.
+func AddGroup(name string, ownerId primitive.ObjectID) (string, error) {
+	now := time.Now()
+	g := Group{
+		ID:      primitive.NewObjectID(),
+		Name:    name,
+		OwnerId: ownerId,
+		Members: []GroupMember{
+			{UserId: ownerId, Role: GroupRoleOwner, JoinedAt: now},
+		},
+		Invites:   make([]GroupInvite, 0),
+		CreatedAt: now,
+	}
+
+	// Вставляем новую группу в MongoDB.
+	_, err := config.Groups.InsertOne(context.TODO(), g)
+	if err != nil {
+		return "", err
+	}
+	return g.ID.Hex(), nil
+}
+
+// UserGroupIds возвращает идентификаторы групп, в которых пользователь состоит с одной из указанных ролей.
+// Если роли не указаны, возвращаются все группы пользователя.
+func UserGroupIds(userId primitive.ObjectID, roles ...string) ([]primitive.ObjectID, error) {
+	member := bson.M{"userId": userId}
+	if len(roles) > 0 {
+		member["role"] = bson.M{"$in": roles}
+	}
+
+	cursor, err := config.Groups.Find(context.TODO(),
+		bson.M{"members": bson.M{"$elemMatch": member}},
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	ids := make([]primitive.ObjectID, 0)
+	for cursor.Next(context.Background()) {
+		var g Group
+		if err := cursor.Decode(&g); err != nil {
+			return nil, err
+		}
+		ids = append(ids, g.ID)
+	}
+	return ids, cursor.Err()
+}
+
+// GroupMemberRole возвращает роль пользователя в группе или пустую строку, если он не является участником.
+func GroupMemberRole(groupId, userId primitive.ObjectID) (string, error) {
+	var g Group
+	err := config.Groups.FindOne(context.TODO(), bson.M{"_id": groupId, "members.userId": userId}).Decode(&g)
+	if err == mongo.ErrNoDocuments {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	for _, m := range g.Members {
+		if m.UserId == userId {
+			return m.Role, nil
+		}
+	}
+	return "", nil
+}
+
+// AddGroupInvite приглашает пользователя в группу. Приглашать могут только владелец и администраторы.
+func AddGroupInvite(groupId, inviterId, userId primitive.ObjectID, role string) (bool, error) {
+	filter := bson.M{
+		"_id": groupId,
+		"members": bson.M{"$elemMatch": bson.M{
+			"userId": inviterId,
+			"role":   bson.M{"$in": bson.A{GroupRoleOwner, GroupRoleAdmin}},
+		}},
+		"members.userId": bson.M{"$ne": userId},
+		"invites.userId": bson.M{"$ne": userId},
+	}
+	update := bson.M{
+		"$push": bson.M{
+			"invites": GroupInvite{
+				UserId:    userId,
+				Role:      role,
+				InvitedBy: inviterId,
+				CreatedAt: time.Now(),
+			},
+		},
+	}
+
+	// Выполняем обновление в MongoDB.
+	result, err := config.Groups.UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// AcceptGroupInvite принимает приглашение пользователя в группу.
+func AcceptGroupInvite(groupId, userId primitive.ObjectID) (bool, error) {
+	// Находим приглашение, чтобы узнать назначенную роль.
+	var g Group
+	err := config.Groups.FindOne(context.TODO(), bson.M{"_id": groupId, "invites.userId": userId}).Decode(&g)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	role := GroupRoleMember
+	for _, i := range g.Invites {
+		if i.UserId == userId && i.Role != GroupRoleOwner && i.Role != "" {
+			role = i.Role
+		}
+	}
+
+	filter := bson.M{"_id": groupId, "invites.userId": userId, "members.userId": bson.M{"$ne": userId}}
+	update := bson.M{
+		"$pull": bson.M{"invites": bson.M{"userId": userId}},
+		"$push": bson.M{"members": GroupMember{UserId: userId, Role: role, JoinedAt: time.Now()}},
+	}
+	result, err := config.Groups.UpdateOne(context.TODO(), filter, update)
+	if err != nil || result.ModifiedCount != 1 {
+		return false, err
+	}
+
+	// Списки группы должны попасть в следующую синхронизацию нового участника.
+	if err := touchLists(bson.M{"groupId": groupId}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DeclineGroupInvite отклоняет приглашение пользователя в группу.
+func DeclineGroupInvite(groupId, userId primitive.ObjectID) (bool, error) {
+	filter := bson.M{"_id": groupId, "invites.userId": userId}
+	update := bson.M{"$pull": bson.M{"invites": bson.M{"userId": userId}}}
+
+	result, err := config.Groups.UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// LeaveGroup удаляет пользователя из группы по его собственному желанию.
+// Владелец может покинуть группу, только если он остался в ней один, и тогда группа удаляется.
+func LeaveGroup(groupId, userId primitive.ObjectID) (bool, error) {
+	var g Group
+	err := config.Groups.FindOne(context.TODO(), bson.M{"_id": groupId, "members.userId": userId}).Decode(&g)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if g.OwnerId == userId {
+		if len(g.Members) > 1 {
+			return false, ErrGroupOwnerLeave
+		}
+		return RemoveGroup(groupId, userId)
+	}
+
+	result, err := config.Groups.UpdateOne(context.TODO(),
+		bson.M{"_id": groupId},
+		bson.M{"$pull": bson.M{"members": bson.M{"userId": userId}}})
+	if err != nil || result.ModifiedCount != 1 {
+		return false, err
+	}
+
+	if err := revokeGroupListsForUser(groupId, userId); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RemoveGroupMember исключает участника из группы.
+// Владелец может исключить любого участника, администратор - только обычных участников.
+func RemoveGroupMember(groupId, actorId, userId primitive.ObjectID) (bool, error) {
+	actorRole, err := GroupMemberRole(groupId, actorId)
+	if err != nil {
+		return false, err
+	}
+
+	removable := bson.A{GroupRoleMember}
+	if actorRole == GroupRoleOwner {
+		removable = append(removable, GroupRoleAdmin)
+	} else if actorRole != GroupRoleAdmin {
+		return false, nil
+	}
+
+	filter := bson.M{
+		"_id": groupId,
+		"members": bson.M{"$elemMatch": bson.M{
+			"userId": userId,
+			"role":   bson.M{"$in": removable},
+		}},
+	}
+	update := bson.M{"$pull": bson.M{"members": bson.M{"userId": userId}}}
+
+	result, err := config.Groups.UpdateOne(context.TODO(), filter, update)
+	if err != nil || result.ModifiedCount != 1 {
+		return false, err
+	}
+
+	if err := revokeGroupListsForUser(groupId, userId); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// revokeGroupListsForUser отмечает для синхронизации, что пользователь потерял доступ к спискам группы,
+// кроме тех, которыми он владеет или которые открыты ему напрямую, и снимает его с элементов этих списков.
+func revokeGroupListsForUser(groupId, userId primitive.ObjectID) error {
+	filter := bson.M{
+		"groupId":    groupId,
+		"ownerId":    bson.M{"$ne": userId},
+		"sharingIds": bson.M{"$ne": userId},
+	}
+	if err := revokeListsForUser(filter, userId); err != nil {
+		return err
+	}
+	return unassignUser(filter, userId)
+}
+
+// SetGroupMemberRole изменяет роль участника группы. Менять роли может только владелец.
+// Назначение роли владельца передает владение группой, а прежний владелец становится администратором.
+func SetGroupMemberRole(groupId, ownerId, userId primitive.ObjectID, role string) (bool, error) {
+	if userId == ownerId {
+		return false, nil
+	}
+
+	filter := bson.M{"_id": groupId, "ownerId": ownerId, "members.userId": userId}
+	update := bson.M{
+		"$set": bson.M{"members.$[target].role": role},
+	}
+	arrayFilters := []interface{}{bson.M{"target.userId": userId}}
+
+	if role == GroupRoleOwner {
+		update["$set"] = bson.M{
+			"ownerId":                userId,
+			"members.$[target].role": GroupRoleOwner,
+			"members.$[prev].role":   GroupRoleAdmin,
+		}
+		arrayFilters = append(arrayFilters, bson.M{"prev.userId": ownerId})
+	}
+
+	result, err := config.Groups.UpdateOne(context.TODO(), filter, update,
+		options.Update().SetArrayFilters(options.ArrayFilters{Filters: arrayFilters}))
+	if err != nil {
+		return false, err
+	}
+	// Назначение участнику его текущей роли ничего не меняет, но считается успешным.
+	return result.MatchedCount == 1, nil
+}
+
+// RemoveGroup удаляет группу. Удалить группу может только ее владелец.
+// Списки группы не удаляются: они остаются у создавших их пользователей.
+func RemoveGroup(groupId, ownerId primitive.ObjectID) (bool, error) {
+	var g Group
+	err := config.Groups.FindOneAndDelete(context.TODO(), bson.M{"_id": groupId, "ownerId": ownerId}).Decode(&g)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	// Участники теряют доступ к спискам группы, которыми не владеют.
+	for _, m := range g.Members {
+		if err := revokeGroupListsForUser(groupId, m.UserId); err != nil {
+			return false, err
+		}
+	}
+
+	// Отвязываем списки от удаленной группы.
+	seq, err := NextChangeSeq()
+	if err != nil {
+		return false, err
+	}
+	defer releaseChangeSeq(seq)
+	_, err = config.ShoppingLists.UpdateMany(context.TODO(),
+		bson.M{"groupId": groupId},
+		bson.M{"$unset": bson.M{"groupId": ""}, "$max": bson.M{"seq": seq}, "$inc": bson.M{"version": 1}})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
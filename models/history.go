@@ -0,0 +1,241 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/abel-03/go-todo/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Checkout представляет запись истории покупок: завершение покупок списка и купленные элементы.
+// UserId - пользователь, завершивший покупки; у записей, созданных при обновлении повторяющегося списка, он не задан.
+// Payment - оплата покупки, если ее указал пользователь. MemberIds заполняется при удалении списка: записи
+// удаленного списка остаются в подсказках и аналитике тех, кто имел к нему доступ.
+type Checkout struct {
+	ID       primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	ListId   primitive.ObjectID  `json:"listId" bson:"listId"`
+	UserId   *primitive.ObjectID `json:"userId,omitempty" bson:"userId,omitempty"`
+	UserName string              `json:"userName,omitempty" bson:"userName,omitempty"`
+	Time     time.Time           `json:"time" bson:"time"`
+	Items    []PurchasedItem     `json:"items" bson:"items"`
+	Payment  *CheckoutPayment    `json:"payment,omitempty" bson:"payment,omitempty"`
+
+	MemberIds []primitive.ObjectID `json:"-" bson:"memberIds,omitempty"`
+}
+
+// PurchasedItem представляет купленный элемент в том виде, в каком он был в списке при завершении покупок.
+type PurchasedItem struct {
+	ItemId     primitive.ObjectID  `json:"itemId" bson:"itemId"`
+	Name       string              `json:"name" bson:"name"`
+	Quantity   float64             `json:"quantity,omitempty" bson:"quantity,omitempty"`
+	Unit       string              `json:"unit,omitempty" bson:"unit,omitempty"`
+	Note       string              `json:"note,omitempty" bson:"note,omitempty"`
+	Price      float64             `json:"price,omitempty" bson:"price,omitempty"`
+	Currency   string              `json:"currency,omitempty" bson:"currency,omitempty"`
+	Category   string              `json:"category,omitempty" bson:"category,omitempty"`
+	AssigneeId *primitive.ObjectID `json:"assigneeId,omitempty" bson:"assigneeId,omitempty"`
+}
+
+// CheckoutPage представляет страницу истории покупок списка. Total - общее число записей.
+type CheckoutPage struct {
+	Checkouts []Checkout `json:"checkouts"`
+	Total     int64      `json:"total"`
+	Limit     int64      `json:"limit"`
+	Offset    int64      `json:"offset"`
+}
+
+// EnsureHistoryIndexes создает индексы истории покупок и платежей участников для выборки записей списка
+// от новых к старым, уникальный индекс ключей платежей, а также индекс истории удаленных списков по их бывшим
+// участникам.
+func EnsureHistoryIndexes() error {
+	index := mongo.IndexModel{
+		Keys: bson.D{{Key: "listId", Value: 1}, {Key: "time", Value: -1}},
+	}
+	if _, err := config.Checkouts.Indexes().CreateOne(context.TODO(), index); err != nil {
+		return err
+	}
+	if _, err := config.Settlements.Indexes().CreateOne(context.TODO(), index); err != nil {
+		return err
+	}
+	_, err := config.Settlements.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "key", Value: 1}},
+		Options: options.Index().SetUnique(true).SetSparse(true),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = config.Checkouts.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "memberIds", Value: 1}, {Key: "time", Value: -1}},
+		Options: options.Index().SetSparse(true),
+	})
+	return err
+}
+
+// recordCheckout сохраняет в историю покупок элементы itemIds списка l, купленные в момент now, и их оплату payment.
+// Если ни одного элемента не куплено и оплата не задана, запись не создается и возвращается nil.
+func recordCheckout(l ShoppingList, userId *primitive.ObjectID, itemIds []primitive.ObjectID, payment *CheckoutPayment, now time.Time) (*Checkout, error) {
+	bought := make(map[primitive.ObjectID]bool, len(itemIds))
+	for _, id := range itemIds {
+		bought[id] = true
+	}
+
+	c := Checkout{
+		ID:      primitive.NewObjectID(),
+		ListId:  l.ID,
+		UserId:  userId,
+		Time:    now,
+		Items:   make([]PurchasedItem, 0, len(itemIds)),
+		Payment: payment,
+	}
+	for _, li := range l.Items {
+		if !bought[li.ID] {
+			continue
+		}
+		c.Items = append(c.Items, PurchasedItem{
+			ItemId:     li.ID,
+			Name:       li.Name,
+			Quantity:   li.Quantity,
+			Unit:       li.Unit,
+			Note:       li.Note,
+			Price:      li.Price,
+			Currency:   li.Currency,
+			Category:   li.Category,
+			AssigneeId: li.AssigneeId,
+		})
+	}
+	if len(c.Items) == 0 && payment == nil {
+		return nil, nil
+	}
+
+	if _, err := config.Checkouts.InsertOne(context.TODO(), c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// ListHistory возвращает страницу истории покупок списка от новых записей к старым
+// вместе с отображаемыми именами пользователей, завершивших покупки.
+// Если список не найден или недоступен пользователю, возвращается nil.
+func ListHistory(listId, userId primitive.ObjectID, limit, offset int64) (*CheckoutPage, error) {
+	access, err := listAccessFilter(userId)
+	if err != nil {
+		return nil, err
+	}
+	n, err := config.ShoppingLists.CountDocuments(context.TODO(), bson.M{"_id": listId, "$or": access},
+		options.Count().SetLimit(1))
+	if err != nil || n == 0 {
+		return nil, err
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"listId": listId}}},
+		{{Key: "$sort", Value: bson.D{{Key: "time", Value: -1}, {Key: "_id", Value: -1}}}},
+		{{Key: "$facet", Value: bson.M{
+			"checkouts": bson.A{
+				bson.M{"$skip": offset},
+				bson.M{"$limit": limit},
+				bson.M{"$lookup": bson.M{
+					"from":         "users",
+					"localField":   "userId",
+					"foreignField": "_id",
+					"as":           "user",
+				}},
+				bson.M{"$addFields": bson.M{
+					"userName": bson.M{"$arrayElemAt": bson.A{displayNames("$user"), 0}},
+				}},
+				bson.M{"$project": bson.M{"user": 0}},
+			},
+			"total": bson.A{bson.M{"$count": "n"}},
+		}}},
+	}
+	cursor, err := config.Checkouts.Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+
+	var result []struct {
+		Checkouts []Checkout `bson:"checkouts"`
+		Total     []struct {
+			N int64 `bson:"n"`
+		} `bson:"total"`
+	}
+	if err = cursor.All(context.TODO(), &result); err != nil {
+		return nil, err
+	}
+
+	page := CheckoutPage{Checkouts: make([]Checkout, 0), Limit: limit, Offset: offset}
+	if len(result) > 0 {
+		page.Checkouts = append(page.Checkouts, result[0].Checkouts...)
+		if len(result[0].Total) > 0 {
+			page.Total = result[0].Total[0].N
+		}
+	}
+	return &page, nil
+}
+
+// ReAddCheckoutItems снова добавляет купленные элементы записи checkoutId истории списка listId в конец списка targetId
+// и возвращает идентификаторы новых элементов. Если задан itemIds, добавляются только перечисленные элементы,
+// иначе вся покупка целиком. Если запись, список или список назначения не найдены или недоступны пользователю,
+// возвращается nil.
+func ReAddCheckoutItems(listId, checkoutId, userId, targetId primitive.ObjectID, itemIds []primitive.ObjectID) ([]primitive.ObjectID, error) {
+	access, err := listAccessFilter(userId)
+	if err != nil {
+		return nil, err
+	}
+	n, err := config.ShoppingLists.CountDocuments(context.TODO(), bson.M{"_id": listId, "$or": access},
+		options.Count().SetLimit(1))
+	if err != nil || n == 0 {
+		return nil, err
+	}
+
+	var c Checkout
+	err = config.Checkouts.FindOne(context.TODO(), bson.M{"_id": checkoutId, "listId": listId}).Decode(&c)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	selected := make(map[primitive.ObjectID]bool, len(itemIds))
+	for _, id := range itemIds {
+		selected[id] = true
+	}
+	items := make([]ListItem, 0, len(c.Items))
+	for _, pi := range c.Items {
+		if len(itemIds) > 0 && !selected[pi.ItemId] {
+			continue
+		}
+		items = append(items, ListItem{
+			Name:     pi.Name,
+			Quantity: pi.Quantity,
+			Unit:     pi.Unit,
+			Note:     pi.Note,
+			Price:    pi.Price,
+			Currency: pi.Currency,
+			Category: pi.Category,
+		})
+	}
+	return AddListItems(items, userId, targetId)
+}
+
+// keepListHistory сохраняет историю покупок удаленного списка для пользователей members, имевших к нему доступ.
+// Платежи участников не изменяются: список с неоплаченными долгами удалить нельзя (см. RemoveList).
+func keepListHistory(listId primitive.ObjectID, members []primitive.ObjectID) error {
+	_, err := config.Checkouts.UpdateMany(context.TODO(), bson.M{"listId": listId},
+		bson.M{"$set": bson.M{"memberIds": members}})
+	return err
+}
+
+// historyMatch возвращает условие выборки истории покупок списков listIds и удаленных списков,
+// к которым пользователь имел доступ.
+func historyMatch(userId primitive.ObjectID, listIds []primitive.ObjectID) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"listId": bson.M{"$in": listIds}},
+		bson.M{"memberIds": userId},
+	}}
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/abel-03/go-todo/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// IdempotencyRecord хранит результат запроса, выполненного с заголовком Idempotency-Key.
+// Пока запрос выполняется, Status равен нулю, а запись истекает через config.IdempotencyLease.
+// Для запросов без пользователя UserId нулевой.
+type IdempotencyRecord struct {
+	ID          primitive.ObjectID  `bson:"_id,omitempty"`
+	Key         string              `bson:"key"`
+	UserId      primitive.ObjectID  `bson:"userId"`
+	RequestHash string              `bson:"requestHash"`
+	Status      int                 `bson:"status"`
+	Header      map[string][]string `bson:"header,omitempty"`
+	Body        []byte              `bson:"body,omitempty"`
+	CreatedAt   time.Time           `bson:"createdAt"`
+	ExpiresAt   time.Time           `bson:"expiresAt"`
+}
+
+// EnsureIdempotencyIndexes создает индексы коллекции ключей идемпотентности:
+// уникальный ключ в пределах пользователя и удаление записей по истечении срока хранения.
+func EnsureIdempotencyIndexes() error {
+	_, err := config.IdempotencyKeys.Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+// BeginIdempotentRequest резервирует ключ для выполнения запроса.
+// Если ключ уже использовался, возвращается сохраненная запись, и запрос выполнять не нужно.
+// Если возвращается nil, ключ зарезервирован за вызывающим, и он должен завершить или освободить его.
+func BeginIdempotentRequest(userId primitive.ObjectID, key, requestHash string) (*IdempotencyRecord, error) {
+	now := time.Now()
+
+	// Истекшая запись могла еще не быть удалена индексом: ключ можно использовать заново.
+	_, err := config.IdempotencyKeys.DeleteOne(context.TODO(),
+		bson.M{"userId": userId, "key": key, "expiresAt": bson.M{"$lte": now}})
+	if err != nil {
+		return nil, err
+	}
+
+	rec := IdempotencyRecord{
+		Key:         key,
+		UserId:      userId,
+		RequestHash: requestHash,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(config.IdempotencyLease),
+	}
+	var existing IdempotencyRecord
+	err = config.IdempotencyKeys.FindOneAndUpdate(context.TODO(),
+		bson.M{"userId": userId, "key": key},
+		bson.M{"$setOnInsert": rec},
+		options.FindOneAndUpdate().SetUpsert(true)).Decode(&existing)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if mongo.IsDuplicateKeyError(err) {
+		// Параллельный запрос успел зарезервировать ключ первым.
+		err = config.IdempotencyKeys.FindOne(context.TODO(), bson.M{"userId": userId, "key": key}).Decode(&existing)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &existing, nil
+}
+
+// CompleteIdempotentRequest сохраняет ответ на запрос, чтобы повторы с тем же ключом получали его
+// в течение config.IdempotencyKeyTTL.
+func CompleteIdempotentRequest(userId primitive.ObjectID, key string, status int, header map[string][]string, body []byte) error {
+	_, err := config.IdempotencyKeys.UpdateOne(context.TODO(),
+		bson.M{"userId": userId, "key": key, "status": 0},
+		bson.M{"$set": bson.M{
+			"status":    status,
+			"header":    header,
+			"body":      body,
+			"expiresAt": time.Now().Add(config.IdempotencyKeyTTL),
+		}})
+	return err
+}
+
+// ReleaseIdempotentRequest освобождает ключ запроса, завершившегося ошибкой сервера, чтобы повтор выполнил его заново.
+func ReleaseIdempotentRequest(userId primitive.ObjectID, key string) error {
+	_, err := config.IdempotencyKeys.DeleteOne(context.TODO(), bson.M{"userId": userId, "key": key, "status": 0})
+	return err
+}
@@ -0,0 +1,193 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/abel-03/go-todo/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Состояния фонового задания.
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Виды фоновых заданий.
+const (
+	jobReminder = "reminder"
+	jobDelivery = "delivery"
+)
+
+// Ограничения выполнения заданий.
+const (
+	// maxJobsPerRun - сколько заданий выполняется за один вызов RunJobs.
+	maxJobsPerRun = 100
+	// maxJobRetryDelay - наибольшая задержка перед повтором задания.
+	maxJobRetryDelay = time.Hour
+	// jobRetention - сколько хранятся завершенные задания. Пока задание хранится, задание с тем же ключом
+	// повторно не создается.
+	jobRetention = 7 * 24 * time.Hour
+)
+
+// errJobSkipped возвращается обработчиком, если задание больше не нужно выполнять: например, элемент,
+// о котором нужно напомнить, уже удален. Такое задание завершается без повторов.
+var errJobSkipped = errors.New("job skipped")
+
+// Job - фоновое задание. Key уникален: задание с уже существующим ключом не создается, поэтому одно
+// и то же событие порождает одно задание. Задание выполняется не раньше RunAt; пока оно выполняется,
+// оно закреплено за сервером до LeaseUntil, после чего может быть выполнено повторно.
+type Job struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Kind       string             `json:"kind" bson:"kind"`
+	Key        string             `json:"key" bson:"key"`
+	Payload    bson.M             `json:"payload" bson:"payload"`
+	Status     string             `json:"status" bson:"status"`
+	RunAt      time.Time          `json:"runAt" bson:"runAt"`
+	LeaseUntil *time.Time         `json:"leaseUntil,omitempty" bson:"leaseUntil,omitempty"`
+	Attempts   int                `json:"attempts" bson:"attempts"`
+	LastError  string             `json:"lastError,omitempty" bson:"lastError,omitempty"`
+	CreatedAt  time.Time          `json:"createdAt" bson:"createdAt"`
+	ExpiresAt  *time.Time         `json:"-" bson:"expiresAt,omitempty"`
+}
+
+// EnsureJobIndexes создает индексы коллекции заданий: уникальный ключ, выборку готовых к выполнению заданий
+// и удаление завершенных заданий по истечении срока хранения. Также создает индексы уведомлений.
+func EnsureJobIndexes() error {
+	_, err := config.Jobs.Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "status", Value: 1}, {Key: "runAt", Value: 1}},
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return ensureNotificationIndexes()
+}
+
+// enqueueJob создает задание, если задания с ключом key еще нет. Возвращает true, если задание создано.
+func enqueueJob(ctx context.Context, kind, key string, runAt time.Time, payload bson.M) (bool, error) {
+	job := Job{
+		ID:        primitive.NewObjectID(),
+		Kind:      kind,
+		Key:       key,
+		Payload:   payload,
+		Status:    JobPending,
+		RunAt:     runAt,
+		CreatedAt: time.Now(),
+	}
+	res, err := config.Jobs.UpdateOne(ctx, bson.M{"key": key}, bson.M{"$setOnInsert": job},
+		options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return res.UpsertedCount > 0, nil
+}
+
+// claimJob закрепляет за вызывающим одно готовое к выполнению задание или возвращает nil, если таких нет.
+// Готовы задания, время которых наступило, и выполняемые задания, срок закрепления которых истек.
+func claimJob(ctx context.Context, now time.Time) (*Job, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"status": JobPending, "runAt": bson.M{"$lte": now}},
+		bson.M{"status": JobRunning, "leaseUntil": bson.M{"$lte": now}},
+	}}
+	update := bson.M{
+		"$set": bson.M{"status": JobRunning, "leaseUntil": now.Add(config.JobLease)},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "runAt", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var j Job
+	err := config.Jobs.FindOneAndUpdate(ctx, filter, update, opts).Decode(&j)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// finishJob сохраняет результат выполнения задания j. Неудачное задание повторяется с удваивающейся задержкой,
+// пока не исчерпаны config.JobMaxAttempts попыток. Результат сохраняется, только если задание все еще закреплено
+// за вызывающим.
+func finishJob(ctx context.Context, j Job, now time.Time, jobErr error) error {
+	set := bson.M{}
+	unset := bson.M{"leaseUntil": ""}
+	switch {
+	case jobErr == nil || errors.Is(jobErr, errJobSkipped):
+		set["status"] = JobDone
+	case j.Attempts >= config.JobMaxAttempts:
+		set["status"] = JobFailed
+		set["lastError"] = jobErr.Error()
+	default:
+		delay := config.JobRetryDelay << (j.Attempts - 1)
+		if delay > maxJobRetryDelay || delay <= 0 {
+			delay = maxJobRetryDelay
+		}
+		set["status"] = JobPending
+		set["runAt"] = now.Add(delay)
+		set["lastError"] = jobErr.Error()
+	}
+	if set["status"] != JobPending {
+		set["expiresAt"] = now.Add(jobRetention)
+	}
+
+	_, err := config.Jobs.UpdateOne(ctx,
+		bson.M{"_id": j.ID, "status": JobRunning, "attempts": j.Attempts},
+		bson.M{"$set": set, "$unset": unset})
+	return err
+}
+
+// runJob выполняет задание его обработчиком.
+func runJob(ctx context.Context, j Job, now time.Time) error {
+	switch j.Kind {
+	case jobReminder:
+		return runReminder(ctx, j, now)
+	case jobDelivery:
+		return runDelivery(ctx, j, now)
+	}
+	return errors.New("unknown job kind " + j.Kind)
+}
+
+// RunJobs выполняет готовые к моменту now фоновые задания, но не больше maxJobsPerRun за вызов.
+// Задание выполняется хотя бы один раз: если сервер остановится, не завершив его, задание повторится
+// по истечении срока закрепления, поэтому обработчики должны допускать повторное выполнение.
+func RunJobs(ctx context.Context, now time.Time) error {
+	for i := 0; i < maxJobsPerRun && ctx.Err() == nil; i++ {
+		j, err := claimJob(ctx, now)
+		if err != nil {
+			return err
+		} else if j == nil {
+			return nil
+		}
+
+		jobErr := runJob(ctx, *j, now)
+		if jobErr != nil && !errors.Is(jobErr, errJobSkipped) {
+			log.Printf("job %s (%s) attempt %d: %v", j.Key, j.Kind, j.Attempts, jobErr)
+		}
+		if err := finishJob(ctx, *j, now, jobErr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
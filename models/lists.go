@@ -2,43 +2,138 @@ package models
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/abel-03/go-todo/config"
+	"github.com/abel-03/go-todo/events"
+	"github.com/abel-03/go-todo/hlc"
+	"github.com/abel-03/go-todo/parser"
+	"github.com/abel-03/go-todo/rank"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// ErrVersionMismatch возвращается, если версия списка или элемента не совпадает с ожидаемой клиентом.
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// ErrUnsettledBalances возвращается при удалении списка, по которому у участников остались неоплаченные долги.
+var ErrUnsettledBalances = errors.New("list has unsettled balances")
+
+// ErrMissingCurrency возвращается, если у элемента с ценой после изменения не оказывается валюты.
+var ErrMissingCurrency = errors.New("item price without currency")
+
 // ListItem представляет элемент списка покупок.
+// ClientId - идентификатор, под которым элемент был создан на клиенте до загрузки на сервер.
+// Price - цена за единицу в валюте Currency (код ISO 4217).
+// Position задает порядок элементов в списке (см. пакет rank).
+// Recurrence задает повторение элемента: при завершении покупок он не удаляется, а скрывается до HiddenUntil.
+// ParentId - родительский элемент подзадачи (см. MaxItemDepth). Элементы хранятся плоским массивом,
+// а Children заполняется только в ответах со списками в виде дерева.
+// AssigneeId - участник списка, который взялся купить элемент; AssigneeName подставляется при чтении списков.
 type ListItem struct {
-	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	Name        string             `json:"name"`
-	IsCompleted bool               `json:"isCompleted" bson:"isCompleted"`
+	ID           primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	ClientId     string              `json:"clientId,omitempty" bson:"clientId,omitempty"`
+	Name         string              `json:"name"`
+	IsCompleted  bool                `json:"isCompleted" bson:"isCompleted"`
+	Quantity     float64             `json:"quantity,omitempty" bson:"quantity,omitempty"`
+	Unit         string              `json:"unit,omitempty" bson:"unit,omitempty"`
+	Note         string              `json:"note,omitempty" bson:"note,omitempty"`
+	Price        float64             `json:"price,omitempty" bson:"price,omitempty"`
+	Currency     string              `json:"currency,omitempty" bson:"currency,omitempty"`
+	Priority     string              `json:"priority,omitempty" bson:"priority,omitempty"`
+	Category     string              `json:"category,omitempty" bson:"category,omitempty"`
+	Position     string              `json:"position" bson:"position"`
+	ParentId     *primitive.ObjectID `json:"parentId,omitempty" bson:"parentId,omitempty"`
+	AssigneeId   *primitive.ObjectID `json:"assigneeId,omitempty" bson:"assigneeId,omitempty"`
+	AssigneeName string              `json:"assigneeName,omitempty" bson:"assigneeName,omitempty"`
+	Due          *ItemDue            `json:"due,omitempty" bson:"due,omitempty"`
+	Recurrence   *Recurrence         `json:"recurrence,omitempty" bson:"recurrence,omitempty"`
+	HiddenUntil  *time.Time          `json:"hiddenUntil,omitempty" bson:"hiddenUntil,omitempty"`
+	Seq          int64               `json:"seq" bson:"seq"`
+	CreatedSeq   int64               `json:"createdSeq" bson:"createdSeq"`
+	Clock        ItemClock           `json:"clock" bson:"clock"`
+	Version      int64               `json:"version" bson:"version"`
+	Children     []ListItem          `json:"children,omitempty" bson:"-"`
 }
 
 // ShoppingList представляет список покупок.
+// ClientId - идентификатор, под которым список был создан на клиенте до загрузки на сервер.
+// Recurrence задает повторение списка по шаблону (см. ListRecurrence).
 type ShoppingList struct {
-	ID               primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
-	OwnerId          primitive.ObjectID   `json:"ownerId" bson:"ownerId"`
-	OwnerName        string               `json:"ownerName" bson:"ownerName"`
-	Name             string               `json:"name" bson:"name"`
-	Items            []ListItem           `json:"items" bson:"items"`
-	SharingIds       []primitive.ObjectID `json:"sharingIds" bson:"sharingIds"`
-	SharingInviteIds []primitive.ObjectID `json:"sharingInviteIds" bson:"sharingInviteIds"`
-	SharingNames     []string             `json:"sharingNames" bson:"sharingNames"`
+	ID                 primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	ClientId           string               `json:"clientId,omitempty" bson:"clientId,omitempty"`
+	OwnerId            primitive.ObjectID   `json:"ownerId" bson:"ownerId"`
+	OwnerName          string               `json:"ownerName" bson:"ownerName"`
+	OwnerAvatarURL     string               `json:"ownerAvatarUrl,omitempty" bson:"ownerAvatarUrl,omitempty"`
+	Name               string               `json:"name" bson:"name"`
+	Description        string               `json:"description,omitempty" bson:"description,omitempty"`
+	Color              string               `json:"color,omitempty" bson:"color,omitempty"`
+	Icon               string               `json:"icon,omitempty" bson:"icon,omitempty"`
+	Archived           bool                 `json:"archived" bson:"archived,omitempty"`
+	Items              []ListItem           `json:"items" bson:"items"`
+	SharingIds         []primitive.ObjectID `json:"sharingIds" bson:"sharingIds"`
+	CoOwnerIds         []primitive.ObjectID `json:"coOwnerIds" bson:"coOwnerIds"`
+	SharingNames       []string             `json:"sharingNames" bson:"sharingNames"`
+	SharingAvatarURLs  []string             `json:"sharingAvatarUrls,omitempty" bson:"sharingAvatarUrls,omitempty"`
+	GroupId            *primitive.ObjectID  `json:"groupId" bson:"groupId,omitempty"`
+	GroupName          string               `json:"groupName,omitempty" bson:"groupName,omitempty"`
+	PendingInviteCount int                  `json:"pendingInviteCount" bson:"pendingInviteCount,omitempty"`
+	Seq                int64                `json:"seq" bson:"seq"`
+	CreatedSeq         int64                `json:"createdSeq" bson:"createdSeq"`
+	Version            int64                `json:"version" bson:"version"`
+	Recurrence         *ListRecurrence      `json:"recurrence,omitempty" bson:"recurrence,omitempty"`
+	Totals             *ListTotals          `json:"totals,omitempty" bson:"-"`
+	Sections           []ListSection        `json:"sections,omitempty" bson:"-"`
 }
 
-// AllShoppingLists возвращает все списки покупок для заданного пользователя.
-func AllShoppingLists(userId primitive.ObjectID) (*[]ShoppingList, error) {
-	// Формируем конвейер для агрегации данных в MongoDB.
-	pipeline := mongo.Pipeline{
-		{
-			{Key: "$match", Value: bson.M{
-				"$or": []interface{}{
-					bson.M{"ownerId": userId},
-					bson.M{"sharingIds": userId},
-				},
-			}},
-		},
+// ListTotals содержит итоги по элементам списка. Стоимость считается по валютам как цена,
+// умноженная на количество (единица, если количество не задано). Элементы с ценой без валюты в оценку не входят.
+type ListTotals struct {
+	ItemCount      int                `json:"itemCount"`
+	CompletedCount int                `json:"completedCount"`
+	EstimatedCost  map[string]float64 `json:"estimatedCost"`
+	RemainingCost  map[string]float64 `json:"remainingCost"`
+}
+
+// listTotals подсчитывает итоги по элементам списка.
+func listTotals(items []ListItem) *ListTotals {
+	t := ListTotals{
+		ItemCount:     len(items),
+		EstimatedCost: make(map[string]float64),
+		RemainingCost: make(map[string]float64),
+	}
+	for _, li := range items {
+		if li.IsCompleted {
+			t.CompletedCount++
+		}
+		if li.Price <= 0 || li.Currency == "" {
+			continue
+		}
+		quantity := li.Quantity
+		if quantity <= 0 {
+			quantity = 1
+		}
+		cost := li.Price * quantity
+		t.EstimatedCost[li.Currency] += cost
+		if !li.IsCompleted {
+			t.RemainingCost[li.Currency] += cost
+		}
+	}
+	for c := range t.EstimatedCost {
+		t.EstimatedCost[c] = math.Round(t.EstimatedCost[c]*100) / 100
+		t.RemainingCost[c] = math.Round(t.RemainingCost[c]*100) / 100
+	}
+	return &t
+}
+
+// listUserLookupStages возвращает стадии конвейера, подставляющие в список отображаемые имена
+// и аватары владельца и пользователей, с которыми список открыт, а также имена исполнителей элементов.
+func listUserLookupStages() mongo.Pipeline {
+	return append(mongo.Pipeline{
 		{
 			{Key: "$lookup", Value: bson.M{
 				"from":         "users",
@@ -49,7 +144,8 @@ func AllShoppingLists(userId primitive.ObjectID) (*[]ShoppingList, error) {
 		},
 		{
 			{Key: "$addFields", Value: bson.M{
-				"ownerName": bson.M{"$arrayElemAt": []interface{}{"$owner.name", 0}},
+				"ownerName":      bson.M{"$arrayElemAt": []interface{}{displayNames("$owner"), 0}},
+				"ownerAvatarUrl": bson.M{"$arrayElemAt": []interface{}{"$owner.avatarUrl", 0}},
 			}},
 		},
 		{
@@ -62,10 +158,106 @@ func AllShoppingLists(userId primitive.ObjectID) (*[]ShoppingList, error) {
 		},
 		{
 			{Key: "$addFields", Value: bson.M{
-				"sharingNames": "$sharings.name",
+				"sharingNames": displayNames("$sharings"),
+				"sharingAvatarUrls": bson.M{"$map": bson.M{
+					"input": "$sharings",
+					"as":    "u",
+					"in":    bson.M{"$ifNull": bson.A{"$$u.avatarUrl", ""}},
+				}},
 			}},
 		},
+		{
+			{Key: "$project", Value: bson.M{"owner": 0, "sharings": 0}},
+		},
+	}, assigneeLookupStages()...)
+}
+
+// listAccessFilter возвращает условия, при выполнении любого из которых пользователь имеет доступ к списку:
+// он владелец списка, список открыт ему напрямую или список принадлежит одной из его групп.
+func listAccessFilter(userId primitive.ObjectID) (bson.A, error) {
+	groupIds, err := UserGroupIds(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	return bson.A{
+		bson.M{"ownerId": userId},
+		bson.M{"sharingIds": userId},
+		bson.M{"groupId": bson.M{"$in": groupIds}},
+	}, nil
+}
+
+// AllShoppingLists возвращает все списки покупок для заданного пользователя.
+// Если задан archived, возвращаются только архивные или только активные списки.
+// Элементы списков возвращаются деревом: подзадачи вложены в родительские элементы.
+func AllShoppingLists(userId primitive.ObjectID, archived *bool) (*[]ShoppingList, error) {
+	access, err := listAccessFilter(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	match := bson.M{"$or": access}
+	if archived != nil && *archived {
+		match["archived"] = true
+	} else if archived != nil {
+		match["archived"] = bson.M{"$ne": true}
+	}
+	lists, err := AllShoppingListsMatching(userId, match)
+	if err != nil {
+		return nil, err
+	}
+	withItemTree(lists)
+	return lists, nil
+}
+
+// AllShoppingListsMatching возвращает списки покупок по фильтру вместе с именами участников.
+// Фильтр должен сам ограничивать выборку списками, доступными пользователю.
+func AllShoppingListsMatching(userId primitive.ObjectID, match bson.M) (*[]ShoppingList, error) {
+	// Формируем конвейер для агрегации данных в MongoDB.
+	pipeline := mongo.Pipeline{
+		{
+			{Key: "$match", Value: match},
+		},
 	}
+	pipeline = append(pipeline, listUserLookupStages()...)
+	pipeline = append(pipeline, mongo.Pipeline{
+		{
+			{Key: "$lookup", Value: bson.M{
+				"from":         "groups",
+				"localField":   "groupId",
+				"foreignField": "_id",
+				"as":           "group",
+			}},
+		},
+		{
+			{Key: "$addFields", Value: bson.M{
+				"groupName": bson.M{"$arrayElemAt": []interface{}{"$group.name", 0}},
+			}},
+		},
+		{
+			{Key: "$lookup", Value: bson.M{
+				"from":         "shareInvites",
+				"localField":   "_id",
+				"foreignField": "listId",
+				"as":           "invites",
+			}},
+		},
+		{
+			{Key: "$addFields", Value: bson.M{
+				"pendingInviteCount": bson.M{"$size": bson.M{"$filter": bson.M{
+					"input": "$invites",
+					"as":    "i",
+					"cond": bson.M{"$and": bson.A{
+						bson.M{"$eq": bson.A{"$$i.status", InviteStatusPending}},
+						bson.M{"$gt": bson.A{"$$i.expiresAt", time.Now()}},
+					}},
+				}}},
+			}},
+		},
+		{
+			{Key: "$project", Value: bson.M{"invites": 0}},
+		},
+	}...)
 
 	// Выполняем агрегацию данных в MongoDB.
 	var result []ShoppingList
@@ -77,181 +269,879 @@ func AllShoppingLists(userId primitive.ObjectID) (*[]ShoppingList, error) {
 	if err = cursor.All(context.Background(), &result); err != nil {
 		return nil, err
 	}
+	for i := range result {
+		result[i].Items = visibleItems(result[i].Items)
+		sortItems(result[i].Items)
+		result[i].Totals = listTotals(result[i].Items)
+	}
 
 	return &result, nil
 }
 
-// AddListItem добавляет новый элемент в список покупок.
-func AddListItem(name string, userId, listId primitive.ObjectID) error {
+// visibleItems возвращает элементы без скрытых до следующего повторения. Вместе с элементом скрываются его подзадачи.
+func visibleItems(items []ListItem) []ListItem {
+	idx := newItemIndex(items)
+	hidden := make(map[primitive.ObjectID]bool)
+	for _, li := range items {
+		if li.HiddenUntil != nil {
+			for _, id := range idx.subtree([]primitive.ObjectID{li.ID}) {
+				hidden[id] = true
+			}
+		}
+	}
+
+	visible := items[:0]
+	for _, li := range items {
+		if !hidden[li.ID] {
+			visible = append(visible, li)
+		}
+	}
+	return visible
+}
+
+// GetShoppingList возвращает доступный пользователю список покупок или nil, если список не найден.
+// Элементы списка возвращаются деревом, как в AllShoppingLists.
+func GetShoppingList(listId, userId primitive.ObjectID) (*ShoppingList, error) {
+	access, err := listAccessFilter(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	lists, err := AllShoppingListsMatching(userId, bson.M{"_id": listId, "$or": access})
+	if err != nil {
+		return nil, err
+	} else if len(*lists) == 0 {
+		return nil, nil
+	}
+	withItemTree(lists)
+	return &(*lists)[0], nil
+}
+
+// ListMetadata представляет свойства списка, которые могут изменить его владелец и совладельцы.
+// Поля, равные nil, не изменяются; пустые строки в необязательных полях удаляют значение.
+type ListMetadata struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Color       *string `json:"color"`
+	Icon        *string `json:"icon"`
+	Archived    *bool   `json:"archived"`
+}
+
+// UpdateShoppingList изменяет свойства списка. Изменить список могут его владелец, совладельцы,
+// а для списков группы также владелец и администраторы группы. Если заданы versions, изменяется только список
+// одной из этих версий.
+func UpdateShoppingList(listId, userId primitive.ObjectID, m ListMetadata, versions []int64) (bool, error) {
+	editors, err := listEditorsFilter(userId)
+	if err != nil {
+		return false, err
+	}
+
+	seq, err := NextChangeSeq()
+	if err != nil {
+		return false, err
+	}
+	defer releaseChangeSeq(seq)
+
+	set, unset := bson.M{}, bson.M{}
+	if m.Name != nil {
+		set["name"] = *m.Name
+	}
+	optional := map[string]*string{"description": m.Description, "color": m.Color, "icon": m.Icon}
+	for field, v := range optional {
+		if v == nil {
+			continue
+		} else if *v == "" {
+			unset[field] = ""
+		} else {
+			set[field] = *v
+		}
+	}
+	if m.Archived != nil {
+		set["archived"] = *m.Archived
+	}
+
+	update := bson.M{"$max": bson.M{"seq": seq}, "$inc": bson.M{"version": 1}}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	// Формируем фильтр для поиска списка, который пользователь может изменять.
+	filter := bson.M{
+		"_id": listId,
+		"$or": editors,
+	}
+	if versions != nil {
+		filter["version"] = bson.M{"$in": versions}
+	}
+
+	var l ShoppingList
+	err = config.ShoppingLists.FindOneAndUpdate(context.TODO(), filter, update,
+		options.FindOneAndUpdate().SetProjection(listMembersProjection)).Decode(&l)
+	if err == mongo.ErrNoDocuments && versions != nil {
+		delete(filter, "version")
+		return false, versionMismatch(filter)
+	} else if err == mongo.ErrNoDocuments {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	publishListEvent(l, events.ListUpdated, userId, nil, m)
+	return true, nil
+}
+
+// MaxItemQuantity - наибольшее количество элемента.
+const MaxItemQuantity = 1e6
+
+// withParsedName разбирает название элемента, введенное свободным текстом, если остальные поля элемента не заполнены.
+// Клиент, передавший структурированные поля, разбор не получает. Разбор с количеством больше MaxItemQuantity
+// отбрасывается, и название остается как было.
+func withParsedName(li ListItem) ListItem {
+	if li.Quantity != 0 || li.Unit != "" || li.Note != "" || li.Priority != "" || li.Category != "" {
+		return li
+	}
+
+	p := parser.ParseItem(li.Name)
+	if p.Quantity > MaxItemQuantity {
+		return li
+	}
+	li.Name, li.Quantity, li.Unit, li.Note = p.Name, p.Quantity, p.Unit, p.Note
+	li.Priority, li.Category = p.Priority, p.Category
+	return li
+}
+
+// AddListItem добавляет новый элемент в список покупок и возвращает его идентификатор.
+// Из li используются название, количество, единица, заметка, цена, приоритет, категория, срок, повторение
+// родительский элемент и исполнитель. Если заполнено только название, оно разбирается на структурированные поля (см. withParsedName).
+// Подзадача добавляется незавершенной, поэтому завершенный родитель снова становится незавершенным.
+// Если родитель не найден в списке или вложенность превысила бы MaxItemDepth, возвращается ErrInvalidParent,
+// если исполнитель не является участником списка - ErrInvalidAssignee.
+// Элементу без категории она назначается автоматически (см. categorize).
+// Метка ts задает время создания элемента на клиенте; пустая метка заменяется временем сервера.
+// Если список не найден или недоступен пользователю, возвращается пустая строка.
+func AddListItem(li ListItem, userId, listId primitive.ObjectID, ts hlc.Timestamp) (string, error) {
+	ts, err := editTimestamp(ts)
+	if err != nil {
+		return "", err
+	}
+	if li.Price != 0 && li.Currency == "" {
+		return "", ErrMissingCurrency
+	}
+
+	seq, err := NextChangeSeq()
+	if err != nil {
+		return "", err
+	}
+	defer releaseChangeSeq(seq)
+
 	// Создаем новый элемент списка покупок.
-	li := ListItem{
+	li, err = categorize(userId, withParsedName(li))
+	if err != nil {
+		return "", err
+	}
+	li = ListItem{
 		ID:          primitive.NewObjectID(),
-		Name:        name,
+		Name:        li.Name,
 		IsCompleted: false,
+		Quantity:    li.Quantity,
+		Unit:        li.Unit,
+		Note:        li.Note,
+		Price:       li.Price,
+		Currency:    li.Currency,
+		Priority:    li.Priority,
+		Category:    li.Category,
+		Due:         li.Due,
+		Recurrence:  li.Recurrence,
+		ParentId:    li.ParentId,
+		AssigneeId:  li.AssigneeId,
+		Seq:         seq,
+		CreatedSeq:  seq,
+		Clock:       NewItemClock(ts),
+		Version:     1,
+	}
+
+	access, err := listAccessFilter(userId)
+	if err != nil {
+		return "", err
 	}
 
 	// Формируем фильтр для определения списка, к которому добавляется элемент.
 	filter := bson.M{
 		"_id": listId,
-		"$or": bson.A{
-			bson.M{"ownerId": userId},
-			bson.M{"sharingIds": userId},
-		},
+		"$or": access,
 	}
 
-	// Формируем обновление для добавления элемента в список.
-	update := bson.M{
-		"$push": bson.M{
-			"items": li,
-		},
+	// Исполнителем можно назначить только участника списка.
+	if li.AssigneeId != nil {
+		if err := invalidAssignee(filter, *li.AssigneeId); err != nil {
+			return "", err
+		}
+	}
+
+	// Новый элемент ставится в конец списка. Позиция занимается, только если за это время
+	// в конец не добавили другой элемент, иначе она вычисляется заново.
+	var l ShoppingList
+	for attempt := 1; ; attempt++ {
+		current, err := findListItems(filter, options.FindOne().SetProjection(bson.M{
+			"items._id":      1,
+			"items.parentId": 1,
+			"items.position": 1,
+		}))
+		if err != nil || current == nil {
+			return "", err
+		}
+		if li.ParentId != nil {
+			idx := newItemIndex(current.Items)
+			if _, ok := idx.items[*li.ParentId]; !ok || idx.depth(*li.ParentId) >= MaxItemDepth {
+				return "", ErrInvalidParent
+			}
+		}
+		if li.Position, err = rank.After(lastPosition(current.Items)); err != nil {
+			return "", err
+		}
+
+		// Формируем обновление для добавления элемента в список.
+		update := bson.M{
+			"$push": bson.M{
+				"items": li,
+			},
+			"$max": bson.M{
+				"seq": seq,
+			},
+			"$inc": bson.M{
+				"version": 1,
+			},
+		}
+
+		// Выполняем обновление в MongoDB.
+		guarded := bson.M{
+			"_id":            listId,
+			"$or":            access,
+			"items.position": bson.M{"$not": bson.M{"$gte": li.Position}},
+		}
+		if li.ParentId != nil {
+			guarded["items._id"] = *li.ParentId
+		}
+		if li.AssigneeId != nil {
+			if guarded, err = withAssignee(guarded, *li.AssigneeId); err != nil {
+				return "", err
+			}
+		}
+		err = config.ShoppingLists.FindOneAndUpdate(context.TODO(), guarded, update,
+			options.FindOneAndUpdate().SetProjection(listMembersProjection)).Decode(&l)
+		if err == mongo.ErrNoDocuments && attempt < maxPositionAttempts {
+			continue
+		} else if err == mongo.ErrNoDocuments {
+			return "", ErrPositionConflict
+		} else if err != nil {
+			return "", err
+		}
+		break
 	}
 
-	// Выполняем обновление в MongoDB.
-	_, err := config.ShoppingLists.UpdateOne(context.TODO(), filter, update)
+	publishListEvent(l, events.ItemAdded, userId, &li.ID, li)
+	if li.ParentId != nil {
+		if err := syncCompletion(listId, *li.ParentId, userId, ts, false); err != nil {
+			return "", err
+		}
+	}
+	return li.ID.Hex(), nil
+}
+
+// AddListItems добавляет элементы в конец списка покупок одним изменением и возвращает их идентификаторы
+// в порядке items. Поля элементов используются так же, как в AddListItem; элементы создаются незавершенными.
+// Если список не найден или недоступен пользователю, возвращается nil.
+func AddListItems(items []ListItem, userId, listId primitive.ObjectID) ([]primitive.ObjectID, error) {
+	if len(items) == 0 {
+		return make([]primitive.ObjectID, 0), nil
+	}
+
+	seq, err := NextChangeSeq()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	defer releaseChangeSeq(seq)
+
+	// Создаем новые элементы списка покупок.
+	ts := hlc.Default.Now()
+	newItems := make([]ListItem, 0, len(items))
+	ids := make([]primitive.ObjectID, 0, len(items))
+	for _, li := range items {
+		li, err = categorize(userId, withParsedName(li))
+		if err != nil {
+			return nil, err
+		}
+		li = ListItem{
+			ID:         primitive.NewObjectID(),
+			Name:       li.Name,
+			Quantity:   li.Quantity,
+			Unit:       li.Unit,
+			Note:       li.Note,
+			Price:      li.Price,
+			Currency:   li.Currency,
+			Priority:   li.Priority,
+			Category:   li.Category,
+			Due:        li.Due,
+			Recurrence: li.Recurrence,
+			Seq:        seq,
+			CreatedSeq: seq,
+			Clock:      NewItemClock(ts),
+			Version:    1,
+		}
+		newItems = append(newItems, li)
+		ids = append(ids, li.ID)
+	}
+
+	access, err := listAccessFilter(userId)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"_id": listId, "$or": access}
+
+	// Элементы ставятся в конец списка так же, как в AddListItem.
+	var l ShoppingList
+	for attempt := 1; ; attempt++ {
+		current, err := findListItems(filter, options.FindOne().SetProjection(bson.M{"items.position": 1}))
+		if err != nil || current == nil {
+			return nil, err
+		}
+		if err := appendPositions(newItems, lastPosition(current.Items)); err != nil {
+			return nil, err
+		}
+
+		update := bson.M{
+			"$push": bson.M{"items": bson.M{"$each": newItems}},
+			"$max":  bson.M{"seq": seq},
+			"$inc":  bson.M{"version": 1},
+		}
+		guarded := bson.M{
+			"_id":            listId,
+			"$or":            access,
+			"items.position": bson.M{"$not": bson.M{"$gte": newItems[0].Position}},
+		}
+		err = config.ShoppingLists.FindOneAndUpdate(context.TODO(), guarded, update,
+			options.FindOneAndUpdate().SetProjection(listMembersProjection)).Decode(&l)
+		if err == mongo.ErrNoDocuments && attempt < maxPositionAttempts {
+			continue
+		} else if err == mongo.ErrNoDocuments {
+			return nil, ErrPositionConflict
+		} else if err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	for i := range newItems {
+		publishListEvent(l, events.ItemAdded, userId, &newItems[i].ID, newItems[i])
+	}
+	return ids, nil
 }
 
-// ModifyListItem обновляет информацию об элементе списка покупок.
-func ModifyListItem(userId primitive.ObjectID, li ListItem) error {
+// ModifyListItem применяет правку к элементу списка покупок и возвращает элемент после слияния.
+// Каждое поле сохраняет значение правки с наибольшей меткой времени, поэтому устаревшая правка
+// не затирает более позднюю. Выбранная категория запоминается для автоматического назначения.
+// Отметка о завершении переносится на все подзадачи элемента, а родитель считается завершенным,
+// когда завершены все его подзадачи (см. completionChanges).
+// Исполнителем можно назначить только участника списка, иначе возвращается ErrInvalidAssignee.
+// Если элемент не найден или недоступен пользователю, возвращается nil.
+func ModifyListItem(userId, itemId primitive.ObjectID, p ListItemPatch) (*ListItem, error) {
+	ts, err := editTimestamp(p.Clock)
+	if err != nil {
+		return nil, err
+	}
+
+	seq, err := NextChangeSeq()
+	if err != nil {
+		return nil, err
+	}
+	defer releaseChangeSeq(seq)
+
+	access, err := listAccessFilter(userId)
+	if err != nil {
+		return nil, err
+	}
+
 	// Формируем фильтр для поиска элемента списка.
 	filter := bson.M{
-		"items._id": li.ID,
-		"$or": bson.A{
-			bson.M{"ownerId": userId},
-			bson.M{"sharingIds": userId},
-		},
+		"items._id": itemId,
+		"$or":       access,
+	}
+	if p.Versions != nil {
+		delete(filter, "items._id")
+		filter["items"] = bson.M{"$elemMatch": bson.M{"_id": itemId, "version": bson.M{"$in": p.Versions}}}
+	}
+	assign := p.AssigneeId != nil && !p.AssigneeId.IsZero()
+	if assign {
+		if filter, err = withAssignee(filter, *p.AssigneeId); err != nil {
+			return nil, err
+		}
 	}
 
-	// Формируем обновление для изменения информации об элементе.
-	update := bson.M{
-		"$set": bson.M{
-			"items.$": li,
-		},
+	// Сливаем правку с текущим элементом и записываем изменившиеся поля, если элемент не изменился после чтения.
+	// Иначе читаем элемент заново.
+	var l *ShoppingList
+	var li ListItem
+	for attempt := 0; ; attempt++ {
+		l, err = findListItems(filter, options.FindOne().SetProjection(bson.M{
+			"ownerId":    1,
+			"sharingIds": 1,
+			"groupId":    1,
+			"items":      bson.M{"$elemMatch": bson.M{"_id": itemId}},
+		}))
+		if err != nil {
+			return nil, err
+		} else if (l == nil || len(l.Items) == 0) && assign {
+			if err := invalidAssignee(bson.M{"items._id": itemId, "$or": access}, *p.AssigneeId); err != nil {
+				return nil, err
+			}
+		}
+		if (l == nil || len(l.Items) == 0) && p.Versions != nil {
+			return nil, versionMismatch(bson.M{"items._id": itemId, "$or": access})
+		} else if l == nil || len(l.Items) == 0 {
+			return nil, nil
+		} else if attempt == maxMergeAttempts {
+			return nil, ErrMergeConflict
+		}
+
+		cur := l.Items[0]
+		merged, changed := mergeItemPatch(cur, p, ts)
+		// Цену можно изменить без валюты, если валюта у элемента уже есть.
+		if (p.Price != nil || p.Currency != nil) && merged.Price != 0 && merged.Currency == "" {
+			return nil, ErrMissingCurrency
+		}
+		update, err := itemMergeUpdate(merged, changed, seq)
+		if err != nil {
+			return nil, err
+		}
+		res, err := config.ShoppingLists.UpdateOne(context.TODO(), bson.M{
+			"_id":   l.ID,
+			"items": bson.M{"$elemMatch": bson.M{"_id": itemId, "version": cur.Version}},
+		}, update)
+		if err != nil {
+			return nil, err
+		} else if res.MatchedCount == 1 {
+			li = merged
+			li.Seq, li.Version = seq, cur.Version+1
+			break
+		}
+	}
+	if p.Category != nil && *p.Category != "" && li.Category == *p.Category {
+		if err := rememberCategory(userId, li.Name, li.Category); err != nil {
+			return nil, err
+		}
 	}
+	publishListEvent(*l, events.ItemModified, userId, &li.ID, li)
 
-	// Выполняем обновление в MongoDB.
-	_, err := config.ShoppingLists.UpdateOne(context.TODO(), filter, update)
-	if err != nil {
-		return err
+	// Отметка переносится на подзадачи элемента и пересчитывается у его предков.
+	if p.IsCompleted != nil && li.Clock.IsCompleted == ts {
+		if err := syncCompletion(l.ID, li.ID, userId, ts, true); err != nil {
+			return nil, err
+		}
 	}
-	return nil
+	return &li, nil
 }
 
 // AddNewShoppingList добавляет новый список покупок для пользователя.
-func AddNewShoppingList(name string, ownerId primitive.ObjectID) (string, error) {
+// Если указан groupId, список создается внутри группы и становится доступен всем ее участникам.
+func AddNewShoppingList(name string, ownerId primitive.ObjectID, groupId *primitive.ObjectID) (string, error) {
+	seq, err := NextChangeSeq()
+	if err != nil {
+		return "", err
+	}
+	defer releaseChangeSeq(seq)
+
 	// Создаем новый список покупок.
 	t := ShoppingList{
-		ID:               primitive.NewObjectID(),
-		OwnerId:          ownerId,
-		Name:             name,
-		Items:            make([]ListItem, 0),
-		SharingIds:       make([]primitive.ObjectID, 0),
-		SharingInviteIds: make([]primitive.ObjectID, 0),
+		ID:         primitive.NewObjectID(),
+		OwnerId:    ownerId,
+		Name:       name,
+		Items:      make([]ListItem, 0),
+		SharingIds: make([]primitive.ObjectID, 0),
+		CoOwnerIds: make([]primitive.ObjectID, 0),
+		GroupId:    groupId,
+		Seq:        seq,
+		CreatedSeq: seq,
+		Version:    1,
 	}
 	// Вставляем новый список в MongoDB.
-	_, err := config.ShoppingLists.InsertOne(context.TODO(), t)
+	_, err = config.ShoppingLists.InsertOne(context.TODO(), t)
 	if err != nil {
 		return "", err
 	}
+
+	publishListEvent(t, events.ListCreated, ownerId, nil, t)
 	return t.ID.Hex(), nil
 }
 
 // AddShoppingLists добавляет несколько списков покупок для пользователя.
 func AddShoppingLists(sl []ShoppingList, ownerId primitive.ObjectID) error {
+	if len(sl) == 0 {
+		return nil
+	}
+
+	seq, err := NextChangeSeq()
+	if err != nil {
+		return err
+	}
+	defer releaseChangeSeq(seq)
+
 	// Преобразуем структуры данных в формат, подходящий для вставки в MongoDB.
+	ts := hlc.Default.Now()
 	slInterface := make([]interface{}, len(sl))
 	for i := range sl {
+		sl[i].Seq, sl[i].CreatedSeq, sl[i].Version = seq, seq, 1
+		positions := rank.Spread(len(sl[i].Items))
+		for j := range sl[i].Items {
+			sl[i].Items[j].Position = positions[j]
+			sl[i].Items[j].Seq, sl[i].Items[j].CreatedSeq, sl[i].Items[j].Version = seq, seq, 1
+			sl[i].Items[j].Clock = NewItemClock(ts)
+			// Загруженные элементы добавляются без вложенности и исполнителей: они из загрузки не проверяются.
+			sl[i].Items[j].ParentId, sl[i].Items[j].AssigneeId = nil, nil
+		}
 		slInterface[i] = sl[i]
 	}
 
 	// Вставляем несколько списков покупок в MongoDB.
-	_, err := config.ShoppingLists.InsertMany(context.TODO(), slInterface)
+	_, err = config.ShoppingLists.InsertMany(context.TODO(), slInterface)
 	if err != nil {
 		return err
 	}
+
+	for _, l := range sl {
+		publishListEvent(l, events.ListCreated, ownerId, nil, l)
+	}
 	return nil
 }
 
 // CheckoutList удаляет завершенные элементы из списка покупок.
-func CheckoutList(listId primitive.ObjectID) error {
-	// Формируем фильтр для поиска списка по ID.
-	filter := bson.M{"_id": listId}
+// Элементы, отмеченные позже метки ts, остаются в списке: пользователь, завершивший покупки, их еще не видел.
+// Если задан itemIds, удаляются только перечисленные завершенные элементы.
+// Повторяющиеся элементы не удаляются, а возобновляются к следующему повторению (см. renewRecurringItems).
+// Подзадачи удаляются и возобновляются только вместе со своим элементом верхнего уровня: завершенная подзадача
+// незавершенного элемента остается в списке.
+// Купленные элементы сохраняются в историю покупок (см. Checkout) вместе с оплатой payment, если она задана.
+// Если заданы versions, покупки завершаются только для списка одной из этих версий.
+func CheckoutList(listId, userId primitive.ObjectID, ts hlc.Timestamp, itemIds []primitive.ObjectID, payment *CheckoutPayment, versions []int64) (bool, error) {
+	ts, err := editTimestamp(ts)
+	if err != nil {
+		return false, err
+	}
 
-	// Формируем обновление для удаления завершенных элементов из списка.
+	access, err := listAccessFilter(userId)
+	if err != nil {
+		return false, err
+	}
+
+	// Оплата проверяется до завершения покупок: плательщик и доли должны относиться к участникам списка.
+	if payment != nil {
+		current, err := findListItems(bson.M{"_id": listId, "$or": access},
+			options.FindOne().SetProjection(listMembersProjection))
+		if err != nil || current == nil {
+			return false, err
+		}
+		if payment, err = resolvePayment(*current, *payment); err != nil {
+			return false, err
+		}
+	}
+
+	seq, err := NextChangeSeq()
+	if err != nil {
+		return false, err
+	}
+	defer releaseChangeSeq(seq)
+
+	// Формируем фильтр для поиска доступного пользователю списка по ID.
+	filter := bson.M{"_id": listId, "$or": access}
+	if versions != nil {
+		filter["version"] = bson.M{"$in": versions}
+	}
+
+	// Формируем обновление для удаления завершенных элементов из списка. Повторяющиеся элементы обрабатываются отдельно.
+	cond := checkoutCondition(ts, itemIds)
+	cond["recurrence"] = nil
+	cond["parentId"] = nil
 	update := bson.M{
 		"$pull": bson.M{
-			"items": bson.M{
-				"isCompleted": true,
-			},
+			"items": cond,
+		},
+		"$max": bson.M{
+			"seq": seq,
 		},
+		"$inc": bson.M{
+			"version": 1,
+		},
+	}
+
+	// Выполняем обновление в MongoDB. Возвращается состояние списка до удаления элементов.
+	var l ShoppingList
+	err = config.ShoppingLists.FindOneAndUpdate(context.TODO(), filter, update).Decode(&l)
+	if err == mongo.ErrNoDocuments && versions != nil {
+		return false, versionMismatch(bson.M{"_id": listId, "$or": access})
+	} else if err == mongo.ErrNoDocuments {
+		return false, nil
+	} else if err != nil {
+		return false, err
 	}
 
-	// Выполняем обновление в MongoDB.
-	updatedResult, err := config.ShoppingLists.UpdateOne(context.TODO(), filter, update)
-	fmt.Println("Update result", updatedResult.ModifiedCount)
+	removed := make([]primitive.ObjectID, 0)
+	recurring := make([]ListItem, 0)
+	for _, li := range l.Items {
+		if li.ParentId != nil || !checkoutRemoves(li, ts, itemIds) {
+			continue
+		} else if li.Recurrence != nil {
+			recurring = append(recurring, li)
+		} else {
+			removed = append(removed, li.ID)
+		}
+	}
+	ended, renewed, err := renewRecurringItems(l, recurring, ts, seq, time.Now())
 	if err != nil {
-		return err
+		return false, err
 	}
+	removed = append(removed, ended...)
 
-	return nil
+	// Удаляем подзадачи удаленных элементов. После удаления родителя новые подзадачи к нему
+	// добавить нельзя, поэтому состояние до удаления содержит их все.
+	idx := newItemIndex(l.Items)
+	nested := make([]primitive.ObjectID, 0)
+	for _, id := range removed {
+		nested = append(nested, idx.descendants(id)...)
+	}
+	if len(nested) > 0 {
+		_, err := config.ShoppingLists.UpdateOne(context.TODO(), bson.M{"_id": l.ID}, bson.M{
+			"$pull": bson.M{"items": bson.M{"_id": bson.M{"$in": nested}}},
+			"$max":  bson.M{"seq": seq},
+			"$inc":  bson.M{"version": 1},
+		})
+		if err != nil {
+			return false, err
+		}
+		removed = append(removed, nested...)
+	}
+
+	// Подзадачи возобновленных элементов снова становятся незавершенными.
+	for _, id := range renewed {
+		if err := syncCompletion(l.ID, id, userId, ts, true); err != nil {
+			return false, err
+		}
+	}
+
+	tombstones := make([]Tombstone, 0, len(removed))
+	for _, id := range removed {
+		tombstones = append(tombstones, Tombstone{Kind: TombstoneItem, EntityId: id, ListId: l.ID, Seq: seq})
+	}
+	if err := addTombstones(tombstones); err != nil {
+		return false, err
+	}
+	if renewed == nil {
+		renewed = make([]primitive.ObjectID, 0)
+	}
+
+	// Сохраняем купленные элементы в историю покупок.
+	checkout, err := recordCheckout(l, &userId, append(append([]primitive.ObjectID{}, removed...), renewed...), payment, time.Now())
+	if err != nil {
+		return false, err
+	}
+	data := bson.M{"removedItemIds": removed, "renewedItemIds": renewed}
+	if checkout != nil {
+		data["checkoutId"] = checkout.ID
+	}
+	publishListEvent(l, events.ListCheckedOut, userId, nil, data)
+
+	return true, nil
 }
 
-// RemoveListItem удаляет элемент списка покупок.
-func RemoveListItem(itemId string, userId primitive.ObjectID) error {
+// RemoveListItem удаляет элемент списка покупок вместе со всеми его подзадачами.
+// Отметка родителя удаленного элемента пересчитывается по оставшимся подзадачам.
+func RemoveListItem(itemId string, userId primitive.ObjectID) (bool, error) {
 	// Преобразуем строковый идентификатор элемента в ObjectID.
 	objId, err := primitive.ObjectIDFromHex(itemId)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	// Формируем фильтр для поиска элемента по ID пользователя.
+	seq, err := NextChangeSeq()
+	if err != nil {
+		return false, err
+	}
+	defer releaseChangeSeq(seq)
+
+	access, err := listAccessFilter(userId)
+	if err != nil {
+		return false, err
+	}
+
+	// Формируем фильтр для поиска доступного пользователю списка, содержащего элемент.
 	filter := bson.M{
-		"$or": bson.A{
-			bson.M{"ownerId": userId},
-			bson.M{"sharingIds": userId},
-		},
+		"items._id": objId,
+		"$or":       access,
 	}
 
-	// Формируем обновление для удаления элемента из списка.
-	update := bson.M{
-		"$pull": bson.M{
-			"items": bson.M{
-				"_id": objId,
+	// Поддерево удаляется, только если список не изменился после чтения: иначе к элементу
+	// могли добавить подзадачу, которая осталась бы без родителя.
+	for attempt := 0; attempt < maxPositionAttempts; attempt++ {
+		current, err := findListItems(filter, options.FindOne().SetProjection(bson.M{
+			"version":        1,
+			"items._id":      1,
+			"items.parentId": 1,
+		}))
+		if err != nil || current == nil {
+			return false, err
+		}
+		idx := newItemIndex(current.Items)
+		removed := idx.subtree([]primitive.ObjectID{objId})
+
+		// Формируем обновление для удаления элемента из списка.
+		update := bson.M{
+			"$pull": bson.M{
+				"items": bson.M{
+					"_id": bson.M{"$in": removed},
+				},
 			},
-		},
+			"$max": bson.M{
+				"seq": seq,
+			},
+			"$inc": bson.M{
+				"version": 1,
+			},
+		}
+
+		// Выполняем обновление в MongoDB.
+		var l ShoppingList
+		err = config.ShoppingLists.FindOneAndUpdate(context.TODO(),
+			bson.M{"_id": current.ID, "version": current.Version, "items._id": objId, "$or": access}, update,
+			options.FindOneAndUpdate().SetProjection(listMembersProjection)).Decode(&l)
+		if err == mongo.ErrNoDocuments {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+
+		tombstones := make([]Tombstone, 0, len(removed))
+		for _, id := range removed {
+			tombstones = append(tombstones, Tombstone{Kind: TombstoneItem, EntityId: id, ListId: l.ID, Seq: seq})
+		}
+		if err := addTombstones(tombstones); err != nil {
+			return false, err
+		}
+
+		for i := range removed {
+			publishListEvent(l, events.ItemRemoved, userId, &removed[i], nil)
+		}
+		if parentId := idx.parent(idx.items[objId]); parentId != nil {
+			if err := syncCompletion(l.ID, *parentId, userId, hlc.Default.Now(), false); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
 	}
 
-	// Выполняем обновление в MongoDB.
-	_, err = config.ShoppingLists.UpdateMany(context.TODO(), filter, update)
+	// Элемент удалили или список постоянно меняется.
+	n, err := config.ShoppingLists.CountDocuments(context.TODO(), filter, options.Count().SetLimit(1))
 	if err != nil {
-		return err
+		return false, err
+	} else if n == 0 {
+		return false, nil
 	}
-	return nil
+	return false, ErrPositionConflict
 }
 
-// RemoveList удаляет список покупок пользователя.
-func RemoveList(listId string, ownerId primitive.ObjectID) (success bool, err error) {
+// RemoveList удаляет список покупок пользователя. История покупок списка остается в подсказках и аналитике
+// его участников. Список, по которому остались неоплаченные долги, не удаляется: возвращается ErrUnsettledBalances.
+// Списки группы также могут удалять владелец и администраторы группы.
+// Если заданы versions, удаляется только список одной из этих версий.
+func RemoveList(listId string, ownerId primitive.ObjectID, versions []int64) (success bool, err error) {
 	// Преобразуем строковый идентификатор списка в ObjectID.
 	listObjId, err := primitive.ObjectIDFromHex(listId)
 	if err != nil {
 		return false, err
 	}
 
+	adminGroupIds, err := UserGroupIds(ownerId, GroupRoleOwner, GroupRoleAdmin)
+	if err != nil {
+		return false, err
+	}
+
 	// Формируем запрос на удаление списка из MongoDB.
-	dr, err := config.ShoppingLists.DeleteOne(context.TODO(), bson.M{
-		"_id":     listObjId,
-		"ownerId": ownerId,
-	})
+	filter := bson.M{
+		"_id": listObjId,
+		"$or": bson.A{
+			bson.M{"ownerId": ownerId},
+			bson.M{"groupId": bson.M{"$in": adminGroupIds}},
+		},
+	}
 
-	if err != nil {
+	// Удаление списка не должно прощать долги: сначала участники рассчитываются друг с другом.
+	if open, err := hasOpenBalances(listObjId); err != nil {
 		return false, err
-	} else if dr.DeletedCount == 0 {
+	} else if open {
+		n, err := config.ShoppingLists.CountDocuments(context.TODO(), filter, options.Count().SetLimit(1))
+		if err != nil {
+			return false, err
+		} else if n > 0 {
+			return false, ErrUnsettledBalances
+		}
+	}
+
+	if versions != nil {
+		filter["version"] = bson.M{"$in": versions}
+	}
+	var l ShoppingList
+	err = config.ShoppingLists.FindOneAndDelete(context.TODO(), filter,
+		options.FindOneAndDelete().SetProjection(listMembersProjection)).Decode(&l)
+
+	if err == mongo.ErrNoDocuments && versions != nil {
+		delete(filter, "version")
+		return false, versionMismatch(filter)
+	} else if err == mongo.ErrNoDocuments {
 		return false, nil
+	} else if err != nil {
+		return false, err
 	}
 
+	// Сохраняем запись об удалении для всех, кто имел доступ к списку.
+	members, err := listMembers(l)
+	if err != nil {
+		return false, err
+	}
+	seq, err := NextChangeSeq()
+	if err != nil {
+		return false, err
+	}
+	defer releaseChangeSeq(seq)
+	err = addTombstones([]Tombstone{{Kind: TombstoneList, EntityId: l.ID, ListId: l.ID, UserIds: members, Seq: seq}})
+	if err != nil {
+		return false, err
+	}
+	if err := keepListHistory(l.ID, members); err != nil {
+		return false, err
+	}
+
+	publishListEvent(l, events.ListDeleted, ownerId, nil, nil)
 	return true, nil
 }
 
+// versionMismatch вызывается, когда документ с ожидаемой версией не найден. Возвращает ErrVersionMismatch,
+// если документ по фильтру без учета версии существует, и nil, если его нет совсем.
+func versionMismatch(filter bson.M) error {
+	n, err := config.ShoppingLists.CountDocuments(context.TODO(), filter, options.Count().SetLimit(1))
+	if err != nil {
+		return err
+	} else if n > 0 {
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
+// hasVersion сообщает, входит ли версия version в versions.
+func hasVersion(versions []int64, version int64) bool {
+	for _, v := range versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
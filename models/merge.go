@@ -0,0 +1,250 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/abel-03/go-todo/config"
+	"github.com/abel-03/go-todo/hlc"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrInvalidClock возвращается, если метка времени правки имеет неверный формат
+// или опережает часы сервера больше чем на config.MaxClockSkew.
+var ErrInvalidClock = errors.New("invalid edit clock")
+
+// ErrMergeConflict возвращается, если элемент изменялся другими правками при каждой из maxMergeAttempts попыток
+// записать слияние.
+var ErrMergeConflict = errors.New("item merge conflict")
+
+// maxMergeAttempts - сколько раз правка элемента перечитывает элемент, если его изменили между чтением и записью.
+const maxMergeAttempts = 5
+
+// ItemClock хранит метки времени последних правок каждого поля элемента.
+// Поле элемента меняется, только если метка новой правки больше сохраненной,
+// поэтому одновременные и офлайн-правки сходятся к одному состоянию независимо от порядка доставки.
+type ItemClock struct {
+	Name        hlc.Timestamp `json:"name" bson:"name"`
+	IsCompleted hlc.Timestamp `json:"isCompleted" bson:"isCompleted"`
+	Quantity    hlc.Timestamp `json:"quantity,omitempty" bson:"quantity,omitempty"`
+	Unit        hlc.Timestamp `json:"unit,omitempty" bson:"unit,omitempty"`
+	Note        hlc.Timestamp `json:"note,omitempty" bson:"note,omitempty"`
+	Price       hlc.Timestamp `json:"price,omitempty" bson:"price,omitempty"`
+	Currency    hlc.Timestamp `json:"currency,omitempty" bson:"currency,omitempty"`
+	Priority    hlc.Timestamp `json:"priority,omitempty" bson:"priority,omitempty"`
+	Category    hlc.Timestamp `json:"category,omitempty" bson:"category,omitempty"`
+	Due         hlc.Timestamp `json:"due,omitempty" bson:"due,omitempty"`
+	Recurrence  hlc.Timestamp `json:"recurrence,omitempty" bson:"recurrence,omitempty"`
+	AssigneeId  hlc.Timestamp `json:"assigneeId,omitempty" bson:"assigneeId,omitempty"`
+}
+
+// NewItemClock возвращает метки нового элемента: все его поля созданы в момент ts.
+func NewItemClock(ts hlc.Timestamp) ItemClock {
+	return ItemClock{
+		Name:        ts,
+		IsCompleted: ts,
+		Quantity:    ts,
+		Unit:        ts,
+		Note:        ts,
+		Price:       ts,
+		Currency:    ts,
+		Priority:    ts,
+		Category:    ts,
+		Due:         ts,
+		Recurrence:  ts,
+		AssigneeId:  ts,
+	}
+}
+
+// ListItemPatch описывает правку элемента. Поля со значением nil не изменяются.
+// Пустая метка Clock означает, что правка помечается часами сервера в момент получения.
+// Due с нулевым моментом At снимает срок элемента, Recurrence с пустым правилом - повторение,
+// нулевой AssigneeId - исполнителя.
+// Если заданы Versions, правка применяется только к элементу одной из этих версий.
+type ListItemPatch struct {
+	Name        *string
+	IsCompleted *bool
+	Quantity    *float64
+	Unit        *string
+	Note        *string
+	Price       *float64
+	Currency    *string
+	Priority    *string
+	Category    *string
+	Due         *ItemDue
+	Recurrence  *Recurrence
+	AssigneeId  *primitive.ObjectID
+	Clock       hlc.Timestamp
+	Versions    []int64
+}
+
+// IsEmpty сообщает, что правка не изменяет ни одного поля.
+func (p ListItemPatch) IsEmpty() bool {
+	return p.Name == nil && p.IsCompleted == nil && p.Quantity == nil && p.Unit == nil &&
+		p.Note == nil && p.Price == nil && p.Currency == nil && p.Priority == nil && p.Category == nil &&
+		p.Due == nil && p.Recurrence == nil && p.AssigneeId == nil
+}
+
+// editTimestamp возвращает метку времени, которой помечается правка.
+// Метка клиента принимается, если она корректна и не слишком опережает часы сервера.
+func editTimestamp(ts hlc.Timestamp) (hlc.Timestamp, error) {
+	if ts == "" {
+		return hlc.Default.Now(), nil
+	}
+
+	if err := checkClock(ts); err != nil {
+		return "", err
+	}
+	// Следующие метки сервера должны быть больше всех принятых меток клиентов.
+	if err := hlc.Default.Update(ts); err != nil {
+		return "", ErrInvalidClock
+	}
+	return ts, nil
+}
+
+// checkClock проверяет метку клиента: она должна быть корректной и не слишком опережать часы сервера.
+func checkClock(ts hlc.Timestamp) error {
+	if _, _, _, err := hlc.Parse(ts); err != nil {
+		return ErrInvalidClock
+	}
+	if ts.Time().After(time.Now().Add(config.MaxClockSkew)) {
+		return ErrInvalidClock
+	}
+	return nil
+}
+
+// mergeItemPatch применяет правку p с меткой ts к элементу li по принципу "побеждает последняя запись" отдельно
+// для каждого поля: поле принимает значение правки, только если ts больше его метки, и тогда его метка становится ts.
+// Результат не зависит от порядка, в котором применяются правки. Возвращает элемент после слияния и имена
+// измененных полей, как они хранятся в MongoDB.
+func mergeItemPatch(li ListItem, p ListItemPatch, ts hlc.Timestamp) (ListItem, []string) {
+	changed := make([]string, 0)
+	lww := func(field string, clock *hlc.Timestamp, apply func()) {
+		if ts > *clock {
+			apply()
+			*clock = ts
+			changed = append(changed, field)
+		}
+	}
+	if p.Name != nil {
+		lww("name", &li.Clock.Name, func() { li.Name = *p.Name })
+	}
+	if p.IsCompleted != nil {
+		lww("isCompleted", &li.Clock.IsCompleted, func() { li.IsCompleted = *p.IsCompleted })
+	}
+	if p.Quantity != nil {
+		lww("quantity", &li.Clock.Quantity, func() { li.Quantity = *p.Quantity })
+	}
+	if p.Unit != nil {
+		lww("unit", &li.Clock.Unit, func() { li.Unit = *p.Unit })
+	}
+	if p.Note != nil {
+		lww("note", &li.Clock.Note, func() { li.Note = *p.Note })
+	}
+	if p.Price != nil {
+		lww("price", &li.Clock.Price, func() { li.Price = *p.Price })
+	}
+	if p.Currency != nil {
+		lww("currency", &li.Clock.Currency, func() { li.Currency = *p.Currency })
+	}
+	if p.Priority != nil {
+		lww("priority", &li.Clock.Priority, func() { li.Priority = *p.Priority })
+	}
+	if p.Category != nil {
+		lww("category", &li.Clock.Category, func() { li.Category = *p.Category })
+	}
+	if p.Due != nil {
+		lww("due", &li.Clock.Due, func() {
+			li.Due = nil
+			if !p.Due.At.IsZero() {
+				due := *p.Due
+				li.Due = &due
+			}
+		})
+	}
+	if p.Recurrence != nil {
+		lww("recurrence", &li.Clock.Recurrence, func() {
+			li.Recurrence = nil
+			if p.Recurrence.Rule != "" {
+				rec := *p.Recurrence
+				li.Recurrence = &rec
+			}
+		})
+	}
+	if p.AssigneeId != nil {
+		lww("assigneeId", &li.Clock.AssigneeId, func() {
+			li.AssigneeId = nil
+			if !p.AssigneeId.IsZero() {
+				id := *p.AssigneeId
+				li.AssigneeId = &id
+			}
+		})
+	}
+	return li, changed
+}
+
+// itemMergeUpdate возвращает обновление, записывающее в элемент, найденный позиционным оператором, поля changed
+// элемента li после слияния и их метки. Поля с пустым значением удаляются, как при сохранении элемента целиком.
+func itemMergeUpdate(li ListItem, changed []string, seq int64) (bson.M, error) {
+	raw, err := bson.Marshal(li)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	clock := doc["clock"].(bson.M)
+
+	set, unset := bson.M{"items.$.seq": seq}, bson.M{}
+	for _, field := range changed {
+		if v, ok := doc[field]; ok {
+			set["items.$."+field] = v
+		} else {
+			unset["items.$."+field] = ""
+		}
+		set["items.$.clock."+field] = clock[field]
+	}
+	update := bson.M{
+		"$set": set,
+		"$max": bson.M{"seq": seq},
+		"$inc": bson.M{"items.$.version": 1, "version": 1},
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	return update, nil
+}
+
+// checkoutCondition возвращает условие удаления элементов при завершении покупок: удаляются отмеченные элементы,
+// отметка которых не новее метки завершения. Если заданы itemIds, удаляются только перечисленные элементы.
+func checkoutCondition(ts hlc.Timestamp, itemIds []primitive.ObjectID) bson.M {
+	cond := bson.M{
+		"isCompleted": true,
+		"$or": bson.A{
+			bson.M{"clock.isCompleted": bson.M{"$exists": false}},
+			bson.M{"clock.isCompleted": bson.M{"$lte": ts}},
+		},
+	}
+	if len(itemIds) > 0 {
+		cond["_id"] = bson.M{"$in": itemIds}
+	}
+	return cond
+}
+
+// checkoutRemoves сообщает, удаляет ли завершение покупок с меткой ts элемент li. Повторяет checkoutCondition.
+func checkoutRemoves(li ListItem, ts hlc.Timestamp, itemIds []primitive.ObjectID) bool {
+	if !li.IsCompleted || li.Clock.IsCompleted > ts {
+		return false
+	}
+	if len(itemIds) == 0 {
+		return true
+	}
+	for _, id := range itemIds {
+		if id == li.ID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,205 @@
+package models
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+
+	"github.com/abel-03/go-todo/hlc"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// timedPatch - правка элемента с меткой времени.
+type timedPatch struct {
+	patch ListItemPatch
+	ts    hlc.Timestamp
+}
+
+// concurrentPatches - одновременные правки одного элемента с разными метками времени.
+type concurrentPatches []timedPatch
+
+func (concurrentPatches) Generate(r *rand.Rand, _ int) reflect.Value {
+	names := []string{"milk", "bread", "молоко", "apples"}
+	units := []string{"", "kg", "l", "pcs"}
+	assignees := []primitive.ObjectID{primitive.NewObjectID(), primitive.NewObjectID()}
+	str := func(pool []string) *string { return &pool[r.Intn(len(pool))] }
+	num := func() *float64 { f := float64(r.Intn(5)); return &f }
+
+	n := 2 + r.Intn(5)
+	seen := make(map[hlc.Timestamp]bool)
+	res := make(concurrentPatches, 0, n)
+	for len(res) < n {
+		// Метки из узкого диапазона, чтобы совпадали физическое время и счетчик разных узлов.
+		ts := hlc.Format(1+r.Int63n(4), uint32(r.Intn(3)), []string{"a", "b", "c"}[r.Intn(3)])
+		if seen[ts] {
+			continue
+		}
+		seen[ts] = true
+
+		var p ListItemPatch
+		for p.IsEmpty() {
+			if r.Intn(2) == 0 {
+				p.Name = str(names)
+			}
+			if r.Intn(2) == 0 {
+				done := r.Intn(2) == 0
+				p.IsCompleted = &done
+			}
+			if r.Intn(2) == 0 {
+				p.Quantity = num()
+			}
+			if r.Intn(2) == 0 {
+				p.Unit = str(units)
+			}
+			if r.Intn(3) == 0 {
+				p.Price = num()
+			}
+			if r.Intn(3) == 0 {
+				p.Category = str([]string{"dairy", "bakery"})
+			}
+			if r.Intn(3) == 0 {
+				p.AssigneeId = &assignees[r.Intn(len(assignees))]
+			}
+		}
+		res = append(res, timedPatch{patch: p, ts: ts})
+	}
+	return reflect.ValueOf(res)
+}
+
+// applyPatches применяет правки к одному и тому же исходному элементу в порядке order и возвращает элемент.
+func applyPatches(patches concurrentPatches, order []int) ListItem {
+	base := hlc.Format(1, 0, "a")
+	li := ListItem{ID: primitive.ObjectID{2}, Name: "milk", Clock: ItemClock{Name: base, IsCompleted: base}}
+	for _, k := range order {
+		li, _ = mergeItemPatch(li, patches[k].patch, patches[k].ts)
+	}
+	return li
+}
+
+// Любой порядок доставки одновременных правок дает одно и то же состояние элемента и те же метки полей.
+func TestItemPatchConvergence(t *testing.T) {
+	f := func(patches concurrentPatches, seed int64) bool {
+		identity := make([]int, len(patches))
+		for i := range identity {
+			identity[i] = i
+		}
+		want := applyPatches(patches, identity)
+
+		r := rand.New(rand.NewSource(seed))
+		for k := 0; k < 5; k++ {
+			order := r.Perm(len(patches))
+			if got := applyPatches(patches, order); !reflect.DeepEqual(got, want) {
+				t.Logf("order %v: got %+v, want %+v", order, got, want)
+				return false
+			}
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// Каждое поле получает значение правки с наибольшей меткой среди изменявших его, а его метка - эту метку.
+func TestItemPatchLastWriterWins(t *testing.T) {
+	f := func(patches concurrentPatches, seed int64) bool {
+		order := rand.New(rand.NewSource(seed)).Perm(len(patches))
+		got := applyPatches(patches, order)
+
+		var name, unit *string
+		var nameTs, unitTs hlc.Timestamp = hlc.Format(1, 0, "a"), ""
+		var quantity *float64
+		var quantityTs hlc.Timestamp
+		for _, tp := range patches {
+			if tp.patch.Name != nil && tp.ts > nameTs {
+				name, nameTs = tp.patch.Name, tp.ts
+			}
+			if tp.patch.Unit != nil && tp.ts > unitTs {
+				unit, unitTs = tp.patch.Unit, tp.ts
+			}
+			if tp.patch.Quantity != nil && tp.ts > quantityTs {
+				quantity, quantityTs = tp.patch.Quantity, tp.ts
+			}
+		}
+
+		if got.Clock.Name != nameTs || (name != nil && got.Name != *name) {
+			return false
+		}
+		if got.Clock.Unit != unitTs || (unit != nil && got.Unit != *unit) {
+			return false
+		}
+		if got.Clock.Quantity != quantityTs || (quantity != nil && got.Quantity != *quantity) {
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// Устаревшая правка не меняет поле, а снятие срока, повторения и исполнителя удаляет поле из элемента.
+func TestItemMergeUpdate(t *testing.T) {
+	old, ts := hlc.Format(1, 0, "a"), hlc.Format(2, 0, "a")
+	assignee := primitive.NewObjectID()
+	li := ListItem{
+		Name:       "milk",
+		Quantity:   2,
+		AssigneeId: &assignee,
+		Clock:      ItemClock{Name: hlc.Format(3, 0, "a"), IsCompleted: old, Quantity: old, AssigneeId: old},
+	}
+	name, quantity, done, nobody := "bread", 0.0, true, primitive.NilObjectID
+	merged, changed := mergeItemPatch(li, ListItemPatch{Name: &name, Quantity: &quantity, IsCompleted: &done, AssigneeId: &nobody}, ts)
+	if want := []string{"isCompleted", "quantity", "assigneeId"}; !reflect.DeepEqual(changed, want) {
+		t.Errorf("changed = %v, want %v", changed, want)
+	}
+	if merged.Name != "milk" || !merged.IsCompleted || merged.Quantity != 0 || merged.AssigneeId != nil {
+		t.Errorf("merged = %+v", merged)
+	}
+
+	update, err := itemMergeUpdate(merged, changed, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bson.M{
+		"$set": bson.M{
+			"items.$.seq":               int64(7),
+			"items.$.isCompleted":       true,
+			"items.$.clock.isCompleted": string(ts),
+			"items.$.clock.quantity":    string(ts),
+			"items.$.clock.assigneeId":  string(ts),
+		},
+		"$unset": bson.M{"items.$.quantity": "", "items.$.assigneeId": ""},
+		"$max":   bson.M{"seq": int64(7)},
+		"$inc":   bson.M{"items.$.version": 1, "version": 1},
+	}
+	if !reflect.DeepEqual(update, want) {
+		t.Errorf("update = %v, want %v", update, want)
+	}
+}
+
+// Завершение покупок удаляет только отмеченные элементы, отметка которых не новее метки завершения.
+func TestCheckoutRemoves(t *testing.T) {
+	ts := hlc.Format(5, 0, "a")
+	id, other := primitive.NewObjectID(), primitive.NewObjectID()
+	tests := []struct {
+		li      ListItem
+		itemIds []primitive.ObjectID
+		want    bool
+	}{
+		{ListItem{ID: id, IsCompleted: true, Clock: ItemClock{IsCompleted: hlc.Format(4, 0, "a")}}, nil, true},
+		{ListItem{ID: id, IsCompleted: true, Clock: ItemClock{IsCompleted: ts}}, nil, true},
+		{ListItem{ID: id, IsCompleted: true}, nil, true},
+		{ListItem{ID: id, IsCompleted: true, Clock: ItemClock{IsCompleted: hlc.Format(6, 0, "a")}}, nil, false},
+		{ListItem{ID: id, Clock: ItemClock{IsCompleted: hlc.Format(4, 0, "a")}}, nil, false},
+		{ListItem{ID: id, IsCompleted: true}, []primitive.ObjectID{other, id}, true},
+		{ListItem{ID: id, IsCompleted: true}, []primitive.ObjectID{other}, false},
+	}
+	for i, tt := range tests {
+		if got := checkoutRemoves(tt.li, ts, tt.itemIds); got != tt.want {
+			t.Errorf("case %d: checkoutRemoves = %v, want %v", i, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,633 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/abel-03/go-todo/config"
+	"github.com/abel-03/go-todo/events"
+	"github.com/abel-03/go-todo/notify"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInvalidQuietHours возвращается, если время тихих часов или их часовой пояс заданы неверно.
+var ErrInvalidQuietHours = errors.New("invalid quiet hours")
+
+// quietHoursLayout - формат начала и конца тихих часов.
+const quietHoursLayout = "15:04"
+
+// NotificationChannels - все каналы доставки уведомлений в порядке их отображения в настройках.
+var NotificationChannels = []string{notify.ChannelInbox, notify.ChannelPush, notify.ChannelEmail, notify.ChannelWebhook}
+
+var (
+	channelsMu sync.RWMutex
+	channels   = map[string]notify.Channel{notify.ChannelInbox: inboxChannel{}}
+)
+
+// RegisterNotificationChannel подключает канал доставки уведомлений. Входящие уведомления подключены всегда;
+// внешние каналы подключаются при запуске сервера.
+func RegisterNotificationChannel(ch notify.Channel) {
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+	channels[ch.Name()] = ch
+}
+
+// notificationChannel возвращает подключенный канал по имени или nil.
+func notificationChannel(name string) notify.Channel {
+	channelsMu.RLock()
+	defer channelsMu.RUnlock()
+	return channels[name]
+}
+
+// QuietHours - время суток, когда уведомления не отправляются по внешним каналам, а откладываются до конца
+// тихих часов. Если End раньше Start, тихие часы переходят через полночь.
+type QuietHours struct {
+	Start    string `json:"start" bson:"start"`
+	End      string `json:"end" bson:"end"`
+	TimeZone string `json:"timeZone" bson:"timeZone"`
+}
+
+// Valid сообщает, что время и часовой пояс тихих часов заданы верно.
+func (q QuietHours) Valid() bool {
+	_, errStart := time.Parse(quietHoursLayout, q.Start)
+	_, errEnd := time.Parse(quietHoursLayout, q.End)
+	_, errZone := time.LoadLocation(q.TimeZone)
+	return errStart == nil && errEnd == nil && errZone == nil && q.Start != q.End
+}
+
+// Until возвращает конец тихих часов, если момент now попадает в них. Второй результат равен false,
+// если now вне тихих часов.
+func (q QuietHours) Until(now time.Time) (time.Time, bool) {
+	loc, err := time.LoadLocation(q.TimeZone)
+	if err != nil {
+		return time.Time{}, false
+	}
+	start, errStart := time.Parse(quietHoursLayout, q.Start)
+	end, errEnd := time.Parse(quietHoursLayout, q.End)
+	if errStart != nil || errEnd != nil {
+		return time.Time{}, false
+	}
+
+	local := now.In(loc)
+	at := func(t time.Time, days int) time.Time {
+		return time.Date(local.Year(), local.Month(), local.Day()+days, t.Hour(), t.Minute(), 0, 0, loc)
+	}
+	// Тихие часы, начавшиеся вчера, могут еще продолжаться сегодня.
+	for _, day := range []int{-1, 0} {
+		from, to := at(start, day), at(end, day)
+		if !to.After(from) {
+			to = at(end, day+1)
+		}
+		if !now.Before(from) && now.Before(to) {
+			return to, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// NotificationSettings - настройки уведомлений пользователя. Channels - включенные каналы; nil означает все каналы.
+// WebhookSecret подписывает тела веб-хуков (см. notify.Webhook) и создается при задании WebhookURL.
+type NotificationSettings struct {
+	UserId        primitive.ObjectID   `json:"-" bson:"_id"`
+	Channels      []string             `json:"channels" bson:"channels,omitempty"`
+	Email         string               `json:"email,omitempty" bson:"email,omitempty"`
+	WebhookURL    string               `json:"webhookUrl,omitempty" bson:"webhookUrl,omitempty"`
+	WebhookSecret string               `json:"webhookSecret,omitempty" bson:"webhookSecret,omitempty"`
+	QuietHours    *QuietHours          `json:"quietHours,omitempty" bson:"quietHours,omitempty"`
+	MutedListIds  []primitive.ObjectID `json:"mutedListIds" bson:"mutedListIds,omitempty"`
+}
+
+// enabled сообщает, включен ли канал channel.
+func (s NotificationSettings) enabled(channel string) bool {
+	if s.Channels == nil {
+		return true
+	}
+	for _, c := range s.Channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// muted сообщает, отключены ли уведомления списка listId.
+func (s NotificationSettings) muted(listId primitive.ObjectID) bool {
+	for _, id := range s.MutedListIds {
+		if id == listId {
+			return true
+		}
+	}
+	return false
+}
+
+// NotificationSettingsUpdate - изменение настроек уведомлений. Поля, равные nil, не изменяются;
+// пустые строки и тихие часы с пустым началом удаляют значение.
+type NotificationSettingsUpdate struct {
+	Channels   []string    `json:"channels"`
+	Email      *string     `json:"email"`
+	WebhookURL *string     `json:"webhookUrl"`
+	QuietHours *QuietHours `json:"quietHours"`
+}
+
+// GetNotificationSettings возвращает настройки уведомлений пользователя. Пользователь без сохраненных
+// настроек получает уведомления по всем каналам без тихих часов.
+func GetNotificationSettings(userId primitive.ObjectID) (*NotificationSettings, error) {
+	s := NotificationSettings{UserId: userId}
+	err := config.NotificationSettings.FindOne(context.TODO(), bson.M{"_id": userId}).Decode(&s)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	if s.MutedListIds == nil {
+		s.MutedListIds = make([]primitive.ObjectID, 0)
+	}
+	return &s, nil
+}
+
+// UpdateNotificationSettings изменяет настройки уведомлений пользователя и возвращает их новое состояние.
+// При первом задании адреса веб-хука создается секрет для подписи его запросов.
+func UpdateNotificationSettings(userId primitive.ObjectID, u NotificationSettingsUpdate) (*NotificationSettings, error) {
+	if u.QuietHours != nil && u.QuietHours.Start != "" && !u.QuietHours.Valid() {
+		return nil, ErrInvalidQuietHours
+	}
+
+	set, unset := bson.M{}, bson.M{}
+	if u.Channels != nil {
+		set["channels"] = u.Channels
+	}
+	optional := map[string]*string{"email": u.Email, "webhookUrl": u.WebhookURL}
+	for field, v := range optional {
+		if v == nil {
+			continue
+		} else if *v == "" {
+			unset[field] = ""
+		} else {
+			set[field] = *v
+		}
+	}
+	if u.QuietHours != nil && u.QuietHours.Start == "" {
+		unset["quietHours"] = ""
+	} else if u.QuietHours != nil {
+		set["quietHours"] = *u.QuietHours
+	}
+
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	if len(update) > 0 {
+		_, err := config.NotificationSettings.UpdateOne(context.TODO(), bson.M{"_id": userId}, update,
+			options.Update().SetUpsert(true))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Секрет создается при первом задании адреса веб-хука и дальше не меняется.
+	if u.WebhookURL != nil && *u.WebhookURL != "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		_, err := config.NotificationSettings.UpdateOne(context.TODO(),
+			bson.M{"_id": userId, "webhookSecret": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"webhookSecret": hex.EncodeToString(secret)}})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return GetNotificationSettings(userId)
+}
+
+// SetListMuted отключает или снова включает уведомления доступного пользователю списка.
+// Если список не найден или недоступен пользователю, возвращается false.
+func SetListMuted(userId, listId primitive.ObjectID, muted bool) (bool, error) {
+	access, err := listAccessFilter(userId)
+	if err != nil {
+		return false, err
+	}
+	n, err := config.ShoppingLists.CountDocuments(context.TODO(), bson.M{"_id": listId, "$or": access},
+		options.Count().SetLimit(1))
+	if err != nil || n == 0 {
+		return false, err
+	}
+
+	update := bson.M{"$pull": bson.M{"mutedListIds": listId}}
+	if muted {
+		update = bson.M{"$addToSet": bson.M{"mutedListIds": listId}}
+	}
+	_, err = config.NotificationSettings.UpdateOne(context.TODO(), bson.M{"_id": userId}, update,
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PushSubscription - подписка браузера пользователя на Web Push.
+type PushSubscription struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserId    primitive.ObjectID `json:"-" bson:"userId"`
+	Endpoint  string             `json:"endpoint" bson:"endpoint"`
+	P256dh    string             `json:"p256dh" bson:"p256dh"`
+	Auth      string             `json:"auth" bson:"auth"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// AddPushSubscription сохраняет подписку браузера. Подписка с тем же адресом службы заменяется:
+// браузер мог обновить ключи или перейти к другому пользователю.
+func AddPushSubscription(userId primitive.ObjectID, sub notify.PushSubscription) error {
+	_, err := config.PushSubscriptions.UpdateOne(context.TODO(),
+		bson.M{"endpoint": sub.Endpoint},
+		bson.M{
+			"$set":         bson.M{"userId": userId, "p256dh": sub.P256dh, "auth": sub.Auth},
+			"$setOnInsert": bson.M{"createdAt": time.Now()},
+		},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+// RemovePushSubscription удаляет подписку браузера пользователя по адресу службы.
+func RemovePushSubscription(userId primitive.ObjectID, endpoint string) (bool, error) {
+	res, err := config.PushSubscriptions.DeleteOne(context.TODO(), bson.M{"userId": userId, "endpoint": endpoint})
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount > 0, nil
+}
+
+// pushSubscriptions возвращает подписки браузеров пользователя.
+func pushSubscriptions(ctx context.Context, userId primitive.ObjectID) ([]PushSubscription, error) {
+	cursor, err := config.PushSubscriptions.Find(ctx, bson.M{"userId": userId})
+	if err != nil {
+		return nil, err
+	}
+	subs := make([]PushSubscription, 0)
+	if err := cursor.All(ctx, &subs); err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// Notification - входящее уведомление пользователя. Key - ID уведомления (см. notify.Message);
+// повторная доставка уведомления с тем же ключом не создает второе уведомление.
+type Notification struct {
+	ID        primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	UserId    primitive.ObjectID  `json:"-" bson:"userId"`
+	Key       string              `json:"key" bson:"key"`
+	Title     string              `json:"title" bson:"title"`
+	Body      string              `json:"body" bson:"body"`
+	ListId    *primitive.ObjectID `json:"listId,omitempty" bson:"listId,omitempty"`
+	ItemId    *primitive.ObjectID `json:"itemId,omitempty" bson:"itemId,omitempty"`
+	CreatedAt time.Time           `json:"createdAt" bson:"createdAt"`
+	ReadAt    *time.Time          `json:"readAt,omitempty" bson:"readAt,omitempty"`
+}
+
+// NotificationsPage - страница входящих уведомлений. Unread - общее число непрочитанных уведомлений.
+type NotificationsPage struct {
+	Items  []Notification `json:"items"`
+	Unread int64          `json:"unread"`
+	Limit  int64          `json:"limit"`
+	Offset int64          `json:"offset"`
+}
+
+// ensureNotificationIndexes создает индексы уведомлений и подписок: уникальный ключ уведомления
+// в пределах пользователя и уникальный адрес службы подписки.
+func ensureNotificationIndexes() error {
+	_, err := config.Notifications.Indexes().CreateMany(context.TODO(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = config.PushSubscriptions.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "endpoint", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// InboxNotifications возвращает входящие уведомления пользователя от новых к старым.
+// Если unreadOnly, возвращаются только непрочитанные.
+func InboxNotifications(userId primitive.ObjectID, unreadOnly bool, limit, offset int64) (*NotificationsPage, error) {
+	filter := bson.M{"userId": userId}
+	if unreadOnly {
+		filter["readAt"] = bson.M{"$exists": false}
+	}
+	cursor, err := config.Notifications.Find(context.TODO(), filter, options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(offset).
+		SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	page := NotificationsPage{Items: make([]Notification, 0), Limit: limit, Offset: offset}
+	if err := cursor.All(context.TODO(), &page.Items); err != nil {
+		return nil, err
+	}
+
+	page.Unread, err = config.Notifications.CountDocuments(context.TODO(),
+		bson.M{"userId": userId, "readAt": bson.M{"$exists": false}})
+	if err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// MarkNotificationsRead отмечает прочитанными уведомления пользователя. Пустой ids отмечает все уведомления.
+// Возвращает число отмеченных уведомлений.
+func MarkNotificationsRead(userId primitive.ObjectID, ids []primitive.ObjectID) (int64, error) {
+	filter := bson.M{"userId": userId, "readAt": bson.M{"$exists": false}}
+	if len(ids) > 0 {
+		filter["_id"] = bson.M{"$in": ids}
+	}
+	res, err := config.Notifications.UpdateMany(context.TODO(), filter, bson.M{"$set": bson.M{"readAt": time.Now()}})
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
+
+// inboxChannel доставляет уведомления во входящие пользователя и сообщает о них подпиской на события.
+type inboxChannel struct{}
+
+// Name возвращает имя канала.
+func (inboxChannel) Name() string {
+	return notify.ChannelInbox
+}
+
+// Send сохраняет уведомление во входящих. Повторная доставка уведомления не создает дубликат.
+func (inboxChannel) Send(ctx context.Context, to notify.Recipient, m notify.Message) error {
+	userId, err := primitive.ObjectIDFromHex(to.UserId)
+	if err != nil {
+		return err
+	}
+
+	n := Notification{ID: primitive.NewObjectID(), UserId: userId, Key: m.ID, Title: m.Title, Body: m.Body, CreatedAt: m.Time}
+	if id, err := primitive.ObjectIDFromHex(m.ListId); err == nil {
+		n.ListId = &id
+	}
+	if id, err := primitive.ObjectIDFromHex(m.ItemId); err == nil {
+		n.ItemId = &id
+	}
+	res, err := config.Notifications.UpdateOne(ctx,
+		bson.M{"userId": userId, "key": m.ID},
+		bson.M{"$setOnInsert": n},
+		options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if res.UpsertedCount > 0 {
+		var listId primitive.ObjectID
+		if n.ListId != nil {
+			listId = *n.ListId
+		}
+		events.Publish(events.Event{
+			Type:       events.NotificationCreated,
+			ListId:     listId,
+			ItemId:     n.ItemId,
+			ActorId:    userId,
+			Data:       n,
+			Recipients: []primitive.ObjectID{userId},
+		})
+	}
+	return nil
+}
+
+// ScheduleReminders создает задания напоминаний для элементов, время напоминания которых наступает
+// не позже следующего вызова планировщика, то есть к моменту now + config.SchedulerInterval.
+// Напоминания, опоздавшие больше чем на config.ReminderGrace, не создаются.
+// Ключ задания включает срок и время напоминания, поэтому изменение срока порождает новое напоминание,
+// а повторный вызов - нет.
+func ScheduleReminders(ctx context.Context, now time.Time) error {
+	from, to := now.Add(-config.ReminderGrace), now.Add(config.SchedulerInterval)
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"archived":                 bson.M{"$ne": true},
+			"items.due.reminderOffset": bson.M{"$exists": true},
+		}}},
+		{{Key: "$unwind", Value: "$items"}},
+		{{Key: "$match", Value: bson.M{
+			"items.isCompleted":        false,
+			"items.hiddenUntil":        bson.M{"$exists": false},
+			"items.due.reminderOffset": bson.M{"$exists": true},
+		}}},
+		{{Key: "$addFields", Value: bson.M{
+			"remindAt": bson.M{"$subtract": bson.A{
+				"$items.due.at",
+				bson.M{"$multiply": bson.A{"$items.due.reminderOffset", 60 * 1000}},
+			}},
+		}}},
+		{{Key: "$match", Value: bson.M{"remindAt": bson.M{"$gt": from, "$lte": to}}}},
+		{{Key: "$project", Value: bson.M{"listId": "$_id", "item": "$items", "remindAt": 1}}},
+	}
+	cursor, err := config.ShoppingLists.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	var reminders []struct {
+		ListId   primitive.ObjectID `bson:"listId"`
+		Item     ListItem           `bson:"item"`
+		RemindAt time.Time          `bson:"remindAt"`
+	}
+	if err := cursor.All(ctx, &reminders); err != nil {
+		return err
+	}
+
+	for _, r := range reminders {
+		key := fmt.Sprintf("reminder:%s:%d:%d", r.Item.ID.Hex(), r.Item.Due.At.Unix(), *r.Item.Due.ReminderOffset)
+		_, err := enqueueJob(ctx, jobReminder, key, r.RemindAt, bson.M{
+			"listId": r.ListId,
+			"itemId": r.Item.ID,
+			"dueAt":  r.Item.Due.At,
+			"offset": *r.Item.Due.ReminderOffset,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runReminder рассылает напоминание участникам списка: для каждого участника и каждого включенного у него канала
+// создается задание доставки. Доставки по внешним каналам во время тихих часов участника откладываются до их конца.
+// Напоминание об уже завершенном, удаленном или перенесенном элементе не рассылается.
+func runReminder(ctx context.Context, j Job, now time.Time) error {
+	listId, _ := j.Payload["listId"].(primitive.ObjectID)
+	itemId, _ := j.Payload["itemId"].(primitive.ObjectID)
+	dueAt, _ := j.Payload["dueAt"].(primitive.DateTime)
+
+	l, err := findListItems(bson.M{"_id": listId, "items._id": itemId}, options.FindOne().SetProjection(bson.M{
+		"name":       1,
+		"ownerId":    1,
+		"sharingIds": 1,
+		"groupId":    1,
+		"archived":   1,
+		"items":      bson.M{"$elemMatch": bson.M{"_id": itemId}},
+	}))
+	if err != nil {
+		return err
+	} else if l == nil || len(l.Items) == 0 || l.Archived {
+		return errJobSkipped
+	}
+	li := l.Items[0]
+	if li.IsCompleted || li.HiddenUntil != nil || li.Due == nil || !li.Due.At.Equal(dueAt.Time()) {
+		return errJobSkipped
+	}
+
+	members, err := listMembers(*l)
+	if err != nil {
+		return err
+	}
+	seen := make(map[primitive.ObjectID]bool)
+	for _, userId := range members {
+		if seen[userId] {
+			continue
+		}
+		seen[userId] = true
+
+		s, err := GetNotificationSettings(userId)
+		if err != nil {
+			return err
+		}
+		if s.muted(listId) {
+			continue
+		}
+		quietUntil, quiet := time.Time{}, false
+		if s.QuietHours != nil {
+			quietUntil, quiet = s.QuietHours.Until(now)
+		}
+
+		for _, channel := range NotificationChannels {
+			if !s.enabled(channel) {
+				continue
+			}
+			at := now
+			if quiet && channel != notify.ChannelInbox {
+				at = quietUntil
+			}
+			targets := []string{""}
+			if channel == notify.ChannelPush {
+				subs, err := pushSubscriptions(ctx, userId)
+				if err != nil {
+					return err
+				}
+				targets = targets[:0]
+				for _, sub := range subs {
+					targets = append(targets, sub.Endpoint)
+				}
+			}
+			for _, endpoint := range targets {
+				key := j.Key + ":" + userId.Hex() + ":" + channel
+				if endpoint != "" {
+					sum := sha256.Sum256([]byte(endpoint))
+					key += ":" + hex.EncodeToString(sum[:8])
+				}
+				_, err := enqueueJob(ctx, jobDelivery, key, at, bson.M{
+					"userId":    userId,
+					"channel":   channel,
+					"endpoint":  endpoint,
+					"messageId": j.Key,
+					"title":     li.Name,
+					"body":      reminderBody(l.Name, *li.Due),
+					"listId":    listId,
+					"itemId":    itemId,
+					"time":      now,
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// reminderBody возвращает текст напоминания со сроком в часовом поясе элемента.
+func reminderBody(listName string, due ItemDue) string {
+	loc, err := time.LoadLocation(due.TimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+	if due.Date != "" {
+		return fmt.Sprintf("%s: due %s", listName, due.Date)
+	}
+	return fmt.Sprintf("%s: due %s", listName, due.At.In(loc).Format("2006-01-02 15:04"))
+}
+
+// runDelivery доставляет уведомление одному получателю по одному каналу. Канал, не настроенный на сервере
+// или у получателя, и исчезнувшая подписка Web Push не повторяются; исчезнувшая подписка удаляется.
+func runDelivery(ctx context.Context, j Job, now time.Time) error {
+	userId, _ := j.Payload["userId"].(primitive.ObjectID)
+	channel, _ := j.Payload["channel"].(string)
+	endpoint, _ := j.Payload["endpoint"].(string)
+
+	ch := notificationChannel(channel)
+	if ch == nil {
+		return errJobSkipped
+	}
+	s, err := GetNotificationSettings(userId)
+	if err != nil {
+		return err
+	}
+	// Настройки могли измениться, пока доставка ждала окончания тихих часов.
+	listId, _ := j.Payload["listId"].(primitive.ObjectID)
+	if !s.enabled(channel) || (!listId.IsZero() && s.muted(listId)) {
+		return errJobSkipped
+	}
+
+	to := notify.Recipient{UserId: userId.Hex(), Email: s.Email, WebhookURL: s.WebhookURL, WebhookSecret: s.WebhookSecret}
+	if endpoint != "" {
+		var sub PushSubscription
+		err := config.PushSubscriptions.FindOne(ctx, bson.M{"userId": userId, "endpoint": endpoint}).Decode(&sub)
+		if err == mongo.ErrNoDocuments {
+			return errJobSkipped
+		} else if err != nil {
+			return err
+		}
+		to.Push = &notify.PushSubscription{Endpoint: sub.Endpoint, P256dh: sub.P256dh, Auth: sub.Auth}
+	}
+
+	m := notify.Message{ID: fmt.Sprint(j.Payload["messageId"]), Title: fmt.Sprint(j.Payload["title"]), Body: fmt.Sprint(j.Payload["body"]), Time: now}
+	if t, ok := j.Payload["time"].(primitive.DateTime); ok {
+		m.Time = t.Time()
+	}
+	if !listId.IsZero() {
+		m.ListId = listId.Hex()
+	}
+	if id, ok := j.Payload["itemId"].(primitive.ObjectID); ok {
+		m.ItemId = id.Hex()
+	}
+
+	err = ch.Send(ctx, to, m)
+	switch {
+	case errors.Is(err, notify.ErrNotConfigured), errors.Is(err, notify.ErrForbiddenAddress):
+		return errJobSkipped
+	case errors.Is(err, notify.ErrGone):
+		if _, err := RemovePushSubscription(userId, endpoint); err != nil {
+			return err
+		}
+		return errJobSkipped
+	}
+	return err
+}
@@ -0,0 +1,403 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/abel-03/go-todo/config"
+	"github.com/abel-03/go-todo/events"
+	"github.com/abel-03/go-todo/rank"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxPositionAttempts - сколько раз пересчитывается позиция, если список изменили между чтением и записью.
+const maxPositionAttempts = 5
+
+// ErrPositionConflict возвращается, если соседние элементы перемещения не найдены в списке
+// или позицию элемента не удалось занять из-за одновременных правок.
+var ErrPositionConflict = errors.New("item move conflict")
+
+// ItemMove описывает перемещение элемента. ListId задает список назначения (nil - тот же список);
+// элемент ставится после AfterId и перед BeforeId. Без соседей элемент перемещается в конец списка.
+// Если заданы Versions, перемещается только элемент одной из этих версий.
+type ItemMove struct {
+	ListId   *primitive.ObjectID
+	AfterId  *primitive.ObjectID
+	BeforeId *primitive.ObjectID
+	Versions []int64
+}
+
+// sortItems упорядочивает элементы по позиции. Элементы с одинаковой позицией упорядочиваются по идентификатору.
+func sortItems(items []ListItem) {
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Position != items[j].Position {
+			return items[i].Position < items[j].Position
+		}
+		return items[i].ID.Hex() < items[j].ID.Hex()
+	})
+}
+
+// lastPosition возвращает наибольшую позицию среди элементов.
+func lastPosition(items []ListItem) string {
+	last := ""
+	for _, li := range items {
+		if li.Position > last {
+			last = li.Position
+		}
+	}
+	return last
+}
+
+// appendPositions присваивает элементам позиции после last в порядке их следования.
+func appendPositions(items []ListItem, last string) error {
+	for i := range items {
+		pos, err := rank.After(last)
+		if err != nil {
+			return err
+		}
+		items[i].Position, last = pos, pos
+	}
+	return nil
+}
+
+// findListItems возвращает список по фильтру с элементами, но без имен участников, или nil, если он не найден.
+func findListItems(filter bson.M, opts ...*options.FindOneOptions) (*ShoppingList, error) {
+	var l ShoppingList
+	err := config.ShoppingLists.FindOne(context.TODO(), filter, opts...).Decode(&l)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// positionGap описывает место для элемента между соседями и условия, при которых оно еще свободно.
+type positionGap struct {
+	position   string
+	conditions bson.A
+}
+
+// findGap вычисляет позицию элемента itemId в списке l согласно перемещению m.
+// Условия проверяют, что соседи остались на своих позициях и между ними не появилось других элементов.
+func findGap(l ShoppingList, itemId primitive.ObjectID, m ItemMove) (*positionGap, error) {
+	others := make([]ListItem, 0, len(l.Items))
+	for _, li := range l.Items {
+		if li.ID != itemId {
+			others = append(others, li)
+		}
+	}
+	sortItems(others)
+
+	indexOf := func(id primitive.ObjectID) int {
+		for i, li := range others {
+			if li.ID == id {
+				return i
+			}
+		}
+		return -1
+	}
+
+	// Определяем соседей, между которыми встанет элемент.
+	var lower, upper *ListItem
+	switch {
+	case m.AfterId != nil && m.BeforeId != nil:
+		a, b := indexOf(*m.AfterId), indexOf(*m.BeforeId)
+		if a < 0 || b < 0 || a >= b {
+			return nil, ErrPositionConflict
+		}
+		lower, upper = &others[a], &others[b]
+	case m.AfterId != nil:
+		a := indexOf(*m.AfterId)
+		if a < 0 {
+			return nil, ErrPositionConflict
+		}
+		lower = &others[a]
+		if a+1 < len(others) {
+			upper = &others[a+1]
+		}
+	case m.BeforeId != nil:
+		b := indexOf(*m.BeforeId)
+		if b < 0 {
+			return nil, ErrPositionConflict
+		}
+		upper = &others[b]
+		if b > 0 {
+			lower = &others[b-1]
+		}
+	case len(others) > 0:
+		lower = &others[len(others)-1]
+	}
+
+	gap := positionGap{conditions: make(bson.A, 0)}
+	between := bson.M{"_id": bson.M{"$ne": itemId}}
+	bounds := bson.M{}
+	lowerPos, upperPos := "", ""
+	if lower != nil {
+		lowerPos = lower.Position
+		bounds["$gt"] = lowerPos
+		gap.conditions = append(gap.conditions,
+			bson.M{"items": bson.M{"$elemMatch": bson.M{"_id": lower.ID, "position": lowerPos}}})
+	}
+	if upper != nil {
+		upperPos = upper.Position
+		bounds["$lt"] = upperPos
+		gap.conditions = append(gap.conditions,
+			bson.M{"items": bson.M{"$elemMatch": bson.M{"_id": upper.ID, "position": upperPos}}})
+	}
+	if lowerPos != "" && lowerPos == upperPos {
+		// Соседи с одинаковой позицией появляются только при одновременных вставках: между ними места нет.
+		return nil, ErrPositionConflict
+	}
+
+	pos, err := rank.Between(lowerPos, upperPos)
+	if err != nil {
+		return nil, err
+	}
+	gap.position = pos
+
+	// Между соседями не должно появиться других элементов; в пустом списке - вообще никаких.
+	if len(bounds) > 0 {
+		between["position"] = bounds
+	}
+	gap.conditions = append(gap.conditions, bson.M{"items": bson.M{"$not": bson.M{"$elemMatch": between}}})
+	return &gap, nil
+}
+
+// MoveListItem перемещает элемент внутри списка или в другой доступный пользователю список и возвращает
+// элемент после перемещения. Меняется только позиция перемещаемого элемента; место между соседями занимается,
+// только если за время перемещения его не заняли другие участники, иначе позиция пересчитывается заново.
+// Элемент с подзадачами и подзадачу нельзя перенести в другой список (ErrSubtreeMove).
+// Если элемент или список назначения не найден или недоступен пользователю, возвращается nil.
+func MoveListItem(userId, itemId primitive.ObjectID, m ItemMove) (*ListItem, error) {
+	access, err := listAccessFilter(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; attempt < maxPositionAttempts; attempt++ {
+		source, err := findListItems(bson.M{"items._id": itemId, "$or": access})
+		if err != nil || source == nil {
+			return nil, err
+		}
+
+		var item ListItem
+		for _, li := range source.Items {
+			if li.ID == itemId {
+				item = li
+			}
+		}
+		if m.Versions != nil && !hasVersion(m.Versions, item.Version) {
+			return nil, ErrVersionMismatch
+		}
+
+		if m.ListId == nil || *m.ListId == source.ID {
+			li, err := moveWithinList(*source, item, userId, access, m)
+			if err != nil || li != nil {
+				return li, err
+			}
+			continue
+		}
+
+		// Подзадачи связаны с элементами своего списка, поэтому между списками переносятся только отдельные элементы.
+		idx := newItemIndex(source.Items)
+		if idx.parent(item) != nil || len(idx.children[item.ID]) > 0 {
+			return nil, ErrSubtreeMove
+		}
+
+		target, err := findListItems(bson.M{"_id": *m.ListId, "$or": access})
+		if err != nil || target == nil {
+			return nil, err
+		}
+		li, err := moveToList(*source, *target, item, userId, access, m)
+		if err != nil || li != nil {
+			return li, err
+		}
+	}
+	return nil, ErrPositionConflict
+}
+
+// moveWithinList меняет позицию элемента в его списке. Возвращает nil без ошибки,
+// если список изменился после чтения и позицию нужно вычислить заново.
+func moveWithinList(l ShoppingList, item ListItem, userId primitive.ObjectID, access bson.A, m ItemMove) (*ListItem, error) {
+	gap, err := findGap(l, item.ID, m)
+	if err != nil {
+		return nil, err
+	}
+
+	seq, err := NextChangeSeq()
+	if err != nil {
+		return nil, err
+	}
+	defer releaseChangeSeq(seq)
+
+	conditions := append(gap.conditions, bson.M{"items": bson.M{"$elemMatch": bson.M{"_id": item.ID, "version": item.Version}}})
+	filter := bson.M{"_id": l.ID, "$or": access, "$and": conditions}
+	update := bson.M{
+		"$set": bson.M{
+			"items.$[it].position": gap.position,
+			"items.$[it].seq":      seq,
+		},
+		"$max": bson.M{"seq": seq},
+		"$inc": bson.M{
+			"items.$[it].version": 1,
+			"version":             1,
+		},
+	}
+
+	var updated ShoppingList
+	opts := options.FindOneAndUpdate().
+		SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"it._id": item.ID}}}).
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{
+			"ownerId":    1,
+			"sharingIds": 1,
+			"groupId":    1,
+			"items":      bson.M{"$elemMatch": bson.M{"_id": item.ID}},
+		})
+	err = config.ShoppingLists.FindOneAndUpdate(context.TODO(), filter, update, opts).Decode(&updated)
+	if err == mongo.ErrNoDocuments || (err == nil && len(updated.Items) == 0) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	li := updated.Items[0]
+	publishListEvent(updated, events.ItemMoved, userId, &li.ID, li)
+	return &li, nil
+}
+
+// moveToList переносит элемент из списка source в список target. Элемент сначала забирается из исходного списка,
+// чтобы перенести его последнее состояние, а затем добавляется в список назначения; если место в нем занять
+// не удалось, элемент возвращается обратно. Возвращает nil без ошибки, если перемещение нужно повторить.
+func moveToList(source, target ShoppingList, item ListItem, userId primitive.ObjectID, access bson.A, m ItemMove) (*ListItem, error) {
+	gap, err := findGap(target, item.ID, m)
+	if err != nil {
+		return nil, err
+	}
+
+	seq, err := NextChangeSeq()
+	if err != nil {
+		return nil, err
+	}
+	defer releaseChangeSeq(seq)
+
+	// Забираем элемент из исходного списка.
+	var taken ShoppingList
+	err = config.ShoppingLists.FindOneAndUpdate(context.TODO(),
+		bson.M{"_id": source.ID, "$or": access, "items": bson.M{"$elemMatch": bson.M{"_id": item.ID, "version": item.Version}}},
+		bson.M{
+			"$pull": bson.M{"items": bson.M{"_id": item.ID}},
+			"$max":  bson.M{"seq": seq},
+			"$inc":  bson.M{"version": 1},
+		},
+		options.FindOneAndUpdate().SetProjection(bson.M{
+			"ownerId":    1,
+			"sharingIds": 1,
+			"groupId":    1,
+			"items":      bson.M{"$elemMatch": bson.M{"_id": item.ID}},
+		})).Decode(&taken)
+	if err == mongo.ErrNoDocuments || (err == nil && len(taken.Items) == 0) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Добавляем элемент в список назначения. В нем элемент считается созданным заново,
+	// а исполнитель снимается: он может не быть участником списка назначения.
+	li := taken.Items[0]
+	li.Position, li.AssigneeId = gap.position, nil
+	li.Seq, li.CreatedSeq = seq, seq
+	li.Version++
+	var updated ShoppingList
+	err = config.ShoppingLists.FindOneAndUpdate(context.TODO(),
+		bson.M{"_id": target.ID, "$or": access, "items._id": bson.M{"$ne": item.ID}, "$and": gap.conditions},
+		bson.M{
+			"$push": bson.M{"items": li},
+			"$max":  bson.M{"seq": seq},
+			"$inc":  bson.M{"version": 1},
+		},
+		options.FindOneAndUpdate().SetProjection(listMembersProjection)).Decode(&updated)
+	if err == mongo.ErrNoDocuments {
+		// Место заняли или список назначения удалили: возвращаем элемент на прежнее место.
+		restored := taken.Items[0]
+		restored.Seq = seq
+		restored.Version++
+		_, err := config.ShoppingLists.UpdateOne(context.TODO(), bson.M{"_id": source.ID},
+			bson.M{
+				"$push": bson.M{"items": restored},
+				"$max":  bson.M{"seq": seq},
+				"$inc":  bson.M{"version": 1},
+			})
+		return nil, err
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = addTombstones([]Tombstone{{Kind: TombstoneItem, EntityId: item.ID, ListId: source.ID, Seq: seq}})
+	if err != nil {
+		return nil, err
+	}
+
+	publishListEvent(taken, events.ItemRemoved, userId, &li.ID, nil)
+	publishListEvent(updated, events.ItemAdded, userId, &li.ID, li)
+	return &li, nil
+}
+
+// MigrateItemPositions присваивает позиции элементам, сохраненным до появления ручного порядка.
+// Элементы без позиции ставятся после упорядоченных в том порядке, в котором они хранятся в списке.
+func MigrateItemPositions() error {
+	cursor, err := config.ShoppingLists.Find(context.TODO(),
+		bson.M{"items": bson.M{"$elemMatch": bson.M{"position": bson.M{"$in": bson.A{nil, ""}}}}},
+		options.Find().SetProjection(bson.M{"items._id": 1, "items.position": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(context.Background()) {
+		var l ShoppingList
+		if err := cursor.Decode(&l); err != nil {
+			return err
+		}
+
+		ordered := make([]ListItem, 0, len(l.Items))
+		missing := make([]ListItem, 0)
+		for _, li := range l.Items {
+			if rank.Valid(li.Position) {
+				ordered = append(ordered, li)
+			} else {
+				missing = append(missing, li)
+			}
+		}
+		if err := appendPositions(missing, lastPosition(ordered)); err != nil {
+			return err
+		}
+
+		seq, err := NextChangeSeq()
+		if err != nil {
+			return err
+		}
+		set := bson.M{}
+		filters := make([]interface{}, len(missing))
+		for i, li := range missing {
+			name := "i" + strconv.Itoa(i)
+			set["items.$["+name+"].position"] = li.Position
+			set["items.$["+name+"].seq"] = seq
+			filters[i] = bson.M{name + "._id": li.ID}
+		}
+		_, err = config.ShoppingLists.UpdateOne(context.TODO(), bson.M{"_id": l.ID},
+			bson.M{"$set": set, "$max": bson.M{"seq": seq}, "$inc": bson.M{"version": 1}},
+			options.Update().SetArrayFilters(options.ArrayFilters{Filters: filters}))
+		releaseChangeSeq(seq)
+		if err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
@@ -0,0 +1,562 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abel-03/go-todo/catalog"
+	"github.com/abel-03/go-todo/config"
+	"github.com/abel-03/go-todo/events"
+	"github.com/abel-03/go-todo/hlc"
+	"github.com/abel-03/go-todo/rrule"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrInvalidRecurrence возвращается, если правило повторения, его начало или часовой пояс заданы неверно
+// либо по правилу больше нет повторений.
+var ErrInvalidRecurrence = errors.New("invalid recurrence")
+
+// Recurrence описывает повторение по правилу RRULE (RFC 5545), которое отсчитывается от Start
+// в часовом поясе TimeZone.
+type Recurrence struct {
+	Rule     string    `json:"rule" bson:"rule"`
+	Start    time.Time `json:"start" bson:"start"`
+	TimeZone string    `json:"timeZone" bson:"timeZone"`
+}
+
+// ParseRecurrence разбирает повторение. start - дата ("2024-05-01") или местное время ("2024-05-01T09:00")
+// в часовом поясе timeZone либо момент в формате RFC 3339; пустой start означает момент now.
+// Пустой timeZone означает UTC.
+func ParseRecurrence(rule, start, timeZone string, now time.Time) (*Recurrence, error) {
+	if timeZone == "" {
+		timeZone = "UTC"
+	}
+	loc, err := time.LoadLocation(timeZone)
+	if err != nil {
+		return nil, ErrInvalidRecurrence
+	}
+	r, err := rrule.Parse(rule)
+	if err != nil {
+		return nil, ErrInvalidRecurrence
+	}
+
+	rec := Recurrence{Rule: r.String(), TimeZone: timeZone}
+	switch {
+	case start == "":
+		rec.Start = now
+	default:
+		parsed := false
+		for _, layout := range []string{dueDateLayout, dueLocalLayout, dueLocalSecsLayout} {
+			if t, err := time.ParseInLocation(layout, start, loc); err == nil {
+				rec.Start, parsed = t, true
+				break
+			}
+		}
+		if t, err := time.Parse(time.RFC3339, start); !parsed && err == nil {
+			rec.Start, parsed = t, true
+		}
+		if !parsed {
+			return nil, ErrInvalidRecurrence
+		}
+	}
+	rec.Start = rec.Start.UTC().Truncate(time.Second)
+	return &rec, nil
+}
+
+// Next возвращает первое повторение строго после after. Второй результат равен false, если повторений больше нет.
+func (rec Recurrence) Next(after time.Time) (time.Time, bool) {
+	r, err := rrule.Parse(rec.Rule)
+	if err != nil {
+		return time.Time{}, false
+	}
+	loc, err := time.LoadLocation(rec.TimeZone)
+	if err != nil {
+		return time.Time{}, false
+	}
+	next, ok := r.After(rec.Start.In(loc), after)
+	return next.UTC(), ok
+}
+
+// movedTo возвращает срок, перенесенный на повторение t: срок без времени переносится на дату повторения
+// в своем часовом поясе, срок со временем - на сам момент повторения.
+func (d ItemDue) movedTo(t time.Time) ItemDue {
+	if d.Date == "" {
+		d.At = t
+		return d
+	}
+	loc, err := time.LoadLocation(d.TimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+	local := t.In(loc)
+	day := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+	d.Date, d.At = day.Format(dueDateLayout), day.AddDate(0, 0, 1).UTC()
+	return d
+}
+
+// TemplateItem - элемент шаблона повторяющегося списка.
+type TemplateItem struct {
+	Name     string  `json:"name" bson:"name"`
+	Quantity float64 `json:"quantity,omitempty" bson:"quantity,omitempty"`
+	Unit     string  `json:"unit,omitempty" bson:"unit,omitempty"`
+	Note     string  `json:"note,omitempty" bson:"note,omitempty"`
+	Price    float64 `json:"price,omitempty" bson:"price,omitempty"`
+	Currency string  `json:"currency,omitempty" bson:"currency,omitempty"`
+	Priority string  `json:"priority,omitempty" bson:"priority,omitempty"`
+	Category string  `json:"category,omitempty" bson:"category,omitempty"`
+}
+
+// ListRecurrence описывает повторяющийся список. В момент NextAt список обновляется по шаблону Template:
+// завершенные элементы верхнего уровня удаляются вместе с подзадачами, а недостающие элементы шаблона
+// добавляются в конец списка.
+type ListRecurrence struct {
+	Recurrence `bson:",inline"`
+	NextAt     time.Time      `json:"nextAt" bson:"nextAt"`
+	Template   []TemplateItem `json:"template" bson:"template"`
+}
+
+// templateFromItems возвращает шаблон из элементов списка.
+func templateFromItems(items []ListItem) []TemplateItem {
+	template := make([]TemplateItem, 0, len(items))
+	for _, li := range items {
+		template = append(template, TemplateItem{
+			Name:     li.Name,
+			Quantity: li.Quantity,
+			Unit:     li.Unit,
+			Note:     li.Note,
+			Price:    li.Price,
+			Currency: li.Currency,
+			Priority: li.Priority,
+			Category: li.Category,
+		})
+	}
+	return template
+}
+
+// listEditorsFilter возвращает условия, при выполнении любого из которых пользователь может изменять
+// свойства списка: он владелец или совладелец списка либо владелец или администратор группы списка.
+func listEditorsFilter(userId primitive.ObjectID) (bson.A, error) {
+	adminGroupIds, err := UserGroupIds(userId, GroupRoleOwner, GroupRoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+
+	return bson.A{
+		bson.M{"ownerId": userId},
+		bson.M{"coOwnerIds": userId},
+		bson.M{"groupId": bson.M{"$in": adminGroupIds}},
+	}, nil
+}
+
+// SetListRecurrence делает список повторяющимся. Если template равен nil, шаблоном становятся текущие элементы списка.
+// Изменить повторение могут те же пользователи, что и свойства списка (см. UpdateShoppingList).
+// Если список не найден или недоступен пользователю, возвращается nil.
+func SetListRecurrence(listId, userId primitive.ObjectID, rec Recurrence, template []TemplateItem, now time.Time) (*ListRecurrence, error) {
+	next, ok := rec.Next(now)
+	if !ok {
+		return nil, ErrInvalidRecurrence
+	}
+
+	editors, err := listEditorsFilter(userId)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"_id": listId, "$or": editors}
+
+	if template == nil {
+		l, err := findListItems(filter)
+		if err != nil || l == nil {
+			return nil, err
+		}
+		sortItems(l.Items)
+		template = templateFromItems(l.Items)
+	}
+
+	seq, err := NextChangeSeq()
+	if err != nil {
+		return nil, err
+	}
+	defer releaseChangeSeq(seq)
+
+	lr := ListRecurrence{Recurrence: rec, NextAt: next, Template: template}
+	update := bson.M{
+		"$set": bson.M{"recurrence": lr},
+		"$max": bson.M{"seq": seq},
+		"$inc": bson.M{"version": 1},
+	}
+	var l ShoppingList
+	err = config.ShoppingLists.FindOneAndUpdate(context.TODO(), filter, update,
+		options.FindOneAndUpdate().SetProjection(listMembersProjection)).Decode(&l)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	publishListEvent(l, events.ListUpdated, userId, nil, bson.M{"recurrence": lr})
+	return &lr, nil
+}
+
+// RemoveListRecurrence отменяет повторение списка.
+func RemoveListRecurrence(listId, userId primitive.ObjectID) (bool, error) {
+	editors, err := listEditorsFilter(userId)
+	if err != nil {
+		return false, err
+	}
+
+	seq, err := NextChangeSeq()
+	if err != nil {
+		return false, err
+	}
+	defer releaseChangeSeq(seq)
+
+	filter := bson.M{"_id": listId, "$or": editors, "recurrence": bson.M{"$exists": true}}
+	update := bson.M{
+		"$unset": bson.M{"recurrence": ""},
+		"$max":   bson.M{"seq": seq},
+		"$inc":   bson.M{"version": 1},
+	}
+	var l ShoppingList
+	err = config.ShoppingLists.FindOneAndUpdate(context.TODO(), filter, update,
+		options.FindOneAndUpdate().SetProjection(listMembersProjection)).Decode(&l)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	publishListEvent(l, events.ListUpdated, userId, nil, bson.M{"recurrence": nil})
+	return true, nil
+}
+
+// renewRecurringItems обрабатывает повторяющиеся элементы items, завершенные при завершении покупок списка l.
+// Элемент с повторением снова становится незавершенным и скрывается до следующего повторения после now;
+// его срок переносится на это повторение. Элементы, у которых повторений больше нет, удаляются.
+// Элемент, который успели отметить заново после завершения покупок, не изменяется.
+// Возвращает идентификаторы удаленных и возобновленных элементов.
+func renewRecurringItems(l ShoppingList, items []ListItem, ts hlc.Timestamp, seq int64, now time.Time) (removed, renewed []primitive.ObjectID, err error) {
+	set, inc := bson.M{}, bson.M{}
+	filters := make([]interface{}, 0)
+	for _, li := range items {
+		next, ok := li.Recurrence.Next(now)
+		if !ok {
+			removed = append(removed, li.ID)
+			continue
+		}
+
+		name := "i" + strconv.Itoa(len(filters))
+		prefix := "items.$[" + name + "]."
+		set[prefix+"isCompleted"] = false
+		set[prefix+"hiddenUntil"] = next
+		set[prefix+"clock.isCompleted"] = ts
+		set[prefix+"seq"] = seq
+		inc[prefix+"version"] = 1
+		if li.Due != nil {
+			set[prefix+"due"] = li.Due.movedTo(next)
+		}
+		filters = append(filters, bson.M{
+			name + "._id":               li.ID,
+			name + ".isCompleted":       true,
+			name + ".clock.isCompleted": li.Clock.IsCompleted,
+		})
+		renewed = append(renewed, li.ID)
+	}
+
+	if len(filters) > 0 {
+		inc["version"] = 1
+		_, err = config.ShoppingLists.UpdateOne(context.TODO(), bson.M{"_id": l.ID},
+			bson.M{"$set": set, "$max": bson.M{"seq": seq}, "$inc": inc},
+			options.Update().SetArrayFilters(options.ArrayFilters{Filters: filters}))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(removed) > 0 {
+		_, err = config.ShoppingLists.UpdateOne(context.TODO(), bson.M{"_id": l.ID}, bson.M{
+			"$pull": bson.M{"items": bson.M{"_id": bson.M{"$in": removed}, "isCompleted": true}},
+			"$max":  bson.M{"seq": seq},
+			"$inc":  bson.M{"version": 1},
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return removed, renewed, nil
+}
+
+// recurringStore - хранилище списков, с которым работают задачи повторения. Задачи используют mongoRecurringStore,
+// тесты - хранилище в памяти.
+type recurringStore interface {
+	// nextSeq выдает номер изменения, releaseSeq освобождает его после записи (см. NextChangeSeq).
+	nextSeq() (int64, error)
+	releaseSeq(seq int64)
+	// hiddenDue возвращает идентификаторы списков со скрытыми элементами, время повторения которых наступило к now.
+	hiddenDue(ctx context.Context, now time.Time) ([]primitive.ObjectID, error)
+	// revealItems возвращает в список id скрытые элементы, время повторения которых наступило к now, и отмечает их
+	// номером изменения seq. Возвращает состояние списка до изменения или nil, если таких элементов уже нет.
+	revealItems(ctx context.Context, id primitive.ObjectID, now time.Time, seq int64) (*ShoppingList, error)
+	// regenerationDue возвращает неархивные списки, время повторения которых наступило к now.
+	regenerationDue(ctx context.Context, now time.Time) ([]ShoppingList, error)
+	// saveRegenerated записывает обновленные элементы списка l и следующее повторение next, если список не изменился
+	// после чтения. Пустой next снимает повторение. Возвращает false, если список изменился или удален.
+	saveRegenerated(ctx context.Context, l ShoppingList, items []ListItem, next *time.Time, seq int64) (bool, error)
+	// recordRemoved сохраняет надгробия и историю покупок для удаленных при обновлении элементов.
+	recordRemoved(l ShoppingList, removed []primitive.ObjectID, seq int64, now time.Time) error
+}
+
+// mongoRecurringStore хранит списки в MongoDB.
+type mongoRecurringStore struct{}
+
+func (mongoRecurringStore) nextSeq() (int64, error) {
+	return NextChangeSeq()
+}
+
+func (mongoRecurringStore) releaseSeq(seq int64) {
+	releaseChangeSeq(seq)
+}
+
+func (mongoRecurringStore) hiddenDue(ctx context.Context, now time.Time) ([]primitive.ObjectID, error) {
+	cursor, err := config.ShoppingLists.Find(ctx,
+		bson.M{"items.hiddenUntil": bson.M{"$lte": now}},
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var docs []struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, 0, len(docs))
+	for _, doc := range docs {
+		ids = append(ids, doc.ID)
+	}
+	return ids, nil
+}
+
+func (mongoRecurringStore) revealItems(ctx context.Context, id primitive.ObjectID, now time.Time, seq int64) (*ShoppingList, error) {
+	var l ShoppingList
+	err := config.ShoppingLists.FindOneAndUpdate(ctx,
+		bson.M{"_id": id, "items.hiddenUntil": bson.M{"$lte": now}},
+		bson.M{
+			"$unset": bson.M{"items.$[h].hiddenUntil": ""},
+			"$set":   bson.M{"items.$[h].seq": seq},
+			"$max":   bson.M{"seq": seq},
+			"$inc":   bson.M{"items.$[h].version": 1, "version": 1},
+		},
+		options.FindOneAndUpdate().
+			SetArrayFilters(options.ArrayFilters{Filters: []interface{}{bson.M{"h.hiddenUntil": bson.M{"$lte": now}}}})).
+		Decode(&l)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (mongoRecurringStore) regenerationDue(ctx context.Context, now time.Time) ([]ShoppingList, error) {
+	cursor, err := config.ShoppingLists.Find(ctx, bson.M{
+		"recurrence.nextAt": bson.M{"$lte": now},
+		"archived":          bson.M{"$ne": true},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var lists []ShoppingList
+	if err := cursor.All(ctx, &lists); err != nil {
+		return nil, err
+	}
+	return lists, nil
+}
+
+func (mongoRecurringStore) saveRegenerated(ctx context.Context, l ShoppingList, items []ListItem, next *time.Time, seq int64) (bool, error) {
+	update := bson.M{
+		"$set": bson.M{"items": items},
+		"$max": bson.M{"seq": seq},
+		"$inc": bson.M{"version": 1},
+	}
+	if next != nil {
+		update["$set"].(bson.M)["recurrence.nextAt"] = *next
+	} else {
+		update["$unset"] = bson.M{"recurrence": ""}
+	}
+	res, err := config.ShoppingLists.UpdateOne(ctx, bson.M{"_id": l.ID, "version": l.Version}, update)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount == 1, nil
+}
+
+func (mongoRecurringStore) recordRemoved(l ShoppingList, removed []primitive.ObjectID, seq int64, now time.Time) error {
+	tombstones := make([]Tombstone, 0, len(removed))
+	for _, id := range removed {
+		tombstones = append(tombstones, Tombstone{Kind: TombstoneItem, EntityId: id, ListId: l.ID, Seq: seq})
+	}
+	if err := addTombstones(tombstones); err != nil {
+		return err
+	}
+	_, err := recordCheckout(l, nil, removed, nil, now)
+	return err
+}
+
+// ProcessRecurringItems возвращает в списки повторяющиеся элементы, время повторения которых наступило к моменту now.
+func ProcessRecurringItems(ctx context.Context, now time.Time) error {
+	return processRecurringItems(ctx, mongoRecurringStore{}, now)
+}
+
+// processRecurringItems выполняет ProcessRecurringItems со списками из хранилища store.
+func processRecurringItems(ctx context.Context, store recurringStore, now time.Time) error {
+	ids, err := store.hiddenDue(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		seq, err := store.nextSeq()
+		if err != nil {
+			return err
+		}
+
+		// Возвращается состояние списка до обновления, по которому видно, какие элементы появились.
+		l, err := store.revealItems(ctx, id, now, seq)
+		store.releaseSeq(seq)
+		if err != nil {
+			return err
+		} else if l == nil {
+			continue
+		}
+
+		for _, li := range revealedItems(l.Items, now) {
+			li.HiddenUntil, li.Seq, li.Version = nil, seq, li.Version+1
+			publishListEvent(*l, events.ItemModified, l.OwnerId, &li.ID, li)
+		}
+	}
+	return nil
+}
+
+// revealedItems возвращает скрытые элементы, время повторения которых наступило к моменту now.
+func revealedItems(items []ListItem, now time.Time) []ListItem {
+	res := make([]ListItem, 0)
+	for _, li := range items {
+		if li.HiddenUntil != nil && !li.HiddenUntil.After(now) {
+			res = append(res, li)
+		}
+	}
+	return res
+}
+
+// ProcessRecurringLists обновляет по шаблону повторяющиеся списки, время повторения которых наступило к моменту now.
+// Список, измененный во время обновления, обрабатывается при следующем вызове.
+func ProcessRecurringLists(ctx context.Context, now time.Time) error {
+	return processRecurringLists(ctx, mongoRecurringStore{}, now)
+}
+
+// processRecurringLists выполняет ProcessRecurringLists со списками из хранилища store.
+func processRecurringLists(ctx context.Context, store recurringStore, now time.Time) error {
+	lists, err := store.regenerationDue(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range lists {
+		if err := regenerateList(ctx, store, l, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// regenerateList обновляет повторяющийся список l по шаблону и переносит его повторение на следующее после now.
+// Элементы шаблона, которые уже есть в списке незавершенными, повторно не добавляются.
+// Удаленные элементы сохраняются в историю покупок.
+func regenerateList(ctx context.Context, store recurringStore, l ShoppingList, now time.Time) error {
+	seq, err := store.nextSeq()
+	if err != nil {
+		return err
+	}
+	defer store.releaseSeq(seq)
+
+	items, added, removed, err := regeneratedItems(l, hlc.Default.Now(), seq)
+	if err != nil {
+		return err
+	}
+
+	var next *time.Time
+	if at, ok := l.Recurrence.Next(now); ok {
+		next = &at
+	}
+	if ok, err := store.saveRegenerated(ctx, l, items, next, seq); err != nil || !ok {
+		return err
+	}
+	if err := store.recordRemoved(l, removed, seq, now); err != nil {
+		return err
+	}
+
+	publishListEvent(l, events.ListRegenerated, l.OwnerId, nil, bson.M{"removedItemIds": removed, "addedItems": added})
+	return nil
+}
+
+// regeneratedItems возвращает элементы списка l после обновления по шаблону, добавленные элементы шаблона
+// и идентификаторы удаленных завершенных элементов. ts и seq - метка времени и номер изменения добавленных элементов.
+// Как и при завершении покупок, удаляются только завершенные элементы верхнего уровня вместе с подзадачами.
+func regeneratedItems(l ShoppingList, ts hlc.Timestamp, seq int64) (items, added []ListItem, removed []primitive.ObjectID, err error) {
+	roots := make([]primitive.ObjectID, 0)
+	for _, li := range l.Items {
+		if li.IsCompleted && li.Recurrence == nil && li.ParentId == nil {
+			roots = append(roots, li.ID)
+		}
+	}
+	removed = newItemIndex(l.Items).subtree(roots)
+	isRemoved := make(map[primitive.ObjectID]bool, len(removed))
+	for _, id := range removed {
+		isRemoved[id] = true
+	}
+
+	items = make([]ListItem, 0, len(l.Items)+len(l.Recurrence.Template))
+	present := make(map[string]bool)
+	for _, li := range l.Items {
+		if isRemoved[li.ID] {
+			continue
+		}
+		items = append(items, li)
+		if !li.IsCompleted {
+			present[catalog.NormalizeName(li.Name)] = true
+		}
+	}
+
+	added = make([]ListItem, 0)
+	for _, t := range l.Recurrence.Template {
+		key := catalog.NormalizeName(t.Name)
+		if present[key] || strings.TrimSpace(t.Name) == "" {
+			continue
+		}
+		present[key] = true
+		added = append(added, ListItem{
+			ID:         primitive.NewObjectID(),
+			Name:       t.Name,
+			Quantity:   t.Quantity,
+			Unit:       t.Unit,
+			Note:       t.Note,
+			Price:      t.Price,
+			Currency:   t.Currency,
+			Priority:   t.Priority,
+			Category:   t.Category,
+			Seq:        seq,
+			CreatedSeq: seq,
+			Clock:      NewItemClock(ts),
+			Version:    1,
+		})
+	}
+	if err := appendPositions(added, lastPosition(items)); err != nil {
+		return nil, nil, nil, err
+	}
+	return append(items, added...), added, removed, nil
+}
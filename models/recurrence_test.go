@@ -0,0 +1,281 @@
+package models
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/abel-03/go-todo/clock"
+	"github.com/abel-03/go-todo/events"
+	"github.com/abel-03/go-todo/hlc"
+	"github.com/abel-03/go-todo/scheduler"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseRecurrence(t *testing.T) {
+	now := time.Date(2024, time.March, 4, 10, 30, 15, 500, time.UTC)
+	tests := []struct {
+		rule, start, timeZone string
+		want                  Recurrence
+	}{
+		{"freq=weekly;byday=mo", "2024-03-04T09:00", "Europe/Moscow",
+			Recurrence{Rule: "FREQ=WEEKLY;BYDAY=MO", Start: time.Date(2024, time.March, 4, 6, 0, 0, 0, time.UTC), TimeZone: "Europe/Moscow"}},
+		{"FREQ=DAILY", "2024-03-04", "", Recurrence{Rule: "FREQ=DAILY", Start: time.Date(2024, time.March, 4, 0, 0, 0, 0, time.UTC), TimeZone: "UTC"}},
+		{"FREQ=DAILY", "", "UTC", Recurrence{Rule: "FREQ=DAILY", Start: now.Truncate(time.Second), TimeZone: "UTC"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseRecurrence(tt.rule, tt.start, tt.timeZone, now)
+		if err != nil || !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("ParseRecurrence(%q, %q, %q) = %+v, %v, want %+v", tt.rule, tt.start, tt.timeZone, got, err, tt.want)
+		}
+	}
+
+	for _, bad := range [][3]string{{"FREQ=HOURLY", "", ""}, {"FREQ=DAILY", "tomorrow", ""}, {"FREQ=DAILY", "", "Mars/Base"}} {
+		if _, err := ParseRecurrence(bad[0], bad[1], bad[2], now); err != ErrInvalidRecurrence {
+			t.Errorf("ParseRecurrence(%q, %q, %q) = %v, want ErrInvalidRecurrence", bad[0], bad[1], bad[2], err)
+		}
+	}
+}
+
+func TestRecurrenceNext(t *testing.T) {
+	// Каждый понедельник в 9:00 по Москве, то есть в 6:00 UTC.
+	rec := Recurrence{Rule: "FREQ=WEEKLY;BYDAY=MO;COUNT=2", Start: time.Date(2024, time.March, 4, 6, 0, 0, 0, time.UTC), TimeZone: "Europe/Moscow"}
+	next, ok := rec.Next(time.Date(2024, time.March, 4, 6, 0, 0, 0, time.UTC))
+	if want := time.Date(2024, time.March, 11, 6, 0, 0, 0, time.UTC); !ok || !next.Equal(want) || next.Location() != time.UTC {
+		t.Errorf("Next = %v, %v, want %v", next, ok, want)
+	}
+	if next, ok := rec.Next(next); ok {
+		t.Errorf("Next after last occurrence = %v", next)
+	}
+}
+
+func TestItemDueMovedTo(t *testing.T) {
+	next := time.Date(2024, time.March, 10, 22, 0, 0, 0, time.UTC)
+
+	at := ItemDue{At: time.Date(2024, time.March, 3, 22, 0, 0, 0, time.UTC), TimeZone: "UTC"}
+	if got := at.movedTo(next); !got.At.Equal(next) || got.Date != "" {
+		t.Errorf("movedTo = %+v, want at %v", got, next)
+	}
+
+	// В Москве повторение приходится уже на 11 марта.
+	date := ItemDue{Date: "2024-03-04", At: time.Date(2024, time.March, 4, 21, 0, 0, 0, time.UTC), TimeZone: "Europe/Moscow"}
+	got := date.movedTo(next)
+	if want := time.Date(2024, time.March, 11, 21, 0, 0, 0, time.UTC); got.Date != "2024-03-11" || !got.At.Equal(want) {
+		t.Errorf("movedTo = %+v, want date 2024-03-11 at %v", got, want)
+	}
+}
+
+// memRecurringStore - хранилище задач повторения в памяти. Оно запоминает, когда возвращались элементы
+// и какие элементы удалялись при обновлении списков.
+type memRecurringStore struct {
+	lists    []ShoppingList
+	seq      int64
+	revealed []time.Time
+	removed  [][]primitive.ObjectID
+}
+
+func (s *memRecurringStore) nextSeq() (int64, error) {
+	s.seq++
+	return s.seq, nil
+}
+
+func (s *memRecurringStore) releaseSeq(int64) {}
+
+func (s *memRecurringStore) hiddenDue(ctx context.Context, now time.Time) ([]primitive.ObjectID, error) {
+	ids := make([]primitive.ObjectID, 0)
+	for _, l := range s.lists {
+		if len(revealedItems(l.Items, now)) > 0 {
+			ids = append(ids, l.ID)
+		}
+	}
+	return ids, nil
+}
+
+func (s *memRecurringStore) revealItems(ctx context.Context, id primitive.ObjectID, now time.Time, seq int64) (*ShoppingList, error) {
+	for i := range s.lists {
+		l := &s.lists[i]
+		if l.ID != id || len(revealedItems(l.Items, now)) == 0 {
+			continue
+		}
+		before := *l
+		before.Items = append([]ListItem(nil), l.Items...)
+		for j := range l.Items {
+			if li := &l.Items[j]; li.HiddenUntil != nil && !li.HiddenUntil.After(now) {
+				li.HiddenUntil, li.Seq, li.Version = nil, seq, li.Version+1
+			}
+		}
+		l.Version++
+		s.revealed = append(s.revealed, now)
+		return &before, nil
+	}
+	return nil, nil
+}
+
+func (s *memRecurringStore) regenerationDue(ctx context.Context, now time.Time) ([]ShoppingList, error) {
+	lists := make([]ShoppingList, 0)
+	for _, l := range s.lists {
+		if l.Recurrence != nil && !l.Recurrence.NextAt.After(now) && !l.Archived {
+			lists = append(lists, l)
+		}
+	}
+	return lists, nil
+}
+
+func (s *memRecurringStore) saveRegenerated(ctx context.Context, l ShoppingList, items []ListItem, next *time.Time, seq int64) (bool, error) {
+	for i := range s.lists {
+		cur := &s.lists[i]
+		if cur.ID != l.ID || cur.Version != l.Version {
+			continue
+		}
+		cur.Items, cur.Version = items, cur.Version+1
+		if next != nil {
+			rec := *cur.Recurrence
+			rec.NextAt = *next
+			cur.Recurrence = &rec
+		} else {
+			cur.Recurrence = nil
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func (s *memRecurringStore) recordRemoved(l ShoppingList, removed []primitive.ObjectID, seq int64, now time.Time) error {
+	s.removed = append(s.removed, removed)
+	return nil
+}
+
+// Повторяющийся элемент, скрытый при завершении покупок, возвращается в список на первом шаге планировщика
+// после наступления следующего повторения, и только один раз.
+func TestSchedulerRevealsRecurringItems(t *testing.T) {
+	start := time.Date(2024, time.March, 4, 10, 0, 0, 0, time.UTC)
+	rec := &Recurrence{Rule: "FREQ=WEEKLY;BYDAY=MO", Start: time.Date(2024, time.March, 4, 9, 0, 0, 0, time.UTC), TimeZone: "UTC"}
+	hidden, ok := rec.Next(start)
+	if !ok {
+		t.Fatal("no next occurrence")
+	}
+	milk := primitive.NewObjectID()
+	store := &memRecurringStore{lists: []ShoppingList{{ID: primitive.NewObjectID(), OwnerId: primitive.NewObjectID(), Items: []ListItem{
+		{ID: milk, Name: "milk", Recurrence: rec, HiddenUntil: &hidden, Version: 1},
+		{ID: primitive.NewObjectID(), Name: "bread", Version: 1},
+	}}}}
+	sub := events.Default.Subscribe(store.lists[0].OwnerId)
+	defer sub.Close()
+
+	c := clock.NewFake(start)
+	s := scheduler.New(c, time.Hour).Add("recurring items", func(ctx context.Context, now time.Time) error {
+		return processRecurringItems(ctx, store, now)
+	})
+	for i := 0; i < 14*24; i++ {
+		s.RunOnce(context.Background())
+		c.Advance(time.Hour)
+	}
+
+	if want := time.Date(2024, time.March, 11, 9, 0, 0, 0, time.UTC); len(store.revealed) != 1 || !store.revealed[0].Equal(want) {
+		t.Errorf("revealed at %v, want [%v]", store.revealed, want)
+	}
+	if li := store.lists[0].Items[0]; li.HiddenUntil != nil || li.Version != 2 {
+		t.Errorf("item = %+v, want revealed with version 2", li)
+	}
+	select {
+	case e := <-sub.C:
+		if e.Type != events.ItemModified || e.ItemId == nil || *e.ItemId != milk {
+			t.Errorf("event = %+v, want %s for %s", e, events.ItemModified, milk.Hex())
+		}
+	default:
+		t.Error("no event published")
+	}
+}
+
+// Повторяющийся список обновляется по шаблону в каждое наступившее повторение: завершенные элементы удаляются,
+// отсутствующие элементы шаблона добавляются, а незавершенные не дублируются.
+func TestSchedulerRegeneratesRecurringLists(t *testing.T) {
+	start := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	rec := Recurrence{Rule: "FREQ=DAILY;COUNT=3", Start: time.Date(2024, time.March, 1, 8, 0, 0, 0, time.UTC), TimeZone: "UTC"}
+	nextAt, _ := rec.Next(start)
+	milk := primitive.NewObjectID()
+	store := &memRecurringStore{lists: []ShoppingList{{
+		ID:      primitive.NewObjectID(),
+		OwnerId: primitive.NewObjectID(),
+		Items: []ListItem{
+			{ID: milk, Name: "Milk", IsCompleted: true, Position: "a"},
+			{ID: primitive.NewObjectID(), Name: "bread", Position: "b"},
+			{ID: primitive.NewObjectID(), Name: "eggs", IsCompleted: true, Position: "c", Recurrence: &rec},
+		},
+		Recurrence: &ListRecurrence{Recurrence: rec, NextAt: nextAt, Template: []TemplateItem{{Name: "milk"}, {Name: "Bread"}}},
+		Version:    1,
+	}}}
+	sub := events.Default.Subscribe(store.lists[0].OwnerId)
+	defer sub.Close()
+
+	c := clock.NewFake(start)
+	s := scheduler.New(c, time.Hour).Add("recurring lists", func(ctx context.Context, now time.Time) error {
+		return processRecurringLists(ctx, store, now)
+	})
+	for i := 0; i < 5*24; i++ {
+		s.RunOnce(context.Background())
+		c.Advance(time.Hour)
+	}
+
+	if want := [][]primitive.ObjectID{{milk}, {}}; !reflect.DeepEqual(store.removed, want) {
+		t.Errorf("removed = %v, want %v", store.removed, want)
+	}
+	for i := range store.removed {
+		if e := <-sub.C; e.Type != events.ListRegenerated {
+			t.Errorf("event %d = %+v, want %s", i, e, events.ListRegenerated)
+		}
+	}
+	l := store.lists[0]
+	if l.Recurrence != nil {
+		t.Errorf("recurrence = %+v after last occurrence, want nil", l.Recurrence)
+	}
+
+	names := make([]string, 0)
+	for _, li := range l.Items {
+		names = append(names, li.Name)
+	}
+	if want := []string{"bread", "eggs", "milk"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("items = %v, want %v", names, want)
+	}
+	if last := l.Items[len(l.Items)-1]; last.Position <= "c" || last.Seq != 1 || last.Clock.Name == "" {
+		t.Errorf("added item = %+v, want position after c, seq 1 and a clock", last)
+	}
+}
+
+// При обновлении списка завершенный элемент удаляется вместе с подзадачами, а завершенные подзадачи
+// незавершенных элементов остаются.
+func TestRegeneratedItemsSubtasks(t *testing.T) {
+	parent, child, grandchild := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	open, doneChild := primitive.NewObjectID(), primitive.NewObjectID()
+	l := ShoppingList{
+		Items: []ListItem{
+			{ID: parent, Name: "party", IsCompleted: true, Position: "a"},
+			{ID: child, Name: "cake", ParentId: &parent, Position: "b"},
+			{ID: grandchild, Name: "candles", ParentId: &child, IsCompleted: true, Position: "c"},
+			{ID: open, Name: "breakfast", Position: "d"},
+			{ID: doneChild, Name: "eggs", ParentId: &open, IsCompleted: true, Position: "e"},
+		},
+		Recurrence: &ListRecurrence{Template: []TemplateItem{{Name: "cake"}, {Name: "eggs"}}},
+	}
+
+	items, added, removed, err := regeneratedItems(l, hlc.Format(1, 0, "n"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []primitive.ObjectID{parent, child, grandchild}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed = %v, want %v", removed, want)
+	}
+	names := make([]string, 0)
+	for _, li := range items {
+		names = append(names, li.Name)
+	}
+	// Незавершенная подзадача удаленного элемента удаляется вместе с ним, поэтому "cake" добавляется заново,
+	// а завершенная подзадача "eggs" остается и не мешает добавлению элемента шаблона.
+	if want := []string{"breakfast", "eggs", "cake", "eggs"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("items = %v, want %v", names, want)
+	}
+	if len(added) != 2 || added[0].ParentId != nil || added[1].ParentId != nil {
+		t.Errorf("added = %+v, want two top-level items", added)
+	}
+}
@@ -0,0 +1,149 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/abel-03/go-todo/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Статусы жалоб на приглашения.
+const (
+	ReportStatusOpen      = "open"
+	ReportStatusResolved  = "resolved"
+	ReportStatusDismissed = "dismissed"
+)
+
+// InviteReport представляет жалобу пользователя на приглашение к списку.
+type InviteReport struct {
+	ID               primitive.ObjectID  `json:"id" bson:"_id,omitempty"`
+	InviteId         primitive.ObjectID  `json:"inviteId" bson:"inviteId"`
+	ListId           primitive.ObjectID  `json:"listId" bson:"listId"`
+	ReporterId       primitive.ObjectID  `json:"reporterId" bson:"reporterId"`
+	ReportedUserId   primitive.ObjectID  `json:"reportedUserId" bson:"reportedUserId"`
+	Reason           string              `json:"reason" bson:"reason"`
+	Status           string              `json:"status" bson:"status"`
+	CreatedAt        time.Time           `json:"createdAt" bson:"createdAt"`
+	ReviewedBy       *primitive.ObjectID `json:"reviewedBy" bson:"reviewedBy,omitempty"`
+	ReviewedAt       *time.Time          `json:"reviewedAt" bson:"reviewedAt,omitempty"`
+	ReviewNote       string              `json:"reviewNote" bson:"reviewNote,omitempty"`
+	ReporterName     string              `json:"reporterName,omitempty" bson:"reporterName,omitempty"`
+	ReportedUserName string              `json:"reportedUserName,omitempty" bson:"reportedUserName,omitempty"`
+}
+
+// ReportShareInvite сохраняет жалобу приглашенного пользователя на приглашение и отклоняет его.
+// При block = true пригласивший также попадает в список заблокированных.
+func ReportShareInvite(inviteId, reporterId primitive.ObjectID, reason string, block bool) (bool, error) {
+	var invite ShareInvite
+	err := config.ShareInvites.FindOne(context.TODO(), bson.M{"_id": inviteId, "inviteeId": reporterId}).Decode(&invite)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	// На одно приглашение сохраняется не более одной жалобы.
+	report := InviteReport{
+		InviteId:       invite.ID,
+		ListId:         invite.ListId,
+		ReporterId:     reporterId,
+		ReportedUserId: invite.InviterId,
+		Reason:         reason,
+		Status:         ReportStatusOpen,
+		CreatedAt:      time.Now(),
+	}
+	_, err = config.InviteReports.UpdateOne(context.TODO(),
+		bson.M{"inviteId": invite.ID, "reporterId": reporterId},
+		bson.M{"$setOnInsert": report},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return false, err
+	}
+
+	if invite.Status == InviteStatusPending {
+		if _, err := RespondToShareInvite(invite.ID, reporterId, false); err != nil {
+			return false, err
+		}
+	}
+
+	if block {
+		if err := BlockUser(reporterId, invite.InviterId); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// AllInviteReports возвращает жалобы для проверки администратором. Пустой статус означает любой статус.
+func AllInviteReports(status string) (*[]InviteReport, error) {
+	match := bson.M{}
+	if status != "" {
+		match["status"] = status
+	}
+
+	pipeline := mongo.Pipeline{
+		{
+			{Key: "$match", Value: match},
+		},
+		{
+			{Key: "$sort", Value: bson.M{"createdAt": -1}},
+		},
+		{
+			{Key: "$lookup", Value: bson.M{
+				"from":         "users",
+				"localField":   "reporterId",
+				"foreignField": "_id",
+				"as":           "reporter",
+			}},
+		},
+		{
+			{Key: "$lookup", Value: bson.M{
+				"from":         "users",
+				"localField":   "reportedUserId",
+				"foreignField": "_id",
+				"as":           "reported",
+			}},
+		},
+		{
+			{Key: "$addFields", Value: bson.M{
+				"reporterName":     bson.M{"$arrayElemAt": []interface{}{"$reporter.name", 0}},
+				"reportedUserName": bson.M{"$arrayElemAt": []interface{}{"$reported.name", 0}},
+			}},
+		},
+		{
+			{Key: "$project", Value: bson.M{"reporter": 0, "reported": 0}},
+		},
+	}
+
+	result := make([]InviteReport, 0)
+	cursor, err := config.InviteReports.Aggregate(context.Background(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	if err = cursor.All(context.Background(), &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// ReviewInviteReport закрывает жалобу с решением администратора.
+func ReviewInviteReport(reportId, adminId primitive.ObjectID, status, note string) (bool, error) {
+	result, err := config.InviteReports.UpdateOne(context.TODO(),
+		bson.M{"_id": reportId},
+		bson.M{"$set": bson.M{
+			"status":     status,
+			"reviewedBy": adminId,
+			"reviewedAt": time.Now(),
+			"reviewNote": note,
+		}})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
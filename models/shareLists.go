@@ -2,11 +2,68 @@ package models
 
 import (
 	"context"
+	"errors"
+	"time"
+
+	"github.com/abel-03/go-todo/config"
+	"github.com/abel-03/go-todo/events"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Статусы приглашений к спискам покупок.
+const (
+	InviteStatusPending   = "pending"
+	InviteStatusAccepted  = "accepted"
+	InviteStatusDeclined  = "declined"
+	InviteStatusCancelled = "cancelled"
+	InviteStatusExpired   = "expired"
+)
+
+// Роли, которые получает пользователь при принятии приглашения.
+const (
+	ShareRoleEditor  = "editor"
+	ShareRoleCoOwner = "co-owner"
 )
 
+// Ошибки, возвращаемые при превышении ограничений на отправку приглашений.
+var (
+	ErrInviteRateLimited     = errors.New("too many share invites sent recently")
+	ErrTooManyPendingInvites = errors.New("invitee has too many pending invites")
+)
+
+// ShareInvite представляет приглашение пользователя к списку покупок.
+type ShareInvite struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	ListId      primitive.ObjectID `json:"listId" bson:"listId"`
+	InviterId   primitive.ObjectID `json:"inviterId" bson:"inviterId"`
+	InviteeId   primitive.ObjectID `json:"inviteeId" bson:"inviteeId"`
+	Role        string             `json:"role" bson:"role"`
+	Status      string             `json:"status" bson:"status"`
+	CreatedAt   time.Time          `json:"createdAt" bson:"createdAt"`
+	RespondedAt *time.Time         `json:"respondedAt" bson:"respondedAt"`
+	ExpiresAt   time.Time          `json:"expiresAt" bson:"expiresAt"`
+	ListName    string             `json:"listName,omitempty" bson:"listName,omitempty"`
+	InviterName string             `json:"inviterName,omitempty" bson:"inviterName,omitempty"`
+	InviteeName string             `json:"inviteeName,omitempty" bson:"inviteeName,omitempty"`
+}
+
+// IsValidShareRole проверяет, что роль может быть указана в приглашении.
+func IsValidShareRole(role string) bool {
+	return role == ShareRoleEditor || role == ShareRoleCoOwner
+}
+
+// IsValidInviteStatus проверяет, что статус приглашения существует.
+func IsValidInviteStatus(status string) bool {
+	switch status {
+	case InviteStatusPending, InviteStatusAccepted, InviteStatusDeclined, InviteStatusCancelled, InviteStatusExpired:
+		return true
+	}
+	return false
+}
+
 // GetUserIdByName возвращает идентификатор пользователя по его имени.
 func GetUserIdByName(userName string) (primitive.ObjectID, error) {
 	// Ищем пользователя в базе данных по имени.
@@ -19,44 +76,125 @@ func GetUserIdByName(userName string) (primitive.ObjectID, error) {
 	return u.ID, nil
 }
 
+// ExpireShareInvites помечает просроченными ожидающие приглашения, срок действия которых истек к моменту now.
+// Выполняется планировщиком; до его следующего запуска просроченные приглашения скрываются при чтении
+// условием pendingInvite.
+func ExpireShareInvites(ctx context.Context, now time.Time) error {
+	_, err := config.ShareInvites.UpdateMany(ctx,
+		bson.M{"status": InviteStatusPending, "expiresAt": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"status": InviteStatusExpired}})
+	return err
+}
+
+// pendingInvite дополняет фильтр условием, что приглашение ожидает ответа и его срок действия не истек на момент now.
+func pendingInvite(filter bson.M, now time.Time) bson.M {
+	filter["status"] = InviteStatusPending
+	filter["expiresAt"] = bson.M{"$gt": now}
+	return filter
+}
+
 // AllShareInviteShoppingLists возвращает все списки покупок, к которым пользователь приглашен.
 func AllShareInviteShoppingLists(userId primitive.ObjectID) (*[]ShoppingList, error) {
+	// Находим списки, по которым у пользователя есть ожидающие приглашения.
+	listIds, err := config.ShareInvites.Distinct(context.TODO(), "listId",
+		pendingInvite(bson.M{"inviteeId": userId}, time.Now()))
+	if err != nil {
+		return nil, err
+	}
+
 	// Формируем конвейер для агрегации данных в MongoDB.
+	pipeline := append(mongo.Pipeline{
+		{
+			{Key: "$match", Value: bson.M{"_id": bson.M{"$in": listIds}}},
+		},
+	}, listUserLookupStages()...)
+
+	// Выполняем агрегацию данных в MongoDB.
+	var result []ShoppingList
+	cursor, err := config.ShoppingLists.Aggregate(context.Background(), pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	if err = cursor.All(context.Background(), &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// allShareInvites возвращает приглашения по фильтру вместе с названием списка и именами пользователей.
+// Ожидающие приглашения с истекшим сроком, которые планировщик еще не пометил, считаются просроченными.
+func allShareInvites(match bson.M) (*[]ShareInvite, error) {
+	now := time.Now()
+	switch match["status"] {
+	case InviteStatusPending:
+		match = pendingInvite(match, now)
+	case InviteStatusExpired:
+		delete(match, "status")
+		match["$or"] = bson.A{
+			bson.M{"status": InviteStatusExpired},
+			bson.M{"status": InviteStatusPending, "expiresAt": bson.M{"$lte": now}},
+		}
+	}
+
 	pipeline := mongo.Pipeline{
 		{
-			{Key: "$match", Value: bson.M{"sharingInviteIds": userId}},
+			{Key: "$match", Value: match},
+		},
+		{
+			{Key: "$addFields", Value: bson.M{
+				"status": bson.M{"$cond": bson.A{
+					bson.M{"$and": bson.A{
+						bson.M{"$eq": bson.A{"$status", InviteStatusPending}},
+						bson.M{"$lte": bson.A{"$expiresAt", now}},
+					}},
+					InviteStatusExpired,
+					"$status",
+				}},
+			}},
+		},
+		{
+			{Key: "$sort", Value: bson.M{"createdAt": -1}},
 		},
 		{
 			{Key: "$lookup", Value: bson.M{
-				"from":         "users",
-				"localField":   "ownerId",
+				"from":         "shoppingLists",
+				"localField":   "listId",
 				"foreignField": "_id",
-				"as":           "owner",
+				"as":           "list",
 			}},
 		},
 		{
-			{Key: "$addFields", Value: bson.M{
-				"ownerName": bson.M{"$arrayElemAt": []interface{}{"$owner.name", 0}},
+			{Key: "$lookup", Value: bson.M{
+				"from":         "users",
+				"localField":   "inviterId",
+				"foreignField": "_id",
+				"as":           "inviter",
 			}},
 		},
 		{
 			{Key: "$lookup", Value: bson.M{
 				"from":         "users",
-				"localField":   "sharingIds",
+				"localField":   "inviteeId",
 				"foreignField": "_id",
-				"as":           "sharings",
+				"as":           "invitee",
 			}},
 		},
 		{
 			{Key: "$addFields", Value: bson.M{
-				"sharingNames": "$sharings.name",
+				"listName":    bson.M{"$arrayElemAt": []interface{}{"$list.name", 0}},
+				"inviterName": bson.M{"$arrayElemAt": []interface{}{displayNames("$inviter"), 0}},
+				"inviteeName": bson.M{"$arrayElemAt": []interface{}{displayNames("$invitee"), 0}},
 			}},
 		},
+		{
+			{Key: "$project", Value: bson.M{"list": 0, "inviter": 0, "invitee": 0}},
+		},
 	}
 
-	// Выполняем агрегацию данных в MongoDB.
-	var result []ShoppingList
-	cursor, err := config.ShoppingLists.Aggregate(context.Background(), pipeline)
+	result := make([]ShareInvite, 0)
+	cursor, err := config.ShareInvites.Aggregate(context.Background(), pipeline)
 	if err != nil {
 		return nil, err
 	}
@@ -68,63 +206,277 @@ func AllShareInviteShoppingLists(userId primitive.ObjectID) (*[]ShoppingList, er
 	return &result, nil
 }
 
-// ShareListWithUser делится списком покупок с пользователем.
-func ShareListWithUser(listId, userId primitive.ObjectID) (bool, error) {
-	// Формируем фильтр и обновление для добавления пользователя в список покупок и удаления приглашения.
-	filter := bson.M{"_id": listId, "sharingInviteIds": userId}
-	update := bson.M{
-		"$addToSet": bson.M{
-			"sharingIds": userId,
-		},
-		"$pull": bson.M{
-			"sharingInviteIds": userId,
-		},
+// ShareInvitesInbox возвращает приглашения, полученные пользователем. Пустой статус означает любой статус.
+func ShareInvitesInbox(userId primitive.ObjectID, status string) (*[]ShareInvite, error) {
+	match := bson.M{"inviteeId": userId}
+	if status != "" {
+		match["status"] = status
+	}
+	return allShareInvites(match)
+}
+
+// ShareInvitesOutbox возвращает приглашения, отправленные пользователем. Пустой статус означает любой статус.
+func ShareInvitesOutbox(userId primitive.ObjectID, status string) (*[]ShareInvite, error) {
+	match := bson.M{"inviterId": userId}
+	if status != "" {
+		match["status"] = status
+	}
+	return allShareInvites(match)
+}
+
+// respondToShareInvite переводит ожидающее приглашение в новый статус и при принятии открывает доступ к списку.
+func respondToShareInvite(filter bson.M, accept bool) (bool, error) {
+	status := InviteStatusDeclined
+	if accept {
+		status = InviteStatusAccepted
+	}
+
+	// Атомарно меняем статус, чтобы на одно приглашение нельзя было ответить дважды.
+	// Ответить на приглашение с истекшим сроком нельзя.
+	now := time.Now()
+	var invite ShareInvite
+	err := config.ShareInvites.FindOneAndUpdate(context.TODO(), pendingInvite(filter, now),
+		bson.M{"$set": bson.M{"status": status, "respondedAt": now}}).Decode(&invite)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	} else if err != nil {
+		return false, err
 	}
-	// Выполняем обновление в MongoDB.
-	result, err := config.ShoppingLists.UpdateOne(context.TODO(), filter, update)
+
+	if !accept {
+		return true, nil
+	}
+
+	seq, err := NextChangeSeq()
 	if err != nil {
 		return false, err
 	}
-	if result.ModifiedCount == 0 {
+	defer releaseChangeSeq(seq)
+
+	// Формируем обновление для добавления пользователя в список покупок.
+	addToSet := bson.M{"sharingIds": invite.InviteeId}
+	if invite.Role == ShareRoleCoOwner {
+		addToSet["coOwnerIds"] = invite.InviteeId
+	}
+	var l ShoppingList
+	err = config.ShoppingLists.FindOneAndUpdate(context.TODO(),
+		bson.M{"_id": invite.ListId},
+		bson.M{"$addToSet": addToSet, "$max": bson.M{"seq": seq}, "$inc": bson.M{"version": 1}},
+		options.FindOneAndUpdate().
+			SetProjection(listMembersProjection).
+			SetReturnDocument(options.After)).Decode(&l)
+	if err == mongo.ErrNoDocuments {
 		return false, nil
+	} else if err != nil {
+		return false, err
 	}
+
+	publishListEvent(l, events.ListShared, invite.InviteeId, nil, bson.M{"userId": invite.InviteeId, "role": invite.Role})
 	return true, nil
 }
 
+// ShareListWithUser принимает приглашение пользователя к списку покупок.
+func ShareListWithUser(listId, userId primitive.ObjectID) (bool, error) {
+	return respondToShareInvite(bson.M{"listId": listId, "inviteeId": userId}, true)
+}
+
 // DeclineShareListWithUser отклоняет приглашение пользователя к списку покупок.
 func DeclineShareListWithUser(listId, userId primitive.ObjectID) (bool, error) {
-	// Формируем фильтр и обновление для удаления приглашения пользователя к списку покупок.
-	filter := bson.M{"_id": listId, "sharingInviteIds": userId}
-	update := bson.M{
-		"$pull": bson.M{
-			"sharingInviteIds": userId,
+	return respondToShareInvite(bson.M{"listId": listId, "inviteeId": userId}, false)
+}
+
+// RespondToShareInvite принимает или отклоняет приглашение по его идентификатору.
+func RespondToShareInvite(inviteId, userId primitive.ObjectID, accept bool) (bool, error) {
+	return respondToShareInvite(bson.M{"_id": inviteId, "inviteeId": userId}, accept)
+}
+
+// LeaveSharedList закрывает пользователю доступ к списку, который был ему открыт.
+// Владелец списка покинуть его не может: для этого список нужно удалить.
+func LeaveSharedList(listId, userId primitive.ObjectID) (bool, error) {
+	return removeListMember(bson.M{"_id": listId, "sharingIds": userId}, userId, userId)
+}
+
+// RemoveListMember закрывает доступ к списку пользователю userId, которому он был открыт.
+// Владелец может исключить любого участника, совладелец - только участников без роли совладельца.
+func RemoveListMember(listId, actorId, userId primitive.ObjectID) (bool, error) {
+	return removeListMember(bson.M{
+		"_id":        listId,
+		"sharingIds": userId,
+		"$or": bson.A{
+			bson.M{"ownerId": actorId},
+			bson.M{"$and": bson.A{
+				bson.M{"coOwnerIds": actorId},
+				bson.M{"coOwnerIds": bson.M{"$ne": userId}},
+			}},
 		},
-	}
-	// Выполняем обновление в MongoDB.
-	result, err := config.ShoppingLists.UpdateOne(context.TODO(), filter, update)
+	}, actorId, userId)
+}
+
+// removeListMember исключает пользователя userId из участников списка по фильтру и снимает его с элементов списка,
+// если доступа к списку у него больше нет (например, через группу). Событие получает и исключенный пользователь.
+func removeListMember(filter bson.M, actorId, userId primitive.ObjectID) (bool, error) {
+	seq, err := NextChangeSeq()
 	if err != nil {
 		return false, err
 	}
-	if result.ModifiedCount == 0 {
+	defer releaseChangeSeq(seq)
+
+	var l ShoppingList
+	err = config.ShoppingLists.FindOneAndUpdate(context.TODO(), filter,
+		bson.M{
+			"$pull": bson.M{"sharingIds": userId, "coOwnerIds": userId},
+			"$max":  bson.M{"seq": seq},
+			"$inc":  bson.M{"version": 1},
+		},
+		options.FindOneAndUpdate().
+			SetProjection(listMembersProjection).
+			SetReturnDocument(options.After)).Decode(&l)
+	if err == mongo.ErrNoDocuments {
 		return false, nil
+	} else if err != nil {
+		return false, err
 	}
+
+	access, err := listAccessFilter(userId)
+	if err != nil {
+		return false, err
+	}
+	lost := bson.M{"_id": l.ID, "$nor": access}
+	if err := revokeListsForUser(lost, userId); err != nil {
+		return false, err
+	}
+	if err := unassignUser(lost, userId); err != nil {
+		return false, err
+	}
+
+	members, err := listMembers(l)
+	if err != nil {
+		return false, err
+	}
+	events.Publish(events.Event{
+		Type:       events.ListUnshared,
+		ListId:     l.ID,
+		ActorId:    actorId,
+		Data:       bson.M{"userId": userId},
+		Recipients: append(members, userId),
+	})
 	return true, nil
 }
 
+// CancelShareInvite отзывает ожидающее приглашение. Отозвать приглашение может только пригласивший.
+func CancelShareInvite(inviteId, inviterId primitive.ObjectID) (bool, error) {
+	now := time.Now()
+	result, err := config.ShareInvites.UpdateOne(context.TODO(),
+		pendingInvite(bson.M{"_id": inviteId, "inviterId": inviterId}, now),
+		bson.M{"$set": bson.M{"status": InviteStatusCancelled, "respondedAt": now}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
 // AddShareInvite добавляет приглашение пользователя к списку покупок.
-func AddShareInvite(ownerId, listId, userId primitive.ObjectID) (bool, error) {
-	// Формируем обновление для добавления пользователя в список покупок.
-	update := bson.M{
-		"$addToSet": bson.M{
-			"sharingInviteIds": userId,
+// Приглашать могут владелец и совладельцы списка; повторное приглашение при наличии ожидающего не создается.
+// Приглашения от заблокированных пользователей молча отбрасываются, чтобы не раскрывать факт блокировки.
+func AddShareInvite(ownerId, listId, userId primitive.ObjectID, role string) (bool, error) {
+	blocked, err := IsBlockedBy(ownerId, userId)
+	if err != nil {
+		return false, err
+	} else if blocked {
+		return true, nil
+	}
+
+	// Проверяем ограничение частоты приглашений для приглашающего.
+	now := time.Now()
+	sent, err := config.ShareInvites.CountDocuments(context.TODO(), bson.M{
+		"inviterId": ownerId,
+		"createdAt": bson.M{"$gte": now.Add(-config.ShareInviteRateWindow)},
+	})
+	if err != nil {
+		return false, err
+	} else if sent >= int64(config.ShareInviteRateLimit) {
+		return false, ErrInviteRateLimited
+	}
+
+	// Проверяем ограничение числа ожидающих приглашений у приглашенного.
+	pending, err := config.ShareInvites.CountDocuments(context.TODO(), pendingInvite(bson.M{"inviteeId": userId}, now))
+	if err != nil {
+		return false, err
+	} else if pending >= int64(config.MaxPendingInvitesPerUser) {
+		return false, ErrTooManyPendingInvites
+	}
+
+	return insertShareInvite(ownerId, listId, userId, role, now)
+}
+
+// insertShareInvite создает ожидающее приглашение без проверки ограничений частоты.
+func insertShareInvite(ownerId, listId, userId primitive.ObjectID, role string, now time.Time) (bool, error) {
+	// Проверяем права приглашающего и то, что пользователь еще не имеет доступа к списку.
+	count, err := config.ShoppingLists.CountDocuments(context.TODO(), bson.M{
+		"_id": listId,
+		"$or": bson.A{
+			bson.M{"ownerId": ownerId},
+			bson.M{"coOwnerIds": ownerId},
 		},
+		"ownerId":    bson.M{"$ne": userId},
+		"sharingIds": bson.M{"$ne": userId},
+	})
+	if err != nil || count == 0 {
+		return false, err
 	}
-	// Выполняем обновление в MongoDB.
-	result, err := config.ShoppingLists.UpdateOne(context.TODO(), bson.M{"ownerId": ownerId, "_id": listId}, update)
 
-	if err != nil || result.ModifiedCount != 1 {
+	invite := ShareInvite{
+		ListId:    listId,
+		InviterId: ownerId,
+		InviteeId: userId,
+		Role:      role,
+		Status:    InviteStatusPending,
+		CreatedAt: now,
+		ExpiresAt: now.Add(config.ShareInviteTTL),
+	}
+
+	// Создаем приглашение, только если ожидающего приглашения к этому списку еще нет.
+	// Приглашение с истекшим сроком, которое планировщик еще не пометил, не мешает пригласить снова.
+	result, err := config.ShareInvites.UpdateOne(context.TODO(),
+		pendingInvite(bson.M{"listId": listId, "inviteeId": userId}, now),
+		bson.M{"$setOnInsert": invite},
+		options.Update().SetUpsert(true))
+	if err != nil {
 		return false, err
 	}
-	return true, nil
+	return result.UpsertedCount == 1, nil
 }
 
+// MigrateSharingInviteIds переносит приглашения из устаревшего массива sharingInviteIds в коллекцию приглашений.
+func MigrateSharingInviteIds() error {
+	cursor, err := config.ShoppingLists.Find(context.TODO(),
+		bson.M{"sharingInviteIds.0": bson.M{"$exists": true}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(context.Background()) {
+		var l struct {
+			ID               primitive.ObjectID   `bson:"_id"`
+			OwnerId          primitive.ObjectID   `bson:"ownerId"`
+			SharingInviteIds []primitive.ObjectID `bson:"sharingInviteIds"`
+		}
+		if err := cursor.Decode(&l); err != nil {
+			return err
+		}
+		for _, userId := range l.SharingInviteIds {
+			if _, err := insertShareInvite(l.OwnerId, l.ID, userId, ShareRoleEditor, time.Now()); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	_, err = config.ShoppingLists.UpdateMany(context.TODO(),
+		bson.M{"sharingInviteIds": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"sharingInviteIds": ""}})
+	return err
+}
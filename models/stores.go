@@ -0,0 +1,160 @@
+package models
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/abel-03/go-todo/catalog"
+	"github.com/abel-03/go-todo/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Store представляет магазин пользователя с порядком, в котором в нем расположены отделы.
+// CategoryOrder перечисляет категории товаров в порядке обхода магазина.
+type Store struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	OwnerId       primitive.ObjectID `json:"ownerId" bson:"ownerId"`
+	Name          string             `json:"name" bson:"name"`
+	CategoryOrder []string           `json:"categoryOrder" bson:"categoryOrder"`
+	CreatedAt     time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// StoreUpdate описывает изменение магазина. Поля, равные nil, не изменяются.
+type StoreUpdate struct {
+	Name          *string  `json:"name"`
+	CategoryOrder []string `json:"categoryOrder"`
+}
+
+// ListSection представляет элементы списка одной категории.
+type ListSection struct {
+	Category string     `json:"category"`
+	Items    []ListItem `json:"items"`
+}
+
+// AllStores возвращает магазины пользователя.
+func AllStores(userId primitive.ObjectID) (*[]Store, error) {
+	result := make([]Store, 0)
+	cursor, err := config.Stores.Find(context.TODO(), bson.M{"ownerId": userId})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	if err = cursor.All(context.Background(), &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetStore возвращает магазин пользователя или nil, если он не найден.
+func GetStore(storeId, userId primitive.ObjectID) (*Store, error) {
+	var s Store
+	err := config.Stores.FindOne(context.TODO(), bson.M{"_id": storeId, "ownerId": userId}).Decode(&s)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// AddStore добавляет магазин пользователя и возвращает его идентификатор.
+func AddStore(name string, ownerId primitive.ObjectID, categoryOrder []string) (string, error) {
+	s := Store{
+		ID:            primitive.NewObjectID(),
+		OwnerId:       ownerId,
+		Name:          name,
+		CategoryOrder: categoryOrder,
+		CreatedAt:     time.Now(),
+	}
+	if s.CategoryOrder == nil {
+		s.CategoryOrder = make([]string, 0)
+	}
+
+	_, err := config.Stores.InsertOne(context.TODO(), s)
+	if err != nil {
+		return "", err
+	}
+	return s.ID.Hex(), nil
+}
+
+// UpdateStore изменяет название магазина и порядок его отделов.
+func UpdateStore(storeId, userId primitive.ObjectID, u StoreUpdate) (bool, error) {
+	set := bson.M{}
+	if u.Name != nil {
+		set["name"] = *u.Name
+	}
+	if u.CategoryOrder != nil {
+		set["categoryOrder"] = u.CategoryOrder
+	}
+	if len(set) == 0 {
+		s, err := GetStore(storeId, userId)
+		return s != nil, err
+	}
+
+	result, err := config.Stores.UpdateOne(context.TODO(), bson.M{"_id": storeId, "ownerId": userId}, bson.M{"$set": set})
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+// RemoveStore удаляет магазин пользователя.
+func RemoveStore(storeId, userId primitive.ObjectID) (bool, error) {
+	result, err := config.Stores.DeleteOne(context.TODO(), bson.M{"_id": storeId, "ownerId": userId})
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount == 1, nil
+}
+
+// Sections группирует элементы по категориям в порядке обхода магазина.
+// Категории, которых нет в порядке магазина, идут следом в порядке встроенного словаря, затем по алфавиту;
+// элементы без категории - в самом конце. Внутри раздела некупленные элементы идут раньше купленных.
+func (s Store) Sections(items []ListItem) []ListSection {
+	rank := make(map[string]int, len(s.CategoryOrder)+len(catalog.Categories))
+	for i, c := range s.CategoryOrder {
+		if _, ok := rank[c]; !ok {
+			rank[c] = i
+		}
+	}
+	for i, c := range catalog.Categories {
+		if _, ok := rank[c]; !ok {
+			rank[c] = len(s.CategoryOrder) + i
+		}
+	}
+	unranked := len(s.CategoryOrder) + len(catalog.Categories)
+
+	byCategory := make(map[string][]ListItem)
+	for _, li := range items {
+		byCategory[li.Category] = append(byCategory[li.Category], li)
+	}
+
+	sections := make([]ListSection, 0, len(byCategory))
+	for c, its := range byCategory {
+		sort.SliceStable(its, func(i, j int) bool {
+			return !its[i].IsCompleted && its[j].IsCompleted
+		})
+		sections = append(sections, ListSection{Category: c, Items: its})
+	}
+
+	position := func(c string) int {
+		if c == "" {
+			return unranked + 1
+		} else if r, ok := rank[c]; ok {
+			return r
+		}
+		return unranked
+	}
+	sort.Slice(sections, func(i, j int) bool {
+		pi, pj := position(sections[i].Category), position(sections[j].Category)
+		if pi != pj {
+			return pi < pj
+		}
+		return sections[i].Category < sections[j].Category
+	})
+	return sections
+}
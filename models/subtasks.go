@@ -0,0 +1,233 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/abel-03/go-todo/config"
+	"github.com/abel-03/go-todo/events"
+	"github.com/abel-03/go-todo/hlc"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MaxItemDepth - наибольшая глубина вложенности элементов: элемент верхнего уровня имеет глубину 1.
+const MaxItemDepth = 3
+
+// ErrInvalidParent возвращается, если родительский элемент не найден в списке или вложенность превысила бы MaxItemDepth.
+var ErrInvalidParent = errors.New("invalid parent item")
+
+// ErrSubtreeMove возвращается при попытке перенести в другой список элемент, у которого есть родитель или дети.
+var ErrSubtreeMove = errors.New("items with subtasks cannot be moved between lists")
+
+// itemIndex упрощает обход дерева элементов, хранящихся в списке плоским массивом.
+type itemIndex struct {
+	items    map[primitive.ObjectID]ListItem
+	children map[primitive.ObjectID][]primitive.ObjectID
+}
+
+// newItemIndex строит индекс элементов списка. Элемент, родителя которого нет в списке, считается элементом верхнего уровня.
+func newItemIndex(items []ListItem) itemIndex {
+	idx := itemIndex{
+		items:    make(map[primitive.ObjectID]ListItem, len(items)),
+		children: make(map[primitive.ObjectID][]primitive.ObjectID),
+	}
+	for _, li := range items {
+		idx.items[li.ID] = li
+	}
+	for _, li := range items {
+		if p := idx.parent(li); p != nil {
+			idx.children[*p] = append(idx.children[*p], li.ID)
+		}
+	}
+	return idx
+}
+
+// parent возвращает идентификатор родителя элемента или nil для элемента верхнего уровня.
+func (idx itemIndex) parent(li ListItem) *primitive.ObjectID {
+	if li.ParentId == nil {
+		return nil
+	} else if _, ok := idx.items[*li.ParentId]; !ok {
+		return nil
+	}
+	return li.ParentId
+}
+
+// depth возвращает глубину элемента id.
+func (idx itemIndex) depth(id primitive.ObjectID) int {
+	d := 1
+	for li := idx.items[id]; d <= MaxItemDepth; d++ {
+		p := idx.parent(li)
+		if p == nil {
+			break
+		}
+		li = idx.items[*p]
+	}
+	return d
+}
+
+// descendants возвращает идентификаторы всех потомков элемента id.
+func (idx itemIndex) descendants(id primitive.ObjectID) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0)
+	queue := append([]primitive.ObjectID(nil), idx.children[id]...)
+	for len(queue) > 0 {
+		ids = append(ids, queue[0])
+		queue = append(queue[1:], idx.children[queue[0]]...)
+	}
+	return ids
+}
+
+// subtree возвращает идентификаторы элементов поддерева с корнем в каждом из ids без повторов.
+func (idx itemIndex) subtree(ids []primitive.ObjectID) []primitive.ObjectID {
+	seen := make(map[primitive.ObjectID]bool)
+	res := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		for _, d := range append([]primitive.ObjectID{id}, idx.descendants(id)...) {
+			if !seen[d] {
+				seen[d] = true
+				res = append(res, d)
+			}
+		}
+	}
+	return res
+}
+
+// itemTree возвращает элементы верхнего уровня с вложенными детьми. Элементы каждого уровня упорядочены по позиции:
+// дети собираются в порядке отсортированного массива.
+func itemTree(items []ListItem) []ListItem {
+	sorted := append([]ListItem(nil), items...)
+	sortItems(sorted)
+	idx := newItemIndex(sorted)
+
+	var build func(li ListItem) ListItem
+	build = func(li ListItem) ListItem {
+		li.Children = nil
+		for _, id := range idx.children[li.ID] {
+			li.Children = append(li.Children, build(idx.items[id]))
+		}
+		return li
+	}
+
+	roots := make([]ListItem, 0)
+	for _, li := range sorted {
+		if idx.parent(li) == nil {
+			roots = append(roots, build(li))
+		}
+	}
+	return roots
+}
+
+// withItemTree заменяет элементы списков деревом элементов (см. itemTree).
+func withItemTree(lists *[]ShoppingList) {
+	for i := range *lists {
+		(*lists)[i].Items = itemTree((*lists)[i].Items)
+	}
+}
+
+// completionChanges вычисляет, как меняются отметки элементов items после изменения отметки с меткой ts.
+// Если cascade, отметка элемента id переносится на всех его потомков, а затем выводятся отметки его предков;
+// иначе отметка выводится начиная с самого элемента id. Родитель считается завершенным, если завершены все его дети.
+// Отметка, измененная позже метки ts, не меняется. Возвращает элементы с новыми отметками.
+func completionChanges(items []ListItem, id primitive.ObjectID, ts hlc.Timestamp, cascade bool) []ListItem {
+	idx := newItemIndex(items)
+	state := make(map[primitive.ObjectID]bool, len(items))
+	for _, li := range items {
+		state[li.ID] = li.IsCompleted
+	}
+	changed := make([]primitive.ObjectID, 0)
+	set := func(li ListItem, completed bool) bool {
+		if state[li.ID] == completed || li.Clock.IsCompleted > ts {
+			return false
+		}
+		state[li.ID] = completed
+		changed = append(changed, li.ID)
+		return true
+	}
+
+	from := &id
+	if item, ok := idx.items[id]; ok && cascade {
+		// Каскад применяется, только если отметка самого элемента изменена этой правкой.
+		if item.Clock.IsCompleted == ts {
+			for _, d := range idx.descendants(id) {
+				set(idx.items[d], item.IsCompleted)
+			}
+		}
+		from = idx.parent(item)
+	}
+
+	for from != nil {
+		parent, ok := idx.items[*from]
+		children := idx.children[*from]
+		if !ok || len(children) == 0 {
+			break
+		}
+		completed := true
+		for _, c := range children {
+			completed = completed && state[c]
+		}
+		if !set(parent, completed) {
+			break
+		}
+		from = idx.parent(parent)
+	}
+
+	res := make([]ListItem, 0, len(changed))
+	for _, c := range changed {
+		li := idx.items[c]
+		li.IsCompleted, li.Clock.IsCompleted = state[c], ts
+		res = append(res, li)
+	}
+	return res
+}
+
+// syncCompletion согласует отметки поддерева в списке listId после изменения отметки с меткой ts
+// (см. completionChanges) и публикует изменения элементов от имени userId.
+// Изменения записываются, только если список не изменился после чтения, иначе вычисляются заново.
+func syncCompletion(listId, id, userId primitive.ObjectID, ts hlc.Timestamp, cascade bool) error {
+	for attempt := 0; attempt < maxPositionAttempts; attempt++ {
+		l, err := findListItems(bson.M{"_id": listId})
+		if err != nil || l == nil {
+			return err
+		}
+		changes := completionChanges(l.Items, id, ts, cascade)
+		if len(changes) == 0 {
+			return nil
+		}
+
+		seq, err := NextChangeSeq()
+		if err != nil {
+			return err
+		}
+		set, inc := bson.M{}, bson.M{"version": 1}
+		filters := make([]interface{}, 0, len(changes))
+		for i, li := range changes {
+			name := "i" + strconv.Itoa(i)
+			prefix := "items.$[" + name + "]."
+			set[prefix+"isCompleted"] = li.IsCompleted
+			set[prefix+"clock.isCompleted"] = ts
+			set[prefix+"seq"] = seq
+			inc[prefix+"version"] = 1
+			filters = append(filters, bson.M{name + "._id": li.ID})
+		}
+		res, err := config.ShoppingLists.UpdateOne(context.TODO(),
+			bson.M{"_id": listId, "version": l.Version},
+			bson.M{"$set": set, "$max": bson.M{"seq": seq}, "$inc": inc},
+			options.Update().SetArrayFilters(options.ArrayFilters{Filters: filters}))
+		releaseChangeSeq(seq)
+		if err != nil {
+			return err
+		} else if res.MatchedCount == 0 {
+			continue
+		}
+
+		for _, li := range changes {
+			li.Seq = seq
+			li.Version++
+			publishListEvent(*l, events.ItemModified, userId, &li.ID, li)
+		}
+		return nil
+	}
+	return ErrPositionConflict
+}
@@ -0,0 +1,130 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/abel-03/go-todo/catalog"
+	"github.com/abel-03/go-todo/config"
+	"github.com/abel-03/go-todo/suggest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxSuggestionPurchases - сколько последних покупок учитывается в подсказках товаров.
+const maxSuggestionPurchases = 5000
+
+// purchasedProducts возвращает товары, купленные за период config.SuggestionHistory в доступных пользователю списках
+// и в удаленных списках, к которым он имел доступ, а также нормализованные названия незавершенных элементов
+// доступных списков. Если задан listId, учитывается только этот список. Если список не найден или недоступен
+// пользователю, возвращается nil.
+func purchasedProducts(userId primitive.ObjectID, listId *primitive.ObjectID, now time.Time) ([]suggest.Product, map[string]bool, error) {
+	access, err := listAccessFilter(userId)
+	if err != nil {
+		return nil, nil, err
+	}
+	match := bson.M{"$or": access}
+	if listId != nil {
+		match["_id"] = *listId
+	}
+	cursor, err := config.ShoppingLists.Find(context.TODO(), match, options.Find().SetProjection(bson.M{
+		"items.name":        1,
+		"items.isCompleted": 1,
+	}))
+	if err != nil {
+		return nil, nil, err
+	}
+	var lists []ShoppingList
+	if err := cursor.All(context.TODO(), &lists); err != nil {
+		return nil, nil, err
+	}
+	if listId != nil && len(lists) == 0 {
+		return nil, nil, nil
+	}
+
+	listIds := make([]primitive.ObjectID, 0, len(lists))
+	pending := make(map[string]bool)
+	for _, l := range lists {
+		listIds = append(listIds, l.ID)
+		for _, li := range l.Items {
+			if !li.IsCompleted {
+				pending[catalog.NormalizeName(li.Name)] = true
+			}
+		}
+	}
+
+	// Без listId учитывается и история удаленных списков, к которым пользователь имел доступ.
+	match = bson.M{"listId": bson.M{"$in": listIds}}
+	if listId == nil {
+		match = historyMatch(userId, listIds)
+	}
+	match["time"] = bson.M{"$gte": now.Add(-config.SuggestionHistory)}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$sort", Value: bson.D{{Key: "time", Value: -1}}}},
+		{{Key: "$unwind", Value: "$items"}},
+		{{Key: "$limit", Value: maxSuggestionPurchases}},
+		{{Key: "$project", Value: bson.M{
+			"_id":      0,
+			"name":     "$items.name",
+			"category": "$items.category",
+			"quantity": "$items.quantity",
+			"unit":     "$items.unit",
+			"time":     1,
+		}}},
+	}
+	cursor, err = config.Checkouts.Aggregate(context.TODO(), pipeline)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cursor.Close(context.TODO())
+
+	var purchases []struct {
+		Name     string    `bson:"name"`
+		Category string    `bson:"category"`
+		Quantity float64   `bson:"quantity"`
+		Unit     string    `bson:"unit"`
+		Time     time.Time `bson:"time"`
+	}
+	if err = cursor.All(context.TODO(), &purchases); err != nil {
+		return nil, nil, err
+	}
+
+	ps := make([]suggest.Purchase, 0, len(purchases))
+	for _, p := range purchases {
+		ps = append(ps, suggest.Purchase{Name: p.Name, Category: p.Category, Quantity: p.Quantity, Unit: p.Unit, Time: p.Time})
+	}
+	return suggest.Aggregate(ps, catalog.NormalizeName, now, config.SuggestionHalfLife), pending, nil
+}
+
+// ItemSuggestions возвращает не больше limit товаров из истории покупок пользователя, подходящих к началу названия
+// query, от часто и недавно покупаемых к редким и давним. Если задан listId, подсказки строятся по истории этого
+// списка, иначе по всем доступным пользователю спискам. Если список не найден или недоступен, возвращается nil.
+func ItemSuggestions(userId primitive.ObjectID, listId *primitive.ObjectID, query string, limit int) ([]suggest.Product, error) {
+	products, _, err := purchasedProducts(userId, listId, time.Now())
+	if err != nil || products == nil {
+		return nil, err
+	}
+	return suggest.Complete(products, catalog.NormalizeName(query), limit), nil
+}
+
+// RestockSuggestions возвращает товары, которые пользователь обычно покупает с постоянным интервалом и которые
+// пора купить в течение within. Товары, уже добавленные в списки и не отмеченные, не предлагаются.
+// Если задан listId, учитывается только этот список. Если список не найден или недоступен, возвращается nil.
+func RestockSuggestions(userId primitive.ObjectID, listId *primitive.ObjectID, within time.Duration) ([]suggest.Restock, error) {
+	now := time.Now()
+	products, pending, err := purchasedProducts(userId, listId, now)
+	if err != nil || products == nil {
+		return nil, err
+	}
+
+	res := make([]suggest.Restock, 0)
+	for _, r := range suggest.Restocks(products, now, within) {
+		if !pending[r.Key] {
+			res = append(res, r)
+		}
+	}
+	return res, nil
+}
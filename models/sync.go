@@ -0,0 +1,279 @@
+package models
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/abel-03/go-todo/config"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Виды удаленных сущностей.
+const (
+	TombstoneList = "list"
+	TombstoneItem = "item"
+)
+
+// changeCounterId - идентификатор документа счетчика изменений в коллекции counters.
+const changeCounterId = "changes"
+
+// Tombstone представляет запись об удалении списка или элемента, нужную клиентам для синхронизации.
+// Для списков UserIds содержит пользователей, которые должны узнать об удалении.
+type Tombstone struct {
+	ID        primitive.ObjectID   `json:"-" bson:"_id,omitempty"`
+	Kind      string               `json:"kind" bson:"kind"`
+	EntityId  primitive.ObjectID   `json:"id" bson:"entityId"`
+	ListId    primitive.ObjectID   `json:"listId" bson:"listId"`
+	UserIds   []primitive.ObjectID `json:"-" bson:"userIds,omitempty"`
+	Seq       int64                `json:"seq" bson:"seq"`
+	CreatedAt time.Time            `json:"deletedAt" bson:"createdAt"`
+}
+
+// SyncItem представляет элемент вместе с идентификатором его списка.
+type SyncItem struct {
+	ListId primitive.ObjectID `json:"listId"`
+	Item   ListItem           `json:"item"`
+}
+
+// SyncListChanges содержит изменения списков с момента курсора.
+type SyncListChanges struct {
+	Created []ShoppingList `json:"created"`
+	Updated []ShoppingList `json:"updated"`
+	Deleted []Tombstone    `json:"deleted"`
+}
+
+// SyncItemChanges содержит изменения элементов с момента курсора.
+// Элементы новых списков сюда не попадают: они передаются вместе со списком.
+type SyncItemChanges struct {
+	Created []SyncItem  `json:"created"`
+	Updated []SyncItem  `json:"updated"`
+	Deleted []Tombstone `json:"deleted"`
+}
+
+// SyncChanges представляет ответ на запрос синхронизации.
+type SyncChanges struct {
+	Cursor int64           `json:"cursor"`
+	Lists  SyncListChanges `json:"lists"`
+	Items  SyncItemChanges `json:"items"`
+}
+
+// changeSeqLease - сколько номер изменения считается незаписанным, если выдавший его запрос не освободил номер,
+// например из-за падения сервера. Запись изменения должна укладываться в этот срок.
+const changeSeqLease = time.Minute
+
+// changeCounter представляет документ счетчика изменений. Pending содержит выданные номера, изменения с которыми
+// еще не записаны.
+type changeCounter struct {
+	Seq     int64 `bson:"seq"`
+	Pending []struct {
+		Seq       int64     `bson:"seq"`
+		ExpiresAt time.Time `bson:"expiresAt"`
+	} `bson:"pending"`
+}
+
+// NextChangeSeq возвращает следующий номер изменения. Номера монотонно возрастают для всех списков.
+// Номер считается незаписанным, пока вызывающий не освободит его releaseChangeSeq после записи изменения:
+// до этого курсор синхронизации не переходит через него (см. currentChangeSeq).
+func NextChangeSeq() (int64, error) {
+	now := time.Now()
+	var c changeCounter
+	err := config.Counters.FindOneAndUpdate(context.TODO(),
+		bson.M{"_id": changeCounterId},
+		mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{"seq": bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$seq", int64(0)}}, int64(1)}}}}},
+			{{Key: "$set", Value: bson.M{"pending": bson.M{"$concatArrays": bson.A{
+				bson.M{"$filter": bson.M{
+					"input": bson.M{"$ifNull": bson.A{"$pending", bson.A{}}},
+					"as":    "p",
+					"cond":  bson.M{"$gt": bson.A{"$$p.expiresAt", now}},
+				}},
+				bson.A{bson.M{"seq": "$seq", "expiresAt": now.Add(changeSeqLease)}},
+			}}}}},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&c)
+	return c.Seq, err
+}
+
+// releaseChangeSeq отмечает изменение с номером seq записанным. Ошибка только записывается в журнал:
+// неосвобожденный номер перестанет задерживать курсор по истечении changeSeqLease.
+func releaseChangeSeq(seq int64) {
+	_, err := config.Counters.UpdateOne(context.TODO(),
+		bson.M{"_id": changeCounterId},
+		bson.M{"$pull": bson.M{"pending": bson.M{"seq": seq}}})
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// currentChangeSeq возвращает номер, до которого включительно все изменения записаны: последний выданный номер
+// или номер перед наименьшим еще не записанным.
+func currentChangeSeq() (int64, error) {
+	var c changeCounter
+	err := config.Counters.FindOne(context.TODO(), bson.M{"_id": changeCounterId}).Decode(&c)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	cursor := c.Seq
+	for _, p := range c.Pending {
+		if p.ExpiresAt.After(now) && p.Seq <= cursor {
+			cursor = p.Seq - 1
+		}
+	}
+	return cursor, nil
+}
+
+// addTombstones сохраняет записи об удалении.
+func addTombstones(ts []Tombstone) error {
+	if len(ts) == 0 {
+		return nil
+	}
+
+	docs := make([]interface{}, len(ts))
+	now := time.Now()
+	for i := range ts {
+		ts[i].CreatedAt = now
+		docs[i] = ts[i]
+	}
+	_, err := config.Tombstones.InsertMany(context.TODO(), docs)
+	return err
+}
+
+// touchLists присваивает спискам по фильтру новый номер изменения, чтобы они попали в следующую синхронизацию.
+func touchLists(filter bson.M) error {
+	seq, err := NextChangeSeq()
+	if err != nil {
+		return err
+	}
+	defer releaseChangeSeq(seq)
+	_, err = config.ShoppingLists.UpdateMany(context.TODO(), filter,
+		bson.M{"$max": bson.M{"seq": seq}, "$inc": bson.M{"version": 1}})
+	return err
+}
+
+// revokeListsForUser сохраняет для пользователя записи об удалении списков по фильтру,
+// когда он теряет к ним доступ, хотя сами списки продолжают существовать.
+func revokeListsForUser(filter bson.M, userId primitive.ObjectID) error {
+	ids, err := config.ShoppingLists.Distinct(context.TODO(), "_id", filter)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+
+	seq, err := NextChangeSeq()
+	if err != nil {
+		return err
+	}
+	defer releaseChangeSeq(seq)
+	ts := make([]Tombstone, 0, len(ids))
+	for _, id := range ids {
+		listId := id.(primitive.ObjectID)
+		ts = append(ts, Tombstone{
+			Kind:     TombstoneList,
+			EntityId: listId,
+			ListId:   listId,
+			UserIds:  []primitive.ObjectID{userId},
+			Seq:      seq,
+		})
+	}
+	return addTombstones(ts)
+}
+
+// ChangesSince возвращает все изменения доступных пользователю списков и элементов с номером больше since.
+func ChangesSince(userId primitive.ObjectID, since int64) (*SyncChanges, error) {
+	// Курсор читается до выборки и не переходит через незаписанные изменения. Изменения с большими номерами,
+	// записанные к моменту выборки, придут повторно при следующей синхронизации.
+	cursor, err := currentChangeSeq()
+	if err != nil {
+		return nil, err
+	}
+
+	access, err := listAccessFilter(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := SyncChanges{
+		Cursor: cursor,
+		Lists: SyncListChanges{
+			Created: make([]ShoppingList, 0),
+			Updated: make([]ShoppingList, 0),
+			Deleted: make([]Tombstone, 0),
+		},
+		Items: SyncItemChanges{
+			Created: make([]SyncItem, 0),
+			Updated: make([]SyncItem, 0),
+			Deleted: make([]Tombstone, 0),
+		},
+	}
+
+	// Выбираем измененные списки.
+	lists, err := AllShoppingListsMatching(userId, bson.M{"$or": access, "seq": bson.M{"$gt": since}})
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range *lists {
+		if l.CreatedSeq > since {
+			changes.Lists.Created = append(changes.Lists.Created, l)
+			continue
+		}
+
+		for _, li := range l.Items {
+			if li.Seq <= since {
+				continue
+			}
+			if li.CreatedSeq > since {
+				changes.Items.Created = append(changes.Items.Created, SyncItem{ListId: l.ID, Item: li})
+			} else {
+				changes.Items.Updated = append(changes.Items.Updated, SyncItem{ListId: l.ID, Item: li})
+			}
+		}
+		l.Items = nil
+		changes.Lists.Updated = append(changes.Lists.Updated, l)
+	}
+
+	// Выбираем удаленные списки и списки, к которым пользователь потерял доступ.
+	listIds, err := config.ShoppingLists.Distinct(context.TODO(), "_id", bson.M{"$or": access})
+	if err != nil {
+		return nil, err
+	}
+	opts := options.Find().SetSort(bson.M{"seq": 1})
+	tc, err := config.Tombstones.Find(context.TODO(), bson.M{
+		"seq": bson.M{"$gt": since},
+		"$or": bson.A{
+			bson.M{"kind": TombstoneList, "userIds": userId},
+			bson.M{"kind": TombstoneItem, "listId": bson.M{"$in": listIds}},
+		},
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer tc.Close(context.Background())
+
+	accessible := make(map[primitive.ObjectID]bool, len(listIds))
+	for _, id := range listIds {
+		accessible[id.(primitive.ObjectID)] = true
+	}
+
+	for tc.Next(context.Background()) {
+		var t Tombstone
+		if err := tc.Decode(&t); err != nil {
+			return nil, err
+		}
+		if t.Kind == TombstoneList {
+			// Доступ к списку мог быть возвращен позже: такой список уже есть среди измененных.
+			if !accessible[t.EntityId] {
+				changes.Lists.Deleted = append(changes.Lists.Deleted, t)
+			}
+		} else {
+			changes.Items.Deleted = append(changes.Items.Deleted, t)
+		}
+	}
+	return &changes, tc.Err()
+}
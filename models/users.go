@@ -2,14 +2,51 @@ package models
 
 import (
 	"context"
+	"regexp"
+	"time"
+
+	"github.com/abel-03/go-todo/config"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // User представляет собой модель пользователя.
 type User struct {
-	ID       primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	Name     string             `json:"name"`
-	Password string             `json:"password"`
+	ID             primitive.ObjectID   `json:"id" bson:"_id,omitempty"`
+	Name           string               `json:"name"`
+	Password       string               `json:"password"`
+	DisplayName    string               `json:"displayName" bson:"displayName,omitempty"`
+	AvatarURL      string               `json:"avatarUrl" bson:"avatarUrl,omitempty"`
+	HideFromSearch bool                 `json:"hideFromSearch" bson:"hideFromSearch"`
+	BlockedIds     []primitive.ObjectID `json:"blockedIds" bson:"blockedIds,omitempty"`
+	IsAdmin        bool                 `json:"isAdmin" bson:"isAdmin,omitempty"`
+}
+
+// UserSummary представляет публичные сведения о пользователе, которые видны другим пользователям.
+type UserSummary struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id"`
+	Name        string             `json:"name" bson:"name"`
+	DisplayName string             `json:"displayName" bson:"displayName,omitempty"`
+	AvatarURL   string             `json:"avatarUrl" bson:"avatarUrl,omitempty"`
+}
+
+// UserProfile представляет настройки профиля, которые пользователь может изменить.
+type UserProfile struct {
+	DisplayName    *string `json:"displayName"`
+	AvatarURL      *string `json:"avatarUrl"`
+	HideFromSearch *bool   `json:"hideFromSearch"`
+}
+
+// displayNames возвращает выражение агрегации, которое превращает массив пользователей (путь к полю или выражение)
+// в массив отображаемых имен, используя имя для входа, если отображаемое имя не задано.
+func displayNames(users interface{}) bson.M {
+	return bson.M{"$map": bson.M{
+		"input": users,
+		"as":    "u",
+		"in":    bson.M{"$ifNull": bson.A{"$$u.displayName", "$$u.name"}},
+	}}
 }
 
 // AddUser добавляет нового пользователя в базу данных.
@@ -23,3 +60,142 @@ func AddUser(u User) error {
 	return nil
 }
 
+// GetUser возвращает пользователя по идентификатору.
+func GetUser(userId primitive.ObjectID) (*User, error) {
+	var u User
+	err := config.Users.FindOne(context.TODO(), bson.M{"_id": userId}).Decode(&u)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// UpdateUserProfile изменяет отображаемое имя, аватар и видимость пользователя в поиске.
+// Поля, равные nil, не изменяются; пустые строки удаляют значение.
+func UpdateUserProfile(userId primitive.ObjectID, p UserProfile) error {
+	set, unset := bson.M{}, bson.M{}
+	if p.DisplayName != nil {
+		if *p.DisplayName == "" {
+			unset["displayName"] = ""
+		} else {
+			set["displayName"] = *p.DisplayName
+		}
+	}
+	if p.AvatarURL != nil {
+		if *p.AvatarURL == "" {
+			unset["avatarUrl"] = ""
+		} else {
+			set["avatarUrl"] = *p.AvatarURL
+		}
+	}
+	if p.HideFromSearch != nil {
+		set["hideFromSearch"] = *p.HideFromSearch
+	}
+
+	update := bson.M{}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	if len(update) == 0 {
+		return nil
+	}
+
+	_, err := config.Users.UpdateOne(context.TODO(), bson.M{"_id": userId}, update)
+	return err
+}
+
+// SearchUsers ищет пользователей, имя или отображаемое имя которых начинается с запроса, без учета регистра.
+// Пользователи, скрывшие себя из поиска или заблокировавшие ищущего, и сам ищущий пользователь в результаты не попадают.
+func SearchUsers(query string, userId primitive.ObjectID, limit int64) ([]UserSummary, error) {
+	prefix := primitive.Regex{Pattern: "^" + regexp.QuoteMeta(query), Options: "i"}
+	filter := bson.M{
+		"_id":            bson.M{"$ne": userId},
+		"hideFromSearch": bson.M{"$ne": true},
+		"blockedIds":     bson.M{"$ne": userId},
+		"$or": bson.A{
+			bson.M{"name": prefix},
+			bson.M{"displayName": prefix},
+		},
+	}
+	opts := options.Find().
+		SetProjection(bson.M{"name": 1, "displayName": 1, "avatarUrl": 1}).
+		SetSort(bson.M{"name": 1}).
+		SetLimit(limit)
+
+	cursor, err := config.Users.Find(context.TODO(), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	result := make([]UserSummary, 0)
+	if err = cursor.All(context.Background(), &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// IsBlockedBy проверяет, заблокировал ли пользователь byId пользователя userId.
+func IsBlockedBy(userId, byId primitive.ObjectID) (bool, error) {
+	count, err := config.Users.CountDocuments(context.TODO(), bson.M{"_id": byId, "blockedIds": userId})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// BlockUser добавляет пользователя в список заблокированных и отклоняет его ожидающие приглашения.
+func BlockUser(userId, blockedId primitive.ObjectID) error {
+	_, err := config.Users.UpdateOne(context.TODO(),
+		bson.M{"_id": userId},
+		bson.M{"$addToSet": bson.M{"blockedIds": blockedId}})
+	if err != nil {
+		return err
+	}
+
+	_, err = config.ShareInvites.UpdateMany(context.TODO(),
+		bson.M{"inviterId": blockedId, "inviteeId": userId, "status": InviteStatusPending},
+		bson.M{"$set": bson.M{"status": InviteStatusDeclined, "respondedAt": time.Now()}})
+	return err
+}
+
+// UnblockUser удаляет пользователя из списка заблокированных.
+func UnblockUser(userId, blockedId primitive.ObjectID) (bool, error) {
+	result, err := config.Users.UpdateOne(context.TODO(),
+		bson.M{"_id": userId},
+		bson.M{"$pull": bson.M{"blockedIds": blockedId}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// AllBlockedUsers возвращает пользователей, заблокированных пользователем.
+func AllBlockedUsers(userId primitive.ObjectID) ([]UserSummary, error) {
+	u, err := GetUser(userId)
+	if err != nil || u == nil {
+		return nil, err
+	}
+
+	result := make([]UserSummary, 0)
+	if len(u.BlockedIds) == 0 {
+		return result, nil
+	}
+
+	cursor, err := config.Users.Find(context.TODO(),
+		bson.M{"_id": bson.M{"$in": u.BlockedIds}},
+		options.Find().SetProjection(bson.M{"name": 1, "displayName": 1, "avatarUrl": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+	if err = cursor.All(context.Background(), &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
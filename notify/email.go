@@ -0,0 +1,67 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Email отправляет уведомления письмами через SMTP-сервер Addr без авторизации,
+// например через локальный MailHog или ретранслятор.
+type Email struct {
+	Addr string
+	From string
+}
+
+// Name возвращает имя канала.
+func (e Email) Name() string {
+	return ChannelEmail
+}
+
+// Send отправляет письмо. Заголовок Message-ID выводится из ID уведомления, чтобы почтовые клиенты
+// могли склеить повторные доставки.
+func (e Email) Send(ctx context.Context, to Recipient, m Message) error {
+	if e.Addr == "" || to.Email == "" {
+		return ErrNotConfigured
+	}
+	if strings.ContainsAny(to.Email, "\r\n") {
+		return fmt.Errorf("invalid email address %q", to.Email)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256([]byte(m.ID))
+	domain := e.From[strings.LastIndex(e.From, "@")+1:]
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to.Email)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Title))
+	fmt.Fprintf(&msg, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(sum[:16]), domain)
+	fmt.Fprintf(&msg, "Date: %s\r\n", m.Time.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	body := m.Body
+	if m.URL != "" {
+		body += "\n\n" + m.URL
+	}
+	qp := quotedprintable.NewWriter(&msg)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	if err := qp.Close(); err != nil {
+		return err
+	}
+
+	return smtp.SendMail(e.Addr, nil, e.From, []string{to.Email}, msg.Bytes())
+}
@@ -0,0 +1,60 @@
+// Package notify доставляет уведомления пользователям по внешним каналам: электронной почтой, через Web Push
+// и исходящими веб-хуками. Каналы не хранят состояния: повторы и устранение дубликатов выполняет вызывающий,
+// поэтому одно уведомление может быть доставлено повторно с тем же ID.
+package notify
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Имена каналов доставки.
+const (
+	ChannelInbox   = "inbox"
+	ChannelEmail   = "email"
+	ChannelPush    = "push"
+	ChannelWebhook = "webhook"
+)
+
+var (
+	// ErrNotConfigured возвращается, если канал не настроен на сервере или у получателя: например, не задан адрес почты.
+	// Повторять такую доставку не нужно.
+	ErrNotConfigured = errors.New("notification channel is not configured")
+	// ErrGone возвращается, если подписка получателя больше не действует. Повторять такую доставку не нужно.
+	ErrGone = errors.New("notification subscription is gone")
+)
+
+// Message - уведомление. ID одинаков у всех доставок одного уведомления, по нему получатель отбрасывает дубликаты.
+// ListId и ItemId указывают, к какому списку и элементу относится уведомление.
+type Message struct {
+	ID     string    `json:"id"`
+	Title  string    `json:"title"`
+	Body   string    `json:"body"`
+	URL    string    `json:"url,omitempty"`
+	ListId string    `json:"listId,omitempty"`
+	ItemId string    `json:"itemId,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// PushSubscription - подписка браузера на Web Push: адрес службы и ключи шифрования из PushSubscription.toJSON().
+type PushSubscription struct {
+	Endpoint string `json:"endpoint"`
+	P256dh   string `json:"p256dh"`
+	Auth     string `json:"auth"`
+}
+
+// Recipient - адреса получателя в каналах. Незаполненный адрес означает, что канал получателю недоступен.
+type Recipient struct {
+	UserId        string
+	Email         string
+	WebhookURL    string
+	WebhookSecret string
+	Push          *PushSubscription
+}
+
+// Channel доставляет уведомление получателю.
+type Channel interface {
+	Name() string
+	Send(ctx context.Context, to Recipient, m Message) error
+}
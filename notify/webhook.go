@@ -0,0 +1,179 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress возвращается, если адрес веб-хука указывает не на публичный адрес: на сам сервер,
+// в локальную или внутреннюю сеть. Повторять такую доставку не нужно.
+var ErrForbiddenAddress = errors.New("webhook address is not public")
+
+// nonPublicNets - специальные диапазоны IPv4, которые не проверяются методами net.IP.
+var nonPublicNets = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("192.0.0.0/24"),
+	mustParseCIDR("198.18.0.0/15"),
+	mustParseCIDR("240.0.0.0/4"),
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// PublicIP сообщает, является ли ip публичным адресом, на который можно отправлять веб-хуки. Адреса обратной петли,
+// частных сетей, локальные для канала, групповые, неуказанные и прочие специальные адреса публичными не считаются.
+func PublicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		for _, n := range nonPublicNets {
+			if n.Contains(ip) {
+				return false
+			}
+		}
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// ValidWebhookURL проверяет адрес веб-хука при сохранении: схема http или https, указан хост, а хост,
+// заданный IP-адресом или именем localhost, публичен. Имена хостов разрешаются только при отправке.
+func ValidWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return PublicIP(ip)
+	}
+	return true
+}
+
+// dialPublic разрешает соединение только с публичным адресом. Проверяется уже разрешенный адрес, поэтому
+// проверку не обойти ни перенаправлением, ни сменой записи DNS после проверки имени.
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !PublicIP(ip) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+// publicTransport - транспорт веб-хуков, который соединяется только с публичными адресами и не использует прокси.
+var publicTransport = &http.Transport{
+	DialContext: (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   dialPublic,
+	}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: time.Second,
+}
+
+// checkWebhookHost разрешает имя хоста и возвращает ErrForbiddenAddress, если хотя бы один из его адресов
+// не публичен.
+func checkWebhookHost(ctx context.Context, host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		if !PublicIP(ip) {
+			return ErrForbiddenAddress
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, a := range addrs {
+		if !PublicIP(a.IP) {
+			return ErrForbiddenAddress
+		}
+	}
+	return nil
+}
+
+// Webhook отправляет уведомления POST-запросом с телом Message в формате JSON на адрес получателя.
+// Заголовок X-Notification-Id содержит ID уведомления, а X-Signature - подпись тела
+// "sha256=<hex HMAC-SHA256>" секретом получателя.
+//
+// Уведомления отправляются только на публичные адреса (см. PublicIP): имя хоста проверяется перед отправкой,
+// а если у Client не задан Transport, то и каждый адрес, с которым устанавливается соединение.
+type Webhook struct {
+	Client *http.Client
+}
+
+// Name возвращает имя канала.
+func (h Webhook) Name() string {
+	return ChannelWebhook
+}
+
+// Send отправляет уведомление. Любой ответ, кроме 2xx, считается неудачной доставкой.
+func (h Webhook) Send(ctx context.Context, to Recipient, m Message) error {
+	if to.WebhookURL == "" {
+		return ErrNotConfigured
+	}
+
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, []byte(to.WebhookSecret))
+	mac.Write(body)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, to.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if err := checkWebhookHost(ctx, req.URL.Hostname()); err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Notification-Id", m.ID)
+	req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	client := http.Client{}
+	if h.Client != nil {
+		client = *h.Client
+	}
+	if client.Transport == nil {
+		client.Transport = publicTransport
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPublicIP(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"::":               false,
+		"224.0.0.1":        false,
+		"::ffff:127.0.0.1": false,
+	}
+	for addr, want := range tests {
+		if got := PublicIP(net.ParseIP(addr)); got != want {
+			t.Errorf("PublicIP(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestValidWebhookURL(t *testing.T) {
+	tests := map[string]bool{
+		"https://example.com/hook":      true,
+		"http://93.184.216.34:8080/":    true,
+		"ftp://example.com/":            false,
+		"https:///hook":                 false,
+		"http://localhost:8080/":        false,
+		"http://api.localhost/":         false,
+		"http://127.0.0.1/":             false,
+		"http://[::1]/":                 false,
+		"http://169.254.169.254/latest": false,
+	}
+	for raw, want := range tests {
+		if got := ValidWebhookURL(raw); got != want {
+			t.Errorf("ValidWebhookURL(%q) = %v, want %v", raw, got, want)
+		}
+	}
+}
+
+func TestWebhookRejectsLoopback(t *testing.T) {
+	called := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+	defer srv.Close()
+
+	err := Webhook{}.Send(context.Background(), Recipient{WebhookURL: srv.URL}, Message{ID: "1"})
+	if !errors.Is(err, ErrForbiddenAddress) || called {
+		t.Errorf("Send to %s = %v, called %v, want ErrForbiddenAddress", srv.URL, err, called)
+	}
+}
+
+func TestDialPublic(t *testing.T) {
+	for addr, want := range map[string]error{"127.0.0.1:80": ErrForbiddenAddress, "[::1]:443": ErrForbiddenAddress, "93.184.216.34:443": nil} {
+		if err := dialPublic("tcp", addr, nil); err != want {
+			t.Errorf("dialPublic(%s) = %v, want %v", addr, err, want)
+		}
+	}
+}
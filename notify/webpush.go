@@ -0,0 +1,263 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// pushRecordSize - размер записи зашифрованного сообщения (RFC 8188). Уведомление всегда помещается в одну запись.
+const pushRecordSize = 4096
+
+// ErrInvalidVAPIDKey возвращается, если ключ VAPID задан неверно.
+var ErrInvalidVAPIDKey = errors.New("invalid VAPID key")
+
+// b64 - кодировка ключей и подписей Web Push: base64url без выравнивания.
+var b64 = base64.RawURLEncoding
+
+// VAPIDKeys - пара ключей сервера для Web Push (RFC 8292). Public - несжатая точка P-256, Private - скаляр;
+// оба в base64url без выравнивания, как их выдает `npx web-push generate-vapid-keys`.
+type VAPIDKeys struct {
+	Public  string `json:"publicKey"`
+	Private string `json:"privateKey"`
+}
+
+// GenerateVAPIDKeys создает новую пару ключей VAPID.
+func GenerateVAPIDKeys() (VAPIDKeys, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return VAPIDKeys{}, err
+	}
+	return VAPIDKeys{
+		Public:  b64.EncodeToString(elliptic.Marshal(elliptic.P256(), key.X, key.Y)),
+		Private: b64.EncodeToString(key.D.FillBytes(make([]byte, 32))),
+	}, nil
+}
+
+// WebPush отправляет уведомления через службы Web Push браузеров. Сообщение шифруется ключами подписки
+// (RFC 8291), а сервер подтверждает себя ключами VAPID. Subject - контакт владельца сервера ("mailto:..." или URL).
+type WebPush struct {
+	Subject string
+	TTL     time.Duration
+	Client  *http.Client
+
+	key    *ecdsa.PrivateKey
+	public string
+}
+
+// NewWebPush создает канал Web Push с закрытым ключом VAPID privateKey. Пустой ключ означает,
+// что канал не настроен и доставка возвращает ErrNotConfigured.
+func NewWebPush(privateKey, subject string) (*WebPush, error) {
+	p := &WebPush{Subject: subject, TTL: 24 * time.Hour}
+	if privateKey == "" {
+		return p, nil
+	}
+
+	d, err := b64.DecodeString(privateKey)
+	if err != nil || len(d) != 32 {
+		return nil, ErrInvalidVAPIDKey
+	}
+	curve := elliptic.P256()
+	key := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(d)}
+	key.PublicKey.Curve = curve
+	key.PublicKey.X, key.PublicKey.Y = curve.ScalarBaseMult(d)
+	p.key, p.public = key, b64.EncodeToString(elliptic.Marshal(curve, key.X, key.Y))
+	return p, nil
+}
+
+// PublicKey возвращает открытый ключ VAPID, который браузер передает в pushManager.subscribe.
+func (p *WebPush) PublicKey() string {
+	return p.public
+}
+
+// Name возвращает имя канала.
+func (p *WebPush) Name() string {
+	return ChannelPush
+}
+
+// Send отправляет уведомление в подписку получателя. Заголовок Topic выводится из ID уведомления,
+// поэтому служба заменяет недоставленную копию повторной, а не показывает обе.
+// Если служба сообщает, что подписки больше нет, возвращается ErrGone.
+func (p *WebPush) Send(ctx context.Context, to Recipient, m Message) error {
+	if p.key == nil || to.Push == nil {
+		return ErrNotConfigured
+	}
+
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	body, err := encryptPush(payload, to.Push.P256dh, to.Push.Auth)
+	if err != nil {
+		return err
+	}
+	auth, err := p.vapidAuthorization(to.Push.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, to.Push.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	topic := sha256.Sum256([]byte(m.ID))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(p.TTL.Seconds())))
+	req.Header.Set("Topic", b64.EncodeToString(topic[:24]))
+	req.Header.Set("Urgency", "normal")
+	req.Header.Set("Authorization", auth)
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return ErrGone
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("push service responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// vapidAuthorization возвращает заголовок Authorization со схемой vapid (RFC 8292) для службы endpoint.
+func (p *WebPush) vapidAuthorization(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("invalid push endpoint %q", endpoint)
+	}
+
+	header := b64.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": p.Subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + b64.EncodeToString(claims)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, p.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+	sig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+
+	return "vapid t=" + unsigned + "." + b64.EncodeToString(sig) + ", k=" + p.public, nil
+}
+
+// encryptPush шифрует payload для подписки с открытым ключом p256dh и секретом auth по схеме aes128gcm (RFC 8291).
+func encryptPush(payload []byte, p256dh, auth string) ([]byte, error) {
+	curve := elliptic.P256()
+	uaPublic, err := decodeKey(p256dh)
+	if err != nil {
+		return nil, err
+	}
+	authSecret, err := decodeKey(auth)
+	if err != nil {
+		return nil, err
+	}
+	x, y := elliptic.Unmarshal(curve, uaPublic)
+	if x == nil {
+		return nil, errors.New("invalid push subscription key")
+	}
+
+	// Общий секрет с одноразовым ключом сервера.
+	ephemeral, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := elliptic.Marshal(curve, ephemeral.X, ephemeral.Y)
+	sx, _ := curve.ScalarMult(x, y, ephemeral.D.FillBytes(make([]byte, 32)))
+	ecdhSecret := sx.FillBytes(make([]byte, 32))
+
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm, err := expand(hkdf.Extract(sha256.New, ecdhSecret, authSecret), keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+	cek, err := expand(prk, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := expand(prk, []byte("Content-Encoding: nonce\x00"), 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// Единственная запись завершается разделителем 0x02.
+	plaintext := append(append([]byte{}, payload...), 2)
+	if len(plaintext)+gcm.Overhead() > pushRecordSize {
+		return nil, errors.New("push payload is too large")
+	}
+
+	var out bytes.Buffer
+	out.Write(salt)
+	binary.Write(&out, binary.BigEndian, uint32(pushRecordSize))
+	out.WriteByte(byte(len(asPublic)))
+	out.Write(asPublic)
+	out.Write(gcm.Seal(nil, nonce, plaintext, nil))
+	return out.Bytes(), nil
+}
+
+// expand возвращает n байт HKDF-Expand.
+func expand(prk, info []byte, n int) ([]byte, error) {
+	out := make([]byte, n)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, info), out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// decodeKey декодирует ключ подписки. Браузеры отдают base64url, но некоторые клиенты передают обычный base64.
+func decodeKey(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	if b, err := b64.DecodeString(s); err == nil {
+		return b, nil
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
@@ -0,0 +1,218 @@
+// Package parser разбирает свободный текст элемента списка покупок ("3 x молоко 1л", "apples 2kg",
+// "bread (rye) !urgent") на название, количество, единицу измерения, заметку, приоритет и категорию.
+// Поддерживаются русские и английские обозначения.
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Приоритеты элемента.
+const (
+	PriorityHigh = "high"
+	PriorityLow  = "low"
+)
+
+// Item - результат разбора текста элемента. Нулевые поля означают, что в тексте их нет.
+type Item struct {
+	Name     string  `json:"name"`
+	Quantity float64 `json:"quantity,omitempty"`
+	Unit     string  `json:"unit,omitempty"`
+	Note     string  `json:"note,omitempty"`
+	Priority string  `json:"priority,omitempty"`
+	Category string  `json:"category,omitempty"`
+}
+
+// units сопоставляет обозначения единиц измерения с их каноническим видом.
+var units = map[string]string{
+	"kg": "kg", "kgs": "kg", "kilo": "kg", "kilos": "kg", "kilogram": "kg", "kilograms": "kg",
+	"кг": "kg", "кило": "kg", "килограмм": "kg", "килограмма": "kg", "килограммов": "kg",
+	"g": "g", "gr": "g", "gram": "g", "grams": "g",
+	"г": "g", "гр": "g", "грамм": "g", "грамма": "g", "граммов": "g",
+	"l": "l", "ltr": "l", "liter": "l", "liters": "l", "litre": "l", "litres": "l",
+	"л": "l", "литр": "l", "литра": "l", "литров": "l",
+	"ml": "ml", "мл": "ml",
+	"pc": "pcs", "pcs": "pcs", "piece": "pcs", "pieces": "pcs",
+	"шт": "pcs", "штука": "pcs", "штуки": "pcs", "штук": "pcs",
+	"pack": "pack", "packs": "pack", "pkg": "pack",
+	"уп": "pack", "упак": "pack", "упаковка": "pack", "упаковки": "pack", "упаковок": "pack",
+	"bottle": "bottle", "bottles": "bottle",
+	"бут": "bottle", "бутылка": "bottle", "бутылки": "bottle", "бутылок": "bottle",
+	"can": "can", "cans": "can",
+	"банка": "can", "банки": "can", "банок": "can",
+	"lb": "lb", "lbs": "lb", "oz": "oz",
+	"dozen": "dozen", "дюжина": "dozen", "дюжины": "dozen",
+}
+
+// priorities сопоставляет пометки вида "!urgent" с приоритетом.
+var priorities = map[string]string{
+	"urgent": PriorityHigh, "important": PriorityHigh, "asap": PriorityHigh, "high": PriorityHigh,
+	"срочно": PriorityHigh, "важно": PriorityHigh,
+	"low": PriorityLow, "later": PriorityLow, "someday": PriorityLow,
+	"потом": PriorityLow, "неважно": PriorityLow,
+}
+
+var (
+	// notePattern выделяет заметки в скобках.
+	notePattern = regexp.MustCompile(`\(([^()]*)\)`)
+	// numberPattern выделяет число и присоединенный к нему суффикс: "2kg", "1,5л", "3x".
+	numberPattern = regexp.MustCompile(`^(\d+(?:[.,]\d+)?)(\pL*\.?)$`)
+	// prefixMultiplierPattern выделяет множитель перед числом: "x3", "×3".
+	prefixMultiplierPattern = regexp.MustCompile(`^[xх×*](\d+)$`)
+)
+
+// isMultiplier сообщает, является ли строка знаком умножения: латинская или кириллическая "x", "×" или "*".
+func isMultiplier(s string) bool {
+	switch strings.ToLower(s) {
+	case "x", "х", "×", "*":
+		return true
+	}
+	return false
+}
+
+// lookupUnit возвращает каноническое обозначение единицы измерения.
+func lookupUnit(s string) (string, bool) {
+	u, ok := units[strings.TrimSuffix(strings.ToLower(s), ".")]
+	return u, ok
+}
+
+// parseNumber разбирает число с точкой или запятой в качестве разделителя дробной части.
+func parseNumber(s string) float64 {
+	n, _ := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+	return n
+}
+
+// formatNumber записывает число без лишних нулей дробной части.
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+// ParseItem разбирает текст элемента списка покупок.
+//
+// Распознаются:
+//   - множитель: "3 x молоко", "3x milk", "milk x3";
+//   - количество с единицей: "2kg", "2 кг", "1,5 л", "500 g";
+//   - количество без единицы: "3 apples";
+//   - заметки в скобках: "bread (rye)";
+//   - приоритет: "!urgent", "!срочно", "!low", "!потом";
+//   - категория: "#dairy", "#молочное".
+//
+// Если указаны и множитель, и количество с единицей ("3 x milk 1L"), количеством считается множитель,
+// а объем одной упаковки попадает в заметку. Если после разбора название оказывается пустым ("2 kg"),
+// текст не разбирается: названием остается исходный текст, а остальные поля не заполняются.
+func ParseItem(text string) Item {
+	var item Item
+	text = strings.TrimSpace(text)
+
+	// Заметки в скобках.
+	notes := make([]string, 0)
+	for _, m := range notePattern.FindAllStringSubmatch(text, -1) {
+		if n := strings.TrimSpace(m[1]); n != "" {
+			notes = append(notes, n)
+		}
+	}
+	rest := notePattern.ReplaceAllString(text, " ")
+
+	var (
+		multiplier, measure, bare float64
+		unit                      string
+		name                      []string
+	)
+	tokens := strings.Fields(rest)
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+
+		// Приоритет и категория.
+		if strings.HasPrefix(tok, "!") {
+			if p, ok := priorities[strings.ToLower(strings.TrimLeft(tok, "!"))]; ok {
+				item.Priority = p
+				continue
+			}
+		}
+		if strings.HasPrefix(tok, "#") && len(tok) > 1 {
+			if item.Category == "" {
+				item.Category = strings.ToLower(strings.TrimPrefix(tok, "#"))
+			}
+			continue
+		}
+
+		// Множитель перед числом: "x3".
+		if m := prefixMultiplierPattern.FindStringSubmatch(strings.ToLower(tok)); m != nil && multiplier == 0 {
+			multiplier = parseNumber(m[1])
+			continue
+		}
+
+		m := numberPattern.FindStringSubmatch(tok)
+		if m == nil {
+			// Отдельный знак умножения после названия: "milk x 3".
+			if isMultiplier(tok) && i+1 < len(tokens) && multiplier == 0 {
+				if n := numberPattern.FindStringSubmatch(tokens[i+1]); n != nil && n[2] == "" {
+					multiplier = parseNumber(n[1])
+					i++
+					continue
+				}
+			}
+			name = append(name, tok)
+			continue
+		}
+
+		n, suffix := parseNumber(m[1]), m[2]
+		switch {
+		case isMultiplier(suffix) && multiplier == 0:
+			// "3x milk".
+			multiplier = n
+		case suffix != "":
+			// "2kg"; число с неизвестным суффиксом остается частью названия: "7up".
+			if u, ok := lookupUnit(suffix); ok && measure == 0 {
+				measure, unit = n, u
+			} else {
+				name = append(name, tok)
+			}
+		case i+1 < len(tokens) && isMultiplier(tokens[i+1]) && multiplier == 0:
+			// "3 x milk".
+			multiplier = n
+			i++
+		case i+1 < len(tokens) && measure == 0:
+			// "2 кг" или просто "3 apples".
+			if u, ok := lookupUnit(tokens[i+1]); ok {
+				measure, unit = n, u
+				i++
+			} else if bare == 0 {
+				bare = n
+			} else {
+				name = append(name, tok)
+			}
+		case bare == 0:
+			bare = n
+		default:
+			name = append(name, tok)
+		}
+	}
+
+	// Число без единицы при наличии количества с единицей считается множителем: "3 молоко 1л".
+	if multiplier == 0 && measure != 0 && bare != 0 {
+		multiplier = bare
+	} else if multiplier == 0 && measure == 0 {
+		multiplier = bare
+	}
+
+	switch {
+	case multiplier != 0 && measure != 0:
+		item.Quantity = multiplier
+		notes = append([]string{fmt.Sprintf("%s %s", formatNumber(measure), unit)}, notes...)
+	case measure != 0:
+		item.Quantity, item.Unit = measure, unit
+	case multiplier != 0:
+		item.Quantity = multiplier
+	}
+
+	item.Name = strings.Trim(strings.Join(name, " "), " ,;-–—")
+	if item.Name == "" {
+		return Item{Name: text}
+	}
+	item.Note = strings.Join(notes, "; ")
+	return item
+}
@@ -0,0 +1,30 @@
+package parser
+
+import "testing"
+
+func TestParseItem(t *testing.T) {
+	tests := []struct {
+		text string
+		want Item
+	}{
+		{"milk", Item{Name: "milk"}},
+		{"3 x milk 1L", Item{Name: "milk", Quantity: 3, Note: "1 l"}},
+		{"milk x3", Item{Name: "milk", Quantity: 3}},
+		{"apples 2kg", Item{Name: "apples", Quantity: 2, Unit: "kg"}},
+		{"bread (rye) !urgent", Item{Name: "bread", Note: "rye", Priority: PriorityHigh}},
+		{"7up", Item{Name: "7up"}},
+		{"молоко 1,5 л", Item{Name: "молоко", Quantity: 1.5, Unit: "l"}},
+		{"яйца 10 шт", Item{Name: "яйца", Quantity: 10, Unit: "pcs"}},
+		{"2 молока", Item{Name: "молока", Quantity: 2}},
+		{"сыр 200г #молочное", Item{Name: "сыр", Quantity: 200, Unit: "g", Category: "молочное"}},
+		// Без названия текст не разбирается.
+		{"#dairy", Item{Name: "#dairy"}},
+		{"2 kg", Item{Name: "2 kg"}},
+		{"", Item{}},
+	}
+	for _, tt := range tests {
+		if got := ParseItem(tt.text); got != tt.want {
+			t.Errorf("ParseItem(%q) = %+v, want %+v", tt.text, got, tt.want)
+		}
+	}
+}
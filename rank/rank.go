@@ -0,0 +1,136 @@
+// Package rank выдает позиции для ручного упорядочивания элементов.
+//
+// Позиция - строка из цифр base62 ("0-9A-Za-z"), которая сравнивается лексикографически как дробная часть числа.
+// Между любыми двумя позициями всегда есть свободная, поэтому перемещение элемента меняет только его позицию
+// и не затрагивает соседей. Позиция не может быть пустой и не оканчивается на "0": иначе перед ней
+// не нашлось бы места.
+//
+// Позиции не перераспределяются и со временем удлиняются: при добавлении подряд в конец или в начало списка
+// позиция растет примерно на одну цифру каждые 31 вставку (после 5000 вставок - около 162 цифр), а при
+// вставке подряд в одно и то же место - на цифру каждые несколько вставок. Короткие позиции можно вернуть,
+// выдав элементам списка новые позиции через Spread.
+package rank
+
+import (
+	"errors"
+	"strings"
+)
+
+// digits - цифры позиции в порядке возрастания. Их порядок совпадает с порядком байтов ASCII.
+const digits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// ErrInvalidRange означает, что позиции переданы в неверном порядке или одна из них недопустима.
+var ErrInvalidRange = errors.New("invalid rank range")
+
+// Valid сообщает, является ли строка допустимой позицией.
+func Valid(key string) bool {
+	if key == "" || key[len(key)-1] == digits[0] {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if strings.IndexByte(digits, key[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Between возвращает позицию строго между a и b. Пустая a означает начало, пустая b - конец:
+// Between("", "") дает первую позицию, Between(last, "") - позицию после последней.
+func Between(a, b string) (string, error) {
+	if (a != "" && !Valid(a)) || (b != "" && !Valid(b)) || (a != "" && b != "" && a >= b) {
+		return "", ErrInvalidRange
+	}
+	return midpoint(a, b), nil
+}
+
+// After возвращает позицию после key.
+func After(key string) (string, error) {
+	return Between(key, "")
+}
+
+// Spread возвращает n возрастающих позиций одинаковой длины, равномерно распределенных по всему диапазону,
+// чтобы между соседями оставалось место для последующих перемещений.
+func Spread(n int) []string {
+	keys := make([]string, n)
+	if n == 0 {
+		return keys
+	}
+
+	// Подбираем длину, при которой между соседними позициями остается не меньше base62 свободных значений.
+	base := int64(len(digits))
+	width, space := 1, base
+	for space/int64(n+1) < base && width < 10 {
+		width++
+		space *= base
+	}
+
+	buf := make([]byte, width)
+	for i := range keys {
+		v := space / int64(n+1) * int64(i+1)
+		for j := width - 1; j >= 0; j-- {
+			buf[j] = digits[v%base]
+			v /= base
+		}
+		keys[i] = strings.TrimRight(string(buf), digits[:1])
+	}
+	return keys
+}
+
+// midpoint возвращает позицию между a и b, где пустая b означает конец диапазона.
+// Позиции предполагаются допустимыми и упорядоченными.
+func midpoint(a, b string) string {
+	if b != "" {
+		// Общий префикс переносится в результат как есть; недостающие цифры a считаются нулями.
+		n := 0
+		for n < len(b) && digitAt(a, n) == b[n] {
+			n++
+		}
+		if n > 0 {
+			rest := ""
+			if n < len(a) {
+				rest = a[n:]
+			}
+			return b[:n] + midpoint(rest, b[n:])
+		}
+	}
+
+	digitA := 0
+	if a != "" {
+		digitA = strings.IndexByte(digits, a[0])
+	}
+	digitB := len(digits)
+	if b != "" {
+		digitB = strings.IndexByte(digits, b[0])
+	}
+	if digitB-digitA > 1 {
+		// Элементы обычно добавляются в конец или начало, поэтому у открытого края делаем шаг в одну цифру,
+		// а не в половину диапазона: так позиции удлиняются на цифру лишь раз в несколько десятков вставок.
+		switch {
+		case a != "" && b == "":
+			return string(digits[digitA+1])
+		case a == "" && b != "" && digitB > 1:
+			return string(digits[digitB-1])
+		}
+		return string(digits[(digitA+digitB+1)/2])
+	}
+
+	// Первые цифры соседние: если b длиннее одной цифры, подходит ее первая цифра,
+	// иначе продолжаем после первой цифры a без верхней границы.
+	if len(b) > 1 {
+		return b[:1]
+	}
+	rest := ""
+	if len(a) > 1 {
+		rest = a[1:]
+	}
+	return string(digits[digitA]) + midpoint(rest, "")
+}
+
+// digitAt возвращает n-ю цифру позиции или "0", если позиция короче.
+func digitAt(key string, n int) byte {
+	if n < len(key) {
+		return key[n]
+	}
+	return digits[0]
+}
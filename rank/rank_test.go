@@ -0,0 +1,88 @@
+package rank
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// between проверяет, что Between(a, b) лежит строго между a и b и является допустимой позицией.
+func between(t *testing.T, a, b string) string {
+	t.Helper()
+	key, err := Between(a, b)
+	if err != nil {
+		t.Fatalf("Between(%q, %q): %v", a, b, err)
+	}
+	if !Valid(key) || (a != "" && key <= a) || (b != "" && key >= b) {
+		t.Fatalf("Between(%q, %q) = %q, want valid key strictly between", a, b, key)
+	}
+	return key
+}
+
+func TestValid(t *testing.T) {
+	for key, want := range map[string]bool{"V": true, "0V": true, "zz": true, "": false, "V0": false, "0": false, "V-": false} {
+		if got := Valid(key); got != want {
+			t.Errorf("Valid(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestBetweenInvalid(t *testing.T) {
+	for _, r := range [][2]string{{"b", "a"}, {"a", "a"}, {"a0", ""}, {"", "-"}} {
+		if _, err := Between(r[0], r[1]); err != ErrInvalidRange {
+			t.Errorf("Between(%q, %q) = %v, want ErrInvalidRange", r[0], r[1], err)
+		}
+	}
+}
+
+func TestBetweenRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	keys := []string{between(t, "", "")}
+	for i := 0; i < 2000; i++ {
+		// Вставляем между случайными соседями, включая открытые края.
+		j := r.Intn(len(keys) + 1)
+		a, b := "", ""
+		if j > 0 {
+			a = keys[j-1]
+		}
+		if j < len(keys) {
+			b = keys[j]
+		}
+		key := between(t, a, b)
+		keys = append(keys[:j], append([]string{key}, keys[j:]...)...)
+	}
+}
+
+func TestAppendAndPrepend(t *testing.T) {
+	last, first := "", ""
+	for i := 1; i <= 5000; i++ {
+		last = between(t, last, "")
+		first = between(t, "", first)
+		if i == 31 && (len(last) != 1 || len(first) != 1) {
+			t.Errorf("after %d inserts: last %q, first %q, want one digit", i, last, first)
+		}
+	}
+	// Позиции растут примерно на цифру каждые 31 вставку (см. описание пакета).
+	if len(last) > 200 || len(first) > 200 {
+		t.Errorf("after 5000 inserts: len(last) = %d, len(first) = %d, want at most 200", len(last), len(first))
+	}
+}
+
+func TestSpread(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 61, 62, 1000, 10000} {
+		keys := Spread(n)
+		if len(keys) != n {
+			t.Fatalf("Spread(%d) returned %d keys", n, len(keys))
+		}
+		for i, key := range keys {
+			if !Valid(key) {
+				t.Fatalf("Spread(%d)[%d] = %q, want valid key", n, i, key)
+			} else if i > 0 && key <= keys[i-1] {
+				t.Fatalf("Spread(%d)[%d] = %q after %q, want increasing keys", n, i, key, keys[i-1])
+			}
+		}
+		// Между соседями остается место для вставки.
+		for i := 1; i < n; i++ {
+			between(t, keys[i-1], keys[i])
+		}
+	}
+}
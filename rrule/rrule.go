@@ -0,0 +1,447 @@
+// Package rrule разбирает правила повторения RRULE (RFC 5545) и вычисляет по ним повторения.
+//
+// Поддерживается подмножество, достаточное для списков: FREQ=DAILY|WEEKLY|MONTHLY|YEARLY, INTERVAL, COUNT,
+// UNTIL, BYDAY (с порядковыми номерами для MONTHLY и YEARLY), BYMONTHDAY, BYMONTH и WKST.
+// Повторения вычисляются в часовом поясе начала повторения, поэтому время суток сохраняется при переходе
+// на летнее время.
+package rrule
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency - частота повторения.
+type Frequency int
+
+// Поддерживаемые частоты.
+const (
+	Daily Frequency = iota
+	Weekly
+	Monthly
+	Yearly
+)
+
+var frequencyNames = map[Frequency]string{Daily: "DAILY", Weekly: "WEEKLY", Monthly: "MONTHLY", Yearly: "YEARLY"}
+
+var weekdayNames = map[time.Weekday]string{
+	time.Monday: "MO", time.Tuesday: "TU", time.Wednesday: "WE", time.Thursday: "TH",
+	time.Friday: "FR", time.Saturday: "SA", time.Sunday: "SU",
+}
+
+// maxPeriods ограничивает число перебираемых периодов, чтобы правило, которое никогда не срабатывает
+// (например, 30 февраля), не зацикливало вычисление.
+const maxPeriods = 50000
+
+// Ошибки разбора правила.
+var (
+	ErrInvalidRule     = errors.New("invalid recurrence rule")
+	ErrUnsupportedPart = errors.New("unsupported recurrence rule part")
+)
+
+// WeekdayNum - день недели из BYDAY с необязательным порядковым номером: 1MO - первый понедельник,
+// -1FR - последняя пятница, MO (N = 0) - каждый понедельник.
+type WeekdayNum struct {
+	N   int
+	Day time.Weekday
+}
+
+// Rule - разобранное правило повторения.
+// Until задает последний допустимый момент; если UntilFloating, он задан без часового пояса и сравнивается
+// с местным временем повторений.
+type Rule struct {
+	Freq          Frequency
+	Interval      int
+	Count         int
+	Until         time.Time
+	UntilFloating bool
+	ByDay         []WeekdayNum
+	ByMonthDay    []int
+	ByMonth       []time.Month
+	WeekStart     time.Weekday
+}
+
+// Parse разбирает правило вида "FREQ=WEEKLY;BYDAY=MO,TH". Префикс "RRULE:" допускается.
+func Parse(s string) (*Rule, error) {
+	s = strings.TrimSpace(s)
+	if len(s) >= 6 && strings.EqualFold(s[:6], "RRULE:") {
+		s = s[6:]
+	}
+
+	r := Rule{Freq: -1, Interval: 1, WeekStart: time.Monday}
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(s, ";") {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, ErrInvalidRule
+		}
+		key, value := strings.ToUpper(kv[0]), strings.ToUpper(kv[1])
+		if seen[key] {
+			return nil, ErrInvalidRule
+		}
+		seen[key] = true
+
+		var err error
+		switch key {
+		case "FREQ":
+			r.Freq = -1
+			for f, name := range frequencyNames {
+				if name == value {
+					r.Freq = f
+				}
+			}
+			if r.Freq < 0 {
+				return nil, ErrUnsupportedPart
+			}
+		case "INTERVAL":
+			r.Interval, err = positive(value)
+		case "COUNT":
+			r.Count, err = positive(value)
+		case "UNTIL":
+			r.Until, r.UntilFloating, err = parseUntil(value)
+		case "BYDAY":
+			r.ByDay, err = parseByDay(value)
+		case "BYMONTHDAY":
+			r.ByMonthDay, err = parseInts(value, -31, 31)
+		case "BYMONTH":
+			var months []int
+			months, err = parseInts(value, 1, 12)
+			for _, m := range months {
+				r.ByMonth = append(r.ByMonth, time.Month(m))
+			}
+		case "WKST":
+			day, ok := parseWeekday(value)
+			if !ok {
+				return nil, ErrInvalidRule
+			}
+			r.WeekStart = day
+		default:
+			return nil, ErrUnsupportedPart
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.Freq < 0 || (r.Count > 0 && !r.Until.IsZero()) {
+		return nil, ErrInvalidRule
+	}
+	// Порядковые номера дней недели имеют смысл только внутри месяца или года.
+	for _, d := range r.ByDay {
+		if d.N != 0 && r.Freq != Monthly && r.Freq != Yearly {
+			return nil, ErrInvalidRule
+		}
+	}
+	if len(r.ByMonthDay) > 0 && r.Freq == Weekly {
+		return nil, ErrInvalidRule
+	}
+	return &r, nil
+}
+
+// String записывает правило в формате RFC 5545 без префикса "RRULE:".
+func (r Rule) String() string {
+	parts := []string{"FREQ=" + frequencyNames[r.Freq]}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		if r.UntilFloating {
+			parts = append(parts, "UNTIL="+r.Until.Format("20060102T150405"))
+		} else {
+			parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+		}
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, d := range r.ByDay {
+			days[i] = weekdayNames[d.Day]
+			if d.N != 0 {
+				days[i] = strconv.Itoa(d.N) + days[i]
+			}
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		parts = append(parts, "BYMONTHDAY="+joinInts(r.ByMonthDay))
+	}
+	if len(r.ByMonth) > 0 {
+		months := make([]int, len(r.ByMonth))
+		for i, m := range r.ByMonth {
+			months[i] = int(m)
+		}
+		parts = append(parts, "BYMONTH="+joinInts(months))
+	}
+	if r.WeekStart != time.Monday {
+		parts = append(parts, "WKST="+weekdayNames[r.WeekStart])
+	}
+	return strings.Join(parts, ";")
+}
+
+// After возвращает первое повторение строго после after для повторения, начавшегося в start.
+// Повторения считаются с start в его часовом поясе; второй результат равен false, если повторений больше нет.
+func (r Rule) After(start, after time.Time) (time.Time, bool) {
+	count := 0
+	for period := 0; period < maxPeriods; period++ {
+		for _, t := range r.candidates(start, period) {
+			if t.Before(start) {
+				continue
+			}
+			if r.pastUntil(t) {
+				return time.Time{}, false
+			}
+			count++
+			if r.Count > 0 && count > r.Count {
+				return time.Time{}, false
+			}
+			if t.After(after) {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// pastUntil сообщает, что повторение t позже границы UNTIL.
+func (r Rule) pastUntil(t time.Time) bool {
+	if r.Until.IsZero() {
+		return false
+	}
+	if r.UntilFloating {
+		u := r.Until
+		return t.After(time.Date(u.Year(), u.Month(), u.Day(), u.Hour(), u.Minute(), u.Second(), 0, t.Location()))
+	}
+	return t.After(r.Until)
+}
+
+// candidates возвращает упорядоченные повторения периода с номером period (день, неделя, месяц или год,
+// считая от start с шагом Interval) до проверки границ COUNT и UNTIL.
+func (r Rule) candidates(start time.Time, period int) []time.Time {
+	step := period * r.Interval
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+	}
+
+	var days []time.Time
+	switch r.Freq {
+	case Daily:
+		d := at(start.Year(), start.Month(), start.Day()+step)
+		if r.matchesMonth(d) && r.matchesMonthDay(d) && r.matchesWeekday(d) {
+			days = append(days, d)
+		}
+
+	case Weekly:
+		// Неделя начинается с дня WeekStart.
+		offset := (int(start.Weekday()) - int(r.WeekStart) + 7) % 7
+		weekStart := at(start.Year(), start.Month(), start.Day()-offset+7*step)
+		for i := 0; i < 7; i++ {
+			d := at(weekStart.Year(), weekStart.Month(), weekStart.Day()+i)
+			if len(r.ByDay) == 0 && d.Weekday() != start.Weekday() {
+				continue
+			}
+			if r.matchesWeekday(d) && r.matchesMonth(d) {
+				days = append(days, d)
+			}
+		}
+
+	case Monthly:
+		first := at(start.Year(), start.Month()+time.Month(step), 1)
+		if r.matchesMonth(first) {
+			days = r.monthDays(first, start)
+		}
+
+	case Yearly:
+		year := start.Year() + step
+		switch {
+		case len(r.ByMonth) == 0 && len(r.ByMonthDay) == 0 && len(r.ByDay) > 0:
+			// Дни недели внутри всего года: 20MO - двадцатый понедельник года.
+			days = r.expandByDay(at(year, time.January, 1), at(year+1, time.January, 1))
+		case len(r.ByMonth) == 0:
+			days = r.monthDays(at(year, start.Month(), 1), start)
+		default:
+			for _, m := range r.ByMonth {
+				days = append(days, r.monthDays(at(year, m, 1), start)...)
+			}
+		}
+	}
+
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days
+}
+
+// monthDays возвращает повторения внутри месяца, начинающегося с first.
+func (r Rule) monthDays(first, start time.Time) []time.Time {
+	next := first.AddDate(0, 1, 0)
+	daysInMonth := next.AddDate(0, 0, -1).Day()
+	at := func(d int) time.Time {
+		return time.Date(first.Year(), first.Month(), d, start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+	}
+
+	days := make([]time.Time, 0)
+	switch {
+	case len(r.ByMonthDay) > 0:
+		for _, md := range r.ByMonthDay {
+			if md < 0 {
+				md = daysInMonth + md + 1
+			}
+			if md < 1 || md > daysInMonth {
+				continue
+			}
+			if d := at(md); r.matchesWeekday(d) {
+				days = append(days, d)
+			}
+		}
+	case len(r.ByDay) > 0:
+		days = r.expandByDay(first, next)
+	case start.Day() <= daysInMonth:
+		// Без BYMONTHDAY и BYDAY повторение приходится на день начала; месяцы без такого дня пропускаются.
+		days = append(days, at(start.Day()))
+	}
+	return days
+}
+
+// expandByDay возвращает дни BYDAY в полуинтервале [from, to) с учетом порядковых номеров.
+func (r Rule) expandByDay(from, to time.Time) []time.Time {
+	days := make([]time.Time, 0)
+	for _, wd := range r.ByDay {
+		matches := make([]time.Time, 0, 5)
+		for d := from; d.Before(to); d = d.AddDate(0, 0, 1) {
+			if d.Weekday() == wd.Day {
+				matches = append(matches, d)
+			}
+		}
+		switch {
+		case wd.N == 0:
+			days = append(days, matches...)
+		case wd.N > 0 && wd.N <= len(matches):
+			days = append(days, matches[wd.N-1])
+		case wd.N < 0 && -wd.N <= len(matches):
+			days = append(days, matches[len(matches)+wd.N])
+		}
+	}
+	return days
+}
+
+func (r Rule) matchesWeekday(t time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, d := range r.ByDay {
+		if d.Day == t.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) matchesMonth(t time.Time) bool {
+	if len(r.ByMonth) == 0 {
+		return true
+	}
+	for _, m := range r.ByMonth {
+		if m == t.Month() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) matchesMonthDay(t time.Time) bool {
+	if len(r.ByMonthDay) == 0 {
+		return true
+	}
+	daysInMonth := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, t.Location()).Day()
+	for _, md := range r.ByMonthDay {
+		if md == t.Day() || daysInMonth+md+1 == t.Day() {
+			return true
+		}
+	}
+	return false
+}
+
+// positive разбирает положительное целое число.
+func positive(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, ErrInvalidRule
+	}
+	return n, nil
+}
+
+// parseInts разбирает список целых чисел через запятую в диапазоне [min, max], исключая ноль.
+func parseInts(s string, min, max int) ([]int, error) {
+	values := make([]int, 0)
+	for _, v := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < min || n > max || n == 0 {
+			return nil, ErrInvalidRule
+		}
+		values = append(values, n)
+	}
+	return values, nil
+}
+
+// parseUntil разбирает UNTIL: дату, местное время или время UTC с суффиксом Z.
+// Граница в виде даты включает весь этот день.
+func parseUntil(s string) (time.Time, bool, error) {
+	if t, err := time.Parse("20060102T150405Z", s); err == nil {
+		return t, false, nil
+	}
+	if t, err := time.Parse("20060102T150405", s); err == nil {
+		return t, true, nil
+	}
+	if t, err := time.Parse("20060102", s); err == nil {
+		return t.Add(24*time.Hour - time.Second), true, nil
+	}
+	return time.Time{}, false, ErrInvalidRule
+}
+
+// parseByDay разбирает BYDAY: "MO,WE", "1MO", "-1FR".
+func parseByDay(s string) ([]WeekdayNum, error) {
+	days := make([]WeekdayNum, 0)
+	for _, v := range strings.Split(s, ",") {
+		if len(v) < 2 {
+			return nil, ErrInvalidRule
+		}
+		day, ok := parseWeekday(v[len(v)-2:])
+		if !ok {
+			return nil, ErrInvalidRule
+		}
+		wd := WeekdayNum{Day: day}
+		if prefix := v[:len(v)-2]; prefix != "" {
+			n, err := strconv.Atoi(prefix)
+			if err != nil || n == 0 || n < -53 || n > 53 {
+				return nil, ErrInvalidRule
+			}
+			wd.N = n
+		}
+		days = append(days, wd)
+	}
+	return days, nil
+}
+
+// parseWeekday разбирает двухбуквенное обозначение дня недели.
+func parseWeekday(s string) (time.Weekday, bool) {
+	for d, name := range weekdayNames {
+		if name == s {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+func joinInts(values []int) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.Itoa(v)
+	}
+	return strings.Join(s, ",")
+}
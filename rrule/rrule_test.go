@@ -0,0 +1,190 @@
+package rrule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseString(t *testing.T) {
+	tests := []struct {
+		rule string
+		want string
+	}{
+		{"FREQ=DAILY", "FREQ=DAILY"},
+		{"RRULE:freq=weekly;byday=mo,th", "FREQ=WEEKLY;BYDAY=MO,TH"},
+		{"FREQ=MONTHLY;BYDAY=-1FR;INTERVAL=2", "FREQ=MONTHLY;INTERVAL=2;BYDAY=-1FR"},
+		{"FREQ=YEARLY;BYMONTH=3;BYMONTHDAY=1,-1", "FREQ=YEARLY;BYMONTHDAY=1,-1;BYMONTH=3"},
+		{"FREQ=DAILY;COUNT=3", "FREQ=DAILY;COUNT=3"},
+		{"FREQ=DAILY;UNTIL=20240310T090000Z", "FREQ=DAILY;UNTIL=20240310T090000Z"},
+		{"FREQ=DAILY;UNTIL=20240310", "FREQ=DAILY;UNTIL=20240310T235959"},
+		{"FREQ=WEEKLY;WKST=SU", "FREQ=WEEKLY;WKST=SU"},
+	}
+	for _, tt := range tests {
+		r, err := Parse(tt.rule)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.rule, err)
+			continue
+		}
+		if got := r.String(); got != tt.want {
+			t.Errorf("Parse(%q).String() = %q, want %q", tt.rule, got, tt.want)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		rule string
+		err  error
+	}{
+		{"", ErrInvalidRule},
+		{"INTERVAL=2", ErrInvalidRule},
+		{"FREQ=DAILY;FREQ=WEEKLY", ErrInvalidRule},
+		{"FREQ=DAILY;INTERVAL=0", ErrInvalidRule},
+		{"FREQ=DAILY;COUNT=2;UNTIL=20240310", ErrInvalidRule},
+		{"FREQ=WEEKLY;BYDAY=1MO", ErrInvalidRule},
+		{"FREQ=WEEKLY;BYMONTHDAY=1", ErrInvalidRule},
+		{"FREQ=MONTHLY;BYMONTHDAY=32", ErrInvalidRule},
+		{"FREQ=HOURLY", ErrUnsupportedPart},
+		{"FREQ=DAILY;BYHOUR=9", ErrUnsupportedPart},
+	}
+	for _, tt := range tests {
+		if _, err := Parse(tt.rule); err != tt.err {
+			t.Errorf("Parse(%q) = %v, want %v", tt.rule, err, tt.err)
+		}
+	}
+}
+
+func TestAfter(t *testing.T) {
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utc := func(y int, m time.Month, d, h int) time.Time { return time.Date(y, m, d, h, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name  string
+		rule  string
+		start time.Time
+		after time.Time
+		want  []time.Time
+		// end - после want повторений больше нет.
+		end bool
+	}{
+		{
+			name:  "daily",
+			rule:  "FREQ=DAILY",
+			start: utc(2024, time.March, 1, 9),
+			after: utc(2024, time.March, 1, 9),
+			want:  []time.Time{utc(2024, time.March, 2, 9), utc(2024, time.March, 3, 9)},
+		},
+		{
+			name:  "before start",
+			rule:  "FREQ=DAILY",
+			start: utc(2024, time.March, 1, 9),
+			after: utc(2024, time.January, 1, 0),
+			want:  []time.Time{utc(2024, time.March, 1, 9), utc(2024, time.March, 2, 9)},
+		},
+		{
+			name:  "weekly by day",
+			rule:  "FREQ=WEEKLY;BYDAY=MO,TH",
+			start: utc(2024, time.March, 4, 9), // понедельник
+			after: utc(2024, time.March, 4, 9),
+			want:  []time.Time{utc(2024, time.March, 7, 9), utc(2024, time.March, 11, 9), utc(2024, time.March, 14, 9)},
+		},
+		{
+			name:  "every other week",
+			rule:  "FREQ=WEEKLY;INTERVAL=2",
+			start: utc(2024, time.March, 6, 9),
+			after: utc(2024, time.March, 6, 9),
+			want:  []time.Time{utc(2024, time.March, 20, 9), utc(2024, time.April, 3, 9)},
+		},
+		{
+			name:  "last friday of month",
+			rule:  "FREQ=MONTHLY;BYDAY=-1FR",
+			start: utc(2024, time.January, 1, 9),
+			after: utc(2024, time.January, 1, 9),
+			want:  []time.Time{utc(2024, time.January, 26, 9), utc(2024, time.February, 23, 9), utc(2024, time.March, 29, 9)},
+		},
+		{
+			name:  "monthly skips short months",
+			rule:  "FREQ=MONTHLY",
+			start: utc(2024, time.January, 31, 9),
+			after: utc(2024, time.January, 31, 9),
+			want:  []time.Time{utc(2024, time.March, 31, 9), utc(2024, time.May, 31, 9)},
+		},
+		{
+			name:  "last day of month",
+			rule:  "FREQ=MONTHLY;BYMONTHDAY=-1",
+			start: utc(2024, time.January, 1, 9),
+			after: utc(2024, time.January, 1, 9),
+			want:  []time.Time{utc(2024, time.January, 31, 9), utc(2024, time.February, 29, 9), utc(2024, time.March, 31, 9)},
+		},
+		{
+			name:  "yearly leap day",
+			rule:  "FREQ=YEARLY",
+			start: utc(2024, time.February, 29, 9),
+			after: utc(2024, time.February, 29, 9),
+			want:  []time.Time{utc(2028, time.February, 29, 9)},
+		},
+		{
+			name:  "count",
+			rule:  "FREQ=DAILY;COUNT=3",
+			start: utc(2024, time.March, 1, 9),
+			after: utc(2024, time.March, 1, 9),
+			want:  []time.Time{utc(2024, time.March, 2, 9), utc(2024, time.March, 3, 9)},
+			end:   true,
+		},
+		{
+			name:  "until",
+			rule:  "FREQ=DAILY;UNTIL=20240303T090000Z",
+			start: utc(2024, time.March, 1, 9),
+			after: utc(2024, time.March, 1, 9),
+			want:  []time.Time{utc(2024, time.March, 2, 9), utc(2024, time.March, 3, 9)},
+			end:   true,
+		},
+		{
+			name:  "floating until",
+			rule:  "FREQ=DAILY;UNTIL=20240302",
+			start: time.Date(2024, time.March, 1, 23, 0, 0, 0, moscow),
+			after: time.Date(2024, time.March, 1, 23, 0, 0, 0, moscow),
+			want:  []time.Time{time.Date(2024, time.March, 2, 23, 0, 0, 0, moscow)},
+			end:   true,
+		},
+		{
+			name:  "daylight saving keeps local time",
+			rule:  "FREQ=DAILY",
+			start: time.Date(2024, time.March, 30, 9, 0, 0, 0, berlin),
+			after: time.Date(2024, time.March, 30, 9, 0, 0, 0, berlin),
+			want:  []time.Time{time.Date(2024, time.March, 31, 9, 0, 0, 0, berlin), time.Date(2024, time.April, 1, 9, 0, 0, 0, berlin)},
+		},
+		{
+			name:  "never",
+			rule:  "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30",
+			start: utc(2024, time.January, 1, 9),
+			after: utc(2024, time.January, 1, 9),
+			end:   true,
+		},
+	}
+	for _, tt := range tests {
+		r, err := Parse(tt.rule)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		after := tt.after
+		for i, want := range tt.want {
+			got, ok := r.After(tt.start, after)
+			if !ok || !got.Equal(want) {
+				t.Errorf("%s: occurrence %d = %v, %v, want %v", tt.name, i, got, ok, want)
+				break
+			}
+			after = got
+		}
+		if got, ok := r.After(tt.start, after); tt.end && ok {
+			t.Errorf("%s: unexpected occurrence %v after %v", tt.name, got, after)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Package scheduler периодически выполняет фоновые задачи сервера: например, возвращает повторяющиеся
+// элементы и обновляет повторяющиеся списки. Время берется из clock.Clock, поэтому работу планировщика
+// можно проверять с поддельными часами.
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/abel-03/go-todo/clock"
+)
+
+// TaskFunc выполняет задачу на момент now.
+type TaskFunc func(ctx context.Context, now time.Time) error
+
+type task struct {
+	name string
+	run  TaskFunc
+}
+
+// Scheduler выполняет задачи каждые interval по часам clock.
+type Scheduler struct {
+	clock    clock.Clock
+	interval time.Duration
+	tasks    []task
+}
+
+// New создает планировщик без задач.
+func New(c clock.Clock, interval time.Duration) *Scheduler {
+	return &Scheduler{clock: c, interval: interval}
+}
+
+// Add добавляет задачу. Задачи выполняются по очереди в порядке добавления.
+func (s *Scheduler) Add(name string, run TaskFunc) *Scheduler {
+	s.tasks = append(s.tasks, task{name: name, run: run})
+	return s
+}
+
+// RunOnce выполняет все задачи на текущий момент часов. Ошибка задачи записывается в журнал
+// и не мешает выполнению остальных; задача повторится на следующем шаге.
+func (s *Scheduler) RunOnce(ctx context.Context) {
+	now := s.clock.Now()
+	for _, t := range s.tasks {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := t.run(ctx, now); err != nil {
+			log.Printf("scheduler: %s: %v", t.name, err)
+		}
+	}
+}
+
+// Start выполняет задачи сразу и затем каждые interval, пока не отменен ctx.
+func (s *Scheduler) Start(ctx context.Context) {
+	for {
+		s.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.clock.After(s.interval):
+		}
+	}
+}
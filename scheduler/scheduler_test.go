@@ -0,0 +1,103 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/abel-03/go-todo/clock"
+)
+
+var start = time.Date(2024, time.March, 1, 9, 0, 0, 0, time.UTC)
+
+// waitFor ждет, пока cond не станет истинным.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// receive возвращает время очередного выполнения задачи.
+func receive(t *testing.T, runs <-chan time.Time) time.Time {
+	t.Helper()
+	select {
+	case now := <-runs:
+		return now
+	case <-time.After(5 * time.Second):
+		t.Fatal("task did not run")
+	}
+	return time.Time{}
+}
+
+func TestStartRunsTasksEveryInterval(t *testing.T) {
+	c := clock.NewFake(start)
+	runs := make(chan time.Time, 10)
+	failed := 0
+	s := New(c, time.Minute).
+		Add("failing", func(ctx context.Context, now time.Time) error {
+			failed++
+			return errors.New("boom")
+		}).
+		Add("task", func(ctx context.Context, now time.Time) error {
+			runs <- now
+			return nil
+		})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Start(ctx)
+		close(done)
+	}()
+
+	if now := receive(t, runs); !now.Equal(start) {
+		t.Errorf("first run at %v, want %v", now, start)
+	}
+	for i := 1; i <= 3; i++ {
+		waitFor(t, func() bool { return c.Waiters() == 1 })
+		// Пока интервал не прошел, задачи не выполняются.
+		c.Advance(time.Minute - time.Second)
+		select {
+		case now := <-runs:
+			t.Fatalf("run at %v before interval elapsed", now)
+		default:
+		}
+		c.Advance(time.Second)
+		if now, want := receive(t, runs), start.Add(time.Duration(i)*time.Minute); !now.Equal(want) {
+			t.Errorf("run %d at %v, want %v", i, now, want)
+		}
+	}
+
+	waitFor(t, func() bool { return c.Waiters() == 1 })
+	cancel()
+	<-done
+	if failed != 4 {
+		t.Errorf("failing task ran %d times, want 4", failed)
+	}
+}
+
+func TestRunOnceStopsWhenCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ran := make([]string, 0)
+	s := New(clock.NewFake(start), time.Minute).
+		Add("first", func(ctx context.Context, now time.Time) error {
+			ran = append(ran, "first")
+			cancel()
+			return nil
+		}).
+		Add("second", func(ctx context.Context, now time.Time) error {
+			ran = append(ran, "second")
+			return nil
+		})
+
+	s.RunOnce(ctx)
+	if len(ran) != 1 || ran[0] != "first" {
+		t.Errorf("ran %v, want [first]", ran)
+	}
+}
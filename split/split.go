@@ -0,0 +1,114 @@
+// Package split делит расходы между участниками и вычисляет переводы, которыми участники рассчитываются друг с другом.
+//
+// Суммы задаются в минимальных единицах валюты (центах, копейках), чтобы округление не накапливалось.
+// Баланс участника положителен, если ему должны, и отрицателен, если должен он.
+package split
+
+import (
+	"math"
+	"sort"
+)
+
+// Transfer представляет перевод Amount от участника From участнику To.
+type Transfer struct {
+	From   string
+	To     string
+	Amount int64
+}
+
+// Cents переводит сумму в минимальные единицы валюты с округлением до ближайшей.
+func Cents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// Amount переводит сумму в минимальных единицах валюты обратно в сумму.
+func Amount(cents int64) float64 {
+	return float64(cents) / 100
+}
+
+// Equal делит сумму cents поровну между участниками ids без повторов. Остаток, который не делится поровну,
+// распределяется по одной единице между первыми участниками, поэтому сумма долей всегда равна cents.
+func Equal(cents int64, ids []string) map[string]int64 {
+	unique := make([]string, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	shares := make(map[string]int64, len(unique))
+	if len(unique) == 0 {
+		return shares
+	}
+	n := int64(len(unique))
+	for i, id := range unique {
+		shares[id] = cents / n
+		if int64(i) < cents%n {
+			shares[id]++
+		}
+	}
+	return shares
+}
+
+// party - участник взаиморасчета с суммой, которую ему должны или которую должен он.
+type party struct {
+	id     string
+	amount int64
+}
+
+// Settle возвращает переводы, после которых балансы всех участников становятся нулевыми.
+// Сначала рассчитываются участники с равными долгом и переплатой - каждой такой паре хватает одного перевода,
+// затем наибольший долг гасится переводом наибольшему кредитору, пока долги не кончатся.
+// Каждый перевод обнуляет баланс хотя бы одного участника, поэтому переводов не больше, чем участников
+// с ненулевым балансом, без одного. Это жадная оценка, а не наименьшее возможное число переводов: его поиск
+// требует перебора групп участников с нулевой суммой балансов.
+// Если сумма балансов не равна нулю, непогашенный остаток игнорируется.
+func Settle(balances map[string]int64) []Transfer {
+	debtors, creditors := make([]party, 0), make([]party, 0)
+	for id, b := range balances {
+		if b < 0 {
+			debtors = append(debtors, party{id, -b})
+		} else if b > 0 {
+			creditors = append(creditors, party{id, b})
+		}
+	}
+	byAmount := func(ps []party) {
+		sort.Slice(ps, func(i, j int) bool {
+			if ps[i].amount != ps[j].amount {
+				return ps[i].amount > ps[j].amount
+			}
+			return ps[i].id < ps[j].id
+		})
+	}
+	byAmount(debtors)
+	byAmount(creditors)
+
+	transfers := make([]Transfer, 0)
+	for i := range debtors {
+		for j := range creditors {
+			if debtors[i].amount > 0 && debtors[i].amount == creditors[j].amount {
+				transfers = append(transfers, Transfer{debtors[i].id, creditors[j].id, debtors[i].amount})
+				debtors[i].amount, creditors[j].amount = 0, 0
+				break
+			}
+		}
+	}
+
+	for {
+		byAmount(debtors)
+		byAmount(creditors)
+		if len(debtors) == 0 || len(creditors) == 0 || debtors[0].amount == 0 || creditors[0].amount == 0 {
+			break
+		}
+		amount := debtors[0].amount
+		if creditors[0].amount < amount {
+			amount = creditors[0].amount
+		}
+		transfers = append(transfers, Transfer{debtors[0].id, creditors[0].id, amount})
+		debtors[0].amount -= amount
+		creditors[0].amount -= amount
+	}
+	return transfers
+}
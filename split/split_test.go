@@ -0,0 +1,114 @@
+package split
+
+import (
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestCents(t *testing.T) {
+	for amount, want := range map[float64]int64{0: 0, 0.1: 10, 0.29: 29, 1.25: 125, 19.99: 1999, -2.5: -250} {
+		if got := Cents(amount); got != want {
+			t.Errorf("Cents(%v) = %d, want %d", amount, got, want)
+		}
+	}
+	if got := Amount(1999); got != 19.99 {
+		t.Errorf("Amount(1999) = %v, want 19.99", got)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		cents int64
+		ids   []string
+		want  map[string]int64
+	}{
+		{1000, []string{"a", "b"}, map[string]int64{"a": 500, "b": 500}},
+		{1000, []string{"a", "b", "c"}, map[string]int64{"a": 334, "b": 333, "c": 333}},
+		{1001, []string{"a", "b", "c"}, map[string]int64{"a": 334, "b": 334, "c": 333}},
+		{2, []string{"a", "b", "c"}, map[string]int64{"a": 1, "b": 1, "c": 0}},
+		{100, []string{"a", "b", "a"}, map[string]int64{"a": 50, "b": 50}},
+		{100, nil, map[string]int64{}},
+		{0, []string{"a"}, map[string]int64{"a": 0}},
+	}
+	for _, tt := range tests {
+		got := Equal(tt.cents, tt.ids)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Equal(%d, %v) = %v, want %v", tt.cents, tt.ids, got, tt.want)
+		}
+	}
+}
+
+// apply возвращает балансы после переводов transfers.
+func apply(balances map[string]int64, transfers []Transfer) map[string]int64 {
+	res := make(map[string]int64, len(balances))
+	for id, b := range balances {
+		res[id] = b
+	}
+	for _, tr := range transfers {
+		res[tr.From] += tr.Amount
+		res[tr.To] -= tr.Amount
+	}
+	return res
+}
+
+func TestSettle(t *testing.T) {
+	tests := []struct {
+		name     string
+		balances map[string]int64
+		want     []Transfer
+	}{
+		{"settled", map[string]int64{"a": 0, "b": 0}, []Transfer{}},
+		{"one debt", map[string]int64{"a": 500, "b": -500}, []Transfer{{"b", "a", 500}}},
+		{"one payer", map[string]int64{"a": 600, "b": -300, "c": -300}, []Transfer{{"b", "a", 300}, {"c", "a", 300}}},
+		{"equal pairs first", map[string]int64{"a": 700, "b": 300, "c": -300, "d": -700},
+			[]Transfer{{"d", "a", 700}, {"c", "b", 300}}},
+		{"largest first", map[string]int64{"a": 500, "b": 400, "c": -600, "d": -300},
+			[]Transfer{{"c", "a", 500}, {"d", "b", 300}, {"c", "b", 100}}},
+	}
+	for _, tt := range tests {
+		got := Settle(tt.balances)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Settle(%v) = %v, want %v", tt.name, tt.balances, got, tt.want)
+		}
+	}
+}
+
+// Переводы обнуляют все балансы, идут только от должников к кредиторам, и их не больше n-1,
+// где n - число участников с ненулевым балансом.
+func TestSettleRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		balances := make(map[string]int64)
+		var sum int64
+		n := 1 + r.Intn(8)
+		for j := 0; j < n; j++ {
+			b := r.Int63n(20001) - 10000
+			balances[strconv.Itoa(j)] = b
+			sum += b
+		}
+		balances[strconv.Itoa(n)] = -sum
+
+		nonZero := 0
+		for _, b := range balances {
+			if b != 0 {
+				nonZero++
+			}
+		}
+		transfers := Settle(balances)
+		if nonZero > 0 && len(transfers) > nonZero-1 {
+			t.Fatalf("Settle(%v) = %d transfers, want at most %d", balances, len(transfers), nonZero-1)
+		}
+		for _, tr := range transfers {
+			if tr.Amount <= 0 || balances[tr.From] >= 0 || balances[tr.To] <= 0 {
+				t.Fatalf("Settle(%v): transfer %+v is not from a debtor to a creditor", balances, tr)
+			}
+		}
+		for id, b := range apply(balances, transfers) {
+			if b != 0 {
+				t.Fatalf("Settle(%v): balance of %s is %d after transfers %v", balances, id, b, transfers)
+			}
+		}
+	}
+}
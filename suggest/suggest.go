@@ -0,0 +1,213 @@
+// Package suggest подсказывает товары по истории покупок: дополняет вводимое название и напоминает о товарах,
+// которые обычно покупаются с постоянным интервалом.
+//
+// Подсказки вычисляются в процессе без внешних сервисов. Вес покупки убывает экспоненциально с ее возрастом,
+// поэтому товар, который покупают часто и недавно, оказывается выше купленного много раз, но давно.
+// Интервал пополнения - медиана промежутков между покупками товара.
+package suggest
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MinPurchases - сколько раз нужно купить товар, чтобы по промежуткам между покупками можно было судить об интервале.
+const MinPurchases = 3
+
+// sameTrip - покупки товара, сделанные ближе этого промежутка, считаются одной покупкой,
+// например когда товар был в двух списках одного похода в магазин.
+const sameTrip = 12 * time.Hour
+
+// lapsedIntervals - через сколько пропущенных интервалов товар считается больше не покупаемым
+// и перестает предлагаться для пополнения.
+const lapsedIntervals = 3
+
+// Purchase представляет одну покупку товара.
+type Purchase struct {
+	Name     string
+	Category string
+	Quantity float64
+	Unit     string
+	Time     time.Time
+}
+
+// Product представляет товар, собранный из покупок с одинаковым нормализованным названием Key.
+// Название, категория, количество и единица берутся из последней покупки.
+type Product struct {
+	Key      string    `json:"-"`
+	Name     string    `json:"name"`
+	Category string    `json:"category,omitempty"`
+	Quantity float64   `json:"quantity,omitempty"`
+	Unit     string    `json:"unit,omitempty"`
+	Count    int       `json:"count"`
+	Last     time.Time `json:"lastBought"`
+	Score    float64   `json:"score"`
+
+	times []time.Time
+}
+
+// Restock представляет товар, который пора пополнить: IntervalDays - обычный промежуток между покупками в днях,
+// Due - когда товар следует купить снова.
+type Restock struct {
+	Product
+	IntervalDays int       `json:"intervalDays"`
+	Due          time.Time `json:"due"`
+}
+
+// Aggregate объединяет покупки в товары по названию, приведенному функцией normalize, и вычисляет их вес на момент now:
+// каждая покупка весит 1, а ее вес уменьшается вдвое за каждый halfLife. Покупки с пустым названием пропускаются.
+// Товары упорядочены по убыванию веса.
+func Aggregate(purchases []Purchase, normalize func(string) string, now time.Time, halfLife time.Duration) []Product {
+	byKey := make(map[string]*Product)
+	keys := make([]string, 0)
+	for _, p := range purchases {
+		key := normalize(p.Name)
+		if key == "" {
+			continue
+		}
+		prod, ok := byKey[key]
+		if !ok {
+			prod = &Product{Key: key}
+			byKey[key] = prod
+			keys = append(keys, key)
+		}
+		if prod.Count == 0 || p.Time.After(prod.Last) {
+			prod.Name, prod.Category, prod.Quantity, prod.Unit = p.Name, p.Category, p.Quantity, p.Unit
+			prod.Last = p.Time
+		}
+		prod.Count++
+		prod.Score += decay(now.Sub(p.Time), halfLife)
+		prod.times = append(prod.times, p.Time)
+	}
+
+	products := make([]Product, 0, len(keys))
+	for _, key := range keys {
+		products = append(products, *byKey[key])
+	}
+	sortByScore(products)
+	return products
+}
+
+// decay возвращает вес покупки возрастом age. Покупки "из будущего" из-за расхождения часов весят 1.
+func decay(age, halfLife time.Duration) float64 {
+	if age <= 0 || halfLife <= 0 {
+		return 1
+	}
+	return math.Exp2(-float64(age) / float64(halfLife))
+}
+
+// sortByScore упорядочивает товары по убыванию веса, а при равном весе - по названию.
+func sortByScore(products []Product) {
+	sort.SliceStable(products, func(i, j int) bool {
+		if products[i].Score != products[j].Score {
+			return products[i].Score > products[j].Score
+		}
+		return products[i].Key < products[j].Key
+	})
+}
+
+// Complete возвращает не больше limit товаров, подходящих к нормализованному запросу query: каждое слово запроса
+// должно быть началом одного из слов названия. Товары, название которых начинается с запроса, идут первыми,
+// остальные - следом; внутри каждой группы порядок определяется весом. Пустой запрос подходит ко всем товарам.
+func Complete(products []Product, query string, limit int) []Product {
+	words := strings.Fields(query)
+	leading, rest := make([]Product, 0), make([]Product, 0)
+	for _, p := range products {
+		if !matches(p.Key, words) {
+			continue
+		}
+		if strings.HasPrefix(p.Key, query) {
+			leading = append(leading, p)
+		} else {
+			rest = append(rest, p)
+		}
+	}
+	sortByScore(leading)
+	sortByScore(rest)
+
+	res := append(leading, rest...)
+	if limit >= 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	return res
+}
+
+// matches сообщает, начинается ли каждое слово запроса words с какого-либо слова ключа key.
+func matches(key string, words []string) bool {
+	fields := strings.Fields(key)
+	for _, w := range words {
+		found := false
+		for _, f := range fields {
+			if strings.HasPrefix(f, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Interval возвращает обычный промежуток между покупками: медиану промежутков между покупками, сделанными в разные
+// походы в магазин. Если покупок меньше MinPurchases, интервал не определен и ok равно false.
+func Interval(times []time.Time) (interval time.Duration, ok bool) {
+	sorted := append([]time.Time(nil), times...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+
+	gaps := make([]time.Duration, 0, len(sorted))
+	last := time.Time{}
+	for i, t := range sorted {
+		if i > 0 {
+			gap := t.Sub(last)
+			if gap < sameTrip {
+				continue
+			}
+			gaps = append(gaps, gap)
+		}
+		last = t
+	}
+	if len(gaps) < MinPurchases-1 {
+		return 0, false
+	}
+
+	sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+	mid := len(gaps) / 2
+	if len(gaps)%2 == 0 {
+		return (gaps[mid-1] + gaps[mid]) / 2, true
+	}
+	return gaps[mid], true
+}
+
+// Restocks возвращает товары, которые по обычному интервалу покупок пора купить к моменту now+within,
+// упорядоченные по сроку. Товары, которые не покупались дольше lapsedIntervals интервалов, не предлагаются:
+// вероятно, их больше не покупают.
+func Restocks(products []Product, now time.Time, within time.Duration) []Restock {
+	res := make([]Restock, 0)
+	for _, p := range products {
+		interval, ok := Interval(p.times)
+		if !ok {
+			continue
+		}
+		due := p.Last.Add(interval)
+		if due.After(now.Add(within)) || now.Sub(p.Last) > lapsedIntervals*interval {
+			continue
+		}
+		days := int(math.Round(interval.Hours() / 24))
+		if days < 1 {
+			days = 1
+		}
+		res = append(res, Restock{Product: p, IntervalDays: days, Due: due})
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		if !res[i].Due.Equal(res[j].Due) {
+			return res[i].Due.Before(res[j].Due)
+		}
+		return res[i].Key < res[j].Key
+	})
+	return res
+}